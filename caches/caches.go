@@ -41,6 +41,9 @@ type Caches struct {
 type Config struct {
 	Easer  bool
 	Cacher Cacher
+	// RowLevel tags primary key lookups with RowTag, so that writes to rows known by
+	// primary key invalidate only those rows and the table's non primary key queries
+	RowLevel bool
 }
 
 func (c *Caches) Name() string {
@@ -128,9 +131,12 @@ func (c *Caches) Query(callback func(*gorm.DB)) func(*gorm.DB) {
 			return
 		}
 
-		if res, ok := c.checkCache(identifier); ok {
-			res.replaceOn(db)
-			return
+		policy := PolicyFromContext(db.Statement.Context)
+		if !policy.NoCache && !policy.ForceRefresh {
+			if res, ok := c.checkCache(identifier); ok {
+				res.replaceOn(db)
+				return
+			}
 		}
 
 		c.ease(db, identifier, callback)
@@ -138,7 +144,11 @@ func (c *Caches) Query(callback func(*gorm.DB)) func(*gorm.DB) {
 			return
 		}
 
-		c.storeInCache(db, identifier)
+		if policy.NoCache {
+			return
+		}
+
+		c.storeInCache(db, identifier, policy)
 		if db.Error != nil {
 			return
 		}
@@ -160,18 +170,20 @@ func (c *Caches) AfterWrite(db *gorm.DB) {
 		return
 	}
 
+	tags := c.writeTags(db, tables)
+
 	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
 		// query from database directly when in transaction
 		if value, ok := TablesFromContext(db.Statement.Context); ok {
-			value.Append(tables...)
+			value.Append(tags...)
 		}
 		return
 	}
 
-	if len(tables) == 1 {
-		c.deleteCache(db, tables[0])
+	if len(tags) == 1 {
+		c.deleteCache(db, tags[0])
 	} else {
-		c.deleteCache(db, tables[0], tables[1:]...)
+		c.deleteCache(db, tags[0], tags[1:]...)
 	}
 
 	if db.Error != nil {
@@ -360,12 +372,13 @@ func getTablesPostgres(db *gorm.DB) []string {
 	return tableNames
 }
 
-func (c *Caches) storeInCache(db *gorm.DB, identifier string) {
+func (c *Caches) storeInCache(db *gorm.DB, identifier string, policy Policy) {
 	if c.Conf.Cacher != nil {
 		err := c.Conf.Cacher.Store(identifier, &Query{
-			Tags:         getTables(db),
+			Tags:         c.queryTags(db, policy),
 			Dest:         db.Statement.Dest,
 			RowsAffected: db.Statement.RowsAffected,
+			TTL:          policy.TTL,
 		})
 		if err != nil {
 			_ = db.AddError(err)
@@ -0,0 +1,98 @@
+package caches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/unionj-cloud/toolkit/gocache/lib/cache"
+	"github.com/unionj-cloud/toolkit/gocache/lib/store"
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// GoCacher is a Cacher backed by any gocache store (go-cache, ristretto, bigcache, redis, ...).
+// Each entry is tagged with the tables its query reads from, so that Delete invalidates
+// every cached query of the written tables with a single tag invalidation.
+type GoCacher struct {
+	cache   cache.CacheInterface[any]
+	options []store.Option
+}
+
+// gocacherEntry is the serialized form of a Query. Dest is kept as raw JSON and decoded
+// into the statement destination when the cached result is replayed.
+type gocacherEntry struct {
+	Tags         []string        `json:"tags"`
+	Dest         json.RawMessage `json:"dest"`
+	RowsAffected int64           `json:"rows_affected"`
+}
+
+// NewGoCacher creates a Cacher storing query results in the given gocache cache.
+// options are applied to every entry, e.g. store.WithExpiration to bound its lifetime.
+func NewGoCacher(c cache.CacheInterface[any], options ...store.Option) *GoCacher {
+	return &GoCacher{
+		cache:   c,
+		options: options,
+	}
+}
+
+func (c *GoCacher) Get(key string) *Query {
+	result, err := c.cache.Get(context.Background(), key)
+	if err != nil {
+		if !errors.Is(err, &store.NotFound{}) {
+			zlogger.Error().Err(err).Msgf("get cached query %s error", key)
+		}
+		return nil
+	}
+
+	var data []byte
+	switch v := result.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil
+	}
+
+	var entry gocacherEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		zlogger.Error().Err(err).Msgf("decode cached query %s error", key)
+		return nil
+	}
+
+	return &Query{
+		Tags:         entry.Tags,
+		Dest:         entry.Dest,
+		RowsAffected: entry.RowsAffected,
+	}
+}
+
+func (c *GoCacher) Store(key string, val *Query) error {
+	dest, err := json.Marshal(val.Dest)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(gocacherEntry{
+		Tags:         val.Tags,
+		Dest:         dest,
+		RowsAffected: val.RowsAffected,
+	})
+	if err != nil {
+		return err
+	}
+
+	options := c.options[:len(c.options):len(c.options)]
+	if val.TTL > 0 {
+		options = append(options, store.WithExpiration(val.TTL))
+	}
+	if len(val.Tags) > 0 {
+		options = append(options, store.WithTags(val.Tags))
+	}
+
+	return c.cache.Set(context.Background(), key, data, options...)
+}
+
+func (c *GoCacher) Delete(tag string, tags ...string) error {
+	return c.cache.Invalidate(context.Background(), store.WithInvalidateTags(append([]string{tag}, tags...)))
+}
@@ -0,0 +1,96 @@
+package caches
+
+import (
+	"testing"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unionj-cloud/toolkit/gocache/lib/cache"
+	"github.com/unionj-cloud/toolkit/gocache/store/go_cache"
+)
+
+func newTestGoCacher() *GoCacher {
+	return NewGoCacher(cache.New[any](go_cache.NewGoCache(gocache.New(time.Minute, time.Minute))))
+}
+
+func TestGoCacher_StoreAndDelete(t *testing.T) {
+	cacher := newTestGoCacher()
+
+	err := cacher.Store("users_key", &Query{
+		Tags:         []string{"users"},
+		Dest:         &[]struct{ Name string }{{Name: "John"}},
+		RowsAffected: 1,
+	})
+	require.NoError(t, err)
+	err = cacher.Store("posts_key", &Query{
+		Tags:         []string{"posts"},
+		Dest:         &[]struct{ Title string }{{Title: "Hello"}},
+		RowsAffected: 1,
+	})
+	require.NoError(t, err)
+
+	// 缓存的结果可以写回查询的目标对象
+	res := cacher.Get("users_key")
+	require.NotNil(t, res)
+	assert.Equal(t, []string{"users"}, res.Tags)
+	assert.Equal(t, int64(1), res.RowsAffected)
+
+	var users []struct{ Name string }
+	SetPointedValue(&users, res.Dest)
+	assert.Equal(t, "John", users[0].Name)
+
+	// 按表名标签失效
+	require.NoError(t, cacher.Delete("users"))
+	assert.Nil(t, cacher.Get("users_key"))
+	assert.NotNil(t, cacher.Get("posts_key"))
+
+	assert.Nil(t, cacher.Get("missing_key"))
+}
+
+func TestGoCacher_WithCaches(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if db, _ := db.DB(); db != nil {
+			_ = db.Close()
+		}
+	}()
+
+	cacher := newTestGoCacher()
+	err := db.Use(&Caches{Conf: &Config{Cacher: cacher}})
+	require.NoError(t, err)
+
+	err = db.Exec(`INSERT INTO users (name, age) VALUES ('Alice', 28)`).Error
+	require.NoError(t, err)
+
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	var users []user
+	err = db.Table("users").Select("name, age").Find(&users).Error
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	// 绕过 gorm 写入的行不可见，再次查询从缓存中获取
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`INSERT INTO users (name, age) VALUES ('Bob', 30)`)
+	require.NoError(t, err)
+
+	var cached []user
+	err = db.Table("users").Select("name, age").Find(&cached).Error
+	require.NoError(t, err)
+	assert.Equal(t, users, cached)
+
+	// 通过 gorm 写入 users 表后缓存失效
+	err = db.Exec(`INSERT INTO users (name, age) VALUES ('Carol', 32)`).Error
+	require.NoError(t, err)
+
+	err = db.Table("users").Select("name, age").Find(&users).Error
+	require.NoError(t, err)
+	assert.Len(t, users, 3)
+}
@@ -0,0 +1,65 @@
+package caches
+
+import (
+	"context"
+	"time"
+)
+
+const policyKey = ctxKey(1)
+
+// Policy controls how a single query is cached. It is read from db.Statement.Context,
+// so that queries with different caching needs can share one gorm.DB.
+type Policy struct {
+	// TTL overrides the expiration of the stored entry, zero means the Cacher default
+	TTL time.Duration
+	// NoCache skips both reading and storing the cache
+	NoCache bool
+	// ForceRefresh skips reading the cache and stores the fresh result
+	ForceRefresh bool
+	// Tags are attached to the stored entry in addition to the tables of the query
+	Tags []string
+}
+
+// PolicyFromContext returns the cache policy of ctx, or the zero Policy if none is set
+func PolicyFromContext(ctx context.Context) Policy {
+	if ctx == nil {
+		return Policy{}
+	}
+	policy, _ := ctx.Value(policyKey).(Policy)
+	return policy
+}
+
+func withPolicy(ctx context.Context, update func(*Policy)) context.Context {
+	policy := PolicyFromContext(ctx)
+	update(&policy)
+	return context.WithValue(ctx, policyKey, policy)
+}
+
+// WithTTL stores the results of queries run with ctx for ttl
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return withPolicy(ctx, func(p *Policy) {
+		p.TTL = ttl
+	})
+}
+
+// NoCache makes queries run with ctx bypass the cache
+func NoCache(ctx context.Context) context.Context {
+	return withPolicy(ctx, func(p *Policy) {
+		p.NoCache = true
+	})
+}
+
+// ForceRefresh makes queries run with ctx hit the database and refresh the cached result
+func ForceRefresh(ctx context.Context) context.Context {
+	return withPolicy(ctx, func(p *Policy) {
+		p.ForceRefresh = true
+	})
+}
+
+// WithTags attaches tags to the results of queries run with ctx, so that they can be
+// invalidated with Cacher.Delete independently of their tables
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	return withPolicy(ctx, func(p *Policy) {
+		p.Tags = append(p.Tags[:len(p.Tags):len(p.Tags)], tags...)
+	})
+}
@@ -0,0 +1,100 @@
+package caches
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, Policy{}, PolicyFromContext(ctx))
+
+	ctx = WithTags(WithTTL(ctx, time.Second), "user:42")
+	ctx = WithTags(NoCache(ForceRefresh(ctx)), "user:43")
+	assert.Equal(t, Policy{
+		TTL:          time.Second,
+		NoCache:      true,
+		ForceRefresh: true,
+		Tags:         []string{"user:42", "user:43"},
+	}, PolicyFromContext(ctx))
+}
+
+func TestCaches_QueryWithPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if db, _ := db.DB(); db != nil {
+			_ = db.Close()
+		}
+	}()
+
+	cacher := newTestGoCacher()
+	require.NoError(t, db.Use(&Caches{Conf: &Config{Cacher: cacher}}))
+	require.NoError(t, db.Exec(`INSERT INTO users (name, age) VALUES ('Alice', 28)`).Error)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	insertBypassing := func(name string) {
+		_, err := sqlDB.Exec(`INSERT INTO users (name, age) VALUES ($1, 30)`, name)
+		require.NoError(t, err)
+	}
+
+	countUsers := func(ctx context.Context) int {
+		var names []string
+		err := db.WithContext(ctx).Table("users").Select("name").Find(&names).Error
+		require.NoError(t, err)
+		return len(names)
+	}
+
+	ctx := WithTags(context.Background(), "user:list")
+	assert.Equal(t, 1, countUsers(ctx))
+
+	// NoCache 既不读取也不写入缓存
+	insertBypassing("Bob")
+	assert.Equal(t, 2, countUsers(NoCache(ctx)))
+	assert.Equal(t, 1, countUsers(ctx))
+
+	// ForceRefresh 查询数据库并刷新缓存
+	assert.Equal(t, 2, countUsers(ForceRefresh(ctx)))
+	insertBypassing("Carol")
+	assert.Equal(t, 2, countUsers(ctx))
+
+	// 按上下文中的标签失效
+	require.NoError(t, cacher.Delete("user:list"))
+	assert.Equal(t, 3, countUsers(ctx))
+
+	// WithTTL 控制缓存条目的过期时间
+	ttlCtx := WithTTL(context.Background(), 50*time.Millisecond)
+	assert.Equal(t, 3, countUsers(ForceRefresh(ttlCtx)))
+	insertBypassing("Dave")
+	assert.Equal(t, 3, countUsers(ttlCtx))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 4, countUsers(ttlCtx))
+}
+
+func TestCaches_StoreReceivesPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if db, _ := db.DB(); db != nil {
+			_ = db.Close()
+		}
+	}()
+
+	mockCacher := NewMockCacher()
+	require.NoError(t, db.Use(&Caches{Conf: &Config{Cacher: mockCacher}}))
+
+	ctx := WithTags(WithTTL(context.Background(), time.Minute), "user:42")
+	var names []string
+	require.NoError(t, db.WithContext(ctx).Table("users").Select("name").Find(&names).Error)
+
+	require.Len(t, mockCacher.data, 1)
+	queries := lo.Values(mockCacher.data)
+	query := queries[0]
+	assert.Equal(t, time.Minute, query.TTL)
+	assert.Contains(t, query.Tags, "user:42")
+	assert.Contains(t, query.Tags, "users")
+}
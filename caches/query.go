@@ -1,11 +1,17 @@
 package caches
 
-import "github.com/wubin1989/gorm"
+import (
+	"time"
+
+	"github.com/wubin1989/gorm"
+)
 
 type Query struct {
 	Tags         []string
 	Dest         interface{}
 	RowsAffected int64
+	// TTL is the expiration requested by the query's Policy, zero means the Cacher default
+	TTL time.Duration
 }
 
 func (q *Query) replaceOn(db *gorm.DB) {
@@ -0,0 +1,261 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/samber/lo"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// pkExprPattern matches raw conditions like "id = ?", "users.id IN ?" or "`id` IN (?)"
+var pkExprPattern = regexp.MustCompile("(?i)^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*(?:=|in)\\s*\\(?\\s*\\?\\s*\\)?\\s*$")
+
+// RowTag returns the tag of the cached primary key lookups of a single row
+func RowTag(table string, pk interface{}) string {
+	return fmt.Sprintf("%s:%v", table, pk)
+}
+
+// AllRowsTag returns the tag shared by all cached primary key lookups of a table
+func AllRowsTag(table string) string {
+	return table + ":*"
+}
+
+// queryTags returns the tags of a cached query. With row level invalidation, a query bound
+// to primary keys is tagged with its row tags instead of its table, so that writes to other
+// rows of the table keep it cached.
+func (c *Caches) queryTags(db *gorm.DB, policy Policy) []string {
+	tables := getTables(db)
+	tags := tables
+
+	if c.Conf.RowLevel {
+		if table, ok := primaryTable(db, tables); ok {
+			if keys, ok := whereKeys(db.Statement); ok {
+				tags = append(rowTags(table, keys), AllRowsTag(table))
+			}
+		}
+	}
+
+	return lo.Uniq(append(tags, policy.Tags...))
+}
+
+// writeTags returns the tags to invalidate after a write. With row level invalidation, a write
+// whose rows are known by primary key invalidates only their row tags and the table's non primary
+// key queries; any other write invalidates every query of its tables.
+func (c *Caches) writeTags(db *gorm.DB, tables []string) []string {
+	if !c.Conf.RowLevel {
+		return tables
+	}
+
+	if table, ok := primaryTable(db, tables); ok && !assignsPrimaryKey(db.Statement) {
+		keys, ok := whereKeys(db.Statement)
+		if !ok && isPlainInsert(db.Statement) {
+			keys, ok = modelKeys(db)
+		}
+		if ok {
+			return append(rowTags(table, keys), table)
+		}
+	}
+
+	tags := make([]string, 0, len(tables)*2)
+	for _, table := range tables {
+		tags = append(tags, table, AllRowsTag(table))
+	}
+	return tags
+}
+
+// primaryTable returns the table of a statement reading or writing a single table with a single primary key
+func primaryTable(db *gorm.DB, tables []string) (string, bool) {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || len(stmt.Schema.PrimaryFields) != 1 {
+		return "", false
+	}
+
+	if len(tables) != 1 || tables[0] != stmt.Table || len(stmt.Joins) > 0 {
+		return "", false
+	}
+
+	return stmt.Table, true
+}
+
+// whereKeys returns the primary keys the WHERE clause restricts the statement to
+func whereKeys(stmt *gorm.Statement) ([]interface{}, bool) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil, false
+	}
+
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil, false
+	}
+
+	// the conditions are joined by AND unless an OR condition is present at the top level
+	conds := make([]clause.Expression, 0, len(where.Exprs))
+	for _, expr := range where.Exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			return nil, false
+		}
+		conds = append(conds, flattenAnd(expr)...)
+	}
+
+	for _, cond := range conds {
+		if keys, ok := primaryKeyCondition(stmt, cond); ok {
+			return keys, len(keys) > 0
+		}
+	}
+
+	return nil, false
+}
+
+// flattenAnd expands nested AND conditions that contain no OR condition
+func flattenAnd(expr clause.Expression) []clause.Expression {
+	and, ok := expr.(clause.AndConditions)
+	if !ok {
+		return []clause.Expression{expr}
+	}
+
+	for _, e := range and.Exprs {
+		if _, ok := e.(clause.OrConditions); ok {
+			return []clause.Expression{expr}
+		}
+	}
+
+	exprs := make([]clause.Expression, 0, len(and.Exprs))
+	for _, e := range and.Exprs {
+		exprs = append(exprs, flattenAnd(e)...)
+	}
+	return exprs
+}
+
+// primaryKeyCondition returns the primary keys of an equality or IN condition on the primary key
+func primaryKeyCondition(stmt *gorm.Statement, expr clause.Expression) ([]interface{}, bool) {
+	pk := stmt.Schema.PrioritizedPrimaryField
+
+	switch cond := expr.(type) {
+	case clause.Eq:
+		if isPrimaryColumn(stmt, pk, cond.Column) && cond.Value != nil {
+			return flattenValues([]interface{}{cond.Value}), true
+		}
+	case clause.IN:
+		if isPrimaryColumn(stmt, pk, cond.Column) {
+			return flattenValues(cond.Values), true
+		}
+	case clause.Expr:
+		if matches := pkExprPattern.FindStringSubmatch(cond.SQL); matches != nil && matches[1] == pk.DBName && len(cond.Vars) == 1 {
+			return flattenValues(cond.Vars), true
+		}
+	}
+
+	return nil, false
+}
+
+func isPrimaryColumn(stmt *gorm.Statement, pk *schema.Field, column interface{}) bool {
+	switch col := column.(type) {
+	case string:
+		return col == pk.DBName
+	case clause.Column:
+		if col.Raw || (col.Table != "" && col.Table != clause.CurrentTable && col.Table != stmt.Table) {
+			return false
+		}
+		return col.Name == clause.PrimaryKey || col.Name == pk.DBName
+	}
+	return false
+}
+
+// flattenValues dereferences pointers and expands slices, a nil value makes the keys unknown
+func flattenValues(values []interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		rv := reflect.ValueOf(value)
+		for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+
+		switch {
+		case !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()):
+			return nil
+		case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8:
+			for i := 0; i < rv.Len(); i++ {
+				nested := flattenValues([]interface{}{rv.Index(i).Interface()})
+				if nested == nil {
+					return nil
+				}
+				keys = append(keys, nested...)
+			}
+		default:
+			keys = append(keys, rv.Interface())
+		}
+	}
+	return keys
+}
+
+// assignsPrimaryKey checks whether an update changes the primary key, which moves the row to another row tag
+func assignsPrimaryKey(stmt *gorm.Statement) bool {
+	c, ok := stmt.Clauses["SET"]
+	if !ok {
+		return false
+	}
+
+	set, ok := c.Expression.(clause.Set)
+	if !ok {
+		return false
+	}
+
+	for _, assignment := range set {
+		if assignment.Column.Name == stmt.Schema.PrioritizedPrimaryField.DBName {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlainInsert checks whether a statement is an INSERT without ON CONFLICT, whose rows are the created models
+func isPlainInsert(stmt *gorm.Statement) bool {
+	_, insert := stmt.Clauses["INSERT"]
+	_, onConflict := stmt.Clauses["ON CONFLICT"]
+	return insert && !onConflict
+}
+
+// modelKeys returns the primary keys of the created models, any model without primary key makes the keys unknown
+func modelKeys(db *gorm.DB) ([]interface{}, bool) {
+	stmt := db.Statement
+	pk := stmt.Schema.PrioritizedPrimaryField
+	value := reflect.Indirect(stmt.ReflectValue)
+
+	var rows []reflect.Value
+	switch value.Kind() {
+	case reflect.Struct:
+		rows = []reflect.Value{value}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			rows = append(rows, reflect.Indirect(value.Index(i)))
+		}
+	default:
+		return nil, false
+	}
+
+	keys := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if row.Kind() != reflect.Struct {
+			return nil, false
+		}
+		key, zero := pk.ValueOf(stmt.Context, row)
+		if zero {
+			return nil, false
+		}
+		keys = append(keys, key)
+	}
+	return keys, len(keys) > 0
+}
+
+func rowTags(table string, keys []interface{}) []string {
+	tags := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, RowTag(table, key))
+	}
+	return tags
+}
@@ -0,0 +1,117 @@
+package caches
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+type rowUser struct {
+	ID   int64
+	Name string
+	Age  int
+}
+
+func (rowUser) TableName() string {
+	return "users"
+}
+
+func TestCaches_RowLevel(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if db, _ := db.DB(); db != nil {
+			_ = db.Close()
+		}
+	}()
+
+	cacher := newTestGoCacher()
+	require.NoError(t, db.Use(&Caches{Conf: &Config{Cacher: cacher, RowLevel: true}}))
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`INSERT INTO users (name, age) VALUES ('Alice', 28), ('Bob', 30)`)
+	require.NoError(t, err)
+
+	// 绕过 gorm 修改数据，用于判断查询结果是否来自缓存
+	renameBypassing := func(id int64, name string) {
+		_, err := sqlDB.Exec(`UPDATE users SET name = $1 WHERE id = $2`, name, id)
+		require.NoError(t, err)
+	}
+	find := func(id int64) string {
+		var users []rowUser
+		require.NoError(t, db.Find(&users, id).Error)
+		require.Len(t, users, 1)
+		return users[0].Name
+	}
+	list := func() []string {
+		var names []string
+		require.NoError(t, db.Model(&rowUser{}).Order("id").Pluck("name", &names).Error)
+		return names
+	}
+
+	assert.Equal(t, "Alice", find(1))
+	assert.Equal(t, "Bob", find(2))
+	assert.Equal(t, []string{"Alice", "Bob"}, list())
+
+	t.Run("按主键更新只失效对应行和非主键查询", func(t *testing.T) {
+		renameBypassing(1, "Alice2")
+		require.NoError(t, db.Model(&rowUser{ID: 2}).Update("age", 31).Error)
+
+		assert.Equal(t, "Alice", find(1))
+		assert.Equal(t, "Bob", find(2))
+		assert.Equal(t, []string{"Alice2", "Bob"}, list())
+
+		renameBypassing(2, "Bob2")
+		require.NoError(t, db.Where("id = ?", 2).Delete(&rowUser{}).Error)
+		assert.Equal(t, "Alice", find(1))
+		assert.Equal(t, []string{"Alice2"}, list())
+
+		require.NoError(t, db.Create(&rowUser{ID: 3, Name: "Carol"}).Error)
+		assert.Equal(t, "Alice", find(1))
+		assert.Equal(t, []string{"Alice2", "Carol"}, list())
+	})
+
+	t.Run("无法确定主键的写入失效整张表", func(t *testing.T) {
+		require.NoError(t, db.Model(&rowUser{}).Where("name = ?", "Alice2").Update("age", 29).Error)
+		assert.Equal(t, "Alice2", find(1))
+
+		renameBypassing(1, "Alice3")
+		require.NoError(t, db.Exec(`UPDATE users SET age = 30 WHERE id = 1`).Error)
+		assert.Equal(t, "Alice3", find(1))
+	})
+}
+
+func TestWhereKeys(t *testing.T) {
+	db := setupTestDB(t)
+	defer func() {
+		if db, _ := db.DB(); db != nil {
+			_ = db.Close()
+		}
+	}()
+
+	keysOf := func(query func(tx *gorm.DB) *gorm.DB) ([]interface{}, bool) {
+		var users []rowUser
+		stmt := query(db.Session(&gorm.Session{DryRun: true})).Find(&users).Statement
+		return whereKeys(stmt)
+	}
+
+	keys, ok := keysOf(func(tx *gorm.DB) *gorm.DB { return tx.Where("id = ?", 1) })
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{1}, keys)
+
+	keys, ok = keysOf(func(tx *gorm.DB) *gorm.DB { return tx.Where("users.id IN ?", []int{1, 2}).Where("age > ?", 18) })
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{1, 2}, keys)
+
+	keys, ok = keysOf(func(tx *gorm.DB) *gorm.DB { return tx.Where(&rowUser{ID: 3}) })
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{int64(3)}, keys)
+
+	_, ok = keysOf(func(tx *gorm.DB) *gorm.DB { return tx.Where("id = ?", 1).Or("name = ?", "Alice") })
+	assert.False(t, ok)
+
+	_, ok = keysOf(func(tx *gorm.DB) *gorm.DB { return tx.Where("age = ?", 1) })
+	assert.False(t, ok)
+}
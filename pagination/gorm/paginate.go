@@ -15,6 +15,7 @@ import (
 	"github.com/bytedance/sonic"
 	"github.com/pkg/errors"
 	"github.com/unionj-cloud/toolkit/sliceutils"
+	"github.com/unionj-cloud/toolkit/tenant"
 
 	"github.com/morkid/gocache"
 	"github.com/wubin1989/gorm"
@@ -154,6 +155,10 @@ func (r *resContext) Response(res interface{}) Page {
 
 	if nil != p.Config.CacheAdapter {
 		cKey = createCacheKey(r.cachePrefix, pr)
+		if tenantID, ok := tenant.FromContext(query.Statement.Context); ok && cKey != "" {
+			// pages of different tenants must never share a cache entry
+			cKey = fmt.Sprintf("%s:%v", cKey, tenantID)
+		}
 		adapter = *p.Config.CacheAdapter
 		hasAdapter = true
 		if cKey != "" && adapter.IsValid(cKey) {
@@ -0,0 +1,296 @@
+package tenant
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// scopedClause 标记语句已添加租户条件，避免重复添加
+const scopedClause = "tenant_scoped"
+
+// Config 插件配置
+type Config struct {
+	// 租户列名或字段名，为空时使用 DefaultColumn
+	Column string
+
+	// 是否在上下文中没有租户时拒绝访问带有租户列的模型
+	Strict bool
+}
+
+// Option 插件配置选项
+type Option func(*Config)
+
+// WithColumn 配置租户列名
+func WithColumn(column string) Option {
+	return func(c *Config) {
+		c.Column = column
+	}
+}
+
+// WithStrict 配置严格模式，上下文中没有租户且未调用 SkipScope 时返回 ErrMissingTenant
+func WithStrict(strict bool) Option {
+	return func(c *Config) {
+		c.Strict = strict
+	}
+}
+
+// Plugin 多租户行隔离GORM插件
+// 只作用于能解析出模型且模型带有租户列的语句：查询（包括作为子查询和预加载的查询）、更新和删除添加租户条件，
+// 创建时写入租户ID；写入的模型或更新的值属于其他租户时返回 ErrCrossTenant。原生 SQL 和以子查询为表的外层查询不受影响
+type Plugin struct {
+	config *Config
+}
+
+// NewPlugin 创建多租户插件
+func NewPlugin(options ...Option) *Plugin {
+	config := &Config{Column: DefaultColumn}
+	for _, option := range options {
+		option(config)
+	}
+
+	return &Plugin{config: config}
+}
+
+// Name 实现gorm.Plugin接口
+func (p *Plugin) Name() string {
+	return "tenant"
+}
+
+// Initialize 实现gorm.Plugin接口
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("tenant:query", p.scope); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Row().Before("gorm:row").Register("tenant:row", p.scope); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Create().Before("gorm:create").Register("tenant:create", p.stamp); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Update().Before("gorm:update").Register("tenant:update", p.guard); err != nil {
+		return err
+	}
+
+	return db.Callback().Delete().Before("gorm:delete").Register("tenant:delete", p.guard)
+}
+
+// scope 为查询添加租户条件
+func (p *Plugin) scope(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	field := p.fieldOf(db.Statement)
+	if field == nil {
+		return
+	}
+
+	tenantID, ok, err := p.tenantOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if ok {
+		addCondition(db.Statement, field, tenantID)
+	}
+}
+
+// stamp 创建时写入租户ID
+func (p *Plugin) stamp(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	field := p.fieldOf(db.Statement)
+	if field == nil {
+		return
+	}
+
+	tenantID, ok, err := p.tenantOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if err := stampValue(db.Statement.Context, db.Statement.ReflectValue, field, tenantID); err != nil {
+		db.AddError(fmt.Errorf("failed to create %s: %w", db.Statement.Schema.Table, err))
+	}
+}
+
+// guard 拒绝更新或删除其他租户的行，并为语句添加租户条件
+func (p *Plugin) guard(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	field := p.fieldOf(db.Statement)
+	if field == nil {
+		return
+	}
+
+	tenantID, ok, err := p.tenantOf(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if err := checkValue(db.Statement.Context, db.Statement.ReflectValue, field, tenantID); err != nil {
+		db.AddError(fmt.Errorf("failed to write %s: %w", db.Statement.Schema.Table, err))
+		return
+	}
+
+	// 更新的值不能将行移交给其他租户
+	if err := checkAssignments(db.Statement, field, tenantID); err != nil {
+		db.AddError(fmt.Errorf("failed to update %s: %w", db.Statement.Schema.Table, err))
+		return
+	}
+
+	addCondition(db.Statement, field, tenantID)
+}
+
+// fieldOf 获取语句模型的租户字段，以子查询为表的语句返回 nil
+func (p *Plugin) fieldOf(stmt *gorm.Statement) *schema.Field {
+	if stmt.Schema == nil {
+		return nil
+	}
+
+	if stmt.TableExpr != nil && strings.Contains(stmt.TableExpr.SQL, "(") {
+		return nil
+	}
+
+	return lookUpField(stmt.Schema, p.config.Column)
+}
+
+// tenantOf 获取语句上下文中的租户，严格模式下缺少租户时返回 ErrMissingTenant
+func (p *Plugin) tenantOf(db *gorm.DB) (interface{}, bool, error) {
+	ctx := db.Statement.Context
+	tenantID, ok := FromContext(ctx)
+	if !ok && p.config.Strict && !IsSkipped(ctx) {
+		return nil, false, fmt.Errorf("failed to access %s: %w", db.Statement.Schema.Table, ErrMissingTenant)
+	}
+	return tenantID, ok, nil
+}
+
+// addCondition 添加租户条件，已有的 OR 条件整体加括号，避免租户条件只约束最后一个分支
+func addCondition(stmt *gorm.Statement, field *schema.Field, tenantID interface{}) {
+	if _, scoped := stmt.Clauses[scopedClause]; scoped {
+		return
+	}
+
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+			for _, expr := range where.Exprs {
+				if orCond, ok := expr.(clause.OrConditions); ok && len(orCond.Exprs) == 1 {
+					where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+					c.Expression = where
+					stmt.Clauses["WHERE"] = c
+					break
+				}
+			}
+		}
+	}
+
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenantID},
+	}})
+	stmt.Clauses[scopedClause] = clause.Clause{}
+}
+
+// checkAssignments 检查更新的值中的租户列
+func checkAssignments(stmt *gorm.Statement, field *schema.Field, tenantID interface{}) error {
+	var check func(value interface{}) error
+	check = func(value interface{}) error {
+		// 批量更新以 CASE 表达式为每行选择值，每个分支的值都必须是当前租户
+		if expr, ok := value.(clause.Expr); ok {
+			if results, ok := caseResults(expr); ok {
+				for _, result := range results {
+					if err := check(result); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+
+		if value != nil && !Equal(value, tenantID) {
+			return fmt.Errorf("%w: %v", ErrCrossTenant, value)
+		}
+		return nil
+	}
+
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{field.DBName, field.Name} {
+			if value, exists := dest[key]; exists {
+				if err := check(value); err != nil {
+					return err
+				}
+			}
+		}
+	case clause.Set:
+		for _, assignment := range dest {
+			if assignment.Column.Name == field.DBName {
+				if err := check(assignment.Value); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		// 以结构体更新时只写入非零值字段
+		value := reflect.Indirect(reflect.ValueOf(dest))
+		if value.Kind() != reflect.Struct {
+			return nil
+		}
+		if current := value.FieldByName(field.Name); current.IsValid() && !current.IsZero() {
+			if err := check(reflect.Indirect(current).Interface()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// caseResults 获取 CASE 表达式中 THEN 和 ELSE 分支的参数，不是 CASE 表达式或分支的值不是参数时返回 false
+func caseResults(expr clause.Expr) ([]interface{}, bool) {
+	tokens := strings.Fields(strings.ReplaceAll(expr.SQL, "?", " ? "))
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "CASE") || !strings.EqualFold(tokens[len(tokens)-1], "END") {
+		return nil, false
+	}
+
+	var results []interface{}
+	index := 0
+	for i, token := range tokens {
+		branch := i > 0 && (strings.EqualFold(tokens[i-1], "THEN") || strings.EqualFold(tokens[i-1], "ELSE"))
+		if token != "?" {
+			if branch {
+				return nil, false
+			}
+			continue
+		}
+
+		if index >= len(expr.Vars) {
+			return nil, false
+		}
+		if branch {
+			results = append(results, expr.Vars[index])
+		}
+		index++
+	}
+
+	return results, len(results) > 0
+}
@@ -0,0 +1,167 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+	"github.com/wubin1989/sqlite"
+
+	paginate "github.com/unionj-cloud/toolkit/pagination/gorm"
+	"github.com/unionj-cloud/toolkit/tenant"
+)
+
+type Document struct {
+	ID       uint
+	TenantID uint
+	Title    string
+}
+
+// pageParameter 分页请求参数
+type pageParameter struct {
+	Page int32
+	Size int32
+}
+
+func (p pageParameter) GetPage() int32          { return p.Page }
+func (p pageParameter) GetSize() int32          { return p.Size }
+func (p pageParameter) GetSort() string         { return "" }
+func (p pageParameter) GetOrder() string        { return "" }
+func (p pageParameter) GetFields() string       { return "" }
+func (p pageParameter) GetFilters() interface{} { return nil }
+func (p pageParameter) IParameterInstance()     {}
+
+func setupTestDB(t *testing.T, options ...tenant.Option) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Document{}))
+	require.NoError(t, db.Use(tenant.NewPlugin(options...)))
+
+	admin := db.WithContext(tenant.SkipScope(context.Background()))
+	require.NoError(t, admin.Create(&[]Document{
+		{TenantID: 1, Title: "a"},
+		{TenantID: 1, Title: "b"},
+		{TenantID: 2, Title: "a"},
+	}).Error)
+
+	return db
+}
+
+// TestPlugin 测试多租户插件
+func TestPlugin(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), uint(1))
+
+	t.Run("查询只返回当前租户的行", func(t *testing.T) {
+		db := setupTestDB(t)
+
+		var docs []Document
+		require.NoError(t, db.WithContext(ctx).Find(&docs).Error)
+		assert.Len(t, docs, 2)
+
+		// OR 条件整体受租户条件约束
+		require.NoError(t, db.WithContext(ctx).Where("title = ?", "a").Or("title = ?", "b").Find(&docs).Error)
+		assert.Len(t, docs, 2)
+
+		var count int64
+		require.NoError(t, db.WithContext(tenant.WithTenant(context.Background(), "2")).Model(&Document{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+
+		require.NoError(t, db.Find(&docs).Error)
+		assert.Len(t, docs, 3)
+	})
+
+	t.Run("创建时写入租户并拒绝其他租户的行", func(t *testing.T) {
+		db := setupTestDB(t)
+
+		doc := &Document{Title: "c"}
+		require.NoError(t, db.WithContext(ctx).Create(doc).Error)
+		assert.Equal(t, uint(1), doc.TenantID)
+
+		err := db.WithContext(ctx).Create(&Document{TenantID: 2, Title: "d"}).Error
+		assert.True(t, errors.Is(err, tenant.ErrCrossTenant))
+	})
+
+	t.Run("拒绝更新或删除其他租户的行", func(t *testing.T) {
+		db := setupTestDB(t)
+
+		var other Document
+		require.NoError(t, db.Where("tenant_id = ?", 2).First(&other).Error)
+
+		other.Title = "changed"
+		assert.True(t, errors.Is(db.WithContext(ctx).Save(&other).Error, tenant.ErrCrossTenant))
+		assert.True(t, errors.Is(db.WithContext(ctx).Delete(&other).Error, tenant.ErrCrossTenant))
+
+		result := db.WithContext(ctx).Model(&Document{}).Where("id = ?", other.ID).Update("title", "changed")
+		require.NoError(t, result.Error)
+		assert.Zero(t, result.RowsAffected)
+
+		var own Document
+		require.NoError(t, db.WithContext(ctx).First(&own).Error)
+		err := db.WithContext(ctx).Model(&own).Updates(map[string]interface{}{"tenant_id": 2}).Error
+		assert.True(t, errors.Is(err, tenant.ErrCrossTenant))
+		err = db.WithContext(ctx).Model(&own).Updates(Document{TenantID: 2}).Error
+		assert.True(t, errors.Is(err, tenant.ErrCrossTenant))
+
+		// 批量更新的 CASE 表达式中每个分支都必须是当前租户
+		caseOf := func(tenants ...interface{}) clause.Expr {
+			return clause.Expr{SQL: "CASE ? WHEN ? THEN ? WHEN ? THEN ? END", Vars: append([]interface{}{clause.Column{Name: "id"}}, tenants...)}
+		}
+		err = db.WithContext(ctx).Model(&Document{}).Where("id IN ?", []uint{1, 2}).
+			Updates(map[string]interface{}{"tenant_id": caseOf(1, 1, 2, 2)}).Error
+		assert.True(t, errors.Is(err, tenant.ErrCrossTenant))
+		err = db.WithContext(ctx).Model(&Document{}).Where("id IN ?", []uint{1, 2}).
+			Updates(map[string]interface{}{"tenant_id": caseOf(1, 1, 2, 1)}).Error
+		assert.NoError(t, err)
+
+		require.NoError(t, db.First(&other, other.ID).Error)
+		assert.Equal(t, "a", other.Title)
+	})
+
+	t.Run("严格模式要求上下文中有租户", func(t *testing.T) {
+		db := setupTestDB(t, tenant.WithStrict(true))
+
+		var docs []Document
+		assert.True(t, errors.Is(db.Find(&docs).Error, tenant.ErrMissingTenant))
+		require.NoError(t, db.WithContext(tenant.SkipScope(ctx)).Find(&docs).Error)
+		assert.Len(t, docs, 3)
+	})
+
+	t.Run("分页的子查询同样按租户过滤", func(t *testing.T) {
+		db := setupTestDB(t)
+
+		var docs []Document
+		page := paginate.New().
+			With(db.WithContext(ctx).Model(&Document{})).
+			Request(pageParameter{Page: 0, Size: 10}).
+			Response(&docs)
+
+		assert.Equal(t, int32(2), page.Total)
+		assert.Len(t, docs, 2)
+		for _, doc := range docs {
+			assert.Equal(t, uint(1), doc.TenantID)
+		}
+	})
+}
+
+// TestFieldOf 测试按数据库的命名策略查找租户字段
+func TestFieldOf(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{NameReplacer: strings.NewReplacer("Tenant", "Org")},
+	})
+	require.NoError(t, err)
+
+	documentType := reflect.TypeOf(&Document{})
+	field := tenant.FieldOf(db, documentType, "org_id")
+	require.NotNil(t, field)
+	assert.Equal(t, "TenantID", field.Name)
+
+	assert.Nil(t, tenant.FieldOf(nil, documentType, "org_id"))
+	assert.NotNil(t, tenant.FieldOf(nil, documentType, ""))
+}
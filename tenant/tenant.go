@@ -0,0 +1,195 @@
+// Package tenant 共享表结构的多租户行隔离
+//
+// 租户ID通过 WithTenant 写入上下文，Plugin 为带有租户列的模型的查询、更新和删除添加租户条件，
+// 创建时写入租户ID，并拒绝写入其他租户的行。unitofwork 与 pagination 读取同一上下文
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// DefaultColumn 默认租户列名
+const DefaultColumn = "tenant_id"
+
+var (
+	// ErrMissingTenant 严格模式下访问带有租户列的模型时上下文中没有租户
+	ErrMissingTenant = errors.New("tenant is required")
+
+	// ErrCrossTenant 写入的行属于其他租户
+	ErrCrossTenant = errors.New("row belongs to another tenant")
+)
+
+type tenantKey struct{}
+
+type skipKey struct{}
+
+// WithTenant 将租户ID写入上下文
+func WithTenant(ctx context.Context, tenantID interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// FromContext 从上下文获取租户ID，上下文被 SkipScope 标记时返回 false
+func FromContext(ctx context.Context) (interface{}, bool) {
+	if ctx == nil || IsSkipped(ctx) {
+		return nil, false
+	}
+
+	tenantID := ctx.Value(tenantKey{})
+	return tenantID, tenantID != nil
+}
+
+// SkipScope 标记上下文跳过租户隔离，用于跨租户的管理任务和数据迁移
+func SkipScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipKey{}, true)
+}
+
+// IsSkipped 检查上下文是否跳过租户隔离
+func IsSkipped(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	skipped, _ := ctx.Value(skipKey{}).(bool)
+	return skipped
+}
+
+// Stamp 将上下文中的租户ID写入 value 的租户字段，value 可以是结构体指针或结构体切片，db 用于解析模型，可以为 nil
+// 租户字段已有其他租户的值时返回 ErrCrossTenant；没有租户字段或上下文中没有租户时什么也不做
+func Stamp(ctx context.Context, db *gorm.DB, value interface{}, column string) error {
+	tenantID, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	field := FieldOf(db, reflect.TypeOf(value), column)
+	if field == nil {
+		return nil
+	}
+
+	return stampValue(ctx, reflect.ValueOf(value), field, tenantID)
+}
+
+// Check 检查 value 的租户字段是否属于上下文中的租户，value 可以是结构体指针或结构体切片，db 用于解析模型，可以为 nil
+// 租户字段为零值时视为未分配租户；没有租户字段或上下文中没有租户时什么也不做
+func Check(ctx context.Context, db *gorm.DB, value interface{}, column string) error {
+	tenantID, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	field := FieldOf(db, reflect.TypeOf(value), column)
+	if field == nil {
+		return nil
+	}
+
+	return checkValue(ctx, reflect.ValueOf(value), field, tenantID)
+}
+
+// stampValue 为租户字段为零值的结构体写入租户ID
+func stampValue(ctx context.Context, value reflect.Value, field *schema.Field, tenantID interface{}) error {
+	return eachStruct(value, func(rv reflect.Value) error {
+		current, zero := field.ValueOf(ctx, rv)
+		if !zero {
+			if !Equal(current, tenantID) {
+				return fmt.Errorf("%w: %v", ErrCrossTenant, current)
+			}
+			return nil
+		}
+
+		if err := field.Set(ctx, rv, tenantID); err != nil {
+			return fmt.Errorf("failed to set tenant of %s: %w", rv.Type(), err)
+		}
+		return nil
+	})
+}
+
+// checkValue 检查结构体的租户字段为零值或等于 tenantID
+func checkValue(ctx context.Context, value reflect.Value, field *schema.Field, tenantID interface{}) error {
+	return eachStruct(value, func(rv reflect.Value) error {
+		if current, zero := field.ValueOf(ctx, rv); !zero && !Equal(current, tenantID) {
+			return fmt.Errorf("%w: %v", ErrCrossTenant, current)
+		}
+		return nil
+	})
+}
+
+// Equal 比较两个租户ID，不同类型按格式化后的字符串比较，例如 uint(1) 与 "1" 相等
+func Equal(a, b interface{}) bool {
+	return a == b || fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// schemaCache 模型解析缓存
+var schemaCache sync.Map
+
+// FieldOf 获取模型类型的租户字段，column 可以是列名或字段名，为空时使用 DefaultColumn
+// 按 db 的命名策略解析模型，db 为 nil 时使用默认命名策略；模型不是结构体或没有该字段时返回 nil
+func FieldOf(db *gorm.DB, modelType reflect.Type, column string) *schema.Field {
+	if modelType == nil {
+		return nil
+	}
+
+	for modelType.Kind() == reflect.Ptr || modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Array {
+		modelType = modelType.Elem()
+	}
+
+	if modelType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	model := reflect.New(modelType).Interface()
+	if db != nil {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil
+		}
+		return lookUpField(stmt.Schema, column)
+	}
+
+	parsed, err := schema.Parse(model, &schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil
+	}
+
+	return lookUpField(parsed, column)
+}
+
+// lookUpField 在已解析的模型中查找租户字段
+func lookUpField(parsed *schema.Schema, column string) *schema.Field {
+	if column == "" {
+		column = DefaultColumn
+	}
+
+	field := parsed.LookUpField(column)
+	if field == nil || field.DBName == "" {
+		return nil
+	}
+	return field
+}
+
+// eachStruct 对 value 中的每个结构体调用 fn
+func eachStruct(value reflect.Value, fn func(reflect.Value) error) error {
+	value = reflect.Indirect(value)
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return fn(value)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			elem := reflect.Indirect(value.Index(i))
+			if elem.Kind() != reflect.Struct {
+				continue
+			}
+			if err := fn(elem); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
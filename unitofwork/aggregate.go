@@ -0,0 +1,200 @@
+package unitofwork
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// DeleteAggregate 删除聚合根及其通过 has-one、has-many 关联的所有子实体
+// 子实体从数据库逐层加载并纳入跟踪，从最深的一层开始按 DependencyManager.GetDeletionOrder 的顺序注册删除，
+// 实现 SoftDelete 的实体软删除。关联字段标记 `unitofwork:"nocascade"` 时不级联，
+// 级联的层数由 WithCascadeDepth 限制
+func (uow *UnitOfWork) DeleteAggregate(root Entity) error {
+	if root == nil {
+		return fmt.Errorf("entity cannot be nil")
+	}
+
+	if uow.db == nil {
+		return fmt.Errorf("database is required to delete aggregate %T", root)
+	}
+
+	uow.mu.RLock()
+	finished := uow.isCommitted || uow.isRolledBack
+	uow.mu.RUnlock()
+	if finished {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	levels, err := uow.loadAggregate(root)
+	if err != nil {
+		return fmt.Errorf("failed to load aggregate %T with id %v: %w", root, IdentityOf(root), err)
+	}
+
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	// 已跟踪的子实体以跟踪的实例注册删除
+	entities := []Entity{root}
+	for depth := 1; depth < len(levels); depth++ {
+		for i, entity := range levels[depth] {
+			levels[depth][i] = uow.attach(entity)
+		}
+		entities = append(entities, levels[depth]...)
+	}
+
+	// 删除顺序依赖关联关系，不受 EnableAutoDependency 影响
+	_, entityTypes := groupEntitiesByType(entities)
+	for _, entityType := range entityTypes {
+		if err := uow.dependencyManager.DiscoverDependencies(uow.db, entityType); err != nil {
+			return fmt.Errorf("failed to delete aggregate %T with id %v: %w", root, IdentityOf(root), err)
+		}
+	}
+
+	// 从最深的一层开始注册删除，自引用关联的子实体先于父实体删除
+	for depth := len(levels) - 1; depth >= 0; depth-- {
+		ordered, err := uow.dependencyManager.GetDeletionOrder(levels[depth])
+		if err != nil {
+			return fmt.Errorf("failed to delete aggregate %T with id %v: %w", root, IdentityOf(root), err)
+		}
+
+		for _, entity := range ordered {
+			if err := uow.delete(entity, depth); err != nil {
+				return fmt.Errorf("failed to delete entity %T with id %v of aggregate %T: %w", entity, IdentityOf(entity), root, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadAggregate 逐层加载聚合根的子实体，同一层同类型的父实体合并为一次查询，
+// 返回按层级排列的实体，第一层只包含聚合根
+func (uow *UnitOfWork) loadAggregate(root Entity) ([][]Entity, error) {
+	levels := [][]Entity{{root}}
+	visited := map[string]bool{identityKey(reflect.TypeOf(root), IdentityOf(root)): true}
+
+	level := []Entity{root}
+	for depth := 1; len(level) > 0; depth++ {
+		if uow.config.CascadeDepth > 0 && depth > uow.config.CascadeDepth {
+			break
+		}
+
+		parents, parentTypes := groupEntitiesByType(level)
+		level = nil
+
+		for _, parentType := range parentTypes {
+			children, err := uow.loadChildren(parentType, parents[parentType])
+			if err != nil {
+				return nil, err
+			}
+
+			for _, child := range children {
+				key := identityKey(reflect.TypeOf(child), IdentityOf(child))
+				if visited[key] {
+					continue
+				}
+				visited[key] = true
+
+				level = append(level, child)
+			}
+		}
+
+		if len(level) > 0 {
+			levels = append(levels, level)
+		}
+	}
+
+	return levels, nil
+}
+
+// sortByAggregateDepth 按依赖排序后，同类型的删除操作按聚合中的层级从深到浅排列，
+// 按ID排序无法保证自引用关联的子实体先于父实体删除
+func sortByAggregateDepth(operations []Operation) {
+	depthOf := func(operation Operation) int {
+		if deleteOp, ok := operation.(*DeleteOperation); ok {
+			return deleteOp.depth
+		}
+		return 0
+	}
+
+	for start := 0; start < len(operations); {
+		end := start + 1
+		for end < len(operations) && operations[end].GetEntityType() == operations[start].GetEntityType() {
+			end++
+		}
+
+		group := operations[start:end]
+		sort.SliceStable(group, func(i, j int) bool {
+			return depthOf(group[i]) > depthOf(group[j])
+		})
+		start = end
+	}
+}
+
+// loadChildren 加载一组同类型父实体通过 has-one、has-many 关联的子实体
+func (uow *UnitOfWork) loadChildren(parentType reflect.Type, parents []Entity) ([]Entity, error) {
+	persisted := reflect.MakeSlice(reflect.SliceOf(parentType), 0, len(parents))
+	for _, parent := range parents {
+		// 尚未插入的实体没有持久化的子实体
+		if !parent.IsNew() {
+			persisted = reflect.Append(persisted, reflect.ValueOf(parent))
+		}
+	}
+	if persisted.Len() == 0 {
+		return nil, nil
+	}
+
+	stmt := &gorm.Statement{DB: uow.db}
+	if err := stmt.Parse(reflect.New(parentType.Elem()).Interface()); err != nil {
+		return nil, fmt.Errorf("failed to parse schema of %s: %w", parentType, err)
+	}
+
+	relationships := make([]*schema.Relationship, 0, len(stmt.Schema.Relationships.HasOne)+len(stmt.Schema.Relationships.HasMany))
+	relationships = append(relationships, stmt.Schema.Relationships.HasOne...)
+	relationships = append(relationships, stmt.Schema.Relationships.HasMany...)
+
+	var children []Entity
+	for _, relationship := range relationships {
+		if hasTagOption(relationship.Field.Tag, "nocascade") {
+			continue
+		}
+
+		childType := reflect.PtrTo(relationship.FieldSchema.ModelType)
+		if !childType.Implements(entityInterfaceType) {
+			return nil, fmt.Errorf("association %s.%s: type %s does not implement Entity", parentType, relationship.Name, childType)
+		}
+
+		loaded := reflect.New(reflect.SliceOf(childType))
+		conditions := relationship.ToQueryConditions(uow.ctx, persisted)
+		db := uow.db.Session(&gorm.Session{NewDB: true, Context: uow.queryContext()})
+		if err := db.Clauses(clause.Where{Exprs: conditions}).Find(loaded.Interface()).Error; err != nil {
+			return nil, fmt.Errorf("failed to load association %s.%s: %w", parentType, relationship.Name, err)
+		}
+
+		for i := 0; i < loaded.Elem().Len(); i++ {
+			children = append(children, loaded.Elem().Index(i).Interface().(Entity))
+		}
+	}
+
+	return children, nil
+}
+
+// hasTagOption 判断 unitofwork 标签是否包含指定选项，多个选项以逗号分隔
+func hasTagOption(tag reflect.StructTag, option string) bool {
+	for _, value := range strings.Split(tag.Get("unitofwork"), ",") {
+		if strings.TrimSpace(value) == option {
+			return true
+		}
+	}
+	return false
+}
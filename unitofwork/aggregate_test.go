@@ -0,0 +1,270 @@
+package unitofwork
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/logger"
+	"github.com/wubin1989/sqlite"
+
+	"github.com/unionj-cloud/toolkit/tenant"
+)
+
+// 示例聚合：论坛包含设置、主题和回复，版主不随论坛删除
+type Forum struct {
+	BaseEntity
+	Name       string `gorm:"size:100"`
+	Setting    *ForumSetting
+	Threads    []Thread
+	Moderators []Moderator `unitofwork:"nocascade"`
+}
+
+func (f *Forum) GetTableName() string {
+	return "forums"
+}
+
+type ForumSetting struct {
+	BaseEntity
+	ForumID uint   `gorm:"not null;index"`
+	Theme   string `gorm:"size:50"`
+}
+
+func (s *ForumSetting) GetTableName() string {
+	return "forum_settings"
+}
+
+type Thread struct {
+	ID      uint   `gorm:"primaryKey"`
+	ForumID uint   `gorm:"not null;index"`
+	Title   string `gorm:"size:200"`
+	Replies []*Reply
+}
+
+func (t *Thread) GetTableName() string {
+	return "threads"
+}
+
+func (t *Thread) IsNew() bool {
+	return t.ID == 0
+}
+
+type Reply struct {
+	ID       uint   `gorm:"primaryKey"`
+	ThreadID uint   `gorm:"not null;index"`
+	Body     string `gorm:"type:text"`
+}
+
+func (r *Reply) GetTableName() string {
+	return "replies"
+}
+
+func (r *Reply) IsNew() bool {
+	return r.ID == 0
+}
+
+type Moderator struct {
+	ID      uint   `gorm:"primaryKey"`
+	ForumID uint   `gorm:"not null;index"`
+	Name    string `gorm:"size:100"`
+}
+
+func (m *Moderator) GetTableName() string {
+	return "moderators"
+}
+
+func (m *Moderator) IsNew() bool {
+	return m.ID == 0
+}
+
+// 示例聚合：自引用的目录树
+type Folder struct {
+	ID       uint      `gorm:"primaryKey"`
+	ParentID *uint     `gorm:"index"`
+	Name     string    `gorm:"size:100"`
+	Children []*Folder `gorm:"foreignKey:ParentID"`
+}
+
+func (f *Folder) GetTableName() string {
+	return "folders"
+}
+
+func (f *Folder) IsNew() bool {
+	return f.ID == 0
+}
+
+// 示例聚合：按租户隔离的项目和任务
+type Project struct {
+	BaseEntity
+	TenantID uint `gorm:"index"`
+	Name     string
+	Tasks    []ProjectTask
+}
+
+func (p *Project) GetTableName() string {
+	return "projects"
+}
+
+type ProjectTask struct {
+	ID        uint `gorm:"primaryKey"`
+	ProjectID uint `gorm:"index"`
+	TenantID  uint `gorm:"index"`
+	Title     string
+}
+
+func (t *ProjectTask) GetTableName() string {
+	return "project_tasks"
+}
+
+func (t *ProjectTask) IsNew() bool {
+	return t.ID == 0
+}
+
+func setupAggregateTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:?_foreign_keys=1"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	require.NoError(t, db.AutoMigrate(&Forum{}, &ForumSetting{}, &Thread{}, &Reply{}, &Moderator{}, &Folder{}, &Project{}, &ProjectTask{}))
+	return db
+}
+
+func seedForum(t *testing.T, db *gorm.DB, name string, threads, replies int) *Forum {
+	forum := &Forum{Name: name, Setting: &ForumSetting{Theme: "dark"}}
+	for i := 0; i < threads; i++ {
+		thread := Thread{Title: name}
+		for j := 0; j < replies; j++ {
+			thread.Replies = append(thread.Replies, &Reply{Body: name})
+		}
+		forum.Threads = append(forum.Threads, thread)
+	}
+	require.NoError(t, db.Create(forum).Error)
+	return forum
+}
+
+// TestUnitOfWork_DeleteAggregate 测试按关联关系级联删除聚合
+func TestUnitOfWork_DeleteAggregate(t *testing.T) {
+	t.Run("按依赖顺序删除所有子实体", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		seeded := seedForum(t, db, "go", 2, 2)
+		seedForum(t, db, "rust", 1, 1)
+
+		uow := NewUnitOfWork(db)
+		var forum *Forum
+		require.NoError(t, uow.Find(&forum, seeded.ID))
+		require.NoError(t, uow.DeleteAggregate(forum))
+		assert.Len(t, uow.PendingEntities(OperationTypeDelete), 8)
+
+		// 外键约束下回复必须先于主题删除，论坛和设置软删除
+		require.NoError(t, uow.Commit())
+
+		var forums, threads, replies, settings, deletedSettings int64
+		require.NoError(t, db.Model(&Forum{}).Count(&forums).Error)
+		require.NoError(t, db.Model(&Thread{}).Count(&threads).Error)
+		require.NoError(t, db.Model(&Reply{}).Count(&replies).Error)
+		require.NoError(t, db.Model(&ForumSetting{}).Count(&settings).Error)
+		require.NoError(t, db.Unscoped().Model(&ForumSetting{}).Where("deleted_at IS NOT NULL").Count(&deletedSettings).Error)
+		assert.Equal(t, int64(1), forums)
+		assert.Equal(t, int64(1), threads)
+		assert.Equal(t, int64(1), replies)
+		assert.Equal(t, int64(1), settings)
+		assert.Equal(t, int64(1), deletedSettings)
+	})
+
+	t.Run("已跟踪的子实体以同一实例删除", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		seeded := seedForum(t, db, "go", 2, 0)
+
+		uow := NewUnitOfWork(db)
+		var thread *Thread
+		require.NoError(t, uow.Find(&thread, seeded.Threads[0].ID))
+		thread.Title = "已修改"
+		require.NoError(t, uow.Update(thread))
+
+		var forum *Forum
+		require.NoError(t, uow.Find(&forum, seeded.ID))
+		require.NoError(t, uow.DeleteAggregate(forum))
+
+		assert.Contains(t, uow.PendingEntities(OperationTypeDelete), Entity(thread))
+		assert.Empty(t, uow.PendingEntities(OperationTypeUpdate))
+	})
+
+	t.Run("限制级联层数并跳过不级联的关联", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		seeded := seedForum(t, db, "go", 2, 2)
+		require.NoError(t, db.Create(&Moderator{ForumID: seeded.ID, Name: "张三"}).Error)
+
+		uow := NewUnitOfWork(db, WithCascadeDepth(1))
+		var forum *Forum
+		require.NoError(t, uow.Find(&forum, seeded.ID))
+		require.NoError(t, uow.DeleteAggregate(forum))
+
+		counts := make(map[string]int)
+		for _, entity := range uow.PendingEntities(OperationTypeDelete) {
+			counts[entity.GetTableName()]++
+		}
+		assert.Equal(t, map[string]int{"forums": 1, "forum_settings": 1, "threads": 2}, counts)
+	})
+
+	t.Run("自引用的子实体按层级从深到浅删除", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+
+		// 子目录的ID小于父目录，按ID排序无法得到正确的删除顺序
+		parent := func(id uint) *uint { return &id }
+		require.NoError(t, db.Create(&Folder{ID: 30, Name: "根"}).Error)
+		require.NoError(t, db.Create(&Folder{ID: 20, ParentID: parent(30), Name: "子"}).Error)
+		require.NoError(t, db.Create(&Folder{ID: 25, ParentID: parent(20), Name: "孙"}).Error)
+		require.NoError(t, db.Create(&Folder{ID: 10, ParentID: parent(25), Name: "曾孙"}).Error)
+
+		for _, merge := range []bool{true, false} {
+			uow := NewUnitOfWork(db.Begin(), WithOperationMerge(merge))
+			var root *Folder
+			require.NoError(t, uow.Find(&root, 30))
+			require.NoError(t, uow.DeleteAggregate(root))
+			require.NoError(t, uow.Commit())
+
+			var count int64
+			require.NoError(t, uow.db.Model(&Folder{}).Count(&count).Error)
+			assert.Zero(t, count)
+			require.NoError(t, uow.db.Rollback().Error)
+		}
+	})
+
+	t.Run("子实体按工作单元的上下文加载", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		require.NoError(t, db.Create(&Project{TenantID: 1, Name: "迁移", Tasks: []ProjectTask{
+			{TenantID: 1, Title: "备份"},
+			{TenantID: 2, Title: "其他租户的任务"},
+		}}).Error)
+		require.NoError(t, db.Use(tenant.NewPlugin()))
+
+		ctx := tenant.WithTenant(context.Background(), uint(1))
+		uow := NewUnitOfWork(db).WithContext(ctx)
+		var project *Project
+		require.NoError(t, uow.Find(&project, 1))
+		require.NoError(t, uow.DeleteAggregate(project))
+		assert.Len(t, uow.PendingEntities(OperationTypeDelete), 2)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		uow = NewUnitOfWork(db).WithContext(cancelled)
+		assert.ErrorIs(t, uow.DeleteAggregate(project), context.Canceled)
+	})
+
+	t.Run("新聚合根仅取消注册", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+
+		uow := NewUnitOfWork(db)
+		forum := &Forum{Name: "go"}
+		require.NoError(t, uow.Create(forum))
+		require.NoError(t, uow.DeleteAggregate(forum))
+		assert.Empty(t, uow.PendingEntities())
+	})
+}
@@ -0,0 +1,233 @@
+package unitofwork
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// DefaultAuditTable 默认审计表名
+const DefaultAuditTable = "uow_audit_logs"
+
+// AuditAction 审计动作
+type AuditAction string
+
+const (
+	AuditActionInsert  AuditAction = "INSERT"
+	AuditActionUpdate  AuditAction = "UPDATE"
+	AuditActionDelete  AuditAction = "DELETE"
+	AuditActionUpsert  AuditAction = "UPSERT"
+	AuditActionRestore AuditAction = "RESTORE"
+	AuditActionPurge   AuditAction = "PURGE"
+)
+
+// AuditRecord 审计记录，每个插入、更新、删除、插入或更新、恢复、永久删除的实体对应一条
+type AuditRecord struct {
+	Action     AuditAction            `json:"action"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	Revision   int64                  `json:"revision"`
+	Changes    map[string]FieldChange `json:"changes,omitempty"`
+	Actor      string                 `json:"actor"`
+	Timestamp  time.Time              `json:"timestamp"`
+}
+
+// AuditSink 审计记录接收器，在提交事务内调用，返回错误将导致提交失败
+type AuditSink interface {
+	Write(ctx context.Context, tx *gorm.DB, records []AuditRecord) error
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor 将操作人写入上下文
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFromContext 从上下文获取操作人
+func AuditActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// WithAuditSink 配置审计记录接收器，可多次调用注册多个接收器
+func WithAuditSink(sink AuditSink) ConfigOption {
+	return func(c *Config) {
+		c.AuditSinks = append(c.AuditSinks, sink)
+	}
+}
+
+// pendingAudit 执行操作前收集的审计信息
+type pendingAudit struct {
+	action  AuditAction
+	entity  Entity
+	changes map[string]FieldChange
+}
+
+// prepareAudit 在执行操作前收集审计信息，更新操作的字段变更需在执行前基于快照计算
+func (uow *UnitOfWork) prepareAudit() []pendingAudit {
+	if len(uow.config.AuditSinks) == 0 {
+		return nil
+	}
+
+	audits := make([]pendingAudit, 0, len(uow.operations))
+	for _, operation := range uow.operations {
+		entity := operation.GetEntity()
+		if entity == nil {
+			continue
+		}
+
+		switch operation.GetOperationType() {
+		case OperationTypeInsert:
+			audits = append(audits, pendingAudit{action: AuditActionInsert, entity: entity})
+		case OperationTypeUpdate:
+			changes := uow.lookupSnapshotManager(entity).GetChangedFields(entity)
+			audits = append(audits, pendingAudit{action: AuditActionUpdate, entity: entity, changes: changes})
+		case OperationTypeDelete:
+			audits = append(audits, pendingAudit{action: AuditActionDelete, entity: entity})
+		case OperationTypeUpsert:
+			audits = append(audits, pendingAudit{action: AuditActionUpsert, entity: entity})
+		case OperationTypeRestore:
+			audits = append(audits, pendingAudit{action: AuditActionRestore, entity: entity})
+		case OperationTypePurge:
+			audits = append(audits, pendingAudit{action: AuditActionPurge, entity: entity})
+		}
+	}
+
+	return audits
+}
+
+// writeAudit 在操作执行后生成审计记录并交给所有接收器
+func (uow *UnitOfWork) writeAudit(tx *gorm.DB, audits []pendingAudit) error {
+	if len(audits) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	actor := AuditActorFromContext(uow.ctx)
+	records := make([]AuditRecord, 0, len(audits))
+
+	for _, audit := range audits {
+		record := AuditRecord{
+			Action:     audit.action,
+			EntityType: reflect.TypeOf(audit.entity).String(),
+			EntityID:   IdentityOf(audit.entity).String(),
+			Changes:    audit.changes,
+			Actor:      actor,
+			Timestamp:  now,
+		}
+
+		if revisioned, ok := audit.entity.(HasRevision); ok {
+			record.Revision = revisioned.GetRevision()
+		}
+
+		// 插入和插入或更新记录所有字段的最终值，包括数据库生成的ID
+		if audit.action == AuditActionInsert || audit.action == AuditActionUpsert {
+			record.Changes = make(map[string]FieldChange)
+			for fieldName, value := range extractFieldValues(audit.entity) {
+				record.Changes[fieldName] = FieldChange{
+					FieldName: fieldName,
+					NewValue:  value,
+					Type:      FieldChangeTypeAdded,
+				}
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	for _, sink := range uow.config.AuditSinks {
+		if err := sink.Write(uow.ctx, tx, records); err != nil {
+			return fmt.Errorf("failed to write audit records: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AuditLog 审计表记录
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Action     string    `gorm:"size:16;index" json:"action"`
+	EntityType string    `gorm:"size:255;index:idx_audit_entity" json:"entity_type"`
+	EntityID   string    `gorm:"size:64;index:idx_audit_entity" json:"entity_id"`
+	Revision   int64     `json:"revision"`
+	Changes    string    `gorm:"type:text" json:"changes"`
+	Actor      string    `gorm:"size:255;index" json:"actor"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// GormAuditSink 将审计记录写入数据库表，与实体变更处于同一事务
+type GormAuditSink struct {
+	table string
+}
+
+// NewGormAuditSink 创建数据库审计接收器，table 为空时使用默认表名
+func NewGormAuditSink(table string) *GormAuditSink {
+	if table == "" {
+		table = DefaultAuditTable
+	}
+	return &GormAuditSink{table: table}
+}
+
+// Migrate 创建或迁移审计表
+func (s *GormAuditSink) Migrate(db *gorm.DB) error {
+	return db.Table(s.table).AutoMigrate(&AuditLog{})
+}
+
+// Write 实现AuditSink接口
+func (s *GormAuditSink) Write(ctx context.Context, tx *gorm.DB, records []AuditRecord) error {
+	logs := make([]*AuditLog, 0, len(records))
+	for _, record := range records {
+		changes, err := json.Marshal(record.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal changes of %s#%s: %w", record.EntityType, record.EntityID, err)
+		}
+
+		logs = append(logs, &AuditLog{
+			Action:     string(record.Action),
+			EntityType: record.EntityType,
+			EntityID:   record.EntityID,
+			Revision:   record.Revision,
+			Changes:    string(changes),
+			Actor:      record.Actor,
+			CreatedAt:  record.Timestamp,
+		})
+	}
+
+	return tx.Table(s.table).Create(&logs).Error
+}
+
+// ZloggerAuditSink 将审计记录输出到日志
+type ZloggerAuditSink struct{}
+
+// NewZloggerAuditSink 创建日志审计接收器
+func NewZloggerAuditSink() *ZloggerAuditSink {
+	return &ZloggerAuditSink{}
+}
+
+// Write 实现AuditSink接口
+func (s *ZloggerAuditSink) Write(ctx context.Context, tx *gorm.DB, records []AuditRecord) error {
+	for _, record := range records {
+		zlogger.Info().
+			Str("action", string(record.Action)).
+			Str("entity_type", record.EntityType).
+			Str("entity_id", record.EntityID).
+			Int64("revision", record.Revision).
+			Interface("changes", record.Changes).
+			Str("actor", record.Actor).
+			Time("timestamp", record.Timestamp).
+			Msg("Unit of work audit")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package unitofwork
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+type recordingAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *recordingAuditSink) Write(ctx context.Context, tx *gorm.DB, records []AuditRecord) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Write(ctx context.Context, tx *gorm.DB, records []AuditRecord) error {
+	return errors.New("sink unavailable")
+}
+
+// TestUnitOfWork_Audit 测试审计记录
+func TestUnitOfWork_Audit(t *testing.T) {
+	t.Run("插入更新删除各生成一条记录", func(t *testing.T) {
+		db := setupTestDB()
+		require.NoError(t, db.Create(&User{Name: "张三", Email: "zhangsan@example.com", Age: 20}).Error)
+		require.NoError(t, db.Create(&Tag{Name: "go"}).Error)
+
+		sink := &recordingAuditSink{}
+		ctx := WithAuditActor(context.Background(), "admin")
+
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			var user *User
+			if err := uow.Find(&user, 1); err != nil {
+				return err
+			}
+			user.Age = 21
+			if err := uow.Update(user); err != nil {
+				return err
+			}
+
+			var tag *Tag
+			if err := uow.Find(&tag, 1); err != nil {
+				return err
+			}
+			if err := uow.Delete(tag); err != nil {
+				return err
+			}
+
+			return uow.Create(&Post{Title: "审计", UserID: 1})
+		}, WithAuditSink(sink))
+		require.NoError(t, err)
+
+		require.Len(t, sink.records, 3)
+
+		update := sink.records[0]
+		assert.Equal(t, AuditActionUpdate, update.Action)
+		assert.Equal(t, "*unitofwork.User", update.EntityType)
+		assert.Equal(t, "1", update.EntityID)
+		assert.Equal(t, int64(2), update.Revision)
+		assert.Equal(t, "admin", update.Actor)
+		require.Contains(t, update.Changes, "Age")
+		assert.Equal(t, 20, update.Changes["Age"].OldValue)
+		assert.Equal(t, 21, update.Changes["Age"].NewValue)
+
+		assert.Equal(t, AuditActionDelete, sink.records[1].Action)
+		assert.Equal(t, "*unitofwork.Tag", sink.records[1].EntityType)
+
+		insert := sink.records[2]
+		assert.Equal(t, AuditActionInsert, insert.Action)
+		assert.Equal(t, "1", insert.EntityID)
+		assert.Equal(t, "审计", insert.Changes["Title"].NewValue)
+	})
+
+	t.Run("数据库接收器在同一事务中写入", func(t *testing.T) {
+		db := setupTestDB()
+		sink := NewGormAuditSink("")
+		require.NoError(t, sink.Migrate(db))
+
+		ctx := WithAuditActor(context.Background(), "operator")
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			return uow.Create(&User{Name: "李四", Email: "lisi@example.com", Age: 30})
+		}, WithAuditSink(sink), WithAuditSink(NewZloggerAuditSink()))
+		require.NoError(t, err)
+
+		var logs []AuditLog
+		require.NoError(t, db.Table(DefaultAuditTable).Find(&logs).Error)
+		require.Len(t, logs, 1)
+		assert.Equal(t, "INSERT", logs[0].Action)
+		assert.Equal(t, "operator", logs[0].Actor)
+
+		var changes map[string]FieldChange
+		require.NoError(t, json.Unmarshal([]byte(logs[0].Changes), &changes))
+		assert.Equal(t, FieldChangeTypeAdded, changes["Email"].Type)
+		assert.Equal(t, "lisi@example.com", changes["Email"].NewValue)
+	})
+
+	t.Run("审计写入失败时撤销实体变更", func(t *testing.T) {
+		db := setupTestDB()
+
+		uow := NewUnitOfWork(db, WithAuditSink(failingAuditSink{}))
+		require.NoError(t, uow.Create(&User{Name: "王五", Email: "wangwu@example.com", Age: 40}))
+		assert.ErrorContains(t, uow.Commit(), "sink unavailable")
+
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
@@ -0,0 +1,467 @@
+package unitofwork
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// ConflictError 乐观锁冲突错误，记录版本号不匹配或已不存在的实体
+type ConflictError struct {
+	// 实体类型
+	EntityType reflect.Type
+	// 发生冲突的实体
+	Entities []Entity
+}
+
+// Error 实现error接口
+func (e *ConflictError) Error() string {
+	if len(e.Entities) == 1 {
+		return fmt.Sprintf("optimistic lock failed for entity %s with id %v", e.EntityType, IdentityOf(e.Entities[0]))
+	}
+
+	ids := make([]string, 0, len(e.Entities))
+	for _, entity := range e.Entities {
+		ids = append(ids, IdentityOf(entity).String())
+	}
+	return fmt.Sprintf("optimistic lock failed for %d entities %s with ids [%s]", len(e.Entities), e.EntityType, strings.Join(ids, "; "))
+}
+
+// bulkUpdateGroup 变更列相同的一组实体
+type bulkUpdateGroup struct {
+	fields   []*schema.Field
+	entities []Entity
+}
+
+// Execute 实现Operation接口
+// 按变更列分组，每组按 Config.BatchSize 拆分，每批生成一条 UPDATE 语句，
+// 版本号条件逐行检查，所有批次执行完后汇总报告发生冲突的实体
+func (op *BulkUpdateOperation) Execute(db *gorm.DB) error {
+	if len(op.entities) == 0 {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(op.entities[0]); err != nil {
+		return fmt.Errorf("failed to parse schema of %s: %w", op.entityType, err)
+	}
+
+	// 变更列需在设置时间戳和版本号之前计算
+	groups := op.groupByColumns(stmt.Schema)
+
+	now := time.Now()
+	for _, entity := range op.entities {
+		if err := validateWritable(db.Statement.Context, entity); err != nil {
+			return err
+		}
+
+		if IdentityOf(entity).IsZero() {
+			return fmt.Errorf("failed to update entity %T: %w", entity, gorm.ErrPrimaryKeyRequired)
+		}
+
+		if timestamped, ok := entity.(HasTimestamps); ok {
+			timestamped.SetUpdatedAt(now)
+		}
+	}
+
+	batchSize := len(op.entities)
+	if op.uow != nil && op.uow.config.BatchSize > 0 {
+		batchSize = op.uow.config.BatchSize
+	}
+
+	var conflicts []Entity
+	for _, group := range groups {
+		for start := 0; start < len(group.entities); start += batchSize {
+			end := start + batchSize
+			if end > len(group.entities) {
+				end = len(group.entities)
+			}
+
+			conflicted, err := op.updateBatch(db, stmt.Schema, group.fields, group.entities[start:end])
+			if err != nil {
+				return fmt.Errorf("failed to bulk update entities %s: %w", op.entityType, err)
+			}
+			conflicts = append(conflicts, conflicted...)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{EntityType: op.entityType, Entities: conflicts}
+	}
+
+	return nil
+}
+
+// changesOf 获取实体的字段变更，启用脏检查时以执行时的快照比较结果为准
+func (op *BulkUpdateOperation) changesOf(index int) map[string]FieldChange {
+	entity := op.entities[index]
+	if op.uow != nil && op.uow.config.EnableDirtyCheck {
+		snapshotManager := op.uow.lookupSnapshotManager(entity)
+		if snapshotManager.HasSnapshot(entity) {
+			return snapshotManager.GetChangedFields(entity)
+		}
+	}
+
+	if index < len(op.changes) {
+		return op.changes[index]
+	}
+	return nil
+}
+
+// groupByColumns 按需要更新的列对实体分组，没有变更记录的实体更新所有可更新的列
+func (op *BulkUpdateOperation) groupByColumns(s *schema.Schema) []*bulkUpdateGroup {
+	_, revisioned := op.entities[0].(HasRevision)
+
+	groups := make(map[string]*bulkUpdateGroup)
+	ordered := make([]*bulkUpdateGroup, 0)
+
+	for i, entity := range op.entities {
+		changes := op.changesOf(i)
+
+		fields := make([]*schema.Field, 0)
+		for _, dbName := range s.DBNames {
+			field := s.FieldsByDBName[dbName]
+			if field.PrimaryKey || field.AutoCreateTime > 0 || !field.Updatable {
+				continue
+			}
+
+			// 时间戳与版本号每次更新都会变化
+			managed := field.AutoUpdateTime > 0 || (revisioned && dbName == "revision")
+			if len(changes) > 0 && !managed {
+				if _, changed := changes[field.BindNames[0]]; !changed {
+					continue
+				}
+			}
+
+			fields = append(fields, field)
+		}
+
+		names := make([]string, 0, len(fields))
+		for _, field := range fields {
+			names = append(names, field.DBName)
+		}
+		sort.Strings(names)
+		key := strings.Join(names, ",")
+
+		group, exists := groups[key]
+		if !exists {
+			group = &bulkUpdateGroup{fields: fields}
+			groups[key] = group
+			ordered = append(ordered, group)
+		}
+		group.entities = append(group.entities, entity)
+	}
+
+	return ordered
+}
+
+// updateBatch 使用一条 UPDATE 语句更新一批实体，返回版本号不匹配或已不存在的实体
+// 其他事务可能已将版本号更新为相同的值，因此先查询当前版本号排除冲突的实体，再执行更新
+func (op *BulkUpdateOperation) updateBatch(db *gorm.DB, s *schema.Schema, fields []*schema.Field, entities []Entity) ([]Entity, error) {
+	_, revisioned := entities[0].(HasRevision)
+
+	var conflicts []Entity
+	if revisioned && !db.DryRun {
+		var err error
+		if conflicts, err = findConflicts(db, s, entities); err != nil {
+			return nil, err
+		}
+		entities = excludeEntities(entities, conflicts)
+		if len(entities) == 0 {
+			return conflicts, nil
+		}
+	}
+
+	originalRevisions := make([]int64, len(entities))
+	for i, entity := range entities {
+		if revisioned, ok := entity.(HasRevision); ok {
+			originalRevisions[i] = revisioned.GetRevision()
+			revisioned.SetRevision(revisioned.GetRevisionNext())
+		}
+	}
+
+	var (
+		rowsAffected int64
+		err          error
+	)
+	if db.Dialector.Name() == "postgres" {
+		rowsAffected, err = updateFromValues(db, s, fields, entities, originalRevisions)
+	} else {
+		rowsAffected, err = updateWithCase(db, s, fields, entities, originalRevisions)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected >= int64(len(entities)) || db.DryRun {
+		return conflicts, nil
+	}
+
+	// 查询与更新之间被其他事务修改的行
+	changed, err := findConflicts(db, s, entities)
+	if err != nil {
+		return nil, err
+	}
+	return append(conflicts, changed...), nil
+}
+
+func excludeEntities(entities []Entity, excluded []Entity) []Entity {
+	if len(excluded) == 0 {
+		return entities
+	}
+
+	skip := make(map[Entity]bool, len(excluded))
+	for _, entity := range excluded {
+		skip[entity] = true
+	}
+
+	remaining := make([]Entity, 0, len(entities)-len(excluded))
+	for _, entity := range entities {
+		if !skip[entity] {
+			remaining = append(remaining, entity)
+		}
+	}
+	return remaining
+}
+
+// updateWithCase 生成 UPDATE ... SET col = CASE id WHEN ... END WHERE id IN (...) 语句
+func updateWithCase(db *gorm.DB, s *schema.Schema, fields []*schema.Field, entities []Entity, originalRevisions []int64) (int64, error) {
+	identities := make([]Identity, 0, len(entities))
+	values := make([]reflect.Value, 0, len(entities))
+	for _, entity := range entities {
+		identities = append(identities, IdentityOf(entity))
+		values = append(values, reflect.Indirect(reflect.ValueOf(entity)))
+	}
+
+	assignments := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		results := make([]interface{}, 0, len(entities))
+		for _, value := range values {
+			fieldValue, _ := field.ValueOf(db.Statement.Context, value)
+			results = append(results, fieldValue)
+		}
+		assignments[field.DBName] = caseByIdentity(s, identities, results)
+	}
+
+	model := reflect.New(s.ModelType).Interface()
+	query, err := whereIdentities(db.Session(&gorm.Session{NewDB: true}).Model(model), model, identities)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := entities[0].(HasRevision); ok {
+		revisions := make([]interface{}, 0, len(originalRevisions))
+		for _, revision := range originalRevisions {
+			revisions = append(revisions, revision)
+		}
+		query = query.Where("? = ?", clause.Column{Table: clause.CurrentTable, Name: "revision"}, caseByIdentity(s, identities, revisions))
+	}
+
+	result := query.UpdateColumns(assignments)
+	return result.RowsAffected, result.Error
+}
+
+// caseByIdentity 按主键选择值，单列主键使用 CASE id WHEN ? THEN ? 形式
+func caseByIdentity(s *schema.Schema, identities []Identity, results []interface{}) clause.Expr {
+	var sql strings.Builder
+	vars := make([]interface{}, 0, len(identities)*(len(s.PrimaryFields)+1)+1)
+
+	if len(s.PrimaryFields) == 1 {
+		sql.WriteString("CASE ?")
+		vars = append(vars, clause.Column{Table: clause.CurrentTable, Name: s.PrimaryFields[0].DBName})
+		for i, identity := range identities {
+			sql.WriteString(" WHEN ? THEN ?")
+			vars = append(vars, identity.Values()[0], results[i])
+		}
+	} else {
+		sql.WriteString("CASE")
+		for i, identity := range identities {
+			sql.WriteString(" WHEN ")
+			for j, field := range s.PrimaryFields {
+				if j > 0 {
+					sql.WriteString(" AND ")
+				}
+				sql.WriteString("? = ?")
+				vars = append(vars, clause.Column{Table: clause.CurrentTable, Name: field.DBName}, identity.Values()[j])
+			}
+			sql.WriteString(" THEN ?")
+			vars = append(vars, results[i])
+		}
+	}
+	sql.WriteString(" END")
+
+	return clause.Expr{SQL: sql.String(), Vars: vars}
+}
+
+// updateFromValues 生成 Postgres 的 UPDATE ... FROM (VALUES ...) 语句
+// 第一行的值显式转换为列类型，避免参数被推断为 text
+func updateFromValues(db *gorm.DB, s *schema.Schema, fields []*schema.Field, entities []Entity, originalRevisions []int64) (int64, error) {
+	_, revisioned := entities[0].(HasRevision)
+	const alias = "v"
+	const originalRevision = "__original_revision"
+
+	columns := make([]*schema.Field, 0, len(s.PrimaryFields)+len(fields))
+	columns = append(columns, s.PrimaryFields...)
+	columns = append(columns, fields...)
+
+	var sql strings.Builder
+	vars := make([]interface{}, 0)
+
+	sql.WriteString("UPDATE ? SET ")
+	vars = append(vars, clause.Table{Name: s.Table})
+	for i, field := range fields {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("? = ?")
+		vars = append(vars, clause.Column{Name: field.DBName}, clause.Column{Table: alias, Name: field.DBName})
+	}
+
+	sql.WriteString(" FROM (VALUES ")
+	for i, entity := range entities {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("(")
+
+		value := reflect.Indirect(reflect.ValueOf(entity))
+		for j, field := range columns {
+			if j > 0 {
+				sql.WriteString(", ")
+			}
+			fieldValue, _ := field.ValueOf(db.Statement.Context, value)
+			writeValuesItem(db, &sql, i == 0, field)
+			vars = append(vars, fieldValue)
+		}
+
+		if revisioned {
+			sql.WriteString(", ")
+			if i == 0 {
+				sql.WriteString("CAST(? AS bigint)")
+			} else {
+				sql.WriteString("?")
+			}
+			vars = append(vars, originalRevisions[i])
+		}
+		sql.WriteString(")")
+	}
+
+	sql.WriteString(") AS ?(")
+	vars = append(vars, clause.Table{Name: alias})
+	for i, field := range columns {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("?")
+		vars = append(vars, clause.Column{Name: field.DBName})
+	}
+	if revisioned {
+		sql.WriteString(", ?")
+		vars = append(vars, clause.Column{Name: originalRevision})
+	}
+
+	sql.WriteString(") WHERE ")
+	for i, field := range s.PrimaryFields {
+		if i > 0 {
+			sql.WriteString(" AND ")
+		}
+		sql.WriteString("? = ?")
+		vars = append(vars, clause.Column{Table: s.Table, Name: field.DBName}, clause.Column{Table: alias, Name: field.DBName})
+	}
+	if revisioned {
+		sql.WriteString(" AND ? = ?")
+		vars = append(vars, clause.Column{Table: s.Table, Name: "revision"}, clause.Column{Table: alias, Name: originalRevision})
+	}
+
+	// 与单条更新一致，不更新已软删除的行
+	if !db.Statement.Unscoped {
+		for _, field := range s.Fields {
+			if field.DBName != "" && field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+				sql.WriteString(" AND ? IS NULL")
+				vars = append(vars, clause.Column{Table: s.Table, Name: field.DBName})
+			}
+		}
+	}
+
+	result := db.Session(&gorm.Session{NewDB: true}).Exec(sql.String(), vars...)
+	return result.RowsAffected, result.Error
+}
+
+// writeValuesItem 写入 VALUES 中的一个参数占位符
+func writeValuesItem(db *gorm.DB, sql *strings.Builder, typed bool, field *schema.Field) {
+	if !typed {
+		sql.WriteString("?")
+		return
+	}
+
+	dataType := strings.ToLower(db.Dialector.DataTypeOf(field))
+	switch dataType {
+	case "smallserial":
+		dataType = "smallint"
+	case "serial":
+		dataType = "integer"
+	case "bigserial":
+		dataType = "bigint"
+	}
+
+	sql.WriteString("CAST(? AS " + dataType + ")")
+}
+
+// findConflicts 查询当前版本号，找出与实体版本号不匹配或已不存在的实体
+func findConflicts(db *gorm.DB, s *schema.Schema, entities []Entity) ([]Entity, error) {
+	identities := make([]Identity, 0, len(entities))
+	for _, entity := range entities {
+		identities = append(identities, IdentityOf(entity))
+	}
+
+	selects := make([]string, 0, len(s.PrimaryFields)+1)
+	for _, field := range s.PrimaryFields {
+		selects = append(selects, field.DBName)
+	}
+
+	_, revisioned := entities[0].(HasRevision)
+	if revisioned {
+		selects = append(selects, "revision")
+	}
+
+	model := reflect.New(s.ModelType).Interface()
+	query, err := whereIdentities(db.Session(&gorm.Session{NewDB: true}).Model(model), model, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	if err := query.Select(selects).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to check revisions: %w", err)
+	}
+
+	found := make(map[string]interface{}, len(rows))
+	for _, row := range rows {
+		values := make([]interface{}, 0, len(s.PrimaryFields))
+		for _, field := range s.PrimaryFields {
+			values = append(values, row[field.DBName])
+		}
+		found[NewIdentity(values...).String()] = row["revision"]
+	}
+
+	conflicts := make([]Entity, 0)
+	for i, entity := range entities {
+		revision, exists := found[identities[i].String()]
+		if !exists {
+			conflicts = append(conflicts, entity)
+			continue
+		}
+
+		if revisioned && toString(revision) != toString(entity.(HasRevision).GetRevision()) {
+			conflicts = append(conflicts, entity)
+		}
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,125 @@
+package unitofwork
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/postgres"
+)
+
+func seedUsers(t *testing.T, db *gorm.DB, count int) {
+	for i := 1; i <= count; i++ {
+		require.NoError(t, db.Create(&User{
+			Name:  fmt.Sprintf("用户%d", i),
+			Email: fmt.Sprintf("user%d@example.com", i),
+			Age:   20,
+		}).Error)
+	}
+}
+
+// TestBulkUpdateOperation 测试单语句批量更新
+func TestBulkUpdateOperation(t *testing.T) {
+	t.Run("按变更列分组并按批量大小拆分", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 5)
+
+		uow := NewUnitOfWork(db, WithBatchSize(2))
+		users := make([]*User, 0, 5)
+		for i := 1; i <= 5; i++ {
+			var user *User
+			require.NoError(t, uow.Find(&user, i))
+			users = append(users, user)
+		}
+
+		for _, user := range users[:3] {
+			user.Name = strings.Replace(user.Name, "用户", "会员", 1)
+			require.NoError(t, uow.Update(user))
+		}
+		for _, user := range users[3:] {
+			user.Age = 30
+			require.NoError(t, uow.Update(user))
+		}
+
+		plan, err := uow.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 1)
+		assert.Equal(t, "BULK_UPDATE", plan.Operations[0].OperationType)
+		require.Len(t, plan.Operations[0].SQL, 3)
+		assert.Contains(t, plan.Operations[0].SQL[0], "`name`=CASE `users`.`id` WHEN 1 THEN \"会员1\" WHEN 2 THEN \"会员2\" END")
+		assert.Contains(t, plan.Operations[0].SQL[0], "`users`.`id` IN (1,2) AND `users`.`revision` = CASE `users`.`id` WHEN 1 THEN 1 WHEN 2 THEN 1 END")
+		assert.NotContains(t, plan.Operations[0].SQL[0], "`age`")
+		assert.Contains(t, plan.Operations[0].SQL[2], "`age`=CASE")
+		assert.NotContains(t, plan.Operations[0].SQL[2], "`name`")
+
+		require.NoError(t, uow.Commit())
+
+		var saved []User
+		require.NoError(t, db.Order("id").Find(&saved).Error)
+		require.Len(t, saved, 5)
+		assert.Equal(t, "会员1", saved[0].Name)
+		assert.Equal(t, "会员3", saved[2].Name)
+		assert.Equal(t, 20, saved[2].Age)
+		assert.Equal(t, "用户4", saved[3].Name)
+		assert.Equal(t, 30, saved[4].Age)
+		for _, user := range saved {
+			assert.Equal(t, int64(2), user.Revision)
+		}
+	})
+
+	t.Run("报告版本号冲突的实体", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 3)
+
+		uow := NewUnitOfWork(db)
+		for i := 1; i <= 3; i++ {
+			var user *User
+			require.NoError(t, uow.Find(&user, i))
+			user.Age = 40
+			require.NoError(t, uow.Update(user))
+		}
+
+		// 其他事务已修改第2、3行
+		require.NoError(t, db.Model(&User{}).Where("id IN ?", []int{2, 3}).UpdateColumn("revision", 5).Error)
+
+		err := uow.Commit()
+		require.Error(t, err)
+
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		require.Len(t, conflict.Entities, 2)
+		assert.Equal(t, "2", IdentityOf(conflict.Entities[0]).String())
+		assert.Equal(t, "3", IdentityOf(conflict.Entities[1]).String())
+		assert.Contains(t, err.Error(), "ids [2; 3]")
+	})
+
+	t.Run("Postgres 使用 UPDATE FROM VALUES", func(t *testing.T) {
+		mockDB, _, err := sqlmock.New()
+		require.NoError(t, err)
+		db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+		require.NoError(t, err)
+
+		uow := NewUnitOfWork(db, WithAutoDependency(false))
+		for i := 1; i <= 2; i++ {
+			user := uow.Attach(&User{BaseEntity: BaseEntity{ID: uint(i), Revision: 1}, Name: "用户", Email: "user@example.com"}).(*User)
+			user.Name = fmt.Sprintf("会员%d", i)
+			require.NoError(t, uow.Update(user))
+		}
+
+		plan, err := uow.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 1)
+		require.Len(t, plan.Operations[0].SQL, 1)
+
+		sql := plan.Operations[0].SQL[0]
+		assert.Contains(t, sql, `UPDATE "users" SET "updated_at" = "v"."updated_at", "revision" = "v"."revision", "name" = "v"."name" FROM (VALUES (CAST(1 AS bigint), CAST(`)
+		assert.Contains(t, sql, `CAST('会员1' AS varchar(100)), CAST(1 AS bigint)), (2, `)
+		assert.Contains(t, sql, `AS "v"("id", "updated_at", "revision", "name", "__original_revision")`)
+		assert.Contains(t, sql, `WHERE "users"."id" = "v"."id" AND "users"."revision" = "v"."__original_revision" AND "users"."deleted_at" IS NULL`)
+	})
+}
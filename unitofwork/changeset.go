@@ -0,0 +1,578 @@
+package unitofwork
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/wubin1989/gorm"
+)
+
+// ChangeSetVersion 当前变更集格式版本
+const ChangeSetVersion = 1
+
+// ErrStaleChangeSet 变更集基于的数据已被修改或删除
+var ErrStaleChangeSet = errors.New("stale change set")
+
+// ChangeSetFormat 变更集序列化格式
+type ChangeSetFormat string
+
+const (
+	ChangeSetFormatJSON    ChangeSetFormat = "json"
+	ChangeSetFormatMsgpack ChangeSetFormat = "msgpack"
+)
+
+// ChangeAction 变更动作
+type ChangeAction string
+
+const (
+	ChangeActionInsert  ChangeAction = "INSERT"
+	ChangeActionUpdate  ChangeAction = "UPDATE"
+	ChangeActionDelete  ChangeAction = "DELETE"
+	ChangeActionUpsert  ChangeAction = "UPSERT"
+	ChangeActionRestore ChangeAction = "RESTORE"
+	ChangeActionPurge   ChangeAction = "PURGE"
+)
+
+// ChangeSet 可序列化的待提交变更集合，用于离线编辑、审批流和排队写入
+type ChangeSet struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Changes   []EntityChange `json:"changes"`
+}
+
+// EntityChange 单个实体的变更
+type EntityChange struct {
+	Action ChangeAction `json:"action"`
+
+	// 实体表名，导入时通过 EntityRegistry 查找实体类型
+	Table string `json:"table"`
+
+	// 主键值，按主键字段声明顺序排列，新实体为空
+	ID []interface{} `json:"id,omitempty"`
+
+	// 导出时的版本号，仅支持乐观锁的实体有值
+	Revision int64 `json:"revision,omitempty"`
+
+	// 插入和插入或更新时实体的所有字段值，以字段名为键
+	Fields map[string]interface{} `json:"fields,omitempty"`
+
+	// 更新时发生变更的字段，以字段名为键
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+
+	// 插入或更新的冲突列
+	ConflictColumns []string `json:"conflict_columns,omitempty"`
+}
+
+// Marshal 按指定格式序列化变更集
+func (cs *ChangeSet) Marshal(format ChangeSetFormat) ([]byte, error) {
+	switch format {
+	case ChangeSetFormatJSON:
+		return json.Marshal(cs)
+	case ChangeSetFormatMsgpack:
+		var buf bytes.Buffer
+		encoder := msgpack.NewEncoder(&buf)
+		encoder.SetCustomStructTag("json")
+		if err := encoder.Encode(cs); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported change set format %q", format)
+	}
+}
+
+// UnmarshalChangeSet 按指定格式反序列化变更集，版本不兼容时返回错误
+func UnmarshalChangeSet(data []byte, format ChangeSetFormat) (*ChangeSet, error) {
+	changeSet := &ChangeSet{}
+
+	switch format {
+	case ChangeSetFormatJSON:
+		if err := json.Unmarshal(data, changeSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal change set: %w", err)
+		}
+	case ChangeSetFormatMsgpack:
+		decoder := msgpack.NewDecoder(bytes.NewReader(data))
+		decoder.SetCustomStructTag("json")
+		if err := decoder.Decode(changeSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal change set: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported change set format %q", format)
+	}
+
+	if changeSet.Version != ChangeSetVersion {
+		return nil, fmt.Errorf("unsupported change set version %d, expected %d", changeSet.Version, ChangeSetVersion)
+	}
+
+	return changeSet, nil
+}
+
+// EntityRegistry 实体类型注册表，导入变更集时按表名查找实体类型
+type EntityRegistry struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// NewEntityRegistry 创建实体类型注册表
+func NewEntityRegistry(entities ...Entity) *EntityRegistry {
+	registry := &EntityRegistry{
+		types: make(map[string]reflect.Type),
+	}
+	registry.Register(entities...)
+	return registry
+}
+
+// Register 注册实体类型，entities 为实体类型的零值，例如 &User{}
+func (r *EntityRegistry) Register(entities ...Entity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entity := range entities {
+		r.types[entity.GetTableName()] = reflect.TypeOf(entity)
+	}
+}
+
+// Lookup 按表名查找实体类型
+func (r *EntityRegistry) Lookup(table string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entityType, exists := r.types[table]
+	return entityType, exists
+}
+
+// Export 按注册顺序导出待提交的变更，不修改工作单元的状态
+func (uow *UnitOfWork) Export() (*ChangeSet, error) {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return nil, fmt.Errorf("unit of work is already finished")
+	}
+
+	changeSet := &ChangeSet{
+		Version:   ChangeSetVersion,
+		CreatedAt: time.Now(),
+		Changes:   make([]EntityChange, 0, len(uow.operations)),
+	}
+
+	for _, op := range uow.operations {
+		for _, entity := range operationEntities(op) {
+			change, err := uow.exportChange(op, entity)
+			if err != nil {
+				return nil, err
+			}
+			changeSet.Changes = append(changeSet.Changes, change)
+		}
+	}
+
+	return changeSet, nil
+}
+
+// exportChange 导出操作中单个实体的变更
+func (uow *UnitOfWork) exportChange(op Operation, entity Entity) (EntityChange, error) {
+	change := EntityChange{
+		Table: entity.GetTableName(),
+	}
+
+	if identity := IdentityOf(entity); !identity.IsZero() {
+		change.ID = identity.Values()
+	}
+
+	if revisioned, ok := entity.(HasRevision); ok && !entity.IsNew() {
+		change.Revision = revisioned.GetRevision()
+	}
+
+	switch op.GetOperationType() {
+	case OperationTypeInsert, OperationTypeBulkInsert:
+		change.Action = ChangeActionInsert
+		change.Fields = extractFieldValues(entity)
+	case OperationTypeUpsert, OperationTypeBulkUpsert:
+		change.Action = ChangeActionUpsert
+		change.Fields = extractFieldValues(entity)
+		change.ConflictColumns = conflictColumnsOf(op)
+	case OperationTypeUpdate, OperationTypeBulkUpdate:
+		change.Action = ChangeActionUpdate
+		snapshotManager := uow.lookupSnapshotManager(entity)
+		if snapshotManager.HasSnapshot(entity) {
+			change.Changes = snapshotManager.GetChangedFields(entity)
+		} else {
+			// 没有快照时无法计算差异，导出所有字段
+			change.Fields = extractFieldValues(entity)
+		}
+	case OperationTypeDelete, OperationTypeBulkDelete:
+		change.Action = ChangeActionDelete
+	case OperationTypeRestore, OperationTypeBulkRestore:
+		change.Action = ChangeActionRestore
+	case OperationTypePurge, OperationTypeBulkPurge:
+		change.Action = ChangeActionPurge
+	default:
+		return change, fmt.Errorf("operation %s of entity %T cannot be exported", op.GetOperationType(), entity)
+	}
+
+	return change, nil
+}
+
+// importedChange 已通过检查待注册的变更
+type importedChange struct {
+	change EntityChange
+	entity Entity
+
+	// 更新时应用变更后的副本，注册时写回 entity，避免检查失败时修改已跟踪的实体
+	target Entity
+
+	// 从存储加载的实体尚未纳入跟踪，注册时才纳入
+	loaded bool
+}
+
+// Import 按变更集重建操作，实体类型通过 registry 按表名查找
+// 针对已有行的变更会先加载数据库中的当前状态并纳入跟踪，支持乐观锁的实体比较版本号，
+// 其他实体比较变更字段的原值，不一致或行已不存在时返回 ErrStaleChangeSet。
+// 所有变更都通过检查后才跟踪加载的实体并注册操作，任一变更检查或注册失败时工作单元恢复到导入前的状态
+func (uow *UnitOfWork) Import(changeSet *ChangeSet, registry *EntityRegistry) error {
+	if changeSet == nil {
+		return fmt.Errorf("change set cannot be nil")
+	}
+
+	if changeSet.Version != ChangeSetVersion {
+		return fmt.Errorf("unsupported change set version %d, expected %d", changeSet.Version, ChangeSetVersion)
+	}
+
+	imported := make([]importedChange, 0, len(changeSet.Changes))
+	for i, change := range changeSet.Changes {
+		item, err := uow.prepareChange(change, registry)
+		if err != nil {
+			return fmt.Errorf("failed to import change %d (%s %s): %w", i, change.Action, change.Table, err)
+		}
+		imported = append(imported, item)
+	}
+
+	state := uow.saveImportState()
+	for i, item := range imported {
+		if err := uow.applyChange(item, state); err != nil {
+			uow.restoreImportState(state)
+			return fmt.Errorf("failed to import change %d (%s %s): %w", i, item.change.Action, item.change.Table, err)
+		}
+	}
+
+	return uow.autoFlush()
+}
+
+// importState 导入前的注册状态，以及导入过程中纳入跟踪的实体和被修改的实体
+type importState struct {
+	entities   []map[reflect.Type][]Entity
+	operations []Operation
+	attached   []Entity
+	modified   map[Entity]Entity
+}
+
+// entityLists 返回各类待提交实体列表的地址，顺序固定
+func (uow *UnitOfWork) entityLists() []*map[reflect.Type][]Entity {
+	return []*map[reflect.Type][]Entity{
+		&uow.newEntities, &uow.dirtyEntities, &uow.removedEntities,
+		&uow.upsertedEntities, &uow.restoredEntities, &uow.purgedEntities,
+	}
+}
+
+// saveImportState 复制当前的待提交实体列表和操作队列
+func (uow *UnitOfWork) saveImportState() *importState {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	state := &importState{
+		operations: append([]Operation(nil), uow.operations...),
+		modified:   make(map[Entity]Entity),
+	}
+	for _, list := range uow.entityLists() {
+		saved := make(map[reflect.Type][]Entity, len(*list))
+		for entityType, entities := range *list {
+			saved[entityType] = append([]Entity(nil), entities...)
+		}
+		state.entities = append(state.entities, saved)
+	}
+	return state
+}
+
+// restoreImportState 恢复导入前的状态：还原被修改的实体，移除导入时纳入跟踪的实体，恢复待提交实体列表和操作队列
+func (uow *UnitOfWork) restoreImportState(state *importState) {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	for entity, original := range state.modified {
+		reflect.ValueOf(entity).Elem().Set(reflect.ValueOf(original).Elem())
+	}
+
+	for _, entity := range state.attached {
+		uow.identityMap.Remove(entity)
+		uow.snapshotManager.RemoveSnapshot(entity)
+	}
+
+	for i, list := range uow.entityLists() {
+		*list = state.entities[i]
+	}
+	uow.operations = state.operations
+}
+
+// attachImported 跟踪导入时加载的实体，已跟踪同一行的其他实例时返回已跟踪的实例
+func (uow *UnitOfWork) attachImported(entity Entity, state *importState) Entity {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if tracked, exists := uow.identityMap.Lookup(entity); exists {
+		return tracked
+	}
+
+	state.attached = append(state.attached, entity)
+	return uow.attach(entity)
+}
+
+// prepareChange 构建变更对应的实体并检查是否过期
+func (uow *UnitOfWork) prepareChange(change EntityChange, registry *EntityRegistry) (importedChange, error) {
+	item := importedChange{change: change}
+
+	entityType, exists := registry.Lookup(change.Table)
+	if !exists {
+		return item, fmt.Errorf("entity type of table %s is not registered", change.Table)
+	}
+
+	switch change.Action {
+	case ChangeActionInsert, ChangeActionUpsert:
+		entity := reflect.New(entityType.Elem()).Interface().(Entity)
+		if err := assignFields(entity, change.Fields); err != nil {
+			return item, err
+		}
+		item.entity = entity
+		return item, nil
+	case ChangeActionUpdate, ChangeActionDelete, ChangeActionRestore, ChangeActionPurge:
+	default:
+		return item, fmt.Errorf("unknown change action %q", change.Action)
+	}
+
+	identity, err := identityFromValues(entityType, change.ID)
+	if err != nil {
+		return item, err
+	}
+
+	// 恢复和永久删除针对已软删除的行
+	unscoped := change.Action == ChangeActionRestore || change.Action == ChangeActionPurge
+	current, loaded, err := uow.loadForImport(entityType, identity, unscoped)
+	if err != nil {
+		return item, err
+	}
+	if current == nil {
+		return item, fmt.Errorf("%w: entity %s with id %v no longer exists", ErrStaleChangeSet, entityType, identity)
+	}
+	item.entity = current
+	item.loaded = loaded
+
+	revisioned, hasRevision := current.(HasRevision)
+	if hasRevision && revisioned.GetRevision() != change.Revision {
+		return item, fmt.Errorf("%w: entity %s with id %v is at revision %d, change set is based on revision %d",
+			ErrStaleChangeSet, entityType, identity, revisioned.GetRevision(), change.Revision)
+	}
+
+	if change.Action != ChangeActionUpdate {
+		return item, nil
+	}
+
+	target := cloneEntity(current)
+	targetValue := reflect.ValueOf(target).Elem()
+	for fieldName, fieldChange := range change.Changes {
+		field := targetValue.FieldByName(fieldName)
+		if !field.IsValid() || !field.CanSet() {
+			return item, fmt.Errorf("entity %s has no field %s", entityType, fieldName)
+		}
+
+		if !hasRevision && fieldChange.Type == FieldChangeTypeModified {
+			same, err := sameFieldValue(field, fieldChange.OldValue)
+			if err != nil {
+				return item, err
+			}
+			if !same {
+				return item, fmt.Errorf("%w: field %s of entity %s with id %v has been modified",
+					ErrStaleChangeSet, fieldName, entityType, identity)
+			}
+		}
+
+		if err := decodeFieldValue(field, fieldChange.NewValue); err != nil {
+			return item, fmt.Errorf("failed to assign field %s: %w", fieldName, err)
+		}
+	}
+
+	if err := assignFields(target, change.Fields); err != nil {
+		return item, err
+	}
+	item.target = target
+
+	return item, nil
+}
+
+// applyChange 注册变更对应的操作，全部注册完成后才由 Import 检查是否需要自动刷新
+func (uow *UnitOfWork) applyChange(item importedChange, state *importState) error {
+	entity := item.entity
+	if item.loaded {
+		entity = uow.attachImported(entity, state)
+	}
+
+	switch item.change.Action {
+	case ChangeActionInsert:
+		return uow.create(entity)
+	case ChangeActionUpsert:
+		return uow.upsert(entity, item.change.ConflictColumns...)
+	case ChangeActionUpdate:
+		if _, exists := state.modified[entity]; !exists {
+			state.modified[entity] = cloneEntity(entity)
+		}
+		reflect.ValueOf(entity).Elem().Set(reflect.ValueOf(item.target).Elem())
+		return uow.update(entity)
+	case ChangeActionDelete:
+		return uow.Delete(entity)
+	case ChangeActionRestore:
+		return uow.restore(entity)
+	case ChangeActionPurge:
+		return uow.Purge(entity)
+	default:
+		return fmt.Errorf("unknown change action %q", item.change.Action)
+	}
+}
+
+// loadForImport 获取已跟踪的实体，未跟踪时从存储加载但不纳入跟踪，loaded 表示实体由存储加载，行不存在时返回 nil
+func (uow *UnitOfWork) loadForImport(entityType reflect.Type, identity Identity, unscoped bool) (Entity, bool, error) {
+	uow.mu.RLock()
+	if uow.isCommitted || uow.isRolledBack {
+		uow.mu.RUnlock()
+		return nil, false, fmt.Errorf("unit of work is already finished")
+	}
+	tracked, exists := uow.identityMap.Get(entityType, identity)
+	uow.mu.RUnlock()
+
+	if exists {
+		return tracked, false, nil
+	}
+
+	if uow.memory != nil {
+		stored, err := uow.loadFromMemory(entityType, identity, unscoped)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return stored, true, err
+	}
+
+	if uow.db == nil {
+		return nil, false, fmt.Errorf("database connection is required to import changes of existing entities")
+	}
+
+	stored := reflect.New(entityType.Elem()).Interface()
+	db := uow.db.WithContext(uow.queryContext())
+	if unscoped {
+		db = db.Unscoped()
+	}
+
+	query, err := whereIdentity(db, stored, identity)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to find entity %s with id %v: %w", entityType, identity, err)
+	}
+
+	if err := query.First(stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to find entity %s with id %v: %w", entityType, identity, err)
+	}
+
+	return stored.(Entity), true, nil
+}
+
+// identityFromValues 将主键值转换为实体主键字段的类型并构建标识
+func identityFromValues(entityType reflect.Type, values []interface{}) (Identity, error) {
+	fields := primaryFieldsOf(entityType)
+	if len(fields) == 0 {
+		return Identity{}, fmt.Errorf("entity %s has no primary key", entityType)
+	}
+
+	if len(values) != len(fields) {
+		return Identity{}, fmt.Errorf("entity %s has %d primary key fields, got %d values", entityType, len(fields), len(values))
+	}
+
+	model := reflect.New(entityType.Elem())
+	for i, field := range fields {
+		if err := decodeFieldValue(model.Elem().FieldByIndex(field.StructField.Index), values[i]); err != nil {
+			return Identity{}, fmt.Errorf("invalid primary key %s of entity %s: %w", field.Name, entityType, err)
+		}
+	}
+
+	return IdentityOf(model.Interface().(Entity)), nil
+}
+
+// assignFields 按字段名为实体字段赋值
+func assignFields(entity Entity, fields map[string]interface{}) error {
+	entityValue := reflect.ValueOf(entity).Elem()
+	for fieldName, value := range fields {
+		field := entityValue.FieldByName(fieldName)
+		if !field.IsValid() || !field.CanSet() {
+			return fmt.Errorf("entity %T has no field %s", entity, fieldName)
+		}
+
+		if err := decodeFieldValue(field, value); err != nil {
+			return fmt.Errorf("failed to assign field %s: %w", fieldName, err)
+		}
+	}
+	return nil
+}
+
+// decodeFieldValue 将反序列化得到的值转换为字段类型后赋值
+// JSON 和 msgpack 反序列化后的值丢失了原始类型，统一经过一次 JSON 编解码转换
+func decodeFieldValue(field reflect.Value, value interface{}) error {
+	converted, err := convertValue(field.Type(), value)
+	if err != nil {
+		return err
+	}
+
+	field.Set(converted)
+	return nil
+}
+
+// sameFieldValue 比较字段当前值与变更集中记录的原值
+func sameFieldValue(field reflect.Value, value interface{}) (bool, error) {
+	converted, err := convertValue(field.Type(), value)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := json.Marshal(field.Interface())
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := json.Marshal(converted.Interface())
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(current, expected), nil
+}
+
+// convertValue 将值转换为指定类型
+func convertValue(targetType reflect.Type, value interface{}) (reflect.Value, error) {
+	converted := reflect.New(targetType)
+	if value == nil {
+		return converted.Elem(), nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if err := json.Unmarshal(data, converted.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return converted.Elem(), nil
+}
@@ -0,0 +1,175 @@
+package unitofwork
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitOfWork_ExportImport 测试导出和导入待提交的变更集
+func TestUnitOfWork_ExportImport(t *testing.T) {
+	for _, format := range []ChangeSetFormat{ChangeSetFormatJSON, ChangeSetFormatMsgpack} {
+		t.Run("序列化后在另一个工作单元中导入："+string(format), func(t *testing.T) {
+			db := setupTestDB()
+			seedUsers(t, db, 3)
+
+			source := NewUnitOfWork(db)
+			var first, second *User
+			require.NoError(t, source.Find(&first, 1))
+			require.NoError(t, source.Find(&second, 2))
+			first.Name = "张三"
+			first.Age = 30
+			require.NoError(t, source.Update(first))
+			require.NoError(t, source.Delete(second))
+			require.NoError(t, source.Create(&User{Name: "李四", Email: "lisi@example.com", Age: 18}))
+			require.NoError(t, source.Upsert(&Tag{Name: "go", Color: "#00ADD8"}, "Name"))
+
+			changeSet, err := source.Export()
+			require.NoError(t, err)
+			require.Len(t, changeSet.Changes, 4)
+			assert.Equal(t, ChangeActionUpdate, changeSet.Changes[0].Action)
+			assert.Equal(t, int64(1), changeSet.Changes[0].Revision)
+			assert.Contains(t, changeSet.Changes[0].Changes, "Name")
+
+			// 导出不改变工作单元
+			assert.Len(t, source.PendingEntities(), 4)
+
+			data, err := changeSet.Marshal(format)
+			require.NoError(t, err)
+			decoded, err := UnmarshalChangeSet(data, format)
+			require.NoError(t, err)
+
+			target := NewUnitOfWork(db)
+			require.NoError(t, target.Import(decoded, NewEntityRegistry(&User{}, &Tag{})))
+			assert.Len(t, target.PendingEntities(), 4)
+			require.NoError(t, target.Commit())
+
+			var users []User
+			require.NoError(t, db.Order("id").Find(&users).Error)
+			require.Len(t, users, 3)
+			assert.Equal(t, "张三", users[0].Name)
+			assert.Equal(t, 30, users[0].Age)
+			assert.Equal(t, int64(2), users[0].Revision)
+			assert.Equal(t, uint(3), users[1].ID)
+			assert.Equal(t, "lisi@example.com", users[2].Email)
+
+			var tag Tag
+			require.NoError(t, db.Where("name = ?", "go").First(&tag).Error)
+			assert.Equal(t, "#00ADD8", tag.Color)
+		})
+	}
+
+	t.Run("版本号已变化的变更集被拒绝", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 2)
+
+		source := NewUnitOfWork(db)
+		var first, second *User
+		require.NoError(t, source.Find(&first, 1))
+		require.NoError(t, source.Find(&second, 2))
+		first.Name = "张三"
+		require.NoError(t, source.Update(first))
+		require.NoError(t, source.Delete(second))
+
+		changeSet, err := source.Export()
+		require.NoError(t, err)
+
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 2).Update("revision", 2).Error)
+
+		target := NewUnitOfWork(db)
+		err = target.Import(changeSet, NewEntityRegistry(&User{}))
+		assert.ErrorIs(t, err, ErrStaleChangeSet)
+		assert.Contains(t, err.Error(), "change 1 (DELETE users)")
+
+		// 任一变更过期时不注册任何操作
+		assert.Empty(t, target.PendingEntities())
+		var tracked *User
+		require.NoError(t, target.Find(&tracked, 1))
+		assert.Equal(t, "用户1", tracked.Name)
+	})
+
+	t.Run("注册失败时恢复导入前的状态", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		source := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, source.Find(&user, 1))
+		user.Name = "张三"
+		require.NoError(t, source.Update(user))
+
+		changeSet, err := source.Export()
+		require.NoError(t, err)
+		// 与更新的行主键相同的插入在注册时与已跟踪的实例冲突
+		changeSet.Changes = append(changeSet.Changes, EntityChange{
+			Action: ChangeActionInsert,
+			Table:  "users",
+			Fields: map[string]interface{}{"ID": 1, "Name": "李四", "Email": "lisi@example.com"},
+		})
+
+		target := NewUnitOfWork(db)
+		err = target.Import(changeSet, NewEntityRegistry(&User{}))
+		assert.ErrorContains(t, err, "change 1 (INSERT users)")
+
+		assert.Empty(t, target.PendingEntities())
+		assert.Zero(t, target.identityMap.Len())
+		var tracked *User
+		require.NoError(t, target.Find(&tracked, 1))
+		assert.Equal(t, "用户1", tracked.Name)
+	})
+
+	t.Run("没有版本号的实体比较原值", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		forum := seedForum(t, db, "go", 0, 0)
+		require.NoError(t, db.Create(&Moderator{ForumID: forum.ID, Name: "张三"}).Error)
+
+		source := NewUnitOfWork(db)
+		var moderator *Moderator
+		require.NoError(t, source.Find(&moderator, 1))
+		moderator.Name = "李四"
+		require.NoError(t, source.Update(moderator))
+
+		changeSet, err := source.Export()
+		require.NoError(t, err)
+		registry := NewEntityRegistry(&Moderator{})
+
+		require.NoError(t, db.Model(&Moderator{}).Where("id = ?", 1).Update("name", "王五").Error)
+		assert.ErrorIs(t, NewUnitOfWork(db).Import(changeSet, registry), ErrStaleChangeSet)
+
+		require.NoError(t, db.Model(&Moderator{}).Where("id = ?", 1).Update("name", "张三").Error)
+		target := NewUnitOfWork(db)
+		require.NoError(t, target.Import(changeSet, registry))
+		require.NoError(t, target.Commit())
+
+		var saved Moderator
+		require.NoError(t, db.First(&saved, 1).Error)
+		assert.Equal(t, "李四", saved.Name)
+	})
+
+	t.Run("已删除的行和未注册的类型", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		source := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, source.Find(&user, 1))
+		require.NoError(t, source.Delete(user))
+		changeSet, err := source.Export()
+		require.NoError(t, err)
+
+		err = NewUnitOfWork(db).Import(changeSet, NewEntityRegistry(&Tag{}))
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, ErrStaleChangeSet))
+
+		require.NoError(t, db.Delete(&User{}, 1).Error)
+		assert.ErrorIs(t, NewUnitOfWork(db).Import(changeSet, NewEntityRegistry(&User{})), ErrStaleChangeSet)
+
+		changeSet.Version = ChangeSetVersion + 1
+		data, err := changeSet.Marshal(ChangeSetFormatJSON)
+		require.NoError(t, err)
+		_, err = UnmarshalChangeSet(data, ChangeSetFormatJSON)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,1032 @@
+package unitofwork
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// DefaultCoordinatorLogTable 默认协调日志表名
+const DefaultCoordinatorLogTable = "uow_coordinator_log"
+
+// transactionIDPrefix 全局事务标识前缀，恢复时据此识别协调器生成的分支事务
+const transactionIDPrefix = "uow-"
+
+// ErrTransactionInDoubt 全局事务已决定提交但部分参与者未能确认，需要调用 Coordinator.Recover
+var ErrTransactionInDoubt = errors.New("global transaction is in doubt")
+
+// CoordinatorProtocol 全局事务提交协议
+type CoordinatorProtocol string
+
+const (
+	// CoordinatorProtocolAuto 所有参与者都支持两阶段提交时使用 XA，否则使用补偿日志
+	CoordinatorProtocolAuto CoordinatorProtocol = ""
+
+	// CoordinatorProtocolXA 两阶段提交，依赖数据库的 XA 或 PREPARE TRANSACTION
+	CoordinatorProtocolXA CoordinatorProtocol = "XA"
+
+	// CoordinatorProtocolCompensation 补偿日志，依次提交本地事务，失败时补偿已提交的参与者
+	CoordinatorProtocolCompensation CoordinatorProtocol = "COMPENSATION"
+)
+
+// 协调日志状态
+const (
+	CoordinatorStatusCommitting  = "COMMITTING"
+	CoordinatorStatusCommitted   = "COMMITTED"
+	CoordinatorStatusAborted     = "ABORTED"
+	CoordinatorStatusRolledBack  = "ROLLED_BACK"
+	CoordinatorStatusCompensated = "COMPENSATED"
+)
+
+// CoordinatorLog 协调日志
+// Participant 为空的记录是写入日志库的提交决定；补偿协议下每个参与者在自身数据库的本地事务中
+// 写入一条记录，记录存在即表示该参与者已提交，Compensation 为撤销其变更的 JSON 变更集
+type CoordinatorLog struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID string    `gorm:"size:64;index" json:"transaction_id"`
+	Participant   string    `gorm:"size:255" json:"participant"`
+	Participants  string    `gorm:"type:text" json:"participants"`
+	Protocol      string    `gorm:"size:32" json:"protocol"`
+	Status        string    `gorm:"size:32;index" json:"status"`
+	Compensation  string    `gorm:"type:text" json:"compensation"`
+	LastError     string    `gorm:"type:text" json:"last_error"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// participantNames 提交决定记录的参与者名称
+func (l *CoordinatorLog) participantNames() []string {
+	if l.Participants == "" {
+		return nil
+	}
+	return strings.Split(l.Participants, ",")
+}
+
+// MigrateCoordinatorLog 创建或迁移协调日志表，table 为空时使用默认表名
+// 日志库和使用补偿协议的每个参与者数据库都需要创建该表
+func MigrateCoordinatorLog(db *gorm.DB, table string) error {
+	return db.Table(coordinatorLogTableName(table)).AutoMigrate(&CoordinatorLog{})
+}
+
+func coordinatorLogTableName(table string) string {
+	if table == "" {
+		return DefaultCoordinatorLogTable
+	}
+	return table
+}
+
+// CoordinatorConfig 协调器配置
+type CoordinatorConfig struct {
+	// 协调日志表名
+	Table string
+
+	// 提交协议
+	Protocol CoordinatorProtocol
+
+	// 恢复时只处理创建时间早于该时长的全局事务，避免干扰正在提交的事务
+	RecoveryGracePeriod time.Duration
+
+	// 按 gorm 方言名称配置的两阶段提交方言
+	Dialects map[string]TwoPhaseDialect
+
+	// 参与者工作单元的配置选项
+	UnitOfWorkOptions []ConfigOption
+}
+
+// DefaultCoordinatorConfig 默认协调器配置
+func DefaultCoordinatorConfig() *CoordinatorConfig {
+	return &CoordinatorConfig{
+		Table:               DefaultCoordinatorLogTable,
+		Protocol:            CoordinatorProtocolAuto,
+		RecoveryGracePeriod: time.Minute,
+		Dialects:            defaultTwoPhaseDialects(),
+	}
+}
+
+// CoordinatorOption 协调器配置选项
+type CoordinatorOption func(*CoordinatorConfig)
+
+// WithCoordinatorTable 配置协调日志表名
+func WithCoordinatorTable(table string) CoordinatorOption {
+	return func(c *CoordinatorConfig) {
+		c.Table = table
+	}
+}
+
+// WithCoordinatorProtocol 配置提交协议
+func WithCoordinatorProtocol(protocol CoordinatorProtocol) CoordinatorOption {
+	return func(c *CoordinatorConfig) {
+		c.Protocol = protocol
+	}
+}
+
+// WithRecoveryGracePeriod 配置恢复时跳过的最近事务时长
+func WithRecoveryGracePeriod(period time.Duration) CoordinatorOption {
+	return func(c *CoordinatorConfig) {
+		c.RecoveryGracePeriod = period
+	}
+}
+
+// WithTwoPhaseDialect 为 gorm 方言配置两阶段提交方言，dialect 为 nil 时该方言使用补偿协议
+func WithTwoPhaseDialect(name string, dialect TwoPhaseDialect) CoordinatorOption {
+	return func(c *CoordinatorConfig) {
+		if dialect == nil {
+			delete(c.Dialects, name)
+			return
+		}
+		c.Dialects[name] = dialect
+	}
+}
+
+// WithParticipantOptions 配置参与者工作单元的选项
+func WithParticipantOptions(options ...ConfigOption) CoordinatorOption {
+	return func(c *CoordinatorConfig) {
+		c.UnitOfWorkOptions = append(c.UnitOfWorkOptions, options...)
+	}
+}
+
+// participant 注册到协调器的数据库
+type participant struct {
+	name string
+	db   *gorm.DB
+}
+
+// Coordinator 跨数据库工作单元协调器
+// 每个注册的数据库对应一个工作单元，全局事务按注册顺序预提交所有参与者之后再提交
+type Coordinator struct {
+	mu           sync.RWMutex
+	logDB        *gorm.DB
+	config       *CoordinatorConfig
+	participants []*participant
+	registry     *EntityRegistry
+}
+
+// NewCoordinator 创建协调器，logDB 用于记录提交决定，可以是参与者之一
+func NewCoordinator(logDB *gorm.DB, options ...CoordinatorOption) *Coordinator {
+	config := DefaultCoordinatorConfig()
+	for _, option := range options {
+		option(config)
+	}
+
+	return &Coordinator{
+		logDB:    logDB,
+		config:   config,
+		registry: NewEntityRegistry(),
+	}
+}
+
+// Register 注册参与者数据库，名称在协调器内唯一且不能包含逗号
+func (c *Coordinator) Register(name string, db *gorm.DB) error {
+	if name == "" || strings.Contains(name, ",") {
+		return fmt.Errorf("invalid participant name %q", name)
+	}
+
+	if db == nil {
+		return fmt.Errorf("database of participant %s cannot be nil", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.participants {
+		if p.name == name {
+			return fmt.Errorf("participant %s is already registered", name)
+		}
+	}
+
+	c.participants = append(c.participants, &participant{name: name, db: db})
+	return nil
+}
+
+// RegisterEntities 注册补偿时需要的实体类型
+// 提交时会自动注册涉及的实体类型，进程重启后调用 Recover 之前需要手动注册
+func (c *Coordinator) RegisterEntities(entities ...Entity) {
+	c.registry.Register(entities...)
+}
+
+// participant 按名称查找参与者
+func (c *Coordinator) participant(name string) (*participant, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, p := range c.participants {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// dialectOf 获取数据库的两阶段提交方言
+func (c *Coordinator) dialectOf(db *gorm.DB) (TwoPhaseDialect, bool) {
+	dialect, ok := c.config.Dialects[db.Dialector.Name()]
+	return dialect, ok
+}
+
+// logTable 协调日志表
+func (c *Coordinator) logTable(db *gorm.DB) *gorm.DB {
+	return db.Table(coordinatorLogTableName(c.config.Table))
+}
+
+// updateLog 更新协调日志状态
+func (c *Coordinator) updateLog(ctx context.Context, db *gorm.DB, record *CoordinatorLog, status string, cause error) error {
+	values := map[string]interface{}{"status": status, "updated_at": time.Now()}
+	if cause != nil {
+		values["last_error"] = cause.Error()
+	}
+
+	if err := c.logTable(db.WithContext(ctx)).Where("id = ?", record.ID).Updates(values).Error; err != nil {
+		return fmt.Errorf("failed to update coordinator log of transaction %s: %w", record.TransactionID, err)
+	}
+
+	record.Status = status
+	return nil
+}
+
+// Begin 开启全局事务
+func (c *Coordinator) Begin(ctx context.Context) *GlobalTransaction {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	return &GlobalTransaction{
+		coordinator: c,
+		ctx:         ctx,
+		id:          fmt.Sprintf("%s%d-%s", transactionIDPrefix, now.UnixNano(), strings.ReplaceAll(uuid.NewString(), "-", "")),
+		units:       make(map[string]*UnitOfWork),
+	}
+}
+
+// GlobalTransaction 跨数据库的全局事务
+type GlobalTransaction struct {
+	mu          sync.Mutex
+	coordinator *Coordinator
+	ctx         context.Context
+	id          string
+	units       map[string]*UnitOfWork
+	finished    bool
+}
+
+// ID 获取全局事务标识
+func (t *GlobalTransaction) ID() string {
+	return t.id
+}
+
+// UnitOfWork 获取参与者的工作单元，首次获取时创建
+// 参与者的操作在提交时才在分支事务中执行，不能配置自动刷新，也不能调用 Flush 或 Begin
+func (t *GlobalTransaction) UnitOfWork(name string) (*UnitOfWork, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished {
+		return nil, fmt.Errorf("global transaction %s is already finished", t.id)
+	}
+
+	if uow, exists := t.units[name]; exists {
+		return uow, nil
+	}
+
+	p, exists := t.coordinator.participant(name)
+	if !exists {
+		return nil, fmt.Errorf("participant %s is not registered", name)
+	}
+
+	uow := NewUnitOfWork(p.db, t.coordinator.config.UnitOfWorkOptions...).WithContext(t.ctx)
+	if uow.config.AutoFlushThreshold > 0 {
+		return nil, fmt.Errorf("participant %s of global transaction %s cannot use auto flush", name, t.id)
+	}
+	uow.globalTransaction = t.id
+	t.units[name] = uow
+	return uow, nil
+}
+
+// Rollback 回滚所有参与者的工作单元
+func (t *GlobalTransaction) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished {
+		return fmt.Errorf("global transaction %s is already finished", t.id)
+	}
+	t.finished = true
+
+	var errs []error
+	for name, uow := range t.units {
+		if err := uow.Rollback(); err != nil {
+			errs = append(errs, fmt.Errorf("participant %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Commit 提交全局事务
+// 所有参与者都支持两阶段提交时先预提交所有分支再提交；否则依次提交本地事务，
+// 某个参与者提交失败时按补偿日志撤销已提交的参与者。失败时实体恢复到提交前的状态
+func (t *GlobalTransaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished {
+		return fmt.Errorf("global transaction %s is already finished", t.id)
+	}
+	t.finished = true
+
+	branches := t.branches()
+	if len(branches) == 0 {
+		return nil
+	}
+
+	protocol := t.protocolOf(branches)
+	zlogger.Info().
+		Str("transaction_id", t.id).
+		Str("protocol", string(protocol)).
+		Int("participants", len(branches)).
+		Msg("Starting global transaction commit")
+
+	if protocol == CoordinatorProtocolXA {
+		return t.commitTwoPhase(branches)
+	}
+	return t.commitCompensating(branches)
+}
+
+// branch 全局事务中的一个参与者分支
+type branch struct {
+	participant *participant
+	uow         *UnitOfWork
+	states      map[Entity]Entity
+
+	// 两阶段提交
+	dialect  TwoPhaseDialect
+	xid      string
+	conn     *sql.Conn
+	begun    bool
+	prepared bool
+
+	// 补偿协议
+	tx            *gorm.DB
+	compensations []compensation
+	record        *CoordinatorLog
+}
+
+// branches 按注册顺序收集参与者分支
+func (t *GlobalTransaction) branches() []*branch {
+	t.coordinator.mu.RLock()
+	defer t.coordinator.mu.RUnlock()
+
+	branches := make([]*branch, 0, len(t.units))
+	for _, p := range t.coordinator.participants {
+		if uow, exists := t.units[p.name]; exists {
+			branches = append(branches, &branch{participant: p, uow: uow})
+		}
+	}
+	return branches
+}
+
+// protocolOf 选择提交协议
+func (t *GlobalTransaction) protocolOf(branches []*branch) CoordinatorProtocol {
+	if t.coordinator.config.Protocol == CoordinatorProtocolCompensation {
+		return CoordinatorProtocolCompensation
+	}
+
+	for _, b := range branches {
+		if _, ok := t.coordinator.dialectOf(b.participant.db); !ok {
+			return CoordinatorProtocolCompensation
+		}
+	}
+	return CoordinatorProtocolXA
+}
+
+// participantNames 参与者名称列表
+func participantNames(branches []*branch) string {
+	names := make([]string, 0, len(branches))
+	for _, b := range branches {
+		names = append(names, b.participant.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// flush 在 db 上执行参与者工作单元的所有操作
+func (b *branch) flush(db *gorm.DB) error {
+	b.states = b.uow.saveEntityStates()
+
+	b.uow.mu.Lock()
+	b.uow.db = db
+	b.uow.mu.Unlock()
+
+	if err := b.uow.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// discard 回滚未刷新的工作单元并恢复实体状态
+func (t *GlobalTransaction) discard(branches []*branch) {
+	for _, b := range branches {
+		if !b.uow.IsCommitted() && !b.uow.IsRolledBack() {
+			if err := b.uow.Rollback(); err != nil {
+				zlogger.Error().Err(err).Str("participant", b.participant.name).Msg("Failed to rollback unit of work")
+			}
+		}
+		restoreEntityStates(b.states)
+	}
+}
+
+// commitTwoPhase 按两阶段提交协议提交
+func (t *GlobalTransaction) commitTwoPhase(branches []*branch) error {
+	defer t.closeConns(branches)
+
+	for i, b := range branches {
+		b.dialect, _ = t.coordinator.dialectOf(b.participant.db)
+		b.xid = branchXID(t.id, i)
+
+		if err := t.prepareBranch(b); err != nil {
+			t.abortTwoPhase(branches)
+			return fmt.Errorf("failed to prepare participant %s of transaction %s: %w", b.participant.name, t.id, err)
+		}
+	}
+
+	// 提交决定写入日志之后，预提交的分支只能提交
+	decision := &CoordinatorLog{
+		TransactionID: t.id,
+		Participants:  participantNames(branches),
+		Protocol:      string(CoordinatorProtocolXA),
+		Status:        CoordinatorStatusCommitting,
+	}
+	if err := t.coordinator.logTable(t.coordinator.logDB.WithContext(t.ctx)).Create(decision).Error; err != nil {
+		t.abortTwoPhase(branches)
+		return fmt.Errorf("failed to log commit decision of transaction %s: %w", t.id, err)
+	}
+
+	var errs []error
+	for _, b := range branches {
+		if err := b.dialect.CommitPrepared(t.ctx, b.participant.db, b.xid); err != nil {
+			errs = append(errs, fmt.Errorf("participant %s: %w", b.participant.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: transaction %s: %w", ErrTransactionInDoubt, t.id, errors.Join(errs...))
+	}
+
+	if err := t.coordinator.updateLog(t.ctx, t.coordinator.logDB, decision, CoordinatorStatusCommitted, nil); err != nil {
+		// 所有分支已提交，恢复时会将日志标记为已提交
+		zlogger.Warn().Err(err).Str("transaction_id", t.id).Msg("Failed to mark global transaction as committed")
+	}
+
+	zlogger.Info().Str("transaction_id", t.id).Msg("Global transaction committed")
+	return nil
+}
+
+// prepareBranch 在专用连接上执行参与者的操作并预提交
+func (t *GlobalTransaction) prepareBranch(b *branch) error {
+	sqlDB, err := b.participant.db.DB()
+	if err != nil {
+		return err
+	}
+
+	b.conn, err = sqlDB.Conn(t.ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := b.dialect.Begin(t.ctx, b.conn, b.xid); err != nil {
+		return err
+	}
+	b.begun = true
+
+	// 分支事务中不能再开启 gorm 的默认事务，工作单元视其为外部事务，以保存点执行
+	session := b.participant.db.Session(&gorm.Session{NewDB: true, Context: t.ctx, SkipDefaultTransaction: true})
+	session.Statement.ConnPool = &branchConn{conn: b.conn, xid: b.xid}
+
+	if err := b.flush(session); err != nil {
+		return err
+	}
+
+	if err := b.dialect.Prepare(t.ctx, b.conn, b.xid); err != nil {
+		return err
+	}
+	b.prepared = true
+
+	return nil
+}
+
+// branchConn 分支事务的专用连接，实现 gorm.TxCommitter 使工作单元和 gorm 将其视为已开启的事务，
+// 不会在分支中再开启事务；分支只能由协调器预提交、提交或回滚
+type branchConn struct {
+	conn *sql.Conn
+	xid  string
+}
+
+func (c *branchConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(ctx, query)
+}
+
+func (c *branchConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, query, args...)
+}
+
+func (c *branchConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, query, args...)
+}
+
+func (c *branchConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(ctx, query, args...)
+}
+
+// Commit 实现 gorm.TxCommitter 接口
+func (c *branchConn) Commit() error {
+	return fmt.Errorf("branch transaction %s is committed by the coordinator", c.xid)
+}
+
+// Rollback 实现 gorm.TxCommitter 接口
+func (c *branchConn) Rollback() error {
+	return fmt.Errorf("branch transaction %s is rolled back by the coordinator", c.xid)
+}
+
+// abortTwoPhase 回滚所有已开启的分支事务
+func (t *GlobalTransaction) abortTwoPhase(branches []*branch) {
+	for _, b := range branches {
+		var err error
+		switch {
+		case b.prepared:
+			err = b.dialect.RollbackPrepared(t.ctx, b.participant.db, b.xid)
+		case b.begun:
+			err = b.dialect.Rollback(t.ctx, b.conn, b.xid)
+		}
+
+		if err != nil {
+			zlogger.Error().Err(err).
+				Str("transaction_id", t.id).
+				Str("participant", b.participant.name).
+				Msg("Failed to rollback branch transaction, run Recover to resolve it")
+		}
+	}
+
+	t.discard(branches)
+}
+
+// closeConns 归还专用连接
+func (t *GlobalTransaction) closeConns(branches []*branch) {
+	for _, b := range branches {
+		if b.conn != nil {
+			_ = b.conn.Close()
+		}
+	}
+}
+
+// commitCompensating 按补偿协议提交
+func (t *GlobalTransaction) commitCompensating(branches []*branch) error {
+	// 执行任何操作之前检查所有变更都可以补偿
+	for _, b := range branches {
+		compensations, err := b.uow.compensationPlan(t.coordinator.registry)
+		if err != nil {
+			t.discard(branches)
+			return fmt.Errorf("participant %s of transaction %s: %w", b.participant.name, t.id, err)
+		}
+		b.compensations = compensations
+	}
+
+	for _, b := range branches {
+		if err := t.prepareCompensating(b); err != nil {
+			t.abortCompensating(branches)
+			return fmt.Errorf("failed to prepare participant %s of transaction %s: %w", b.participant.name, t.id, err)
+		}
+	}
+
+	decision := &CoordinatorLog{
+		TransactionID: t.id,
+		Participants:  participantNames(branches),
+		Protocol:      string(CoordinatorProtocolCompensation),
+		Status:        CoordinatorStatusCommitting,
+	}
+	if err := t.coordinator.logTable(t.coordinator.logDB.WithContext(t.ctx)).Create(decision).Error; err != nil {
+		t.abortCompensating(branches)
+		return fmt.Errorf("failed to log commit decision of transaction %s: %w", t.id, err)
+	}
+
+	for i, b := range branches {
+		err := b.tx.Commit().Error
+		b.tx = nil
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("failed to commit participant %s of transaction %s: %w", b.participant.name, t.id, err)
+		t.abortCompensating(branches[i+1:])
+		t.discard(branches[:i+1])
+
+		if logErr := t.coordinator.updateLog(t.ctx, t.coordinator.logDB, decision, CoordinatorStatusAborted, err); logErr != nil {
+			zlogger.Error().Err(logErr).Str("transaction_id", t.id).Msg("Failed to mark global transaction as aborted")
+		}
+
+		// 按提交的逆序补偿
+		var errs []error
+		for j := i - 1; j >= 0; j-- {
+			if compErr := t.coordinator.compensate(t.ctx, branches[j].participant, branches[j].record); compErr != nil {
+				errs = append(errs, compErr)
+			}
+		}
+
+		if len(errs) > 0 {
+			return fmt.Errorf("%w, compensation incomplete, run Recover to retry: %w", err, errors.Join(errs...))
+		}
+
+		if logErr := t.coordinator.updateLog(t.ctx, t.coordinator.logDB, decision, CoordinatorStatusRolledBack, nil); logErr != nil {
+			zlogger.Error().Err(logErr).Str("transaction_id", t.id).Msg("Failed to mark global transaction as rolled back")
+		}
+		return err
+	}
+
+	if err := t.coordinator.updateLog(t.ctx, t.coordinator.logDB, decision, CoordinatorStatusCommitted, nil); err != nil {
+		// 恢复时所有参与者都已提交，会将日志标记为已提交
+		zlogger.Warn().Err(err).Str("transaction_id", t.id).Msg("Failed to mark global transaction as committed")
+	}
+
+	zlogger.Info().Str("transaction_id", t.id).Msg("Global transaction committed")
+	return nil
+}
+
+// prepareCompensating 在本地事务中执行参与者的操作，并在同一事务中写入补偿日志
+func (t *GlobalTransaction) prepareCompensating(b *branch) error {
+	tx := b.participant.db.WithContext(t.ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	b.tx = tx
+
+	if err := b.flush(tx); err != nil {
+		return err
+	}
+
+	changeSet := &ChangeSet{
+		Version:   ChangeSetVersion,
+		CreatedAt: time.Now(),
+		Changes:   make([]EntityChange, 0, len(b.compensations)),
+	}
+	for i := len(b.compensations) - 1; i >= 0; i-- {
+		if change, ok := b.compensations[i].inverse(); ok {
+			changeSet.Changes = append(changeSet.Changes, change)
+		}
+	}
+
+	data, err := changeSet.Marshal(ChangeSetFormatJSON)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compensation: %w", err)
+	}
+
+	b.record = &CoordinatorLog{
+		TransactionID: t.id,
+		Participant:   b.participant.name,
+		Protocol:      string(CoordinatorProtocolCompensation),
+		Status:        CoordinatorStatusCommitted,
+		Compensation:  string(data),
+	}
+	if err := t.coordinator.logTable(tx).Create(b.record).Error; err != nil {
+		return fmt.Errorf("failed to log compensation: %w", err)
+	}
+
+	return nil
+}
+
+// abortCompensating 回滚所有未提交的本地事务
+func (t *GlobalTransaction) abortCompensating(branches []*branch) {
+	for _, b := range branches {
+		if b.tx == nil {
+			continue
+		}
+
+		if err := b.tx.Rollback().Error; err != nil && !errors.Is(err, sql.ErrTxDone) {
+			zlogger.Error().Err(err).
+				Str("transaction_id", t.id).
+				Str("participant", b.participant.name).
+				Msg("Failed to rollback local transaction")
+		}
+		b.tx = nil
+	}
+
+	t.discard(branches)
+}
+
+// compensate 在参与者数据库中导入补偿变更集撤销已提交的变更，并在同一事务中将补偿日志标记为已补偿
+func (c *Coordinator) compensate(ctx context.Context, p *participant, record *CoordinatorLog) error {
+	changeSet, err := UnmarshalChangeSet([]byte(record.Compensation), ChangeSetFormatJSON)
+	if err != nil {
+		return fmt.Errorf("participant %s: %w", p.name, err)
+	}
+
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		uow := NewUnitOfWork(tx, WithValidation(false)).WithContext(ctx)
+		if err := uow.Import(changeSet, c.registry); err != nil {
+			return err
+		}
+
+		if err := uow.Commit(); err != nil {
+			return err
+		}
+
+		return c.updateLog(ctx, tx, record, CoordinatorStatusCompensated, nil)
+	})
+	if err != nil {
+		if logErr := c.updateLog(ctx, p.db, record, record.Status, err); logErr != nil {
+			zlogger.Error().Err(logErr).Str("participant", p.name).Msg("Failed to record compensation error")
+		}
+		return fmt.Errorf("failed to compensate participant %s of transaction %s: %w", p.name, record.TransactionID, err)
+	}
+
+	zlogger.Info().
+		Str("transaction_id", record.TransactionID).
+		Str("participant", p.name).
+		Msg("Compensated participant of global transaction")
+	return nil
+}
+
+// Recover 处理创建时间早于恢复宽限期的未决全局事务，返回处理的事务数量
+// 两阶段提交：已决定提交的分支继续提交，没有提交决定的预提交分支回滚；
+// 补偿协议：所有参与者都已提交的事务标记为已提交，否则补偿已提交的参与者
+func (c *Coordinator) Recover(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-c.config.RecoveryGracePeriod)
+
+	var decisions []*CoordinatorLog
+	if err := c.logTable(c.logDB.WithContext(ctx)).
+		Where("participant = ? AND status IN ? AND created_at < ?", "", []string{CoordinatorStatusCommitting, CoordinatorStatusAborted}, cutoff).
+		Order("id").Find(&decisions).Error; err != nil {
+		return 0, fmt.Errorf("failed to load in-doubt transactions: %w", err)
+	}
+
+	recovered := 0
+	var errs []error
+	for _, decision := range decisions {
+		var err error
+		if decision.Protocol == string(CoordinatorProtocolXA) {
+			err = c.recoverTwoPhase(ctx, decision)
+		} else {
+			err = c.recoverCompensating(ctx, decision)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to recover transaction %s: %w", decision.TransactionID, err))
+			continue
+		}
+		recovered++
+	}
+
+	orphans, err := c.rollbackOrphanBranches(ctx, cutoff)
+	recovered += orphans
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return recovered, errors.Join(errs...)
+}
+
+// recoverTwoPhase 提交已决定提交的事务中仍处于预提交状态的分支
+func (c *Coordinator) recoverTwoPhase(ctx context.Context, decision *CoordinatorLog) error {
+	for i, name := range decision.participantNames() {
+		p, exists := c.participant(name)
+		if !exists {
+			return fmt.Errorf("participant %s is not registered", name)
+		}
+
+		dialect, ok := c.dialectOf(p.db)
+		if !ok {
+			return fmt.Errorf("participant %s does not support two-phase commit", name)
+		}
+
+		prepared, err := dialect.Recover(ctx, p.db)
+		if err != nil {
+			return fmt.Errorf("failed to list prepared transactions of participant %s: %w", name, err)
+		}
+
+		xid := branchXID(decision.TransactionID, i)
+		for _, preparedXID := range prepared {
+			if preparedXID != xid {
+				continue
+			}
+			if err := dialect.CommitPrepared(ctx, p.db, xid); err != nil {
+				return fmt.Errorf("failed to commit participant %s: %w", name, err)
+			}
+		}
+	}
+
+	return c.updateLog(ctx, c.logDB, decision, CoordinatorStatusCommitted, nil)
+}
+
+// recoverCompensating 根据参与者数据库中的补偿日志判断各参与者是否已提交
+func (c *Coordinator) recoverCompensating(ctx context.Context, decision *CoordinatorLog) error {
+	names := decision.participantNames()
+	participants := make([]*participant, len(names))
+	records := make([]*CoordinatorLog, len(names))
+	committed := 0
+
+	for i, name := range names {
+		p, exists := c.participant(name)
+		if !exists {
+			return fmt.Errorf("participant %s is not registered", name)
+		}
+		participants[i] = p
+
+		var found []*CoordinatorLog
+		if err := c.logTable(p.db.WithContext(ctx)).
+			Where("transaction_id = ? AND participant = ?", decision.TransactionID, name).
+			Limit(1).Find(&found).Error; err != nil {
+			return fmt.Errorf("failed to load compensation of participant %s: %w", name, err)
+		}
+
+		// 没有日志的参与者本地事务未提交
+		if len(found) > 0 {
+			records[i] = found[0]
+			if found[0].Status == CoordinatorStatusCommitted {
+				committed++
+			}
+		}
+	}
+
+	// 提交阶段中断但所有参与者都已提交，事务实际上已完成
+	if decision.Status == CoordinatorStatusCommitting && committed == len(names) {
+		return c.updateLog(ctx, c.logDB, decision, CoordinatorStatusCommitted, nil)
+	}
+
+	if decision.Status == CoordinatorStatusCommitting {
+		if err := c.updateLog(ctx, c.logDB, decision, CoordinatorStatusAborted, nil); err != nil {
+			return err
+		}
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		if records[i] == nil || records[i].Status != CoordinatorStatusCommitted {
+			continue
+		}
+
+		if err := c.compensate(ctx, participants[i], records[i]); err != nil {
+			return err
+		}
+	}
+
+	return c.updateLog(ctx, c.logDB, decision, CoordinatorStatusRolledBack, nil)
+}
+
+// rollbackOrphanBranches 回滚没有提交决定的预提交分支，这些分支所属的事务在写入决定之前中断
+func (c *Coordinator) rollbackOrphanBranches(ctx context.Context, cutoff time.Time) (int, error) {
+	c.mu.RLock()
+	participants := append([]*participant{}, c.participants...)
+	c.mu.RUnlock()
+
+	rolledBack := 0
+	var errs []error
+	for _, p := range participants {
+		dialect, ok := c.dialectOf(p.db)
+		if !ok {
+			continue
+		}
+
+		prepared, err := dialect.Recover(ctx, p.db)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list prepared transactions of participant %s: %w", p.name, err))
+			continue
+		}
+
+		for _, xid := range prepared {
+			transactionID, ok := parseBranchXID(xid)
+			if !ok || !transactionStartedBefore(transactionID, cutoff) {
+				continue
+			}
+
+			var decisions int64
+			if err := c.logTable(c.logDB.WithContext(ctx)).
+				Where("transaction_id = ? AND participant = ?", transactionID, "").
+				Count(&decisions).Error; err != nil {
+				errs = append(errs, fmt.Errorf("failed to load decision of transaction %s: %w", transactionID, err))
+				continue
+			}
+			if decisions > 0 {
+				continue
+			}
+
+			if err := dialect.RollbackPrepared(ctx, p.db, xid); err != nil {
+				errs = append(errs, fmt.Errorf("failed to rollback branch %s of participant %s: %w", xid, p.name, err))
+				continue
+			}
+
+			zlogger.Info().Str("xid", xid).Str("participant", p.name).Msg("Rolled back orphan branch transaction")
+			rolledBack++
+		}
+	}
+
+	return rolledBack, errors.Join(errs...)
+}
+
+// transactionStartedBefore 根据全局事务标识中的时间戳判断事务是否开始于 cutoff 之前
+func transactionStartedBefore(transactionID string, cutoff time.Time) bool {
+	rest := strings.TrimPrefix(transactionID, transactionIDPrefix)
+	dash := strings.Index(rest, "-")
+	if dash < 0 {
+		return false
+	}
+
+	nanos, err := strconv.ParseInt(rest[:dash], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Unix(0, nanos).Before(cutoff)
+}
+
+// compensation 补偿协议下一个实体变更的撤销信息
+type compensation struct {
+	entity Entity
+	change EntityChange
+
+	// 删除前的所有字段值，硬删除和永久删除通过重新插入撤销
+	fields map[string]interface{}
+}
+
+// compensationPlan 在刷新之前记录撤销每个变更所需的信息，无法撤销的变更返回错误
+func (uow *UnitOfWork) compensationPlan(registry *EntityRegistry) ([]compensation, error) {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	compensations := make([]compensation, 0, len(uow.operations))
+	for _, op := range uow.operations {
+		for _, entity := range operationEntities(op) {
+			change, err := uow.exportChange(op, entity)
+			if err != nil {
+				return nil, err
+			}
+
+			switch change.Action {
+			case ChangeActionUpsert:
+				return nil, fmt.Errorf("upsert of entity %T cannot be compensated", entity)
+			case ChangeActionUpdate:
+				if change.Changes == nil {
+					return nil, fmt.Errorf("update of entity %T without snapshot cannot be compensated", entity)
+				}
+			}
+
+			item := compensation{entity: entity, change: change}
+			if change.Action == ChangeActionDelete || change.Action == ChangeActionPurge {
+				item.fields = extractFieldValues(entity)
+			}
+
+			registry.Register(entity)
+			compensations = append(compensations, item)
+		}
+	}
+
+	return compensations, nil
+}
+
+// inverse 根据刷新后的实体状态构建撤销变更，没有需要撤销的内容时返回 false
+func (c compensation) inverse() (EntityChange, bool) {
+	inverse := EntityChange{
+		Table: c.change.Table,
+		ID:    IdentityOf(c.entity).Values(),
+	}
+	if revisioned, ok := c.entity.(HasRevision); ok {
+		inverse.Revision = revisioned.GetRevision()
+	}
+
+	switch c.change.Action {
+	case ChangeActionInsert:
+		inverse.Action = ChangeActionPurge
+	case ChangeActionUpdate:
+		if len(c.change.Changes) == 0 {
+			return inverse, false
+		}
+
+		inverse.Action = ChangeActionUpdate
+		inverse.Changes = make(map[string]FieldChange, len(c.change.Changes))
+		for name, change := range c.change.Changes {
+			inverse.Changes[name] = FieldChange{
+				FieldName: change.FieldName,
+				OldValue:  change.NewValue,
+				NewValue:  change.OldValue,
+				Type:      FieldChangeTypeModified,
+			}
+		}
+	case ChangeActionDelete:
+		if _, ok := c.entity.(SoftDelete); ok {
+			inverse.Action = ChangeActionRestore
+		} else {
+			inverse = EntityChange{Action: ChangeActionUpsert, Table: c.change.Table, Fields: c.fields}
+		}
+	case ChangeActionRestore:
+		inverse.Action = ChangeActionDelete
+	case ChangeActionPurge:
+		inverse = EntityChange{Action: ChangeActionUpsert, Table: c.change.Table, Fields: c.fields}
+	default:
+		return inverse, false
+	}
+
+	return inverse, true
+}
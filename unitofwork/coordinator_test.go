@@ -0,0 +1,392 @@
+package unitofwork
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/logger"
+	"github.com/wubin1989/sqlite"
+)
+
+// 示例实体：账本分录，外键延迟到提交时检查
+type LedgerEntry struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint
+	Amount int
+}
+
+func (e *LedgerEntry) GetTableName() string {
+	return "ledger_entries"
+}
+
+func (e *LedgerEntry) IsNew() bool {
+	return e.ID == 0
+}
+
+func setupCoordinatorDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:?_foreign_keys=1"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	require.NoError(t, db.AutoMigrate(&User{}, &Post{}))
+	require.NoError(t, db.Exec(`CREATE TABLE ledger_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) DEFERRABLE INITIALLY DEFERRED,
+		amount INTEGER
+	)`).Error)
+	require.NoError(t, MigrateCoordinatorLog(db, ""))
+	return db
+}
+
+// fakeTwoPhase 以 sqlite 本地事务模拟两阶段提交，预提交的分支保留在专用连接上
+type fakeTwoPhase struct {
+	mu       sync.Mutex
+	prepared map[string]*sql.Conn
+}
+
+func (f *fakeTwoPhase) Begin(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "BEGIN")
+	return err
+}
+
+func (f *fakeTwoPhase) Prepare(ctx context.Context, conn *sql.Conn, xid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prepared[xid] = conn
+	return nil
+}
+
+func (f *fakeTwoPhase) Rollback(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+func (f *fakeTwoPhase) finish(ctx context.Context, xid, statement string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conn, exists := f.prepared[xid]
+	if !exists {
+		return errors.New("unknown xid " + xid)
+	}
+	delete(f.prepared, xid)
+
+	_, err := conn.ExecContext(ctx, statement)
+	return err
+}
+
+func (f *fakeTwoPhase) CommitPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return f.finish(ctx, xid, "COMMIT")
+}
+
+func (f *fakeTwoPhase) RollbackPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return f.finish(ctx, xid, "ROLLBACK")
+}
+
+func (f *fakeTwoPhase) Recover(ctx context.Context, db *gorm.DB) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	xids := make([]string, 0, len(f.prepared))
+	for xid := range f.prepared {
+		xids = append(xids, xid)
+	}
+	return xids, nil
+}
+
+// coordinatorFixture 订单库、账本库和独立的日志库
+type coordinatorFixture struct {
+	orders, ledger, log *gorm.DB
+	coordinator         *Coordinator
+}
+
+func newCoordinatorFixture(t *testing.T, options ...CoordinatorOption) *coordinatorFixture {
+	f := &coordinatorFixture{
+		orders: setupCoordinatorDB(t),
+		ledger: setupCoordinatorDB(t),
+		log:    setupCoordinatorDB(t),
+	}
+	seedUsers(t, f.orders, 3)
+	seedUsers(t, f.ledger, 1)
+
+	f.coordinator = NewCoordinator(f.log, options...)
+	require.NoError(t, f.coordinator.Register("orders", f.orders))
+	require.NoError(t, f.coordinator.Register("ledger", f.ledger))
+	return f
+}
+
+// decisions 日志库中的提交决定
+func (f *coordinatorFixture) decisions(t *testing.T) []CoordinatorLog {
+	var decisions []CoordinatorLog
+	require.NoError(t, f.log.Table(DefaultCoordinatorLogTable).Where("participant = ?", "").Find(&decisions).Error)
+	return decisions
+}
+
+// stageOrders 在订单库中新增、修改和删除用户
+func stageOrders(t *testing.T, transaction *GlobalTransaction) (*User, *User, *User) {
+	orders, err := transaction.UnitOfWork("orders")
+	require.NoError(t, err)
+
+	created := &User{Name: "张三", Email: "zhangsan@example.com", Age: 18}
+	require.NoError(t, orders.Create(created))
+
+	var updated, deleted *User
+	require.NoError(t, orders.Find(&updated, 1))
+	require.NoError(t, orders.Find(&deleted, 2))
+	updated.Age = 30
+	require.NoError(t, orders.Update(updated))
+	require.NoError(t, orders.Delete(deleted))
+
+	return created, updated, deleted
+}
+
+// assertOrdersUnchanged 检查订单库中的用户与初始数据一致
+func assertOrdersUnchanged(t *testing.T, db *gorm.DB) {
+	var users []User
+	require.NoError(t, db.Order("id").Find(&users).Error)
+	require.Len(t, users, 3)
+	assert.Equal(t, 20, users[0].Age)
+	assert.Equal(t, uint(2), users[1].ID)
+}
+
+// TestCoordinator 测试跨数据库全局事务
+func TestCoordinator(t *testing.T) {
+	t.Run("补偿协议提交所有参与者", func(t *testing.T) {
+		f := newCoordinatorFixture(t)
+		transaction := f.coordinator.Begin(context.Background())
+		created, _, _ := stageOrders(t, transaction)
+
+		ledger, err := transaction.UnitOfWork("ledger")
+		require.NoError(t, err)
+		require.NoError(t, ledger.Create(&LedgerEntry{UserID: 1, Amount: 100}))
+
+		require.NoError(t, transaction.Commit())
+		assert.Error(t, transaction.Commit())
+
+		var users []User
+		require.NoError(t, f.orders.Order("id").Find(&users).Error)
+		require.Len(t, users, 3)
+		assert.Equal(t, 30, users[0].Age)
+		assert.Equal(t, created.ID, users[2].ID)
+
+		var entries int64
+		require.NoError(t, f.ledger.Model(&LedgerEntry{}).Count(&entries).Error)
+		assert.Equal(t, int64(1), entries)
+
+		decisions := f.decisions(t)
+		require.Len(t, decisions, 1)
+		assert.Equal(t, CoordinatorStatusCommitted, decisions[0].Status)
+		assert.Equal(t, "orders,ledger", decisions[0].Participants)
+		assert.Equal(t, string(CoordinatorProtocolCompensation), decisions[0].Protocol)
+	})
+
+	t.Run("参与者提交失败时补偿已提交的参与者", func(t *testing.T) {
+		f := newCoordinatorFixture(t)
+		transaction := f.coordinator.Begin(context.Background())
+		created, updated, deleted := stageOrders(t, transaction)
+
+		// 外键在提交时才检查，账本库的本地事务提交失败
+		ledger, err := transaction.UnitOfWork("ledger")
+		require.NoError(t, err)
+		require.NoError(t, ledger.Create(&LedgerEntry{UserID: 99, Amount: 100}))
+
+		err = transaction.Commit()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to commit participant ledger")
+
+		assertOrdersUnchanged(t, f.orders)
+		var purged int64
+		require.NoError(t, f.orders.Unscoped().Model(&User{}).Where("email = ?", "zhangsan@example.com").Count(&purged).Error)
+		assert.Equal(t, int64(0), purged)
+
+		// 实体恢复到提交前的状态
+		assert.Zero(t, created.ID)
+		assert.Equal(t, int64(1), updated.Revision)
+		assert.False(t, deleted.IsDeleted())
+
+		var record CoordinatorLog
+		require.NoError(t, f.orders.Table(DefaultCoordinatorLogTable).Where("participant = ?", "orders").First(&record).Error)
+		assert.Equal(t, CoordinatorStatusCompensated, record.Status)
+
+		decisions := f.decisions(t)
+		require.Len(t, decisions, 1)
+		assert.Equal(t, CoordinatorStatusRolledBack, decisions[0].Status)
+		assert.Contains(t, decisions[0].LastError, "FOREIGN KEY")
+	})
+
+	t.Run("不能补偿的变更在执行前被拒绝", func(t *testing.T) {
+		f := newCoordinatorFixture(t)
+		transaction := f.coordinator.Begin(context.Background())
+
+		orders, err := transaction.UnitOfWork("orders")
+		require.NoError(t, err)
+		require.NoError(t, orders.Upsert(&User{Name: "张三", Email: "user1@example.com", Age: 40}, "Email"))
+
+		_, err = transaction.UnitOfWork("inventory")
+		assert.Error(t, err)
+
+		assert.Error(t, transaction.Commit())
+		assertOrdersUnchanged(t, f.orders)
+		assert.Empty(t, f.decisions(t))
+	})
+
+	t.Run("恢复提交阶段中断的补偿事务", func(t *testing.T) {
+		f := newCoordinatorFixture(t, WithRecoveryGracePeriod(0))
+		transaction := f.coordinator.Begin(context.Background())
+		stageOrders(t, transaction)
+		ledger, err := transaction.UnitOfWork("ledger")
+		require.NoError(t, err)
+		require.NoError(t, ledger.Create(&LedgerEntry{UserID: 1, Amount: 100}))
+
+		// 模拟订单库提交后协调器崩溃，账本库的本地事务随连接断开回滚
+		branches := transaction.branches()
+		for _, b := range branches {
+			b.compensations, err = b.uow.compensationPlan(f.coordinator.registry)
+			require.NoError(t, err)
+			require.NoError(t, transaction.prepareCompensating(b))
+		}
+		require.NoError(t, f.log.Table(DefaultCoordinatorLogTable).Create(&CoordinatorLog{
+			TransactionID: transaction.ID(),
+			Participants:  "orders,ledger",
+			Protocol:      string(CoordinatorProtocolCompensation),
+			Status:        CoordinatorStatusCommitting,
+		}).Error)
+		require.NoError(t, branches[0].tx.Commit().Error)
+		require.NoError(t, branches[1].tx.Rollback().Error)
+
+		// 进程重启后使用新的协调器恢复
+		recovery := NewCoordinator(f.log, WithRecoveryGracePeriod(0))
+		require.NoError(t, recovery.Register("orders", f.orders))
+		require.NoError(t, recovery.Register("ledger", f.ledger))
+		recovery.RegisterEntities(&User{}, &LedgerEntry{})
+
+		recovered, err := recovery.Recover(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, recovered)
+		assertOrdersUnchanged(t, f.orders)
+		assert.Equal(t, CoordinatorStatusRolledBack, f.decisions(t)[0].Status)
+
+		recovered, err = recovery.Recover(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, recovered)
+	})
+
+	t.Run("两阶段提交", func(t *testing.T) {
+		dialect := &fakeTwoPhase{prepared: make(map[string]*sql.Conn)}
+		f := newCoordinatorFixture(t, WithTwoPhaseDialect("sqlite", dialect))
+
+		transaction := f.coordinator.Begin(context.Background())
+		stageOrders(t, transaction)
+		ledger, err := transaction.UnitOfWork("ledger")
+		require.NoError(t, err)
+		require.NoError(t, ledger.Create(&LedgerEntry{UserID: 1, Amount: 100}))
+		require.NoError(t, transaction.Commit())
+
+		var users []User
+		require.NoError(t, f.orders.Order("id").Find(&users).Error)
+		require.Len(t, users, 3)
+		assert.Equal(t, 30, users[0].Age)
+
+		decisions := f.decisions(t)
+		require.Len(t, decisions, 1)
+		assert.Equal(t, string(CoordinatorProtocolXA), decisions[0].Protocol)
+		assert.Equal(t, CoordinatorStatusCommitted, decisions[0].Status)
+
+		// 第二个参与者执行失败时回滚已预提交的分支
+		transaction = f.coordinator.Begin(context.Background())
+		orders, err := transaction.UnitOfWork("orders")
+		require.NoError(t, err)
+		require.NoError(t, orders.Create(&User{Name: "李四", Email: "lisi@example.com"}))
+		ledger, err = transaction.UnitOfWork("ledger")
+		require.NoError(t, err)
+		require.NoError(t, ledger.Create(&User{Name: "用户1", Email: "user1@example.com"}))
+
+		err = transaction.Commit()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to prepare participant ledger")
+		assert.Empty(t, dialect.prepared)
+
+		var count int64
+		require.NoError(t, f.orders.Model(&User{}).Where("email = ?", "lisi@example.com").Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+		assert.Len(t, f.decisions(t), 1)
+	})
+
+	t.Run("两阶段提交的参与者在分支事务中写入审计记录和发件箱", func(t *testing.T) {
+		dialect := &fakeTwoPhase{prepared: make(map[string]*sql.Conn)}
+		sink := NewGormAuditSink("")
+		f := newCoordinatorFixture(t, WithTwoPhaseDialect("sqlite", dialect), WithParticipantOptions(WithAuditSink(sink)))
+		for _, db := range []*gorm.DB{f.orders, f.ledger} {
+			require.NoError(t, db.AutoMigrate(&Order{}))
+			require.NoError(t, MigrateOutbox(db, ""))
+			require.NoError(t, sink.Migrate(db))
+		}
+
+		stage := func(transaction *GlobalTransaction, ledgerEntity Entity) {
+			orders, err := transaction.UnitOfWork("orders")
+			require.NoError(t, err)
+			order := &Order{Number: "SO-1"}
+			order.RecordEvent(OrderPlaced{Number: "SO-1"})
+			require.NoError(t, orders.Create(order))
+
+			ledger, err := transaction.UnitOfWork("ledger")
+			require.NoError(t, err)
+			require.NoError(t, ledger.Create(ledgerEntity))
+		}
+		count := func(db *gorm.DB, table string) int64 {
+			var count int64
+			require.NoError(t, db.Table(table).Count(&count).Error)
+			return count
+		}
+
+		transaction := f.coordinator.Begin(context.Background())
+		stage(transaction, &LedgerEntry{UserID: 1, Amount: 100})
+		require.NoError(t, transaction.Commit())
+		assert.Equal(t, int64(1), count(f.orders, "orders"))
+		assert.Equal(t, int64(1), count(f.orders, DefaultOutboxTable))
+		assert.Equal(t, int64(1), count(f.orders, DefaultAuditTable))
+		assert.Equal(t, int64(1), count(f.ledger, DefaultAuditTable))
+
+		// 第二个参与者失败时第一个参与者的审计记录和发件箱随分支回滚
+		transaction = f.coordinator.Begin(context.Background())
+		stage(transaction, &User{Name: "用户1", Email: "user1@example.com"})
+		require.Error(t, transaction.Commit())
+		assert.Empty(t, dialect.prepared)
+		assert.Equal(t, int64(1), count(f.orders, "orders"))
+		assert.Equal(t, int64(1), count(f.orders, DefaultOutboxTable))
+		assert.Equal(t, int64(1), count(f.orders, DefaultAuditTable))
+	})
+
+	t.Run("参与者不能提前刷新或开启子工作单元", func(t *testing.T) {
+		dialect := &fakeTwoPhase{prepared: make(map[string]*sql.Conn)}
+		f := newCoordinatorFixture(t, WithTwoPhaseDialect("sqlite", dialect))
+
+		transaction := f.coordinator.Begin(context.Background())
+		orders, err := transaction.UnitOfWork("orders")
+		require.NoError(t, err)
+		require.NoError(t, orders.Create(&User{Name: "张三", Email: "zhangsan@example.com"}))
+		assert.ErrorContains(t, orders.Flush(), "cannot flush unit of work in global transaction")
+		_, err = orders.Begin()
+		assert.ErrorContains(t, err, "cannot begin child unit of work in global transaction")
+		require.NoError(t, transaction.Commit())
+
+		var count int64
+		require.NoError(t, f.orders.Model(&User{}).Where("email = ?", "zhangsan@example.com").Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+
+		f = newCoordinatorFixture(t, WithTwoPhaseDialect("sqlite", dialect), WithParticipantOptions(WithAutoFlush(1)))
+		_, err = f.coordinator.Begin(context.Background()).UnitOfWork("orders")
+		assert.ErrorContains(t, err, "cannot use auto flush")
+	})
+}
@@ -0,0 +1,291 @@
+package unitofwork
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/schema"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// DeferredDependency 为打破依赖环而延迟的依赖边
+// 依赖方先以空外键插入，被依赖方插入后再由更新操作回填外键
+type DeferredDependency struct {
+	// 外键被延迟回填的一方
+	Dependent reflect.Type
+	// 外键引用的一方
+	Dependency reflect.Type
+
+	relations []*foreignKeyRelation
+}
+
+// Columns 返回延迟回填的外键列
+func (d DeferredDependency) Columns() []string {
+	columns := make([]string, 0)
+	for _, relation := range d.relations {
+		for _, field := range relation.foreignKeys {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// String 返回延迟依赖描述
+func (d DeferredDependency) String() string {
+	return fmt.Sprintf("%s(%s) -> %s", d.Dependent, strings.Join(d.Columns(), ", "), d.Dependency)
+}
+
+// foreignKeyRelation 依赖边对应的外键关联，外键字段位于依赖方
+type foreignKeyRelation struct {
+	// 关联关系来源，例如 *Post.User(belongs_to)
+	source string
+	// 依赖方上的外键字段
+	foreignKeys []*schema.Field
+	// 被依赖方上被引用的字段
+	primaryKeys []*schema.Field
+	// 依赖方上指向被依赖方的关联字段，仅 belongs-to 关联存在
+	association *schema.Field
+}
+
+// sameColumns 检查两个外键关联是否使用相同的外键列
+func (r *foreignKeyRelation) sameColumns(other *foreignKeyRelation) bool {
+	if len(r.foreignKeys) != len(other.foreignKeys) {
+		return false
+	}
+	for i := range r.foreignKeys {
+		if r.foreignKeys[i].DBName != other.foreignKeys[i].DBName {
+			return false
+		}
+	}
+	return true
+}
+
+// isNullableField 检查字段能否写入 NULL：指针类型或 database/sql 的 Null 类型
+func isNullableField(field *schema.Field) bool {
+	if field.NotNull {
+		return false
+	}
+
+	fieldType := field.FieldType
+	if fieldType.Kind() == reflect.Ptr {
+		return true
+	}
+
+	return fieldType.PkgPath() == "database/sql" && strings.HasPrefix(fieldType.Name(), "Null") &&
+		reflect.PtrTo(fieldType).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
+
+// foreignKeyDeferral 单个实体上被延迟的外键
+type foreignKeyDeferral struct {
+	entity    Entity
+	relations []*foreignKeyRelation
+
+	// 插入前保存的外键与关联字段的值
+	foreignKeyValues  [][]reflect.Value
+	associationValues []reflect.Value
+}
+
+// newForeignKeyDeferral 创建外键延迟，实体上没有需要回填的外键时返回 nil
+func newForeignKeyDeferral(entity Entity, relations []*foreignKeyRelation) *foreignKeyDeferral {
+	deferral := &foreignKeyDeferral{entity: entity, relations: relations}
+
+	ctx := context.Background()
+	value := deferral.reflectValue()
+	for _, relation := range relations {
+		for _, field := range relation.foreignKeys {
+			if !field.ReflectValueOf(ctx, value).IsZero() {
+				return deferral
+			}
+		}
+		if relation.association != nil && !relation.association.ReflectValueOf(ctx, value).IsZero() {
+			return deferral
+		}
+	}
+
+	return nil
+}
+
+func (d *foreignKeyDeferral) reflectValue() reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(d.entity))
+}
+
+// detach 保存并置空外键与关联字段，避免插入时引用尚未插入的记录
+func (d *foreignKeyDeferral) detach(ctx context.Context) {
+	value := d.reflectValue()
+	d.foreignKeyValues = make([][]reflect.Value, len(d.relations))
+	d.associationValues = make([]reflect.Value, len(d.relations))
+
+	for i, relation := range d.relations {
+		for _, f := range relation.foreignKeys {
+			d.foreignKeyValues[i] = append(d.foreignKeyValues[i], takeValue(f.ReflectValueOf(ctx, value)))
+		}
+		if relation.association != nil {
+			d.associationValues[i] = takeValue(relation.association.ReflectValueOf(ctx, value))
+		}
+	}
+}
+
+// reattach 恢复插入前保存的外键与关联字段
+func (d *foreignKeyDeferral) reattach(ctx context.Context) {
+	value := d.reflectValue()
+	for i, relation := range d.relations {
+		for j, f := range relation.foreignKeys {
+			f.ReflectValueOf(ctx, value).Set(d.foreignKeyValues[i][j])
+		}
+		if relation.association != nil {
+			relation.association.ReflectValueOf(ctx, value).Set(d.associationValues[i])
+		}
+	}
+}
+
+// resolve 外键为空但设置了关联对象时，使用关联对象插入后的主键填充外键
+func (d *foreignKeyDeferral) resolve(ctx context.Context) {
+	value := d.reflectValue()
+	for _, relation := range d.relations {
+		if relation.association == nil {
+			continue
+		}
+
+		associated := reflect.Indirect(relation.association.ReflectValueOf(ctx, value))
+		if !associated.IsValid() || associated.Kind() != reflect.Struct {
+			continue
+		}
+
+		for i, f := range relation.foreignKeys {
+			foreignKey := f.ReflectValueOf(ctx, value)
+			if !foreignKey.IsZero() {
+				continue
+			}
+			assignValue(foreignKey, relation.primaryKeys[i].ReflectValueOf(ctx, associated))
+		}
+	}
+}
+
+// columns 返回需要回填的外键列
+func (d *foreignKeyDeferral) columns() []string {
+	return DeferredDependency{relations: d.relations}.Columns()
+}
+
+// changes 返回回填外键的字段变更
+func (d *foreignKeyDeferral) changes(ctx context.Context) map[string]FieldChange {
+	value := d.reflectValue()
+	changes := make(map[string]FieldChange)
+	for _, relation := range d.relations {
+		for _, f := range relation.foreignKeys {
+			changes[f.Name] = FieldChange{
+				FieldName: f.Name,
+				NewValue:  f.ReflectValueOf(ctx, value).Interface(),
+				Type:      FieldChangeTypeModified,
+			}
+		}
+	}
+	return changes
+}
+
+// takeValue 复制字段当前值并将字段置为零值
+func takeValue(value reflect.Value) reflect.Value {
+	saved := reflect.New(value.Type()).Elem()
+	saved.Set(value)
+	value.Set(reflect.Zero(value.Type()))
+	return saved
+}
+
+// assignValue 将主键值写入外键字段，处理指针与非指针之间的转换
+func assignValue(target, source reflect.Value) {
+	source = reflect.Indirect(source)
+	if !source.IsValid() || source.IsZero() {
+		return
+	}
+
+	if target.Kind() == reflect.Ptr {
+		pointer := reflect.New(target.Type().Elem())
+		pointer.Elem().Set(source.Convert(target.Type().Elem()))
+		target.Set(pointer)
+		return
+	}
+
+	target.Set(source.Convert(target.Type()))
+}
+
+// deferredInsertOperation 以空外键执行插入的操作，插入完成后恢复实体上的外键
+type deferredInsertOperation struct {
+	Operation
+	deferrals []*foreignKeyDeferral
+}
+
+// Execute 实现Operation接口
+func (op *deferredInsertOperation) Execute(db *gorm.DB) error {
+	ctx := db.Statement.Context
+	for _, deferral := range op.deferrals {
+		deferral.detach(ctx)
+	}
+
+	err := op.Operation.Execute(db)
+
+	for _, deferral := range op.deferrals {
+		deferral.reattach(ctx)
+	}
+
+	return err
+}
+
+// GetEntities 获取所有实体
+func (op *deferredInsertOperation) GetEntities() []Entity {
+	return operationEntities(op.Operation)
+}
+
+// newDeferredForeignKeyUpdateOperation 创建回填延迟外键的更新操作，只更新外键列
+func newDeferredForeignKeyUpdateOperation(deferral *foreignKeyDeferral) *UpdateOperation {
+	return &UpdateOperation{
+		entity:   deferral.entity,
+		changes:  deferral.changes(context.Background()),
+		deferral: deferral,
+	}
+}
+
+// deferForeignKeys 为延迟依赖的实体包装插入操作，并生成回填外键的更新操作
+func (uow *UnitOfWork) deferForeignKeys(inserts []Operation, deferred []DeferredDependency) ([]Operation, []Operation) {
+	relationsByType := make(map[reflect.Type][]*foreignKeyRelation)
+	for _, dependency := range deferred {
+		relationsByType[dependency.Dependent] = append(relationsByType[dependency.Dependent], dependency.relations...)
+
+		if uow.config.EnableDetailLog {
+			zlogger.Debug().
+				Str("deferred_dependency", dependency.String()).
+				Msg("Breaking dependency cycle with deferred foreign keys")
+		}
+	}
+
+	wrapped := make([]Operation, 0, len(inserts))
+	updates := make([]Operation, 0)
+
+	for _, operation := range inserts {
+		relations, exists := relationsByType[operation.GetEntityType()]
+		if !exists {
+			wrapped = append(wrapped, operation)
+			continue
+		}
+
+		deferrals := make([]*foreignKeyDeferral, 0)
+		for _, entity := range operationEntities(operation) {
+			if deferral := newForeignKeyDeferral(entity, relations); deferral != nil {
+				deferrals = append(deferrals, deferral)
+				updates = append(updates, newDeferredForeignKeyUpdateOperation(deferral))
+			}
+		}
+
+		if len(deferrals) == 0 {
+			wrapped = append(wrapped, operation)
+			continue
+		}
+
+		wrapped = append(wrapped, &deferredInsertOperation{Operation: operation, deferrals: deferrals})
+	}
+
+	return wrapped, updates
+}
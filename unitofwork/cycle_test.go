@@ -0,0 +1,132 @@
+package unitofwork
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/logger"
+	"github.com/wubin1989/sqlite"
+)
+
+// 示例实体：作者与文章互相引用
+type Author struct {
+	BaseEntity
+	Name            string   `gorm:"size:100"`
+	LatestArticleID *uint    `gorm:"index"`
+	LatestArticle   *Article `gorm:"foreignKey:LatestArticleID"`
+}
+
+type Article struct {
+	BaseEntity
+	Title    string  `gorm:"size:200"`
+	AuthorID uint    `gorm:"not null;index"`
+	Author   *Author `gorm:"foreignKey:AuthorID"`
+}
+
+func setupCycleTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:?_foreign_keys=1"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	require.NoError(t, db.AutoMigrate(&Author{}, &Article{}))
+	return db
+}
+
+// TestDependencyManager_BreakCycles 测试通过延迟可为空的外键打破依赖环
+func TestDependencyManager_BreakCycles(t *testing.T) {
+	db := setupCycleTestDB(t)
+	authorType := reflect.TypeOf(&Author{})
+	articleType := reflect.TypeOf(&Article{})
+
+	dm := NewDependencyManager()
+	require.NoError(t, dm.DiscoverDependencies(db, authorType))
+	require.NoError(t, dm.DiscoverDependencies(db, articleType))
+
+	_, err := dm.GetInsertionOrder([]Entity{&Author{}, &Article{}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "circular dependency")
+
+	order, deferred, err := dm.GetInsertionOrderBreakingCycles([]Entity{&Article{}, &Author{}})
+	require.NoError(t, err)
+	assert.IsType(t, &Author{}, order[0])
+	require.Len(t, deferred, 1)
+	assert.Equal(t, authorType, deferred[0].Dependent)
+	assert.Equal(t, articleType, deferred[0].Dependency)
+	assert.Equal(t, []string{"latest_article_id"}, deferred[0].Columns())
+
+	t.Run("外键不可为空时无法打破", func(t *testing.T) {
+		dm := NewDependencyManager()
+		dm.RegisterDependency(authorType, articleType)
+		dm.RegisterDependency(articleType, authorType)
+
+		_, _, err := dm.GetInsertionOrderBreakingCycles([]Entity{&Author{}, &Article{}})
+		assert.Error(t, err)
+	})
+}
+
+// TestUnitOfWork_CycleBreaking 测试工作单元在同一提交中插入互相引用的实体
+func TestUnitOfWork_CycleBreaking(t *testing.T) {
+	t.Run("未启用时报告依赖环", func(t *testing.T) {
+		db := setupCycleTestDB(t)
+		uow := NewUnitOfWork(db)
+
+		articleID := uint(1)
+		require.NoError(t, uow.Create(&Author{BaseEntity: BaseEntity{ID: 1}, Name: "作者", LatestArticleID: &articleID}))
+		require.NoError(t, uow.Create(&Article{BaseEntity: BaseEntity{ID: 1}, Title: "文章", AuthorID: 1}))
+
+		err := uow.Commit()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "circular dependency")
+	})
+
+	t.Run("先置空外键插入再回填", func(t *testing.T) {
+		db := setupCycleTestDB(t)
+		uow := NewUnitOfWork(db, WithCycleBreaking(true))
+
+		articleID := uint(1)
+		author := &Author{BaseEntity: BaseEntity{ID: 1}, Name: "作者", LatestArticleID: &articleID}
+		require.NoError(t, uow.Create(author))
+		require.NoError(t, uow.Create(&Article{BaseEntity: BaseEntity{ID: 1}, Title: "文章", AuthorID: 1}))
+		require.NoError(t, uow.Commit())
+
+		require.NotNil(t, author.LatestArticleID)
+		assert.Equal(t, uint(1), *author.LatestArticleID)
+
+		var saved Author
+		require.NoError(t, db.First(&saved, 1).Error)
+		require.NotNil(t, saved.LatestArticleID)
+		assert.Equal(t, uint(1), *saved.LatestArticleID)
+	})
+
+	t.Run("根据关联对象回填数据库生成的主键", func(t *testing.T) {
+		db := setupCycleTestDB(t)
+		uow := NewUnitOfWork(db, WithCycleBreaking(true))
+
+		author := &Author{BaseEntity: BaseEntity{ID: 1}, Name: "作者"}
+		article := &Article{Title: "文章", AuthorID: 1}
+		author.LatestArticle = article
+		require.NoError(t, uow.Create(author))
+		require.NoError(t, uow.Create(article))
+		require.NoError(t, uow.Commit())
+
+		assert.NotZero(t, article.ID)
+		assert.Same(t, article, author.LatestArticle)
+
+		var saved Author
+		require.NoError(t, db.First(&saved, 1).Error)
+		require.NotNil(t, saved.LatestArticleID)
+		assert.Equal(t, article.ID, *saved.LatestArticleID)
+
+		var count int64
+		require.NoError(t, db.Model(&Article{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+}
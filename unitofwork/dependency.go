@@ -4,6 +4,12 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/schema"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
 )
 
 // DependencyManager 实体依赖关系管理器
@@ -12,23 +18,72 @@ type DependencyManager struct {
 	dependencyGraph map[reflect.Type][]reflect.Type
 	// 实体权重：用于同级实体的排序
 	entityWeights map[reflect.Type]int
+	// 手动注册的依赖关系
+	manualDependencies map[dependencyEdge]bool
+	// 从 gorm schema 发现的依赖关系及其来源
+	discoveredDependencies map[dependencyEdge]string
+	// 依赖边对应的外键关联，用于打破依赖环
+	foreignKeys map[dependencyEdge][]*foreignKeyRelation
+	// 已解析过 schema 的实体类型
+	discoveredTypes map[reflect.Type]bool
+	// 自动发现的关联与手动注册冲突的记录
+	conflicts []DependencyConflict
+}
+
+// dependencyEdge 依赖边：dependent 依赖于 dependency
+type dependencyEdge struct {
+	dependent  reflect.Type
+	dependency reflect.Type
+}
+
+// DependencyConflict 自动发现的关联关系与手动注册的依赖关系冲突
+type DependencyConflict struct {
+	// 关联关系推导出的依赖方
+	Dependent reflect.Type
+	// 关联关系推导出的被依赖方
+	Dependency reflect.Type
+	// 关联关系来源，例如 *Post.User(belongs_to)
+	Relationship string
+}
+
+// String 返回冲突描述
+func (c DependencyConflict) String() string {
+	return fmt.Sprintf("relationship %s implies %s depends on %s, but the reverse dependency was registered manually",
+		c.Relationship, c.Dependent, c.Dependency)
 }
 
 // NewDependencyManager 创建依赖管理器
 func NewDependencyManager() *DependencyManager {
 	return &DependencyManager{
-		dependencyGraph: make(map[reflect.Type][]reflect.Type),
-		entityWeights:   make(map[reflect.Type]int),
+		dependencyGraph:        make(map[reflect.Type][]reflect.Type),
+		entityWeights:          make(map[reflect.Type]int),
+		manualDependencies:     make(map[dependencyEdge]bool),
+		discoveredDependencies: make(map[dependencyEdge]string),
+		foreignKeys:            make(map[dependencyEdge][]*foreignKeyRelation),
+		discoveredTypes:        make(map[reflect.Type]bool),
 	}
 }
 
 // RegisterDependency 注册实体依赖关系
-// dependent 依赖于 dependency
+// dependent 依赖于 dependency，手动注册的依赖优先于自动发现的相反依赖
 func (dm *DependencyManager) RegisterDependency(dependent, dependency reflect.Type) {
 	if dependent == dependency {
 		return // 自依赖，忽略
 	}
 
+	dm.manualDependencies[dependencyEdge{dependent: dependent, dependency: dependency}] = true
+
+	reverse := dependencyEdge{dependent: dependency, dependency: dependent}
+	if relationship, exists := dm.discoveredDependencies[reverse]; exists {
+		dm.recordConflict(reverse, relationship)
+		dm.RemoveDependency(dependency, dependent)
+	}
+
+	dm.addDependency(dependent, dependency)
+}
+
+// addDependency 向依赖图添加依赖边
+func (dm *DependencyManager) addDependency(dependent, dependency reflect.Type) {
 	dependents := dm.dependencyGraph[dependency]
 
 	// 检查是否已存在
@@ -53,7 +108,7 @@ func (dm *DependencyManager) GetInsertionOrder(entities []Entity) ([]Entity, err
 
 // GetDeletionOrder 获取删除顺序（被依赖的实体后删除）
 func (dm *DependencyManager) GetDeletionOrder(entities []Entity) ([]Entity, error) {
-	result, err := dm.topologicalSort(entities, true)
+	result, err := dm.topologicalSort(entities, false)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +128,51 @@ func (dm *DependencyManager) topologicalSort(entities []Entity, reverse bool) ([
 		return entities, nil
 	}
 
+	typeToEntities, entityTypes := groupEntitiesByType(entities)
+
+	result, remaining := dm.sortTypes(entityTypes, reverse, nil)
+
+	// 检查是否存在环
+	if len(remaining) > 0 {
+		return nil, circularDependencyError(remaining)
+	}
+
+	return dm.flattenEntities(result, typeToEntities), nil
+}
+
+// GetInsertionOrderBreakingCycles 获取插入顺序，依赖图存在环时移除环上外键可为空的依赖边，
+// 被移除的依赖边作为延迟依赖返回，由调用方先以空外键插入依赖方，待两端都插入后再回填外键
+func (dm *DependencyManager) GetInsertionOrderBreakingCycles(entities []Entity) ([]Entity, []DeferredDependency, error) {
+	if len(entities) == 0 {
+		return entities, nil, nil
+	}
+
+	typeToEntities, entityTypes := groupEntitiesByType(entities)
+	excluded := make(map[dependencyEdge]bool)
+	deferred := make([]DeferredDependency, 0)
+
+	for {
+		result, remaining := dm.sortTypes(entityTypes, false, excluded)
+		if len(remaining) == 0 {
+			return dm.flattenEntities(result, typeToEntities), deferred, nil
+		}
+
+		edge, ok := dm.findDeferrableEdge(remaining, excluded)
+		if !ok {
+			return nil, nil, circularDependencyError(remaining)
+		}
+
+		excluded[edge] = true
+		deferred = append(deferred, DeferredDependency{
+			Dependent:  edge.dependent,
+			Dependency: edge.dependency,
+			relations:  dm.foreignKeys[edge],
+		})
+	}
+}
+
+// groupEntitiesByType 按类型分组实体
+func groupEntitiesByType(entities []Entity) (map[reflect.Type][]Entity, []reflect.Type) {
 	// 构建类型到实体的映射
 	typeToEntities := make(map[reflect.Type][]Entity)
 	for _, entity := range entities {
@@ -86,8 +186,13 @@ func (dm *DependencyManager) topologicalSort(entities []Entity, reverse bool) ([
 		entityTypes = append(entityTypes, entityType)
 	}
 
+	return typeToEntities, entityTypes
+}
+
+// sortTypes 对实体类型进行拓扑排序，忽略 excluded 中的依赖边，返回已排序的类型和因环无法排序的类型
+func (dm *DependencyManager) sortTypes(entityTypes []reflect.Type, reverse bool, excluded map[dependencyEdge]bool) ([]reflect.Type, []reflect.Type) {
 	// 计算入度
-	inDegree := dm.calculateInDegree(entityTypes)
+	inDegree := dm.calculateInDegree(entityTypes, excluded)
 
 	// 拓扑排序
 	queue := make([]reflect.Type, 0)
@@ -109,7 +214,7 @@ func (dm *DependencyManager) topologicalSort(entities []Entity, reverse bool) ([
 		result = append(result, current)
 
 		// 处理当前节点的邻接节点
-		neighbors := dm.getNeighbors(current, reverse)
+		neighbors := dm.getNeighbors(current, reverse, excluded)
 		nextLevel := make([]reflect.Type, 0)
 
 		for _, neighbor := range neighbors {
@@ -128,25 +233,120 @@ func (dm *DependencyManager) topologicalSort(entities []Entity, reverse bool) ([
 		queue = append(queue, nextLevel...)
 	}
 
-	// 检查是否存在环
-	if len(result) != len(entityTypes) {
-		return nil, fmt.Errorf("circular dependency detected in entity relationships")
+	remaining := make([]reflect.Type, 0)
+	for _, entityType := range entityTypes {
+		if inDegree[entityType] > 0 {
+			remaining = append(remaining, entityType)
+		}
 	}
+	dm.sortByWeight(remaining)
+
+	return result, remaining
+}
 
-	// 根据排序后的类型构建最终的实体列表
-	finalResult := make([]Entity, 0, len(entities))
-	for _, entityType := range result {
+// flattenEntities 根据排序后的类型构建最终的实体列表
+func (dm *DependencyManager) flattenEntities(entityTypes []reflect.Type, typeToEntities map[reflect.Type][]Entity) []Entity {
+	finalResult := make([]Entity, 0)
+	for _, entityType := range entityTypes {
 		entitiesOfType := typeToEntities[entityType]
 		// 对同类型的实体按ID排序（确保排序的确定性）
 		dm.sortEntitiesByID(entitiesOfType)
 		finalResult = append(finalResult, entitiesOfType...)
 	}
+	return finalResult
+}
+
+// circularDependencyError 依赖环错误
+func circularDependencyError(entityTypes []reflect.Type) error {
+	names := make([]string, 0, len(entityTypes))
+	for _, entityType := range entityTypes {
+		names = append(names, entityType.String())
+	}
+	return fmt.Errorf("circular dependency detected in entity relationships: %s", strings.Join(names, ", "))
+}
+
+// findDeferrableEdge 在未能排序的类型中查找位于环上且外键可为空的依赖边
+func (dm *DependencyManager) findDeferrableEdge(entityTypes []reflect.Type, excluded map[dependencyEdge]bool) (dependencyEdge, bool) {
+	inCycle := make(map[reflect.Type]bool, len(entityTypes))
+	for _, entityType := range entityTypes {
+		inCycle[entityType] = true
+	}
+
+	candidates := make([]dependencyEdge, 0)
+	for _, dependency := range entityTypes {
+		for _, dependent := range dm.dependencyGraph[dependency] {
+			edge := dependencyEdge{dependent: dependent, dependency: dependency}
+			if !inCycle[dependent] || excluded[edge] || !dm.isDeferrable(edge) {
+				continue
+			}
+
+			// 依赖方能沿依赖图回到被依赖方时，该边位于环上
+			if dm.reachable(dependent, dependency, inCycle, excluded) {
+				candidates = append(candidates, edge)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return dependencyEdge{}, false
+	}
 
-	return finalResult, nil
+	// 确保选择的确定性
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dependent != candidates[j].dependent {
+			return candidates[i].dependent.String() < candidates[j].dependent.String()
+		}
+		return candidates[i].dependency.String() < candidates[j].dependency.String()
+	})
+
+	return candidates[0], true
+}
+
+// reachable 检查在给定类型范围内能否从 from 沿依赖图到达 to
+func (dm *DependencyManager) reachable(from, to reflect.Type, scope map[reflect.Type]bool, excluded map[dependencyEdge]bool) bool {
+	visited := map[reflect.Type]bool{from: true}
+	queue := []reflect.Type{from}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range dm.dependencyGraph[current] {
+			if !scope[dependent] || visited[dependent] || excluded[dependencyEdge{dependent: dependent, dependency: current}] {
+				continue
+			}
+			if dependent == to {
+				return true
+			}
+			visited[dependent] = true
+			queue = append(queue, dependent)
+		}
+	}
+
+	return false
+}
+
+// isDeferrable 检查依赖边是否可以通过先置空外键再回填的方式延迟
+// 只有来自 gorm 关联且所有外键字段均可为空的依赖边可以延迟
+func (dm *DependencyManager) isDeferrable(edge dependencyEdge) bool {
+	relations := dm.foreignKeys[edge]
+	if len(relations) == 0 {
+		return false
+	}
+
+	for _, relation := range relations {
+		for _, field := range relation.foreignKeys {
+			if !isNullableField(field) {
+				return false
+			}
+		}
+	}
+
+	return true
 }
 
 // calculateInDegree 计算每个节点的入度
-func (dm *DependencyManager) calculateInDegree(entityTypes []reflect.Type) map[reflect.Type]int {
+func (dm *DependencyManager) calculateInDegree(entityTypes []reflect.Type, excluded map[dependencyEdge]bool) map[reflect.Type]int {
 	inDegree := make(map[reflect.Type]int)
 
 	// 初始化所有节点的入度为0
@@ -161,6 +361,9 @@ func (dm *DependencyManager) calculateInDegree(entityTypes []reflect.Type) map[r
 		}
 
 		for _, dep := range dependents {
+			if excluded[dependencyEdge{dependent: dep, dependency: dependency}] {
+				continue
+			}
 			if _, exists := inDegree[dep]; exists {
 				inDegree[dep]++
 			}
@@ -171,23 +374,28 @@ func (dm *DependencyManager) calculateInDegree(entityTypes []reflect.Type) map[r
 }
 
 // getNeighbors 获取邻接节点
-func (dm *DependencyManager) getNeighbors(entityType reflect.Type, reverse bool) []reflect.Type {
+func (dm *DependencyManager) getNeighbors(entityType reflect.Type, reverse bool, excluded map[dependencyEdge]bool) []reflect.Type {
+	neighbors := make([]reflect.Type, 0)
 	if !reverse {
 		// 正向：返回依赖当前类型的项
-		return dm.dependencyGraph[entityType]
-	} else {
-		// 反向：返回当前类型的依赖项
-		neighbors := make([]reflect.Type, 0)
-		for dependency, dependents := range dm.dependencyGraph {
-			for _, dep := range dependents {
-				if dep == entityType {
-					neighbors = append(neighbors, dependency)
-					break
-				}
+		for _, dep := range dm.dependencyGraph[entityType] {
+			if !excluded[dependencyEdge{dependent: dep, dependency: entityType}] {
+				neighbors = append(neighbors, dep)
 			}
 		}
 		return neighbors
 	}
+
+	// 反向：返回当前类型的依赖项
+	for dependency, dependents := range dm.dependencyGraph {
+		for _, dep := range dependents {
+			if dep == entityType && !excluded[dependencyEdge{dependent: entityType, dependency: dependency}] {
+				neighbors = append(neighbors, dependency)
+				break
+			}
+		}
+	}
+	return neighbors
 }
 
 // sortByWeight 按权重排序
@@ -213,11 +421,11 @@ func (dm *DependencyManager) getEntityWeight(entityType reflect.Type) int {
 	return 0 // 默认权重
 }
 
-// sortEntitiesByID 按ID排序实体
+// sortEntitiesByID 按标识排序实体，标识相同（例如尚未分配主键）时保持注册顺序
 func (dm *DependencyManager) sortEntitiesByID(entities []Entity) {
-	sort.Slice(entities, func(i, j int) bool {
-		idI := toString(entities[i].GetID())
-		idJ := toString(entities[j].GetID())
+	sort.SliceStable(entities, func(i, j int) bool {
+		idI := IdentityOf(entities[i]).String()
+		idJ := IdentityOf(entities[j]).String()
 		return idI < idJ
 	})
 }
@@ -264,6 +472,11 @@ func (dm *DependencyManager) HasDependency(dependent, dependency reflect.Type) b
 
 // RemoveDependency 移除依赖关系
 func (dm *DependencyManager) RemoveDependency(dependent, dependency reflect.Type) {
+	edge := dependencyEdge{dependent: dependent, dependency: dependency}
+	delete(dm.manualDependencies, edge)
+	delete(dm.discoveredDependencies, edge)
+	delete(dm.foreignKeys, edge)
+
 	dependents := dm.dependencyGraph[dependency]
 	newDependents := make([]reflect.Type, 0, len(dependents))
 
@@ -280,4 +493,143 @@ func (dm *DependencyManager) RemoveDependency(dependent, dependency reflect.Type
 func (dm *DependencyManager) Clear() {
 	dm.dependencyGraph = make(map[reflect.Type][]reflect.Type)
 	dm.entityWeights = make(map[reflect.Type]int)
+	dm.manualDependencies = make(map[dependencyEdge]bool)
+	dm.discoveredDependencies = make(map[dependencyEdge]string)
+	dm.foreignKeys = make(map[dependencyEdge][]*foreignKeyRelation)
+	dm.discoveredTypes = make(map[reflect.Type]bool)
+	dm.conflicts = nil
+}
+
+// DiscoverDependencies 解析实体的 gorm schema，根据关联关系注册依赖，每种实体类型只解析一次
+// belongs-to 关联的实体依赖于被关联方；has-one、has-many 的关联方依赖于当前实体；
+// many-to-many 的连接表依赖于两端实体。与手动注册相反的关联会被记录为冲突并忽略
+func (dm *DependencyManager) DiscoverDependencies(db *gorm.DB, entityType reflect.Type) error {
+	if dm.discoveredTypes[entityType] {
+		return nil
+	}
+	dm.discoveredTypes[entityType] = true
+
+	modelType := entityType
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(reflect.New(modelType).Interface()); err != nil {
+		return fmt.Errorf("failed to parse schema of %s: %w", entityType, err)
+	}
+
+	for _, relationship := range stmt.Schema.Relationships.Relations {
+		if relationship.FieldSchema == nil {
+			continue
+		}
+
+		ownerType := reflect.PtrTo(relationship.Schema.ModelType)
+		fieldType := reflect.PtrTo(relationship.FieldSchema.ModelType)
+		source := fmt.Sprintf("%s.%s(%s)", ownerType, relationship.Name, relationship.Type)
+
+		switch relationship.Type {
+		case schema.BelongsTo:
+			dm.addDiscoveredDependency(ownerType, fieldType, source)
+			dm.addForeignKeyRelation(ownerType, fieldType, relationship, source)
+		case schema.HasOne, schema.HasMany:
+			dm.addDiscoveredDependency(fieldType, ownerType, source)
+			dm.addForeignKeyRelation(fieldType, ownerType, relationship, source)
+		case schema.Many2Many:
+			if relationship.JoinTable != nil {
+				joinType := reflect.PtrTo(relationship.JoinTable.ModelType)
+				dm.addDiscoveredDependency(joinType, ownerType, source)
+				dm.addDiscoveredDependency(joinType, fieldType, source)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addDiscoveredDependency 注册自动发现的依赖，与手动注册冲突时忽略并记录
+func (dm *DependencyManager) addDiscoveredDependency(dependent, dependency reflect.Type, relationship string) {
+	if dependent == dependency {
+		return
+	}
+
+	edge := dependencyEdge{dependent: dependent, dependency: dependency}
+	if dm.manualDependencies[dependencyEdge{dependent: dependency, dependency: dependent}] {
+		dm.recordConflict(edge, relationship)
+		return
+	}
+
+	if _, exists := dm.discoveredDependencies[edge]; exists {
+		return
+	}
+
+	dm.discoveredDependencies[edge] = relationship
+	dm.addDependency(dependent, dependency)
+}
+
+// addForeignKeyRelation 记录依赖边对应的外键关联，外键字段位于依赖方
+func (dm *DependencyManager) addForeignKeyRelation(dependent, dependency reflect.Type, relationship *schema.Relationship, source string) {
+	if dependent == dependency || !dm.HasDependency(dependent, dependency) {
+		return
+	}
+
+	relation := &foreignKeyRelation{source: source}
+	for _, reference := range relationship.References {
+		// 多态关联的类型列不是外键
+		if reference.PrimaryKey == nil || reference.ForeignKey == nil {
+			continue
+		}
+		relation.foreignKeys = append(relation.foreignKeys, reference.ForeignKey)
+		relation.primaryKeys = append(relation.primaryKeys, reference.PrimaryKey)
+	}
+	if len(relation.foreignKeys) == 0 {
+		return
+	}
+
+	// belongs-to 的关联字段位于依赖方
+	if relationship.Type == schema.BelongsTo {
+		relation.association = relationship.Field
+	}
+
+	edge := dependencyEdge{dependent: dependent, dependency: dependency}
+	for _, existing := range dm.foreignKeys[edge] {
+		if existing.sameColumns(relation) {
+			if existing.association == nil {
+				existing.association = relation.association
+			}
+			return
+		}
+	}
+
+	dm.foreignKeys[edge] = append(dm.foreignKeys[edge], relation)
+}
+
+// recordConflict 记录依赖冲突
+func (dm *DependencyManager) recordConflict(edge dependencyEdge, relationship string) {
+	conflict := DependencyConflict{
+		Dependent:    edge.dependent,
+		Dependency:   edge.dependency,
+		Relationship: relationship,
+	}
+
+	// 同一依赖边只记录一次
+	for _, existing := range dm.conflicts {
+		if existing.Dependent == conflict.Dependent && existing.Dependency == conflict.Dependency {
+			return
+		}
+	}
+
+	dm.conflicts = append(dm.conflicts, conflict)
+	zlogger.Warn().Str("conflict", conflict.String()).Msg("Dependency conflict detected")
+}
+
+// Conflicts 获取自动发现的关联关系与手动注册依赖的冲突
+func (dm *DependencyManager) Conflicts() []DependencyConflict {
+	return dm.conflicts
+}
+
+// IsDiscovered 检查依赖关系是否来自 gorm schema 自动发现
+func (dm *DependencyManager) IsDiscovered(dependent, dependency reflect.Type) bool {
+	_, exists := dm.discoveredDependencies[dependencyEdge{dependent: dependent, dependency: dependency}]
+	return exists
 }
@@ -0,0 +1,80 @@
+package unitofwork
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 示例实体：多对多关联
+type Course struct {
+	BaseEntity
+	Title    string     `gorm:"size:100"`
+	Students []*Student `gorm:"many2many:course_students"`
+}
+
+type Student struct {
+	BaseEntity
+	Name string `gorm:"size:100"`
+}
+
+// TestDependencyManager_Discover 测试从 gorm schema 自动发现依赖
+func TestDependencyManager_Discover(t *testing.T) {
+	db := setupTestDB()
+	userType := reflect.TypeOf(&User{})
+	postType := reflect.TypeOf(&Post{})
+
+	t.Run("has-many 与 belongs-to", func(t *testing.T) {
+		dm := NewDependencyManager()
+		require.NoError(t, dm.DiscoverDependencies(db, userType))
+
+		assert.True(t, dm.HasDependency(postType, userType))
+		assert.True(t, dm.IsDiscovered(postType, userType))
+
+		order, err := dm.GetInsertionOrder([]Entity{&Post{}, &User{}})
+		require.NoError(t, err)
+		assert.IsType(t, &User{}, order[0])
+
+		order, err = dm.GetDeletionOrder([]Entity{&User{}, &Post{}})
+		require.NoError(t, err)
+		assert.IsType(t, &Post{}, order[0])
+	})
+
+	t.Run("多对多连接表依赖两端实体", func(t *testing.T) {
+		dm := NewDependencyManager()
+		require.NoError(t, dm.DiscoverDependencies(db, reflect.TypeOf(&Course{})))
+
+		var joinTypes []reflect.Type
+		for _, entityType := range dm.GetAllEntityTypes() {
+			if entityType != reflect.TypeOf(&Course{}) && entityType != reflect.TypeOf(&Student{}) {
+				joinTypes = append(joinTypes, entityType)
+			}
+		}
+		require.Len(t, joinTypes, 1)
+		assert.True(t, dm.HasDependency(joinTypes[0], reflect.TypeOf(&Course{})))
+		assert.True(t, dm.HasDependency(joinTypes[0], reflect.TypeOf(&Student{})))
+	})
+
+	t.Run("与手动注册冲突时手动优先", func(t *testing.T) {
+		dm := NewDependencyManager()
+		dm.RegisterDependency(userType, postType)
+		require.NoError(t, dm.DiscoverDependencies(db, postType))
+
+		assert.True(t, dm.HasDependency(userType, postType))
+		assert.False(t, dm.HasDependency(postType, userType))
+		require.Len(t, dm.Conflicts(), 1)
+		assert.Equal(t, postType, dm.Conflicts()[0].Dependent)
+		assert.Equal(t, userType, dm.Conflicts()[0].Dependency)
+	})
+
+	t.Run("工作单元首次遇到实体类型时自动发现", func(t *testing.T) {
+		uow := NewUnitOfWork(db)
+		require.NoError(t, uow.Create(&Post{BaseEntity: BaseEntity{ID: 1}, Title: "先注册文章", UserID: 1}))
+		require.NoError(t, uow.Create(&User{BaseEntity: BaseEntity{ID: 1}, Name: "作者", Email: "author@example.com"}))
+
+		assert.True(t, uow.GetDependencyManager().HasDependency(postType, userType))
+		require.NoError(t, uow.Commit())
+	})
+}
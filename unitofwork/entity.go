@@ -6,13 +6,8 @@ import (
 )
 
 // Entity 实体接口，所有参与工作单元的实体都必须实现此接口
+// 实体标识通过 IdentityOf 获取：实现 Identifiable 时使用自定义标识，否则读取 gorm schema 的主键字段
 type Entity interface {
-	// GetID 获取实体ID
-	GetID() uint
-
-	// SetID 设置实体ID
-	SetID(id uint)
-
 	// GetTableName 获取表名
 	GetTableName() string
 
@@ -20,6 +15,17 @@ type Entity interface {
 	IsNew() bool
 }
 
+// HasID 使用 uint 自增主键的实体接口
+type HasID interface {
+	Entity
+
+	// GetID 获取实体ID
+	GetID() uint
+
+	// SetID 设置实体ID
+	SetID(id uint)
+}
+
 // HasRevision 支持乐观锁的实体接口
 type HasRevision interface {
 	Entity
@@ -65,6 +71,24 @@ type SoftDelete interface {
 	IsDeleted() bool
 }
 
+// EventSource 支持记录领域事件的实体接口
+// 记录的事件会在工作单元提交时写入发件箱表，与实体变更处于同一事务
+type EventSource interface {
+	Entity
+
+	// PendingEvents 获取待发布的领域事件
+	PendingEvents() []DomainEvent
+
+	// ClearEvents 清空已写入发件箱的领域事件
+	ClearEvents()
+}
+
+// DomainEvent 领域事件接口，事件本身会被序列化为JSON写入发件箱
+type DomainEvent interface {
+	// EventType 获取事件类型
+	EventType() string
+}
+
 // Validatable 支持验证的实体接口
 type Validatable interface {
 	Entity
@@ -156,3 +180,23 @@ func (b *BaseEntity) IsDeleted() bool {
 func (b *BaseEntity) Validate() error {
 	return nil
 }
+
+// EventRecorder 领域事件记录器，可嵌入到业务实体中实现 EventSource 接口
+type EventRecorder struct {
+	events []DomainEvent
+}
+
+// RecordEvent 记录领域事件
+func (r *EventRecorder) RecordEvent(event DomainEvent) {
+	r.events = append(r.events, event)
+}
+
+// PendingEvents 实现EventSource接口
+func (r *EventRecorder) PendingEvents() []DomainEvent {
+	return r.events
+}
+
+// ClearEvents 实现EventSource接口
+func (r *EventRecorder) ClearEvents() {
+	r.events = nil
+}
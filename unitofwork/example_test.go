@@ -478,12 +478,12 @@ func TestUnitOfWork_Stats(t *testing.T) {
 	// 获取统计信息
 	stats := uow.GetStats()
 
-	assert.Equal(t, 3, stats["total_operations"])
-	assert.Equal(t, 3, stats["new_entities"])
-	assert.Equal(t, 0, stats["dirty_entities"])
-	assert.Equal(t, 0, stats["removed_entities"])
-	assert.False(t, stats["is_committed"].(bool))
-	assert.False(t, stats["is_rolled_back"].(bool))
+	assert.Equal(t, 3, stats.TotalOperations)
+	assert.Equal(t, 3, stats.NewEntities)
+	assert.Equal(t, 0, stats.DirtyEntities)
+	assert.Equal(t, 0, stats.RemovedEntities)
+	assert.False(t, stats.IsCommitted)
+	assert.False(t, stats.IsRolledBack)
 }
 
 // ExampleUnitOfWork_DependencyManagement 依赖关系管理示例
@@ -751,7 +751,7 @@ func TestUnitOfWork_AdvancedFeatures(t *testing.T) {
 
 		// 验证操作被合并
 		assert.Equal(t, 27, loadedUser.Age)
-		assert.NotNil(t, stats["total_operations"])
+		assert.Positive(t, stats.TotalOperations)
 	})
 
 	t.Run("内存限制保护", func(t *testing.T) {
@@ -0,0 +1,290 @@
+package unitofwork
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// Flush 在尚未结束的事务中执行已注册的插入、更新、插入或更新和恢复操作，然后停止跟踪这些实体并释放其快照，
+// 工作单元保持打开，可以继续注册实体。删除和永久删除保留到提交时执行，与一次提交相同，在所有插入和更新之后按依赖逆序删除
+//
+// 首次刷新时工作单元不在事务中则自行开启事务，已在事务中则创建保存点；Commit 执行剩余的操作后提交该事务，
+// Rollback 回滚该事务或回滚到该保存点，撤销所有已刷新的操作。每次刷新在保存点下执行，失败时只撤销本次刷新，
+// 操作保留在队列中，提交失败后需调用 Rollback 结束事务。已刷新的实体不再被跟踪，再次修改前需通过 Find 或 Attach 重新纳入跟踪
+func (uow *UnitOfWork) Flush() error {
+	uow.mu.Lock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		uow.mu.Unlock()
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	if uow.globalTransaction != "" {
+		uow.mu.Unlock()
+		return fmt.Errorf("cannot flush unit of work in global transaction %s before it commits", uow.globalTransaction)
+	}
+
+	if uow.activeChildren > 0 {
+		uow.mu.Unlock()
+		return fmt.Errorf("unit of work has %d active child units", uow.activeChildren)
+	}
+
+	queued := uow.operations
+	operations, removals := splitRemovals(queued)
+	if len(operations) == 0 {
+		uow.mu.Unlock()
+		return nil
+	}
+	uow.operations = operations
+
+	if err := uow.checkAncestorInserts(); err != nil {
+		uow.operations = queued
+		uow.mu.Unlock()
+		return fmt.Errorf("unit of work flush failed: %w", err)
+	}
+
+	if uow.config.EnableValidation {
+		if report := uow.validate(); report != nil {
+			uow.operations = queued
+			uow.mu.Unlock()
+			return fmt.Errorf("unit of work flush failed: %w", report.operationError())
+		}
+	}
+
+	if err := uow.beginFlush(); err != nil {
+		uow.operations = queued
+		uow.mu.Unlock()
+		return err
+	}
+
+	span, _ := startSpan(uow.ctx, uow.config, "unitofwork.Flush")
+	span.SetTag("unitofwork.depth", uow.depth)
+	span.SetTag("unitofwork.operations", len(operations))
+	uow.commitSpan = span
+	startTime := time.Now()
+	flushedEntities := uow.getFlushableCount()
+
+	// 设置执行状态，然后释放锁执行操作，避免死锁
+	uow.isExecuting = true
+	uow.mu.Unlock()
+
+	savepoint := "uow_sp_0"
+	if uow.parent != nil {
+		savepoint = uow.savepoint
+		uow.parent.setExecuting(true)
+	}
+	err := uow.flushInSavepoint(savepoint)
+	if uow.parent != nil {
+		uow.parent.setExecuting(false)
+	}
+
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	uow.isExecuting = false
+	uow.commitSpan = nil
+	finishSpan(span, err)
+
+	if err != nil {
+		uow.operations = queued
+		zlogger.Error().Err(err).Int("depth", uow.depth).Msg("Unit of work flush failed")
+		return fmt.Errorf("unit of work flush failed: %w", err)
+	}
+
+	// 领域事件已写入发件箱
+	for _, source := range uow.collectEventSources() {
+		source.ClearEvents()
+	}
+
+	uow.detachFlushed()
+	uow.operations = removals
+
+	zlogger.Info().
+		Int("depth", uow.depth).
+		Int("flushed_entities", flushedEntities).
+		Int("pending_removals", len(removals)).
+		Dur("duration", time.Since(startTime)).
+		Msg("Unit of work flushed")
+
+	return nil
+}
+
+// autoFlush 待刷新的实体数量达到 Config.AutoFlushThreshold 时执行 Flush，
+// 提交过程中或存在未完成的子工作单元时不刷新
+func (uow *UnitOfWork) autoFlush() error {
+	if uow.config.AutoFlushThreshold <= 0 {
+		return nil
+	}
+
+	uow.mu.RLock()
+	due := !uow.isExecuting && uow.activeChildren == 0 &&
+		uow.getFlushableCount() >= uow.config.AutoFlushThreshold
+	uow.mu.RUnlock()
+
+	if !due {
+		return nil
+	}
+	return uow.Flush()
+}
+
+// splitRemovals 将操作分为可增量刷新的操作和保留到提交时执行的删除
+func splitRemovals(operations []Operation) ([]Operation, []Operation) {
+	flushable := make([]Operation, 0, len(operations))
+	removals := make([]Operation, 0)
+	for _, operation := range operations {
+		switch operation.GetOperationType() {
+		case OperationTypeDelete, OperationTypeBulkDelete, OperationTypePurge, OperationTypeBulkPurge:
+			removals = append(removals, operation)
+		default:
+			flushable = append(flushable, operation)
+		}
+	}
+	return flushable, removals
+}
+
+// beginFlush 首次刷新前开启事务，已在事务中时创建保存点，调用方需持有锁
+func (uow *UnitOfWork) beginFlush() error {
+	if uow.flushed {
+		return nil
+	}
+
+	switch {
+	case uow.memory != nil && uow.parentFlushed:
+		uow.memorySavepoint = uow.memory.savepoint()
+	case uow.memory != nil:
+		uow.memory.begin()
+		uow.ownsTx = true
+	case !inTransaction(uow.db):
+		tx := uow.db.Begin()
+		if tx.Error != nil {
+			return fmt.Errorf("failed to begin transaction for flush: %w", tx.Error)
+		}
+		uow.db = tx
+		uow.ownsTx = true
+	default:
+		savepoint := uow.flushSavepoint()
+		if err := uow.db.Session(&gorm.Session{}).SavePoint(savepoint).Error; err != nil {
+			return fmt.Errorf("failed to create savepoint %s: %w", savepoint, err)
+		}
+	}
+
+	uow.flushed = true
+	return nil
+}
+
+// endFlush 提交或撤销所有已刷新的操作，未刷新过时什么也不做，调用方需持有锁
+func (uow *UnitOfWork) endFlush(commit bool) error {
+	if !uow.flushed {
+		return nil
+	}
+
+	ownsTx, state := uow.ownsTx, uow.memorySavepoint
+	uow.flushed, uow.ownsTx, uow.memorySavepoint = false, false, nil
+
+	switch {
+	case ownsTx && uow.memory != nil:
+		uow.memory.end(commit)
+	case ownsTx && commit:
+		if err := uow.db.Commit().Error; err != nil {
+			return fmt.Errorf("failed to commit flushed transaction: %w", err)
+		}
+	case ownsTx:
+		if err := uow.db.Rollback().Error; err != nil {
+			return fmt.Errorf("failed to rollback flushed transaction: %w", err)
+		}
+	case commit:
+		// 保存点随外层事务提交
+	case uow.memory != nil:
+		uow.memory.rollbackTo(state)
+	default:
+		savepoint := uow.flushSavepoint()
+		if err := uow.db.Session(&gorm.Session{}).RollbackTo(savepoint).Error; err != nil {
+			return fmt.Errorf("failed to rollback to savepoint %s: %w", savepoint, err)
+		}
+	}
+
+	return nil
+}
+
+// flushSavepoint 撤销所有已刷新的操作使用的保存点，与每次刷新使用的保存点不同名
+func (uow *UnitOfWork) flushSavepoint() string {
+	if uow.parent != nil {
+		return uow.savepoint + "_flush"
+	}
+	return "uow_sp_0_flush"
+}
+
+// detachFlushed 停止跟踪已刷新的实体并释放其快照，祖先工作单元跟踪的实体以刷新后的状态作为新的快照，调用方需持有锁
+func (uow *UnitOfWork) detachFlushed() {
+	for _, entityMap := range []map[reflect.Type][]Entity{
+		uow.newEntities, uow.dirtyEntities, uow.upsertedEntities, uow.restoredEntities,
+	} {
+		for _, entities := range entityMap {
+			for _, entity := range entities {
+				uow.snapshotManager.RemoveSnapshot(entity)
+				if !uow.refreshAncestorSnapshot(entity) {
+					uow.identityMap.Remove(entity)
+				}
+			}
+		}
+	}
+
+	uow.newEntities = make(map[reflect.Type][]Entity)
+	uow.dirtyEntities = make(map[reflect.Type][]Entity)
+	uow.upsertedEntities = make(map[reflect.Type][]Entity)
+	uow.restoredEntities = make(map[reflect.Type][]Entity)
+}
+
+// refreshAncestorSnapshot 刷新最近的持有实体快照的祖先工作单元中的快照
+func (uow *UnitOfWork) refreshAncestorSnapshot(entity Entity) bool {
+	for ancestor := uow.parent; ancestor != nil; ancestor = ancestor.parent {
+		ancestor.mu.Lock()
+		found := ancestor.snapshotManager.HasSnapshot(entity)
+		if found {
+			ancestor.snapshotManager.TakeSnapshot(entity)
+		}
+		ancestor.mu.Unlock()
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryStaged 检查内存存储的事务是否由自身或祖先工作单元的增量刷新开启
+func (uow *UnitOfWork) memoryStaged() bool {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+	return uow.flushed || uow.parentFlushed
+}
+
+// loadFromMemory 从内存存储加载行的副本，增量刷新的事务进行中时从事务的工作副本加载
+func (uow *UnitOfWork) loadFromMemory(entityType reflect.Type, identity Identity, unscoped bool) (Entity, error) {
+	return uow.memory.load(entityType, identity, unscoped, uow.memoryStaged())
+}
+
+// getFlushableCount 获取可增量刷新的实体数量
+func (uow *UnitOfWork) getFlushableCount() int {
+	return uow.getEntityCountByType(uow.newEntities) +
+		uow.getEntityCountByType(uow.dirtyEntities) +
+		uow.getEntityCountByType(uow.upsertedEntities) +
+		uow.getEntityCountByType(uow.restoredEntities)
+}
+
+// inTransaction 检查数据库会话是否处于事务中
+func inTransaction(db *gorm.DB) bool {
+	committer, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil
+}
+
+// WithAutoFlush 配置自动刷新阈值，待刷新的实体数量达到 threshold 时自动执行 Flush，适用于长时间运行的批处理
+func WithAutoFlush(threshold int) ConfigOption {
+	return func(c *Config) {
+		c.AutoFlushThreshold = threshold
+	}
+}
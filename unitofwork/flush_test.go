@@ -0,0 +1,190 @@
+package unitofwork
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// operationOrderListener 按执行顺序记录操作类型和实体类型
+type operationOrderListener struct {
+	BaseListener
+	operations []string
+}
+
+func (l *operationOrderListener) BeforeOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	l.operations = append(l.operations, fmt.Sprintf("%s %s", operation.GetOperationType(), operation.GetEntityType().Elem().Name()))
+	return nil
+}
+
+// TestFlush 测试增量刷新
+func TestFlush(t *testing.T) {
+	t.Run("达到阈值时在事务中刷新并释放快照", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db, WithAutoFlush(2))
+
+		users := make([]*User, 5)
+		for i := range users {
+			users[i] = &User{Name: fmt.Sprintf("用户%d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1)}
+			require.NoError(t, uow.Create(users[i]))
+		}
+
+		// 前四个实体已在工作单元开启的事务中插入
+		assert.True(t, uow.flushed)
+		assert.NotZero(t, users[3].ID)
+		assert.Zero(t, users[4].ID)
+		assert.Equal(t, 1, uow.GetStats().NewEntities)
+		assert.Equal(t, 0, uow.snapshotManager.Len())
+
+		var count int64
+		require.NoError(t, uow.db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(4), count)
+
+		// 后续刷新中的实体可以引用已刷新的实体
+		require.NoError(t, uow.Create(&Post{Title: "第一篇", UserID: users[0].ID}))
+		require.NoError(t, uow.Commit())
+
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(5), count)
+		require.NoError(t, db.Model(&Post{}).Where("user_id = ?", users[0].ID).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("回滚撤销所有已刷新的操作", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db, WithAutoFlush(1))
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, 1))
+		loaded.Age = 30
+		require.NoError(t, uow.Update(loaded))
+		require.NoError(t, uow.Create(&User{Name: "张三", Email: "zhangsan@example.com"}))
+		require.NoError(t, uow.Rollback())
+
+		var users []User
+		require.NoError(t, db.Find(&users).Error)
+		require.Len(t, users, 1)
+		assert.Equal(t, 20, users[0].Age)
+	})
+
+	t.Run("外层事务中回滚到保存点", func(t *testing.T) {
+		db := setupTestDB()
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, tx.Create(&Tag{Name: "外层"}).Error)
+
+			uow := NewUnitOfWork(tx, WithAutoFlush(1))
+			require.NoError(t, uow.Create(&Tag{Name: "已刷新"}))
+			assert.False(t, uow.ownsTx)
+			return uow.Rollback()
+		})
+		require.NoError(t, err)
+
+		var tags []Tag
+		require.NoError(t, db.Find(&tags).Error)
+		require.Len(t, tags, 1)
+		assert.Equal(t, "外层", tags[0].Name)
+	})
+
+	t.Run("删除保留到提交时按依赖逆序执行", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+		require.NoError(t, db.Create(&Post{Title: "第一篇", UserID: 1}).Error)
+
+		listener := &operationOrderListener{}
+		uow := NewUnitOfWork(db, WithAutoFlush(1), WithListener(listener))
+		uow.GetDependencyManager().RegisterDependency(reflect.TypeOf(&Post{}), reflect.TypeOf(&User{}))
+
+		var user *User
+		var post *Post
+		require.NoError(t, uow.Find(&user, 1))
+		require.NoError(t, uow.Find(&post, 1))
+		require.NoError(t, uow.Delete(user))
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+
+		assert.Equal(t, []string{"INSERT Tag"}, listener.operations)
+		assert.Equal(t, 1, uow.GetStats().RemovedEntities)
+
+		require.NoError(t, uow.Delete(post))
+		require.NoError(t, uow.Commit())
+		assert.Equal(t, []string{"INSERT Tag", "DELETE Post", "DELETE User"}, listener.operations)
+	})
+
+	t.Run("已刷新的实体重新加载后继续跟踪", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		user := &User{Name: "张三", Email: "zhangsan@example.com"}
+		require.NoError(t, uow.Create(user))
+		require.NoError(t, uow.Flush())
+
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, user.ID))
+		assert.NotSame(t, user, loaded)
+
+		loaded.Age = 30
+		require.NoError(t, uow.Update(loaded))
+		require.NoError(t, uow.Commit())
+
+		var stored User
+		require.NoError(t, db.First(&stored, user.ID).Error)
+		assert.Equal(t, 30, stored.Age)
+		assert.Equal(t, int64(2), stored.Revision)
+	})
+
+	t.Run("刷新失败时只撤销本次刷新", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db, WithValidation(false))
+
+		require.NoError(t, uow.Create(&User{Name: "张三", Email: "zhangsan@example.com"}))
+		require.NoError(t, uow.Flush())
+
+		require.NoError(t, uow.Create(&User{Name: "李四", Email: "lisi@example.com"}))
+		require.NoError(t, uow.Create(&User{Name: "重复", Email: "zhangsan@example.com"}))
+		require.Error(t, uow.Flush())
+		assert.Equal(t, 2, uow.GetStats().NewEntities)
+
+		var count int64
+		require.NoError(t, uow.db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+
+		require.Error(t, uow.Commit())
+		require.NoError(t, uow.Rollback())
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("内存存储的刷新在提交前对其他工作单元不可见", func(t *testing.T) {
+		store := NewMemoryStore()
+		uow := NewMemoryUnitOfWork(store, WithAutoFlush(1))
+
+		user := &User{Name: "张三", Email: "zhangsan@example.com"}
+		require.NoError(t, uow.Create(user))
+
+		var users []*User
+		require.NoError(t, store.FindAll(&users))
+		assert.Empty(t, users)
+
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, user.ID))
+		assert.Equal(t, "张三", loaded.Name)
+		require.NoError(t, uow.Rollback())
+
+		require.NoError(t, store.FindAll(&users))
+		assert.Empty(t, users)
+
+		committing := NewMemoryUnitOfWork(store, WithAutoFlush(1))
+		require.NoError(t, committing.Create(&User{Name: "李四", Email: "lisi@example.com"}))
+		require.NoError(t, committing.Create(&User{Name: "王五", Email: "wangwu@example.com"}))
+		require.NoError(t, committing.Commit())
+
+		require.NoError(t, store.FindAll(&users))
+		assert.Len(t, users, 2)
+	})
+}
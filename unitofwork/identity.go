@@ -0,0 +1,217 @@
+package unitofwork
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+)
+
+// Identity 实体标识，由主键字段的值按声明顺序组成，支持整数、字符串、UUID 及复合主键
+type Identity struct {
+	values []interface{}
+	key    string
+}
+
+// NewIdentity 使用主键值创建实体标识，指针值会被解引用
+func NewIdentity(values ...interface{}) Identity {
+	identity := Identity{values: make([]interface{}, 0, len(values))}
+
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				value = nil
+			} else {
+				value = v.Elem().Interface()
+			}
+		}
+
+		identity.values = append(identity.values, value)
+		parts = append(parts, escapeIdentityPart(toString(value)))
+	}
+
+	identity.key = strings.Join(parts, ",")
+	return identity
+}
+
+// Values 获取主键值
+func (id Identity) Values() []interface{} {
+	return id.values
+}
+
+// IsZero 检查标识是否尚未分配，任一主键值为零值即视为未分配，例如插入前的自增主键
+func (id Identity) IsZero() bool {
+	if len(id.values) == 0 {
+		return true
+	}
+
+	for _, value := range id.values {
+		if value == nil || reflect.ValueOf(value).IsZero() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Equal 检查两个标识是否相同
+func (id Identity) Equal(other Identity) bool {
+	return id.key == other.key
+}
+
+// String 返回标识的字符串形式，复合主键以逗号分隔
+func (id Identity) String() string {
+	return id.key
+}
+
+func escapeIdentityPart(part string) string {
+	if !strings.ContainsAny(part, `\,`) {
+		return part
+	}
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`).Replace(part)
+}
+
+// Identifiable 自定义标识的实体，未实现时从 gorm schema 的主键字段读取标识
+type Identifiable interface {
+	// GetIdentity 获取实体标识
+	GetIdentity() Identity
+}
+
+// IdentityOf 获取实体标识
+func IdentityOf(entity Entity) Identity {
+	if entity == nil {
+		return Identity{}
+	}
+
+	if identifiable, ok := entity.(Identifiable); ok {
+		return identifiable.GetIdentity()
+	}
+
+	value := reflect.ValueOf(entity)
+	if value.Kind() == reflect.Ptr && value.IsNil() {
+		return Identity{}
+	}
+
+	if fields := primaryFieldsOf(value.Type()); len(fields) > 0 {
+		value = reflect.Indirect(value)
+		values := make([]interface{}, 0, len(fields))
+		for _, field := range fields {
+			values = append(values, field.ReflectValueOf(context.Background(), value).Interface())
+		}
+		return NewIdentity(values...)
+	}
+
+	if hasID, ok := entity.(HasID); ok {
+		return NewIdentity(hasID.GetID())
+	}
+
+	return Identity{}
+}
+
+// sameEntity 检查两个实体是否表示同一行数据，标识未分配时只有同一实例才视为相同
+func sameEntity(a, b Entity) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	if a == b {
+		return true
+	}
+
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+
+	identityA, identityB := IdentityOf(a), IdentityOf(b)
+	if identityA.IsZero() || identityB.IsZero() {
+		return false
+	}
+
+	return identityA.Equal(identityB)
+}
+
+// schemaCache 实体主键字段解析缓存
+var schemaCache sync.Map
+
+// primaryFieldsOf 获取实体类型的主键字段
+func primaryFieldsOf(entityType reflect.Type) []*schema.Field {
+	modelType := entityType
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+
+	if modelType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	parsed, err := schema.Parse(reflect.New(modelType).Interface(), &schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil
+	}
+
+	return parsed.PrimaryFields
+}
+
+// whereIdentity 按标识构建主键查询条件
+func whereIdentity(db *gorm.DB, model interface{}, identity Identity) (*gorm.DB, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+
+	fields := stmt.Schema.PrimaryFields
+	values := identity.Values()
+	if len(fields) != len(values) {
+		return nil, gorm.ErrPrimaryKeyRequired
+	}
+
+	for i, field := range fields {
+		db = db.Where(clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName},
+			Value:  values[i],
+		})
+	}
+
+	return db, nil
+}
+
+// whereIdentities 按多个标识构建主键 IN 查询条件，任一标识未分配时返回错误
+func whereIdentities(db *gorm.DB, model interface{}, identities []Identity) (*gorm.DB, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+
+	fields := stmt.Schema.PrimaryFields
+	if len(fields) == 0 {
+		return nil, gorm.ErrPrimaryKeyRequired
+	}
+
+	columns := make([]clause.Column, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, clause.Column{Table: clause.CurrentTable, Name: field.DBName})
+	}
+
+	values := make([]interface{}, 0, len(identities))
+	for _, identity := range identities {
+		if identity.IsZero() || len(identity.Values()) != len(fields) {
+			return nil, gorm.ErrPrimaryKeyRequired
+		}
+
+		if len(fields) == 1 {
+			values = append(values, identity.Values()[0])
+		} else {
+			values = append(values, identity.Values())
+		}
+	}
+
+	if len(fields) == 1 {
+		return db.Where(clause.IN{Column: columns[0], Values: values}), nil
+	}
+	return db.Where(clause.IN{Column: columns, Values: values}), nil
+}
@@ -0,0 +1,161 @@
+package unitofwork
+
+import (
+	"reflect"
+	"unsafe"
+	"weak"
+)
+
+// IdentityMap 实体身份映射，保证同一行数据在工作单元内只对应一个内存实例
+type IdentityMap struct {
+	entities map[string]Entity
+
+	// 弱引用模式下以弱引用持有实体，不再被引用的实体可以被回收
+	refs map[string]weakEntity
+}
+
+// NewIdentityMap 创建身份映射
+func NewIdentityMap() *IdentityMap {
+	return &IdentityMap{
+		entities: make(map[string]Entity),
+	}
+}
+
+// newWeakIdentityMap 创建以弱引用持有实体的身份映射，实体被回收后视为未跟踪
+func newWeakIdentityMap() *IdentityMap {
+	return &IdentityMap{
+		refs: make(map[string]weakEntity),
+	}
+}
+
+// Get 按实体类型和ID获取已跟踪的实体，id 可以是主键值或复合主键的 Identity
+func (im *IdentityMap) Get(entityType reflect.Type, id interface{}) (Entity, bool) {
+	return im.get(identityKey(entityType, toIdentity(id)))
+}
+
+func (im *IdentityMap) get(key string) (Entity, bool) {
+	if im.refs == nil {
+		entity, exists := im.entities[key]
+		return entity, exists
+	}
+
+	ref, exists := im.refs[key]
+	if !exists {
+		return nil, false
+	}
+
+	entity := ref.get()
+	if entity == nil {
+		delete(im.refs, key)
+		return nil, false
+	}
+	return entity, true
+}
+
+// Lookup 获取与给定实体身份相同的已跟踪实体
+func (im *IdentityMap) Lookup(entity Entity) (Entity, bool) {
+	return im.Get(reflect.TypeOf(entity), IdentityOf(entity))
+}
+
+// Put 跟踪实体，如果已存在相同身份的实体则返回已有实例，标识尚未分配的实体不会被跟踪
+func (im *IdentityMap) Put(entity Entity) (Entity, bool) {
+	identity := IdentityOf(entity)
+	if identity.IsZero() {
+		return entity, false
+	}
+
+	key := identityKey(reflect.TypeOf(entity), identity)
+	if existing, exists := im.get(key); exists {
+		return existing, false
+	}
+
+	if im.refs != nil {
+		im.refs[key] = makeWeakEntity(entity)
+	} else {
+		im.entities[key] = entity
+	}
+	return entity, true
+}
+
+// Remove 移除实体
+func (im *IdentityMap) Remove(entity Entity) {
+	im.removeKey(identityKey(reflect.TypeOf(entity), IdentityOf(entity)))
+}
+
+// removeKey 按键移除实体
+func (im *IdentityMap) removeKey(key string) {
+	delete(im.entities, key)
+	delete(im.refs, key)
+}
+
+// Len 获取已跟踪的实体数量
+func (im *IdentityMap) Len() int {
+	if im.refs == nil {
+		return len(im.entities)
+	}
+
+	count := 0
+	for key := range im.refs {
+		if _, alive := im.get(key); alive {
+			count++
+		}
+	}
+	return count
+}
+
+// Clear 清空身份映射
+func (im *IdentityMap) Clear() {
+	if im.refs != nil {
+		im.refs = make(map[string]weakEntity)
+		return
+	}
+	im.entities = make(map[string]Entity)
+}
+
+// weakEntity 实体的弱引用
+type weakEntity struct {
+	entityType reflect.Type
+	pointer    weak.Pointer[byte]
+
+	// 不是结构体指针的实体无法创建弱引用，以强引用持有
+	strong Entity
+}
+
+// makeWeakEntity 创建实体的弱引用，不是结构体指针的实体以强引用持有，不会被清理
+func makeWeakEntity(entity Entity) weakEntity {
+	value := reflect.ValueOf(entity)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Type().Elem().Kind() != reflect.Struct {
+		return weakEntity{strong: entity}
+	}
+
+	return weakEntity{
+		entityType: value.Type(),
+		pointer:    weak.Make((*byte)(value.UnsafePointer())),
+	}
+}
+
+// get 获取实体，已被回收时返回 nil
+func (w weakEntity) get() Entity {
+	if w.strong != nil {
+		return w.strong
+	}
+
+	pointer := w.pointer.Value()
+	if pointer == nil {
+		return nil
+	}
+	return reflect.NewAt(w.entityType.Elem(), unsafe.Pointer(pointer)).Interface().(Entity)
+}
+
+// identityKey 构建实体身份键：类型#标识
+func identityKey(entityType reflect.Type, identity Identity) string {
+	return entityType.String() + "#" + identity.String()
+}
+
+// toIdentity 将主键值转换为实体标识
+func toIdentity(id interface{}) Identity {
+	if identity, ok := id.(Identity); ok {
+		return identity
+	}
+	return NewIdentity(id)
+}
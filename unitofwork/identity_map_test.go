@@ -0,0 +1,124 @@
+package unitofwork
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// 以值接收者实现 Entity 的实体，不是结构体指针
+type settingValue struct {
+	ID    uint
+	Value string
+}
+
+func (s settingValue) GetTableName() string {
+	return "settings"
+}
+
+func (s settingValue) IsNew() bool {
+	return s.ID == 0
+}
+
+// TestUnitOfWork_IdentityMap 测试身份映射
+func TestUnitOfWork_IdentityMap(t *testing.T) {
+	t.Run("Find返回同一实例并自动创建快照", func(t *testing.T) {
+		db := setupTestDB()
+		require.NoError(t, db.Create(&User{Name: "张三", Email: "zhangsan@example.com", Age: 20}).Error)
+
+		uow := NewUnitOfWork(db)
+
+		var first, second *User
+		require.NoError(t, uow.Find(&first, 1))
+		require.NoError(t, uow.Find(&second, 1))
+		assert.Same(t, first, second)
+
+		first.Age = 21
+		require.NoError(t, uow.Update(first))
+		assert.Equal(t, 1, uow.GetStats().DirtyEntities)
+
+		require.NoError(t, uow.Commit())
+
+		var reloaded User
+		require.NoError(t, db.First(&reloaded, 1).Error)
+		assert.Equal(t, 21, reloaded.Age)
+	})
+
+	t.Run("Attach返回已跟踪的实例", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		original := &User{BaseEntity: BaseEntity{ID: 7}, Name: "李四"}
+		duplicate := &User{BaseEntity: BaseEntity{ID: 7}, Name: "李四-副本"}
+
+		assert.Same(t, original, uow.Attach(original))
+		assert.Same(t, original, uow.Attach(duplicate))
+
+		duplicate.Name = "冲突的修改"
+		assert.Error(t, uow.Update(duplicate))
+	})
+
+	t.Run("弱引用模式下以强引用持有非指针实体", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db, WithSnapshotLimit(1))
+
+		setting := settingValue{ID: 1, Value: "dark"}
+		assert.Equal(t, Entity(setting), uow.Attach(setting))
+		uow.Attach(settingValue{ID: 2, Value: "light"})
+		runtime.GC()
+
+		tracked, exists := uow.identityMap.Get(reflect.TypeOf(setting), 1)
+		require.True(t, exists)
+		assert.Equal(t, Entity(setting), tracked)
+		assert.Equal(t, 2, uow.GetStats().TrackedEntities)
+	})
+
+	t.Run("Find参数校验", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		var user User
+		assert.Error(t, uow.Find(&user, 1))
+
+		var missing *User
+		assert.Error(t, uow.Find(&missing, 42))
+	})
+
+	t.Run("插件查询结果纳入身份映射", func(t *testing.T) {
+		db := setupPluginTestDB()
+		require.NoError(t, db.Use(NewPlugin()))
+		require.NoError(t, db.Session(&gorm.Session{SkipHooks: true}).Create(&User{
+			BaseEntity: BaseEntity{ID: 1},
+			Name:       "王五",
+			Email:      "wangwu@example.com",
+			Age:        30,
+		}).Error)
+
+		err := WithUnitOfWork(context.Background(), db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			var users []*User
+			if err := tx.Find(&users).Error; err != nil {
+				return err
+			}
+			require.Len(t, users, 1)
+
+			var found *User
+			if err := uow.Find(&found, 1); err != nil {
+				return err
+			}
+			assert.Same(t, users[0], found)
+
+			found.Age = 31
+			return tx.Save(found).Error
+		})
+		require.NoError(t, err)
+
+		var reloaded User
+		require.NoError(t, db.First(&reloaded, 1).Error)
+		assert.Equal(t, 31, reloaded.Age)
+	})
+}
@@ -0,0 +1,152 @@
+package unitofwork
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 示例实体：字符串主键
+type Device struct {
+	UUID      string `gorm:"primaryKey;size:36"`
+	Name      string `gorm:"size:100"`
+	CreatedAt time.Time
+}
+
+func (d *Device) GetTableName() string {
+	return "devices"
+}
+
+func (d *Device) IsNew() bool {
+	return d.CreatedAt.IsZero()
+}
+
+// 示例实体：复合主键
+type Membership struct {
+	UserID    uint   `gorm:"primaryKey;autoIncrement:false"`
+	GroupID   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Role      string `gorm:"size:50"`
+	CreatedAt time.Time
+}
+
+func (m *Membership) GetTableName() string {
+	return "memberships"
+}
+
+func (m *Membership) IsNew() bool {
+	return m.CreatedAt.IsZero()
+}
+
+// TestIdentityOf 测试实体标识
+func TestIdentityOf(t *testing.T) {
+	assert.Equal(t, "1", IdentityOf(&User{BaseEntity: BaseEntity{ID: 1}}).String())
+	assert.True(t, IdentityOf(&User{}).IsZero())
+
+	device := IdentityOf(&Device{UUID: "7d7c1f0e-5a57-4a3e-9c3b-2f1f1a0d6e11"})
+	assert.Equal(t, "7d7c1f0e-5a57-4a3e-9c3b-2f1f1a0d6e11", device.String())
+	assert.False(t, device.IsZero())
+
+	membership := IdentityOf(&Membership{UserID: 1, GroupID: 2})
+	assert.Equal(t, []interface{}{uint(1), uint(2)}, membership.Values())
+	assert.True(t, membership.Equal(NewIdentity(uint(1), uint(2))))
+	assert.True(t, IdentityOf(&Membership{UserID: 1}).IsZero())
+
+	// 分隔符被转义，不同的复合主键不会产生相同的标识
+	assert.False(t, NewIdentity("a,b", "c").Equal(NewIdentity("a", "b,c")))
+
+	id := uint(3)
+	assert.True(t, NewIdentity(&id).Equal(NewIdentity(uint(3))))
+}
+
+// TestUnitOfWork_Identity 测试按主键标识跟踪实体
+func TestUnitOfWork_Identity(t *testing.T) {
+	t.Run("多个未分配主键的新实体互不冲突", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		first := &User{Name: "张三", Email: "zhangsan@example.com"}
+		second := &User{Name: "李四", Email: "lisi@example.com"}
+		require.NoError(t, uow.Create(first))
+		require.NoError(t, uow.Create(second))
+		require.NoError(t, uow.Create(first))
+		assert.Equal(t, 2, uow.GetStats().NewEntities)
+
+		require.NoError(t, uow.Commit())
+		assert.NotZero(t, first.ID)
+		assert.NotEqual(t, first.ID, second.ID)
+	})
+
+	t.Run("字符串主键", func(t *testing.T) {
+		db := setupTestDB()
+		require.NoError(t, db.AutoMigrate(&Device{}))
+		uuid := "7d7c1f0e-5a57-4a3e-9c3b-2f1f1a0d6e11"
+
+		uow := NewUnitOfWork(db)
+		require.NoError(t, uow.Create(&Device{UUID: uuid, Name: "网关"}))
+		require.NoError(t, uow.Commit())
+
+		uow = NewUnitOfWork(db)
+		var device *Device
+		require.NoError(t, uow.Find(&device, uuid))
+		var again *Device
+		require.NoError(t, uow.Find(&again, uuid))
+		assert.Same(t, device, again)
+
+		device.Name = "边缘网关"
+		require.NoError(t, uow.Update(device))
+		require.NoError(t, uow.Commit())
+
+		var saved Device
+		require.NoError(t, db.First(&saved, "uuid = ?", uuid).Error)
+		assert.Equal(t, "边缘网关", saved.Name)
+	})
+
+	t.Run("复合主键", func(t *testing.T) {
+		db := setupTestDB()
+		require.NoError(t, db.AutoMigrate(&Membership{}))
+
+		uow := NewUnitOfWork(db)
+		require.NoError(t, uow.Create(&Membership{UserID: 1, GroupID: 1, Role: "owner"}))
+		require.NoError(t, uow.Create(&Membership{UserID: 1, GroupID: 2, Role: "member"}))
+		require.NoError(t, uow.Create(&Membership{UserID: 2, GroupID: 1, Role: "member"}))
+		require.NoError(t, uow.Commit())
+
+		uow = NewUnitOfWork(db)
+		var membership *Membership
+		require.NoError(t, uow.Find(&membership, NewIdentity(uint(1), uint(2))))
+		assert.Equal(t, "member", membership.Role)
+
+		tracked, exists := uow.identityMap.Get(reflect.TypeOf(membership), NewIdentity(uint(1), uint(2)))
+		require.True(t, exists)
+		assert.Same(t, membership, tracked)
+
+		// 同一行数据的其他实例不能再注册
+		assert.Error(t, uow.Update(&Membership{UserID: 1, GroupID: 2, CreatedAt: membership.CreatedAt}))
+
+		membership.Role = "admin"
+		require.NoError(t, uow.Update(membership))
+		require.NoError(t, uow.Commit())
+
+		var saved Membership
+		require.NoError(t, db.First(&saved, "user_id = ? AND group_id = ?", 1, 2).Error)
+		assert.Equal(t, "admin", saved.Role)
+
+		// 批量删除按复合主键匹配，不影响主键部分相同的其他行
+		uow = NewUnitOfWork(db)
+		var owner, member *Membership
+		require.NoError(t, uow.Find(&owner, NewIdentity(uint(1), uint(1))))
+		require.NoError(t, uow.Find(&member, NewIdentity(uint(1), uint(2))))
+		require.NoError(t, uow.Delete(owner))
+		require.NoError(t, uow.Delete(member))
+		require.NoError(t, uow.Commit())
+
+		var remaining []Membership
+		require.NoError(t, db.Find(&remaining).Error)
+		require.Len(t, remaining, 1)
+		assert.Equal(t, uint(2), remaining[0].UserID)
+		assert.Equal(t, uint(1), remaining[0].GroupID)
+	})
+}
@@ -0,0 +1,152 @@
+package unitofwork
+
+import (
+	"context"
+
+	"github.com/wubin1989/gorm"
+)
+
+// Listener 工作单元生命周期监听器
+// BeforeFlush、BeforeOperation、AfterOperation 返回错误时否决提交，
+// 已执行的操作随事务或保存点一起回滚
+type Listener interface {
+	// BeforeFlush 在计算和执行操作之前调用，此时不持有工作单元的锁，
+	// 可以修改待提交的实体或注册新的实体
+	BeforeFlush(ctx context.Context, uow *UnitOfWork) error
+
+	// BeforeOperation 在每个操作执行之前调用，tx 为执行操作的数据库会话
+	BeforeOperation(ctx context.Context, tx *gorm.DB, operation Operation) error
+
+	// AfterOperation 在每个操作成功执行之后调用
+	AfterOperation(ctx context.Context, tx *gorm.DB, operation Operation) error
+
+	// AfterCommit 在提交成功之后调用
+	AfterCommit(ctx context.Context, uow *UnitOfWork)
+
+	// AfterRollback 在提交失败或显式回滚之后调用，显式回滚时 cause 为 nil
+	AfterRollback(ctx context.Context, uow *UnitOfWork, cause error)
+}
+
+// BaseListener 空监听器，可嵌入到只关心部分回调的监听器中
+type BaseListener struct{}
+
+// BeforeFlush 实现Listener接口
+func (BaseListener) BeforeFlush(ctx context.Context, uow *UnitOfWork) error {
+	return nil
+}
+
+// BeforeOperation 实现Listener接口
+func (BaseListener) BeforeOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	return nil
+}
+
+// AfterOperation 实现Listener接口
+func (BaseListener) AfterOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	return nil
+}
+
+// AfterCommit 实现Listener接口
+func (BaseListener) AfterCommit(ctx context.Context, uow *UnitOfWork) {}
+
+// AfterRollback 实现Listener接口
+func (BaseListener) AfterRollback(ctx context.Context, uow *UnitOfWork, cause error) {}
+
+// WithListener 配置生命周期监听器
+func WithListener(listener Listener) ConfigOption {
+	return func(c *Config) {
+		c.Listeners = append(c.Listeners, listener)
+	}
+}
+
+// AddListener 为工作单元注册生命周期监听器，监听器按注册顺序调用
+func (uow *UnitOfWork) AddListener(listener Listener) {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	uow.listeners = append(uow.listeners, listener)
+}
+
+// PendingEntities 获取已注册待提交的实体，可按操作类型过滤，例如 OperationTypeInsert
+func (uow *UnitOfWork) PendingEntities(operationTypes ...OperationType) []Entity {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	entities := make([]Entity, 0, len(uow.operations))
+	for _, operation := range uow.operations {
+		if len(operationTypes) > 0 && !containsOperationType(operationTypes, operation.GetOperationType()) {
+			continue
+		}
+		entities = append(entities, operationEntities(operation)...)
+	}
+	return entities
+}
+
+func containsOperationType(operationTypes []OperationType, operationType OperationType) bool {
+	for _, t := range operationTypes {
+		if t == operationType {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginListeners 获取数据库上注册的工作单元插件的全局监听器
+func pluginListeners(db *gorm.DB) []Listener {
+	if db == nil || db.Config == nil {
+		return nil
+	}
+
+	var listeners []Listener
+	for _, plugin := range db.Config.Plugins {
+		if p, ok := plugin.(*Plugin); ok {
+			listeners = append(listeners, p.config.Listeners...)
+		}
+	}
+	return listeners
+}
+
+func (uow *UnitOfWork) getListeners() []Listener {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	return uow.listeners
+}
+
+func (uow *UnitOfWork) beforeFlush() error {
+	for _, listener := range uow.getListeners() {
+		if err := listener.BeforeFlush(uow.ctx, uow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (uow *UnitOfWork) beforeOperation(tx *gorm.DB, operation Operation) error {
+	for _, listener := range uow.getListeners() {
+		if err := listener.BeforeOperation(uow.ctx, tx, operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (uow *UnitOfWork) afterOperation(tx *gorm.DB, operation Operation) error {
+	for _, listener := range uow.getListeners() {
+		if err := listener.AfterOperation(uow.ctx, tx, operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (uow *UnitOfWork) afterCommit() {
+	for _, listener := range uow.getListeners() {
+		listener.AfterCommit(uow.ctx, uow)
+	}
+}
+
+func (uow *UnitOfWork) afterRollback(cause error) {
+	for _, listener := range uow.getListeners() {
+		listener.AfterRollback(uow.ctx, uow, cause)
+	}
+}
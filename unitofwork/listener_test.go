@@ -0,0 +1,204 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// recordingListener 记录生命周期事件的监听器
+type recordingListener struct {
+	BaseListener
+	events []string
+	causes []error
+}
+
+func (l *recordingListener) BeforeFlush(ctx context.Context, uow *UnitOfWork) error {
+	l.events = append(l.events, "BeforeFlush")
+	return nil
+}
+
+func (l *recordingListener) BeforeOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	l.events = append(l.events, "BeforeOperation "+operation.GetOperationType().String())
+	return nil
+}
+
+func (l *recordingListener) AfterOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	l.events = append(l.events, "AfterOperation "+operation.GetOperationType().String())
+	return nil
+}
+
+func (l *recordingListener) AfterCommit(ctx context.Context, uow *UnitOfWork) {
+	l.events = append(l.events, "AfterCommit")
+}
+
+func (l *recordingListener) AfterRollback(ctx context.Context, uow *UnitOfWork, cause error) {
+	l.events = append(l.events, "AfterRollback")
+	l.causes = append(l.causes, cause)
+}
+
+// tagColorListener 提交前为未设置颜色的新标签设置默认颜色
+type tagColorListener struct {
+	BaseListener
+}
+
+func (tagColorListener) BeforeFlush(ctx context.Context, uow *UnitOfWork) error {
+	for _, entity := range uow.PendingEntities(OperationTypeInsert) {
+		if tag, ok := entity.(*Tag); ok && tag.Color == "" {
+			tag.Color = "#000000"
+		}
+	}
+	return nil
+}
+
+// vetoListener 否决指定实体类型的操作
+type vetoListener struct {
+	BaseListener
+	entityType reflect.Type
+}
+
+func (l vetoListener) BeforeOperation(ctx context.Context, tx *gorm.DB, operation Operation) error {
+	if operation.GetEntityType() == l.entityType {
+		return fmt.Errorf("%s is read only", l.entityType)
+	}
+	return nil
+}
+
+// flushVetoListener 否决提交
+type flushVetoListener struct {
+	BaseListener
+	err error
+}
+
+func (l flushVetoListener) BeforeFlush(ctx context.Context, uow *UnitOfWork) error {
+	return l.err
+}
+
+// TestUnitOfWork_Listeners 测试生命周期监听器
+func TestUnitOfWork_Listeners(t *testing.T) {
+	t.Run("按顺序通知生命周期事件", func(t *testing.T) {
+		db := setupTestDB()
+		require.NoError(t, db.Create(&User{Name: "张三", Email: "zhangsan@example.com"}).Error)
+
+		listener := &recordingListener{}
+		uow := NewUnitOfWork(db, WithListener(listener))
+
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Commit())
+
+		assert.Equal(t, []string{
+			"BeforeFlush",
+			"BeforeOperation INSERT",
+			"AfterOperation INSERT",
+			"BeforeOperation UPDATE",
+			"AfterOperation UPDATE",
+			"AfterCommit",
+		}, listener.events)
+	})
+
+	t.Run("提交前修改待插入的实体", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+		uow.AddListener(tagColorListener{})
+
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Create(&Tag{Name: "rust", Color: "#DEA584"}))
+		require.NoError(t, uow.Commit())
+
+		var tags []Tag
+		require.NoError(t, db.Order("id").Find(&tags).Error)
+		require.Len(t, tags, 2)
+		assert.Equal(t, "#000000", tags[0].Color)
+		assert.Equal(t, "#DEA584", tags[1].Color)
+	})
+
+	t.Run("否决提交", func(t *testing.T) {
+		db := setupTestDB()
+		listener := &recordingListener{}
+		veto := errors.New("invariant violated")
+
+		uow := NewUnitOfWork(db, WithListener(listener))
+		uow.AddListener(flushVetoListener{err: veto})
+
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		err := uow.Commit()
+		require.ErrorIs(t, err, veto)
+		assert.False(t, uow.IsCommitted())
+		assert.Equal(t, []string{"BeforeFlush", "AfterRollback"}, listener.events)
+		assert.ErrorIs(t, listener.causes[0], veto)
+
+		var count int64
+		require.NoError(t, db.Model(&Tag{}).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+
+		// 提交失败已通知回滚，之后调用 Rollback 不再重复通知
+		require.NoError(t, uow.Rollback())
+		assert.Equal(t, []string{"BeforeFlush", "AfterRollback"}, listener.events)
+	})
+
+	t.Run("提交失败后回滚只通知一次", func(t *testing.T) {
+		db := setupTestDB()
+		listener := &recordingListener{}
+
+		uow := NewUnitOfWork(db, WithListener(listener))
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		err := uow.Commit()
+		require.Error(t, err)
+		require.NoError(t, uow.Rollback())
+
+		assert.Equal(t, "AfterRollback", listener.events[len(listener.events)-1])
+		require.Len(t, listener.causes, 1)
+		assert.Equal(t, err, listener.causes[0])
+
+		// 未提交直接回滚时通知一次
+		listener = &recordingListener{}
+		uow = NewUnitOfWork(db, WithListener(listener))
+		require.NoError(t, uow.Rollback())
+		assert.Equal(t, []string{"AfterRollback"}, listener.events)
+		assert.Nil(t, listener.causes[0])
+	})
+
+	t.Run("否决操作时回滚事务", func(t *testing.T) {
+		db := setupTestDB()
+
+		err := WithUnitOfWork(context.Background(), db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			require.NoError(t, uow.Create(&User{Name: "张三", Email: "zhangsan@example.com"}))
+			require.NoError(t, uow.Create(&Tag{Name: "go"}))
+			return nil
+		}, WithListener(vetoListener{entityType: reflect.TypeOf(&Tag{})}), WithAutoDependency(false))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "vetoed by listener")
+
+		// 已执行的用户插入随事务回滚
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("插件全局监听器", func(t *testing.T) {
+		db := setupPluginTestDB()
+		listener := &recordingListener{}
+		require.NoError(t, db.Use(NewPlugin(WithPluginListener(listener))))
+
+		uow := NewUnitOfWork(db)
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Commit())
+		assert.Contains(t, listener.events, "AfterCommit")
+
+		child, err := NewUnitOfWork(db).Begin()
+		require.NoError(t, err)
+		require.NoError(t, child.Rollback())
+		assert.Equal(t, "AfterRollback", listener.events[len(listener.events)-1])
+	})
+}
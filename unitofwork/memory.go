@@ -0,0 +1,797 @@
+package unitofwork
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/schema"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// MemoryStore 内存存储，按实体类型将行保存在内存表中，用于在没有 SQL 驱动的情况下测试领域逻辑
+// 通过 NewMemoryUnitOfWork 创建的工作单元将操作应用到内存存储而不是数据库：
+// 每次提交是一个事务，失败时不留下部分变更；更新检查版本号，冲突时返回 *ConflictError；
+// 软删除的行对查询不可见。只检查主键冲突，不检查唯一索引和外键约束，也不级联写入关联
+type MemoryStore struct {
+	mu    sync.RWMutex
+	state *memoryState
+
+	// 事务串行执行，staged 为进行中的事务的工作副本
+	txMu   sync.Mutex
+	staged *memoryState
+}
+
+// MemoryRecord 内存存储中已提交的一次写入
+type MemoryRecord struct {
+	// 写入类型
+	Action ChangeAction
+	// 写入后的行，删除时为删除前的行
+	Entity Entity
+}
+
+// TestingT 断言使用的测试接口，*testing.T 实现了该接口
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type memoryState struct {
+	tables  map[reflect.Type]*memoryTable
+	journal []MemoryRecord
+	outbox  []*OutboxMessage
+}
+
+type memoryTable struct {
+	schema   *schema.Schema
+	rows     map[string]Entity
+	keys     []string
+	sequence int64
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newMemoryState()}
+}
+
+// NewMemoryUnitOfWork 创建使用内存存储的工作单元，操作不经过 gorm 执行
+// 监听器的 BeforeOperation 和 AfterOperation 收到的 tx 为 nil，审计记录接收器同样收到 nil，
+// 领域事件写入内存存储的发件箱，可通过 MemoryStore.Outbox 获取
+func NewMemoryUnitOfWork(store *MemoryStore, options ...ConfigOption) *UnitOfWork {
+	uow := NewUnitOfWork(nil, options...)
+	uow.memory = store
+	return uow
+}
+
+// Seed 直接写入初始数据，不记录写入，未分配的自增主键和默认值会回写到实体
+func (s *MemoryStore) Seed(entities ...Entity) error {
+	return s.transaction(func() error {
+		for _, entity := range entities {
+			table, err := s.staged.table(reflect.TypeOf(entity))
+			if err != nil {
+				return err
+			}
+			if err := table.insert(entity); err != nil {
+				return fmt.Errorf("failed to seed entity %T: %w", entity, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Find 按标识加载实体的副本，软删除的实体视为不存在
+// dest 必须是实体指针的指针，例如 var user *User; store.Find(&user, 1)
+func (s *MemoryStore) Find(dest interface{}, id interface{}) error {
+	return s.find(dest, id, false)
+}
+
+// FindUnscoped 按标识加载实体的副本，包括软删除的实体
+func (s *MemoryStore) FindUnscoped(dest interface{}, id interface{}) error {
+	return s.find(dest, id, true)
+}
+
+func (s *MemoryStore) find(dest interface{}, id interface{}, unscoped bool) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("dest must be a pointer to an entity pointer, got %T", dest)
+	}
+
+	entityType := destValue.Elem().Type()
+	entity, err := s.load(entityType, toIdentity(id), unscoped, false)
+	if err != nil {
+		return fmt.Errorf("failed to find entity %s with id %v: %w", entityType, id, err)
+	}
+
+	destValue.Elem().Set(reflect.ValueOf(entity))
+	return nil
+}
+
+// FindAll 按插入顺序加载同类型所有未删除实体的副本，dest 必须是实体指针切片的指针，例如 var users []*User
+func (s *MemoryStore) FindAll(dest interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a pointer to a slice of entity pointers, got %T", dest)
+	}
+
+	entityType := destValue.Elem().Type().Elem()
+	if !entityType.Implements(entityInterfaceType) {
+		return fmt.Errorf("type %s does not implement Entity", entityType)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := reflect.MakeSlice(destValue.Elem().Type(), 0, 0)
+	if table, exists := s.state.tables[entityType]; exists {
+		for _, key := range table.keys {
+			if row := table.rows[key]; !table.deleted(row) {
+				result = reflect.Append(result, reflect.ValueOf(table.copyRow(row)))
+			}
+		}
+	}
+
+	destValue.Elem().Set(result)
+	return nil
+}
+
+// Journal 按执行顺序返回已提交的写入记录
+func (s *MemoryStore) Journal() []MemoryRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]MemoryRecord(nil), s.state.journal...)
+}
+
+// Outbox 返回已提交的发件箱消息
+func (s *MemoryStore) Outbox() []*OutboxMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*OutboxMessage(nil), s.state.outbox...)
+}
+
+// Reset 清空所有数据、写入记录和发件箱消息
+func (s *MemoryStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = newMemoryState()
+}
+
+// ResetJournal 清空写入记录和发件箱消息，保留数据，通常在准备测试数据之后调用
+func (s *MemoryStore) ResetJournal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.journal = nil
+	s.state.outbox = nil
+}
+
+// AssertInserted 断言按顺序插入了期望的实体
+// 期望的实体已分配标识时按类型和标识比较，否则按类型和非零字段比较
+func (s *MemoryStore) AssertInserted(t TestingT, expected ...Entity) bool {
+	return s.assertJournal(t, ChangeActionInsert, expected)
+}
+
+// AssertUpdated 断言按顺序更新了期望的实体
+func (s *MemoryStore) AssertUpdated(t TestingT, expected ...Entity) bool {
+	return s.assertJournal(t, ChangeActionUpdate, expected)
+}
+
+// AssertDeleted 断言按顺序删除了期望的实体，包括软删除
+func (s *MemoryStore) AssertDeleted(t TestingT, expected ...Entity) bool {
+	return s.assertJournal(t, ChangeActionDelete, expected)
+}
+
+// AssertSoftDeleted 断言实体对应的行存在且已被软删除
+func (s *MemoryStore) AssertSoftDeleted(t TestingT, entities ...Entity) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ok := true
+	for _, entity := range entities {
+		table, row := s.state.lookup(entity)
+		switch {
+		case row == nil:
+			t.Errorf("expected %s to be soft deleted, but it does not exist", describeEntity(entity))
+			ok = false
+		case !table.deleted(row):
+			t.Errorf("expected %s to be soft deleted, but it is not", describeEntity(entity))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertNotExists 断言实体对应的行不存在，包括软删除的行
+func (s *MemoryStore) AssertNotExists(t TestingT, entities ...Entity) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ok := true
+	for _, entity := range entities {
+		if _, row := s.state.lookup(entity); row != nil {
+			t.Errorf("expected %s not to exist", describeEntity(entity))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// assertJournal 比较指定类型的写入记录与期望的实体
+func (s *MemoryStore) assertJournal(t TestingT, action ChangeAction, expected []Entity) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	actual := make([]Entity, 0)
+	for _, record := range s.Journal() {
+		if record.Action == action {
+			actual = append(actual, record.Entity)
+		}
+	}
+
+	matched := len(actual) == len(expected)
+	for i := 0; matched && i < len(expected); i++ {
+		matched = matchEntity(expected[i], actual[i])
+	}
+
+	if !matched {
+		t.Errorf("expected %s of [%s], got [%s]", strings.ToLower(string(action)), describeEntities(expected), describeEntities(actual))
+	}
+	return matched
+}
+
+// transaction 在工作副本上执行 fn，成功时替换已提交的状态
+func (s *MemoryStore) transaction(fn func() error) error {
+	s.begin()
+
+	committed := false
+	defer func() {
+		s.end(committed)
+	}()
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+// begin 开始事务，事务串行执行，直到 end 之前其他事务等待
+func (s *MemoryStore) begin() {
+	s.txMu.Lock()
+
+	s.mu.RLock()
+	s.staged = s.state.clone()
+	s.mu.RUnlock()
+}
+
+// end 结束事务，commit 为 true 时以工作副本替换已提交的状态
+func (s *MemoryStore) end(commit bool) {
+	if commit {
+		s.mu.Lock()
+		s.state = s.staged
+		s.mu.Unlock()
+	}
+
+	s.staged = nil
+	s.txMu.Unlock()
+}
+
+// savepoint 保存进行中的事务的工作副本
+func (s *MemoryStore) savepoint() *memoryState {
+	return s.staged.clone()
+}
+
+// rollbackTo 将进行中的事务恢复到保存点
+func (s *MemoryStore) rollbackTo(savepoint *memoryState) {
+	s.staged = savepoint
+}
+
+// load 按标识加载行的副本，staged 为 true 时从进行中的事务的工作副本加载，行不存在时返回 gorm.ErrRecordNotFound
+func (s *MemoryStore) load(entityType reflect.Type, identity Identity, unscoped, staged bool) (Entity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := s.state
+	if staged {
+		state = s.staged
+	}
+
+	if table, exists := state.tables[entityType]; exists {
+		if row, found := table.rows[identity.String()]; found && (unscoped || !table.deleted(row)) {
+			return table.copyRow(row), nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// appendOutbox 将发件箱消息写入进行中的事务
+func (s *MemoryStore) appendOutbox(messages []*OutboxMessage) {
+	s.staged.outbox = append(s.staged.outbox, messages...)
+}
+
+// apply 在进行中的事务中执行操作
+func (s *MemoryStore) apply(ctx context.Context, operation Operation) error {
+	// 内存表没有外键约束，不需要延迟外键
+	if deferred, ok := operation.(*deferredInsertOperation); ok {
+		operation = deferred.Operation
+	}
+
+	entities := operationEntities(operation)
+	if len(entities) == 0 {
+		return nil
+	}
+
+	table, err := s.staged.table(operation.GetEntityType())
+	if err != nil {
+		return err
+	}
+
+	switch operation.GetOperationType() {
+	case OperationTypeInsert, OperationTypeBulkInsert:
+		return s.insert(ctx, table, entities)
+	case OperationTypeUpdate, OperationTypeBulkUpdate:
+		if update, ok := operation.(*UpdateOperation); ok && update.deferral != nil {
+			return s.fillDeferredForeignKeys(table, update.entity)
+		}
+		return s.update(ctx, table, operation.GetEntityType(), entities)
+	case OperationTypeDelete, OperationTypeBulkDelete:
+		return s.delete(table, entities)
+	case OperationTypeUpsert, OperationTypeBulkUpsert:
+		return s.upsert(ctx, table, entities, conflictColumnsOf(operation))
+	case OperationTypeRestore, OperationTypeBulkRestore:
+		return s.restore(table, operation.GetEntityType(), entities)
+	case OperationTypePurge, OperationTypeBulkPurge:
+		return s.purge(table, entities)
+	default:
+		return fmt.Errorf("unsupported operation type %s", operation.GetOperationType())
+	}
+}
+
+func (s *MemoryStore) insert(ctx context.Context, table *memoryTable, entities []Entity) error {
+	now := time.Now()
+	for _, entity := range entities {
+		if err := validateWritable(ctx, entity); err != nil {
+			return err
+		}
+
+		if timestamped, ok := entity.(HasTimestamps); ok && entity.IsNew() {
+			timestamped.SetCreatedAt(now)
+			timestamped.SetUpdatedAt(now)
+		}
+
+		if err := table.insert(entity); err != nil {
+			return fmt.Errorf("failed to insert entity %T: %w", entity, err)
+		}
+		s.record(ChangeActionInsert, table, entity)
+	}
+	return nil
+}
+
+func (s *MemoryStore) update(ctx context.Context, table *memoryTable, entityType reflect.Type, entities []Entity) error {
+	now := time.Now()
+	var conflicts []Entity
+
+	for _, entity := range entities {
+		if err := validateWritable(ctx, entity); err != nil {
+			return err
+		}
+
+		identity := IdentityOf(entity)
+		if identity.IsZero() {
+			return fmt.Errorf("failed to update entity %T: %w", entity, gorm.ErrPrimaryKeyRequired)
+		}
+
+		if timestamped, ok := entity.(HasTimestamps); ok {
+			timestamped.SetUpdatedAt(now)
+		}
+
+		row, exists := table.rows[identity.String()]
+		exists = exists && !table.deleted(row)
+
+		if revisioned, ok := entity.(HasRevision); ok {
+			if !exists || row.(HasRevision).GetRevision() != revisioned.GetRevision() {
+				conflicts = append(conflicts, entity)
+				continue
+			}
+			revisioned.SetRevision(revisioned.GetRevisionNext())
+		} else if !exists {
+			return fmt.Errorf("failed to update entity %T: %w", entity, gorm.ErrRecordNotFound)
+		}
+
+		table.put(identity.String(), table.copyRow(entity))
+		s.record(ChangeActionUpdate, table, entity)
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{EntityType: entityType, Entities: conflicts}
+	}
+	return nil
+}
+
+// fillDeferredForeignKeys 回填延迟的外键，与数据库中一样不检查版本号
+func (s *MemoryStore) fillDeferredForeignKeys(table *memoryTable, entity Entity) error {
+	key := IdentityOf(entity).String()
+	if _, exists := table.rows[key]; !exists {
+		return fmt.Errorf("failed to fill deferred foreign keys of entity %T: %w", entity, gorm.ErrRecordNotFound)
+	}
+
+	table.put(key, table.copyRow(entity))
+	return nil
+}
+
+func (s *MemoryStore) delete(table *memoryTable, entities []Entity) error {
+	now := time.Now()
+	for _, entity := range entities {
+		key := IdentityOf(entity).String()
+		row, exists := table.rows[key]
+
+		softDeletable, soft := entity.(SoftDelete)
+		if !soft {
+			if exists {
+				table.remove(key)
+				s.record(ChangeActionDelete, table, row)
+			}
+			continue
+		}
+
+		softDeletable.SetDeletedAt(gorm.DeletedAt{Time: now, Valid: true})
+		if timestamped, ok := entity.(HasTimestamps); ok {
+			timestamped.SetUpdatedAt(now)
+		}
+
+		if exists {
+			table.put(key, table.copyRow(entity))
+			s.record(ChangeActionDelete, table, entity)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) upsert(ctx context.Context, table *memoryTable, entities []Entity, conflictColumns []string) error {
+	conflictFields := table.schema.PrimaryFields
+	if len(conflictColumns) > 0 {
+		conflictFields = make([]*schema.Field, 0, len(conflictColumns))
+		for _, column := range conflictColumns {
+			field := table.schema.LookUpField(column)
+			if field == nil {
+				return fmt.Errorf("unknown conflict column %s of %s", column, table.schema.Name)
+			}
+			conflictFields = append(conflictFields, field)
+		}
+	}
+
+	for _, entity := range entities {
+		if err := prepareUpsert(ctx, entity); err != nil {
+			return err
+		}
+
+		existing := table.match(entity, conflictFields)
+		if existing == nil {
+			if err := table.insert(entity); err != nil {
+				return fmt.Errorf("failed to upsert entity %T: %w", entity, err)
+			}
+			s.record(ChangeActionUpsert, table, entity)
+			continue
+		}
+
+		// 与数据库中一样保留主键、创建时间和不可更新的字段，版本号在原值基础上递增
+		ctx := context.Background()
+		value, current := reflect.ValueOf(entity), reflect.ValueOf(existing)
+		for _, field := range table.schema.Fields {
+			if storedOnConflict(field, false) {
+				field.ReflectValueOf(ctx, value).Set(field.ReflectValueOf(ctx, current))
+			}
+		}
+		if revisioned, ok := entity.(HasRevision); ok {
+			revisioned.SetRevision(existing.(HasRevision).GetRevisionNext())
+		}
+
+		table.put(IdentityOf(entity).String(), table.copyRow(entity))
+		s.record(ChangeActionUpsert, table, entity)
+	}
+	return nil
+}
+
+func (s *MemoryStore) restore(table *memoryTable, entityType reflect.Type, entities []Entity) error {
+	now := time.Now()
+	for _, entity := range entities {
+		softDeletable, ok := entity.(SoftDelete)
+		if !ok {
+			return fmt.Errorf("entity %T does not support soft delete", entity)
+		}
+
+		identity := IdentityOf(entity)
+		row, exists := table.rows[identity.String()]
+		if !exists {
+			return fmt.Errorf("failed to restore entities %s with ids %v: %w", entityType, identity, gorm.ErrRecordNotFound)
+		}
+
+		softDeletable.SetDeletedAt(gorm.DeletedAt{})
+
+		// 与数据库中一样只更新删除时间和更新时间
+		restored := table.copyRow(row)
+		restored.(SoftDelete).SetDeletedAt(gorm.DeletedAt{})
+		if timestamped, ok := entity.(HasTimestamps); ok {
+			timestamped.SetUpdatedAt(now)
+			restored.(HasTimestamps).SetUpdatedAt(now)
+		}
+
+		table.put(identity.String(), restored)
+		s.record(ChangeActionRestore, table, restored)
+	}
+	return nil
+}
+
+func (s *MemoryStore) purge(table *memoryTable, entities []Entity) error {
+	for _, entity := range entities {
+		key := IdentityOf(entity).String()
+		if row, exists := table.rows[key]; exists {
+			table.remove(key)
+			s.record(ChangeActionPurge, table, row)
+		}
+	}
+	return nil
+}
+
+// record 在进行中的事务中记录写入
+func (s *MemoryStore) record(action ChangeAction, table *memoryTable, entity Entity) {
+	s.staged.journal = append(s.staged.journal, MemoryRecord{Action: action, Entity: table.copyRow(entity)})
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{tables: make(map[reflect.Type]*memoryTable)}
+}
+
+// clone 复制状态，行是不可变的副本，只需复制索引
+func (st *memoryState) clone() *memoryState {
+	clone := &memoryState{
+		tables:  make(map[reflect.Type]*memoryTable, len(st.tables)),
+		journal: append([]MemoryRecord(nil), st.journal...),
+		outbox:  append([]*OutboxMessage(nil), st.outbox...),
+	}
+
+	for entityType, table := range st.tables {
+		rows := make(map[string]Entity, len(table.rows))
+		for key, row := range table.rows {
+			rows[key] = row
+		}
+
+		clone.tables[entityType] = &memoryTable{
+			schema:   table.schema,
+			rows:     rows,
+			keys:     append([]string(nil), table.keys...),
+			sequence: table.sequence,
+		}
+	}
+
+	return clone
+}
+
+// table 获取实体类型的内存表，不存在时创建
+func (st *memoryState) table(entityType reflect.Type) (*memoryTable, error) {
+	if table, exists := st.tables[entityType]; exists {
+		return table, nil
+	}
+
+	if entityType == nil || entityType.Kind() != reflect.Ptr || entityType.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("entity type %v must be a pointer to struct", entityType)
+	}
+
+	parsed, err := schema.Parse(reflect.New(entityType.Elem()).Interface(), &schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema of %s: %w", entityType, err)
+	}
+
+	table := &memoryTable{schema: parsed, rows: make(map[string]Entity)}
+	st.tables[entityType] = table
+	return table, nil
+}
+
+// lookup 查找实体对应的行，包括软删除的行
+func (st *memoryState) lookup(entity Entity) (*memoryTable, Entity) {
+	table, exists := st.tables[reflect.TypeOf(entity)]
+	if !exists {
+		return nil, nil
+	}
+	return table, table.rows[IdentityOf(entity).String()]
+}
+
+// insert 写入新行，为零值的自增主键分配值，为零值的带默认值的字段和自动时间戳赋值，并回写到实体
+func (t *memoryTable) insert(entity Entity) error {
+	ctx := context.Background()
+	value := reflect.ValueOf(entity)
+	now := time.Now()
+
+	for _, field := range t.schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+
+		fieldValue := field.ReflectValueOf(ctx, value)
+		switch {
+		case field.AutoIncrement && field.PrimaryKey:
+			if fieldValue.IsZero() {
+				t.sequence++
+				setInteger(fieldValue, t.sequence)
+			} else if n := integerOf(fieldValue); n > t.sequence {
+				t.sequence = n
+			}
+		case (field.AutoCreateTime > 0 || field.AutoUpdateTime > 0) && field.FieldType == timeType:
+			if fieldValue.IsZero() {
+				fieldValue.Set(reflect.ValueOf(now))
+			}
+		case field.DefaultValueInterface != nil:
+			if fieldValue.IsZero() {
+				fieldValue.Set(reflect.ValueOf(field.DefaultValueInterface).Convert(fieldValue.Type()))
+			}
+		}
+	}
+
+	identity := IdentityOf(entity)
+	if identity.IsZero() {
+		return gorm.ErrPrimaryKeyRequired
+	}
+
+	key := identity.String()
+	if _, exists := t.rows[key]; exists {
+		return gorm.ErrDuplicatedKey
+	}
+
+	t.put(key, t.copyRow(entity))
+	return nil
+}
+
+// match 按冲突字段查找已存在的行，包括软删除的行
+func (t *memoryTable) match(entity Entity, fields []*schema.Field) Entity {
+	ctx := context.Background()
+	value := reflect.ValueOf(entity)
+
+	for _, field := range fields {
+		if field.ReflectValueOf(ctx, value).IsZero() {
+			return nil
+		}
+	}
+
+	for _, key := range t.keys {
+		row := t.rows[key]
+		current := reflect.ValueOf(row)
+
+		matched := true
+		for _, field := range fields {
+			if !deepEqual(field.ReflectValueOf(ctx, value).Interface(), field.ReflectValueOf(ctx, current).Interface()) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return row
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTable) put(key string, row Entity) {
+	if _, exists := t.rows[key]; !exists {
+		t.keys = append(t.keys, key)
+	}
+	t.rows[key] = row
+}
+
+func (t *memoryTable) remove(key string) {
+	delete(t.rows, key)
+	for i, k := range t.keys {
+		if k == key {
+			t.keys = append(t.keys[:i], t.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// deleted 判断行是否已被软删除
+func (t *memoryTable) deleted(row Entity) bool {
+	field := deletedAtField(t.schema)
+	if field == nil {
+		return false
+	}
+
+	deletedAt, _ := field.ReflectValueOf(context.Background(), reflect.ValueOf(row)).Interface().(gorm.DeletedAt)
+	return deletedAt.Valid
+}
+
+// copyRow 只复制映射到列的字段，关联和非持久化字段保持零值
+func (t *memoryTable) copyRow(entity Entity) Entity {
+	ctx := context.Background()
+	source := reflect.ValueOf(entity)
+	target := reflect.New(source.Type().Elem())
+
+	for _, field := range t.schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		field.ReflectValueOf(ctx, target).Set(field.ReflectValueOf(ctx, source))
+	}
+
+	return target.Interface().(Entity)
+}
+
+// matchEntity 判断写入的行是否与期望的实体一致
+func matchEntity(expected, actual Entity) bool {
+	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+		return false
+	}
+
+	if identity := IdentityOf(expected); !identity.IsZero() {
+		return identity.Equal(IdentityOf(actual))
+	}
+
+	parsed, err := schema.Parse(expected, &schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return false
+	}
+
+	ctx := context.Background()
+	expectedValue, actualValue := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	for _, field := range parsed.Fields {
+		if field.DBName == "" {
+			continue
+		}
+
+		value := field.ReflectValueOf(ctx, expectedValue)
+		if !value.IsZero() && !deepEqual(value.Interface(), field.ReflectValueOf(ctx, actualValue).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+func describeEntity(entity Entity) string {
+	if identity := IdentityOf(entity); !identity.IsZero() {
+		return fmt.Sprintf("%T(%s)", entity, identity)
+	}
+	return fmt.Sprintf("%T(new)", entity)
+}
+
+func describeEntities(entities []Entity) string {
+	descriptions := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		descriptions = append(descriptions, describeEntity(entity))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func setInteger(value reflect.Value, n int64) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(uint64(n))
+	}
+}
+
+func integerOf(value reflect.Value) int64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	}
+	return 0
+}
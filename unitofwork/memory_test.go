@@ -0,0 +1,232 @@
+package unitofwork
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// recordingT 记录断言失败信息
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// TestMemoryStore 测试内存存储后端
+func TestMemoryStore(t *testing.T) {
+	t.Run("按依赖顺序插入并分配主键和默认值", func(t *testing.T) {
+		store := NewMemoryStore()
+		uow := NewMemoryUnitOfWork(store)
+		uow.GetDependencyManager().RegisterDependency(reflect.TypeOf(&Post{}), reflect.TypeOf(&User{}))
+
+		user := &User{Name: "张三", Email: "zhangsan@example.com"}
+		post := &Post{Title: "第一篇", UserID: 1}
+		require.NoError(t, uow.Create(post))
+		require.NoError(t, uow.Create(user))
+		require.NoError(t, uow.Commit())
+
+		assert.Equal(t, uint(1), user.ID)
+		assert.Equal(t, uint(1), post.ID)
+		assert.Equal(t, int64(1), user.Revision)
+		assert.False(t, user.CreatedAt.IsZero())
+
+		assert.True(t, store.AssertInserted(t, user, post))
+
+		// 未分配标识的期望实体按非零字段比较
+		assert.True(t, store.AssertInserted(t, &User{Name: "张三"}, &Post{Title: "第一篇"}))
+
+		recorder := &recordingT{}
+		assert.False(t, store.AssertInserted(recorder, post, user))
+		require.Len(t, recorder.errors, 1)
+		assert.Contains(t, recorder.errors[0], "*unitofwork.Post(1), *unitofwork.User(1)")
+	})
+
+	t.Run("加载的实体是副本且不包含关联", func(t *testing.T) {
+		store := NewMemoryStore()
+		user := &User{Name: "张三", Email: "zhangsan@example.com", Posts: []Post{{Title: "关联"}}}
+		require.NoError(t, store.Seed(user))
+
+		uow := NewMemoryUnitOfWork(store)
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, user.ID))
+		assert.NotSame(t, user, loaded)
+		assert.Equal(t, "张三", loaded.Name)
+		assert.Empty(t, loaded.Posts)
+
+		var again *User
+		require.NoError(t, uow.Find(&again, user.ID))
+		assert.Same(t, loaded, again)
+
+		loaded.Age = 30
+		require.NoError(t, uow.Update(loaded))
+		require.NoError(t, uow.Commit())
+
+		var stored *User
+		require.NoError(t, store.Find(&stored, user.ID))
+		assert.Equal(t, 30, stored.Age)
+		assert.Equal(t, int64(2), stored.Revision)
+		assert.True(t, store.AssertUpdated(t, loaded))
+
+		var missing *User
+		err := store.Find(&missing, 99)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("版本号不匹配时返回冲突并丢弃整个提交", func(t *testing.T) {
+		store := NewMemoryStore()
+		first := &User{Name: "张三", Email: "zhangsan@example.com"}
+		second := &User{Name: "李四", Email: "lisi@example.com"}
+		require.NoError(t, store.Seed(first, second))
+
+		uow := NewMemoryUnitOfWork(store)
+		var a, b *User
+		require.NoError(t, uow.Find(&a, first.ID))
+		require.NoError(t, uow.Find(&b, second.ID))
+
+		// 其他工作单元先提交了修改
+		other := NewMemoryUnitOfWork(store)
+		var concurrent *User
+		require.NoError(t, other.Find(&concurrent, second.ID))
+		concurrent.Age = 50
+		require.NoError(t, other.Update(concurrent))
+		require.NoError(t, other.Commit())
+
+		a.Age = 30
+		b.Age = 30
+		require.NoError(t, uow.Update(a))
+		require.NoError(t, uow.Update(b))
+		err := uow.Commit()
+
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		assert.Equal(t, []Entity{b}, conflict.Entities)
+
+		var stored *User
+		require.NoError(t, store.Find(&stored, first.ID))
+		assert.Equal(t, 0, stored.Age)
+		assert.Equal(t, int64(1), stored.Revision)
+	})
+
+	t.Run("冲突重试时从内存存储重新加载", func(t *testing.T) {
+		store := NewMemoryStore()
+		user := &User{Name: "张三", Email: "zhangsan@example.com"}
+		require.NoError(t, store.Seed(user))
+
+		uow := NewMemoryUnitOfWork(store)
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, user.ID))
+
+		other := NewMemoryUnitOfWork(store)
+		var concurrent *User
+		require.NoError(t, other.Find(&concurrent, user.ID))
+		concurrent.Name = "张三丰"
+		require.NoError(t, other.Update(concurrent))
+		require.NoError(t, other.Commit())
+
+		loaded.Age = 30
+		require.NoError(t, uow.Update(loaded))
+		require.NoError(t, uow.CommitWithRetry(RetryPolicy{MaxAttempts: 2}, nil))
+
+		var stored *User
+		require.NoError(t, store.Find(&stored, user.ID))
+		assert.Equal(t, "张三丰", stored.Name)
+		assert.Equal(t, 30, stored.Age)
+		assert.Equal(t, int64(3), stored.Revision)
+	})
+
+	t.Run("软删除的实体对查询不可见并可恢复", func(t *testing.T) {
+		store := NewMemoryStore()
+		user := &User{Name: "张三", Email: "zhangsan@example.com"}
+		require.NoError(t, store.Seed(user))
+
+		uow := NewMemoryUnitOfWork(store)
+		var loaded *User
+		require.NoError(t, uow.Find(&loaded, user.ID))
+		require.NoError(t, uow.Delete(loaded))
+		require.NoError(t, uow.Commit())
+
+		assert.True(t, store.AssertDeleted(t, loaded))
+		assert.True(t, store.AssertSoftDeleted(t, loaded))
+
+		var users []*User
+		require.NoError(t, store.FindAll(&users))
+		assert.Empty(t, users)
+		assert.Error(t, NewMemoryUnitOfWork(store).Find(&loaded, user.ID))
+
+		var deleted *User
+		require.NoError(t, store.FindUnscoped(&deleted, user.ID))
+		restoring := NewMemoryUnitOfWork(store)
+		require.NoError(t, restoring.Restore(restoring.Attach(deleted)))
+		require.NoError(t, restoring.Commit())
+
+		require.NoError(t, store.FindAll(&users))
+		assert.Len(t, users, 1)
+
+		purging := NewMemoryUnitOfWork(store)
+		require.NoError(t, purging.Purge(purging.Attach(users[0])))
+		require.NoError(t, purging.Commit())
+		assert.True(t, store.AssertNotExists(t, user))
+	})
+
+	t.Run("插入或更新保留主键并递增版本号", func(t *testing.T) {
+		store := NewMemoryStore()
+		tag := &Tag{Name: "go", Color: "#000000"}
+		require.NoError(t, store.Seed(tag))
+
+		uow := NewMemoryUnitOfWork(store)
+		upserted := &Tag{Name: "go", Color: "#ffffff"}
+		require.NoError(t, uow.Upsert(upserted, "name"))
+		require.NoError(t, uow.Upsert(&Tag{Name: "rust", Color: "#ff0000"}, "name"))
+		require.NoError(t, uow.Commit())
+
+		assert.Equal(t, tag.ID, upserted.ID)
+
+		var tags []*Tag
+		require.NoError(t, store.FindAll(&tags))
+		require.Len(t, tags, 2)
+		assert.Equal(t, "#ffffff", tags[0].Color)
+		assert.Equal(t, int64(2), tags[0].Revision)
+		assert.Equal(t, uint(2), tags[1].ID)
+	})
+
+	t.Run("主键重复时整个提交失败", func(t *testing.T) {
+		store := NewMemoryStore()
+		require.NoError(t, store.Seed(&Tag{Name: "go"}))
+
+		uow := NewMemoryUnitOfWork(store)
+		require.NoError(t, uow.Create(&Tag{Name: "rust"}))
+		duplicated := &Tag{Name: "重复"}
+		duplicated.ID = 1
+		require.NoError(t, uow.Create(duplicated))
+		err := uow.Commit()
+		assert.True(t, errors.Is(err, gorm.ErrDuplicatedKey))
+
+		var tags []*Tag
+		require.NoError(t, store.FindAll(&tags))
+		assert.Len(t, tags, 1)
+		assert.Empty(t, store.Journal())
+	})
+
+	t.Run("领域事件写入内存发件箱", func(t *testing.T) {
+		store := NewMemoryStore()
+		uow := NewMemoryUnitOfWork(store)
+
+		order := &Order{Number: "SO-001"}
+		order.RecordEvent(OrderPlaced{Number: "SO-001"})
+		require.NoError(t, uow.Create(order))
+		require.NoError(t, uow.Commit())
+
+		outbox := store.Outbox()
+		require.Len(t, outbox, 1)
+		assert.Equal(t, "order.placed", outbox[0].EventType)
+		assert.Empty(t, order.PendingEvents())
+	})
+}
@@ -0,0 +1,171 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// TestUnitOfWork_Nested 测试嵌套工作单元
+func TestUnitOfWork_Nested(t *testing.T) {
+	t.Run("子工作单元提交后随父工作单元持久化", func(t *testing.T) {
+		db := setupTestDB()
+		ctx := context.Background()
+
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			if err := uow.Create(&User{Name: "父", Email: "parent@example.com", Age: 40}); err != nil {
+				return err
+			}
+
+			return WithUnitOfWork(ctx, tx, func(tx *gorm.DB, child *UnitOfWork) error {
+				assert.Equal(t, uow, child.Parent())
+				assert.Equal(t, 1, child.Depth())
+				return child.Create(&User{Name: "子", Email: "child@example.com", Age: 10})
+			})
+		})
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("子工作单元回滚不影响父工作单元", func(t *testing.T) {
+		db := setupTestDB()
+		ctx := context.Background()
+
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			if err := uow.Create(&User{Name: "父", Email: "parent@example.com", Age: 40}); err != nil {
+				return err
+			}
+
+			childErr := WithUnitOfWork(ctx, tx, func(tx *gorm.DB, child *UnitOfWork) error {
+				if err := child.Create(&User{Name: "子", Email: "child@example.com", Age: 10}); err != nil {
+					return err
+				}
+				return errors.New("child failed")
+			})
+			assert.Error(t, childErr)
+
+			assert.Equal(t, 1, uow.GetStats().NewEntities)
+			return nil
+		})
+		require.NoError(t, err)
+
+		var users []User
+		require.NoError(t, db.Find(&users).Error)
+		require.Len(t, users, 1)
+		assert.Equal(t, "parent@example.com", users[0].Email)
+	})
+
+	t.Run("子工作单元刷新失败回滚到保存点", func(t *testing.T) {
+		db := setupTestDB()
+		ctx := context.Background()
+
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			first, err := uow.Begin()
+			if err != nil {
+				return err
+			}
+			if err := first.Create(&User{BaseEntity: BaseEntity{ID: 1}, Name: "甲", Email: "dup@example.com", Age: 20}); err != nil {
+				return err
+			}
+			if err := first.Commit(); err != nil {
+				return err
+			}
+
+			second, err := uow.Begin()
+			if err != nil {
+				return err
+			}
+			if err := second.Create(&User{BaseEntity: BaseEntity{ID: 2}, Name: "乙", Email: "other@example.com", Age: 21}); err != nil {
+				return err
+			}
+			if err := second.Create(&User{BaseEntity: BaseEntity{ID: 3}, Name: "丙", Email: "dup@example.com", Age: 22}); err != nil {
+				return err
+			}
+			assert.Error(t, second.Commit())
+			require.NoError(t, second.Rollback())
+
+			return uow.Create(&User{BaseEntity: BaseEntity{ID: 4}, Name: "丁", Email: "last@example.com", Age: 23})
+		})
+		require.NoError(t, err)
+
+		var emails []string
+		require.NoError(t, db.Model(&User{}).Order("email").Pluck("email", &emails).Error)
+		assert.Equal(t, []string{"dup@example.com", "last@example.com"}, emails)
+	})
+
+	t.Run("存在未完成的子工作单元时父工作单元不能提交", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		child, err := uow.Begin()
+		require.NoError(t, err)
+
+		assert.Error(t, uow.Commit())
+
+		require.NoError(t, child.Rollback())
+		assert.NoError(t, uow.Commit())
+	})
+
+	t.Run("父工作单元回滚撤销已提交的子工作单元", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		child, err := uow.Begin()
+		require.NoError(t, err)
+		require.NoError(t, child.Create(&User{Name: "子", Email: "child@example.com", Age: 10}))
+		require.NoError(t, child.Commit())
+		require.NoError(t, uow.Rollback())
+
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("内存存储的父工作单元回滚撤销已提交的子工作单元", func(t *testing.T) {
+		store := NewMemoryStore()
+		uow := NewMemoryUnitOfWork(store)
+
+		child, err := uow.Begin()
+		require.NoError(t, err)
+		require.NoError(t, child.Create(&User{Name: "子", Email: "child@example.com", Age: 10}))
+		require.NoError(t, child.Commit())
+		require.NoError(t, uow.Rollback())
+
+		var users []*User
+		require.NoError(t, store.FindAll(&users))
+		assert.Empty(t, users)
+	})
+
+	t.Run("子工作单元的插入不能依赖父工作单元中待插入的实体", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		user := &User{Name: "父", Email: "parent@example.com", Age: 40}
+		require.NoError(t, uow.Create(user))
+
+		child, err := uow.Begin()
+		require.NoError(t, err)
+		require.NoError(t, child.Create(&Post{Title: "第一篇", UserID: user.ID}))
+		assert.ErrorContains(t, child.Commit(), "pending creation in parent unit of work")
+		require.NoError(t, child.Rollback())
+
+		// 父工作单元刷新后子工作单元可以引用已插入的实体
+		require.NoError(t, uow.Flush())
+		child, err = uow.Begin()
+		require.NoError(t, err)
+		require.NoError(t, child.Create(&Post{Title: "第一篇", UserID: user.ID}))
+		require.NoError(t, child.Commit())
+		require.NoError(t, uow.Commit())
+
+		var count int64
+		require.NoError(t, db.Model(&Post{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+}
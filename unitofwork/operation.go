@@ -1,11 +1,16 @@
 package unitofwork
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+	"github.com/wubin1989/gorm/schema"
+	"github.com/wubin1989/gorm/utils"
 )
 
 // Operation 数据库操作接口
@@ -42,6 +47,12 @@ const (
 	OperationTypeBulkInsert
 	OperationTypeBulkUpdate
 	OperationTypeBulkDelete
+	OperationTypeUpsert
+	OperationTypeBulkUpsert
+	OperationTypeRestore
+	OperationTypeBulkRestore
+	OperationTypePurge
+	OperationTypeBulkPurge
 )
 
 // String 返回操作类型的字符串表示
@@ -59,6 +70,18 @@ func (t OperationType) String() string {
 		return "BULK_UPDATE"
 	case OperationTypeBulkDelete:
 		return "BULK_DELETE"
+	case OperationTypeUpsert:
+		return "UPSERT"
+	case OperationTypeBulkUpsert:
+		return "BULK_UPSERT"
+	case OperationTypeRestore:
+		return "RESTORE"
+	case OperationTypeBulkRestore:
+		return "BULK_RESTORE"
+	case OperationTypePurge:
+		return "PURGE"
+	case OperationTypeBulkPurge:
+		return "BULK_PURGE"
 	default:
 		return "UNKNOWN"
 	}
@@ -91,10 +114,8 @@ func (op *InsertOperation) GetOperationType() OperationType {
 // Execute 实现Operation接口
 func (op *InsertOperation) Execute(db *gorm.DB) error {
 	// 验证实体
-	if validatable, ok := op.entity.(Validatable); ok {
-		if err := validatable.Validate(); err != nil {
-			return fmt.Errorf("validation failed for entity %T: %w", op.entity, err)
-		}
+	if err := validateWritable(db.Statement.Context, op.entity); err != nil {
+		return err
 	}
 
 	// 设置时间戳
@@ -129,8 +150,7 @@ func (op *InsertOperation) SameIdentity(other Operation) bool {
 		return false
 	}
 
-	return op.entity.GetID() == otherEntity.GetID() &&
-		reflect.TypeOf(op.entity) == reflect.TypeOf(otherEntity)
+	return sameEntity(op.entity, otherEntity)
 }
 
 // CanMerge 实现Operation接口
@@ -153,6 +173,10 @@ func (op *InsertOperation) Merge(other Operation) Operation {
 type UpdateOperation struct {
 	entity  Entity
 	changes map[string]FieldChange
+	uow     *UnitOfWork
+
+	// 为打破依赖环而延迟的外键，非空时只回填外键列
+	deferral *foreignKeyDeferral
 }
 
 // NewUpdateOperation 创建更新操作
@@ -175,11 +199,13 @@ func (op *UpdateOperation) GetOperationType() OperationType {
 
 // Execute 实现Operation接口
 func (op *UpdateOperation) Execute(db *gorm.DB) error {
+	if op.deferral != nil {
+		return op.fillDeferredForeignKeys(db)
+	}
+
 	// 验证实体
-	if validatable, ok := op.entity.(Validatable); ok {
-		if err := validatable.Validate(); err != nil {
-			return fmt.Errorf("validation failed for entity %T: %w", op.entity, err)
-		}
+	if err := validateWritable(db.Statement.Context, op.entity); err != nil {
+		return err
 	}
 
 	// 设置更新时间戳
@@ -189,17 +215,22 @@ func (op *UpdateOperation) Execute(db *gorm.DB) error {
 
 	// 乐观锁处理
 	if revisioned, ok := op.entity.(HasRevision); ok {
+		// 主键条件由 gorm 根据实体的主键字段生成，标识未分配时会更新所有版本号匹配的行
+		if IdentityOf(op.entity).IsZero() {
+			return fmt.Errorf("failed to update entity %T: %w", op.entity, gorm.ErrPrimaryKeyRequired)
+		}
+
 		originalRevision := revisioned.GetRevision()
 		revisioned.SetRevision(revisioned.GetRevisionNext())
 
-		result := db.Where("id = ? AND revision = ?", op.entity.GetID(), originalRevision).Updates(op.entity)
+		result := db.Where("revision = ?", originalRevision).Updates(op.entity)
 
 		if result.Error != nil {
 			return fmt.Errorf("failed to update entity %T: %w", op.entity, result.Error)
 		}
 
 		if result.RowsAffected == 0 {
-			return fmt.Errorf("optimistic lock failed for entity %T with id %v", op.entity, op.entity.GetID())
+			return &ConflictError{EntityType: op.GetEntityType(), Entities: []Entity{op.entity}}
 		}
 	} else {
 		result := db.Save(op.entity)
@@ -211,6 +242,22 @@ func (op *UpdateOperation) Execute(db *gorm.DB) error {
 	return nil
 }
 
+// fillDeferredForeignKeys 回填延迟的外键，实体刚在同一事务中插入，无需乐观锁检查
+func (op *UpdateOperation) fillDeferredForeignKeys(db *gorm.DB) error {
+	op.deferral.resolve(db.Statement.Context)
+
+	result := db.Model(op.entity).Select(op.deferral.columns()).Updates(op.entity)
+	if result.Error != nil {
+		return fmt.Errorf("failed to fill deferred foreign keys of entity %T: %w", op.entity, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("failed to fill deferred foreign keys of entity %T with id %v: entity not found", op.entity, IdentityOf(op.entity))
+	}
+
+	return nil
+}
+
 // GetEntity 实现Operation接口
 func (op *UpdateOperation) GetEntity() Entity {
 	return op.entity
@@ -227,12 +274,16 @@ func (op *UpdateOperation) SameIdentity(other Operation) bool {
 		return false
 	}
 
-	return op.entity.GetID() == otherEntity.GetID() &&
-		reflect.TypeOf(op.entity) == reflect.TypeOf(otherEntity)
+	return sameEntity(op.entity, otherEntity)
 }
 
 // CanMerge 实现Operation接口
 func (op *UpdateOperation) CanMerge(other Operation) bool {
+	// 回填外键的更新只更新部分列，不能与普通更新合并
+	if otherUpdate, ok := other.(*UpdateOperation); op.deferral != nil || (ok && otherUpdate.deferral != nil) {
+		return false
+	}
+
 	return other.GetOperationType() == OperationTypeUpdate &&
 		op.GetEntityType() == other.GetEntityType()
 }
@@ -243,8 +294,8 @@ func (op *UpdateOperation) Merge(other Operation) Operation {
 		return op
 	}
 
-	entities := []Entity{op.entity, other.GetEntity()}
-	return NewBulkUpdateOperation(entities)
+	bulk := newBulkUpdateOperation([]Entity{op.entity}, []map[string]FieldChange{op.changes}, op.uow)
+	return bulk.Merge(other)
 }
 
 // GetChanges 获取变更信息
@@ -255,6 +306,9 @@ func (op *UpdateOperation) GetChanges() map[string]FieldChange {
 // DeleteOperation 删除操作
 type DeleteOperation struct {
 	entity Entity
+
+	// 实体在删除的聚合中的层级，聚合根为0
+	depth int
 }
 
 // NewDeleteOperation 创建删除操作
@@ -320,8 +374,7 @@ func (op *DeleteOperation) SameIdentity(other Operation) bool {
 		return false
 	}
 
-	return op.entity.GetID() == otherEntity.GetID() &&
-		reflect.TypeOf(op.entity) == reflect.TypeOf(otherEntity)
+	return sameEntity(op.entity, otherEntity)
 }
 
 // CanMerge 实现Operation接口
@@ -398,10 +451,8 @@ func (op *BulkInsertOperation) Execute(db *gorm.DB) error {
 
 	// 验证所有实体
 	for _, entity := range op.entities {
-		if validatable, ok := entity.(Validatable); ok {
-			if err := validatable.Validate(); err != nil {
-				return fmt.Errorf("validation failed for entity %T: %w", entity, err)
-			}
+		if err := validateWritable(db.Statement.Context, entity); err != nil {
+			return err
 		}
 
 		// 设置时间戳
@@ -469,18 +520,32 @@ func (op *BulkInsertOperation) GetEntities() []Entity {
 type BulkUpdateOperation struct {
 	entities   []Entity
 	entityType reflect.Type
+
+	// 与 entities 一一对应的注册时字段变更，为空时更新所有可更新的列
+	changes []map[string]FieldChange
+	uow     *UnitOfWork
 }
 
 // NewBulkUpdateOperation 创建批量更新操作
 func NewBulkUpdateOperation(entities []Entity) *BulkUpdateOperation {
+	return newBulkUpdateOperation(entities, nil, nil)
+}
+
+func newBulkUpdateOperation(entities []Entity, changes []map[string]FieldChange, uow *UnitOfWork) *BulkUpdateOperation {
 	var entityType reflect.Type
 	if len(entities) > 0 {
 		entityType = reflect.TypeOf(entities[0])
 	}
 
+	if len(changes) != len(entities) {
+		changes = make([]map[string]FieldChange, len(entities))
+	}
+
 	return &BulkUpdateOperation{
 		entities:   entities,
 		entityType: entityType,
+		changes:    changes,
+		uow:        uow,
 	}
 }
 
@@ -494,23 +559,6 @@ func (op *BulkUpdateOperation) GetOperationType() OperationType {
 	return OperationTypeBulkUpdate
 }
 
-// Execute 实现Operation接口
-func (op *BulkUpdateOperation) Execute(db *gorm.DB) error {
-	if len(op.entities) == 0 {
-		return nil
-	}
-
-	// 逐个更新（保持乐观锁特性）
-	for _, entity := range op.entities {
-		updateOp := NewUpdateOperation(entity, nil)
-		if err := updateOp.Execute(db); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // GetEntity 实现Operation接口
 func (op *BulkUpdateOperation) GetEntity() Entity {
 	if len(op.entities) > 0 {
@@ -539,14 +587,25 @@ func (op *BulkUpdateOperation) Merge(other Operation) Operation {
 
 	var allEntities []Entity
 	allEntities = append(allEntities, op.entities...)
+	var allChanges []map[string]FieldChange
+	allChanges = append(allChanges, op.changes...)
 
-	if other.GetOperationType() == OperationTypeUpdate {
-		allEntities = append(allEntities, other.GetEntity())
+	if update, ok := other.(*UpdateOperation); ok {
+		allEntities = append(allEntities, update.entity)
+		allChanges = append(allChanges, update.changes)
 	} else if bulkOther, ok := other.(*BulkUpdateOperation); ok {
 		allEntities = append(allEntities, bulkOther.entities...)
+		allChanges = append(allChanges, bulkOther.changes...)
 	}
 
-	return NewBulkUpdateOperation(allEntities)
+	uow := op.uow
+	if uow == nil {
+		if update, ok := other.(*UpdateOperation); ok {
+			uow = update.uow
+		}
+	}
+
+	return newBulkUpdateOperation(allEntities, allChanges, uow)
 }
 
 // GetEntities 获取所有实体
@@ -604,8 +663,19 @@ func (op *BulkDeleteOperation) Execute(db *gorm.DB) error {
 			}
 		}
 	} else {
-		// 硬删除：可以批量处理
-		result := db.Delete(op.entities)
+		// 硬删除：按主键批量处理，复合主键使用 (a, b) IN ((...), (...)) 条件
+		identities := make([]Identity, 0, len(op.entities))
+		for _, entity := range op.entities {
+			identities = append(identities, IdentityOf(entity))
+		}
+
+		model := reflect.New(op.entityType.Elem()).Interface()
+		query, err := whereIdentities(db.Unscoped(), model, identities)
+		if err != nil {
+			return fmt.Errorf("failed to bulk delete entities %T: %w", op.entityType, err)
+		}
+
+		result := query.Delete(model)
 		if result.Error != nil {
 			return fmt.Errorf("failed to bulk delete entities %T: %w", op.entityType, result.Error)
 		}
@@ -656,3 +726,423 @@ func (op *BulkDeleteOperation) Merge(other Operation) Operation {
 func (op *BulkDeleteOperation) GetEntities() []Entity {
 	return op.entities
 }
+
+// UpsertOperation 插入或更新操作，冲突时更新除主键、创建时间和冲突列以外的所有列
+type UpsertOperation struct {
+	entity          Entity
+	conflictColumns []string
+	uow             *UnitOfWork
+}
+
+// NewUpsertOperation 创建插入或更新操作，conflictColumns 为空时以主键判断冲突
+func NewUpsertOperation(entity Entity, conflictColumns []string, uow *UnitOfWork) *UpsertOperation {
+	return &UpsertOperation{
+		entity:          entity,
+		conflictColumns: conflictColumns,
+		uow:             uow,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *UpsertOperation) GetEntityType() reflect.Type {
+	return reflect.TypeOf(op.entity)
+}
+
+// GetOperationType 实现Operation接口
+func (op *UpsertOperation) GetOperationType() OperationType {
+	return OperationTypeUpsert
+}
+
+// Execute 实现Operation接口
+func (op *UpsertOperation) Execute(db *gorm.DB) error {
+	if err := prepareUpsert(db.Statement.Context, op.entity); err != nil {
+		return err
+	}
+
+	clauses, returning, err := upsertClauses(db, op.entity, op.conflictColumns)
+	if err != nil {
+		return err
+	}
+
+	result := db.Clauses(clauses...).Create(op.entity)
+	if result.Error != nil {
+		return fmt.Errorf("failed to upsert entity %T: %w", op.entity, result.Error)
+	}
+
+	if returning {
+		return nil
+	}
+	return readBackUpserted(db, []Entity{op.entity}, op.conflictColumns)
+}
+
+// GetEntity 实现Operation接口
+func (op *UpsertOperation) GetEntity() Entity {
+	return op.entity
+}
+
+// GetConflictColumns 获取冲突列
+func (op *UpsertOperation) GetConflictColumns() []string {
+	return op.conflictColumns
+}
+
+// SameIdentity 实现Operation接口
+func (op *UpsertOperation) SameIdentity(other Operation) bool {
+	if other.GetOperationType() != OperationTypeUpsert {
+		return false
+	}
+
+	otherEntity := other.GetEntity()
+	if otherEntity == nil {
+		return false
+	}
+
+	return sameEntity(op.entity, otherEntity)
+}
+
+// CanMerge 实现Operation接口
+// 同类型、同冲突列的插入或更新操作合并为批量操作；同一实体的插入、更新操作被插入或更新覆盖
+func (op *UpsertOperation) CanMerge(other Operation) bool {
+	if op.GetEntityType() != other.GetEntityType() {
+		return false
+	}
+
+	switch other.GetOperationType() {
+	case OperationTypeUpsert, OperationTypeBulkUpsert:
+		return sameConflictColumns(op.conflictColumns, conflictColumnsOf(other))
+	case OperationTypeInsert, OperationTypeUpdate:
+		return other.GetEntity() == op.entity
+	default:
+		return false
+	}
+}
+
+// Merge 实现Operation接口
+func (op *UpsertOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	switch other.GetOperationType() {
+	case OperationTypeInsert, OperationTypeUpdate:
+		return op
+	case OperationTypeBulkUpsert:
+		return NewBulkUpsertOperation([]Entity{op.entity}, op.conflictColumns, op.uow).Merge(other)
+	}
+
+	if other.GetEntity() == op.entity {
+		return op
+	}
+
+	entities := []Entity{op.entity, other.GetEntity()}
+	return NewBulkUpsertOperation(entities, op.conflictColumns, op.uow)
+}
+
+// BulkUpsertOperation 批量插入或更新操作
+type BulkUpsertOperation struct {
+	entities        []Entity
+	entityType      reflect.Type
+	conflictColumns []string
+	uow             *UnitOfWork
+}
+
+// NewBulkUpsertOperation 创建批量插入或更新操作
+func NewBulkUpsertOperation(entities []Entity, conflictColumns []string, uow *UnitOfWork) *BulkUpsertOperation {
+	var entityType reflect.Type
+	if len(entities) > 0 {
+		entityType = reflect.TypeOf(entities[0])
+	}
+
+	return &BulkUpsertOperation{
+		entities:        entities,
+		entityType:      entityType,
+		conflictColumns: conflictColumns,
+		uow:             uow,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *BulkUpsertOperation) GetEntityType() reflect.Type {
+	return op.entityType
+}
+
+// GetOperationType 实现Operation接口
+func (op *BulkUpsertOperation) GetOperationType() OperationType {
+	return OperationTypeBulkUpsert
+}
+
+// Execute 实现Operation接口
+func (op *BulkUpsertOperation) Execute(db *gorm.DB) error {
+	if len(op.entities) == 0 {
+		return nil
+	}
+
+	for _, entity := range op.entities {
+		if err := prepareUpsert(db.Statement.Context, entity); err != nil {
+			return err
+		}
+	}
+
+	clauses, returning, err := upsertClauses(db, op.entities[0], op.conflictColumns)
+	if err != nil {
+		return err
+	}
+
+	batchSize := len(op.entities)
+	if op.uow != nil && op.uow.config.BatchSize > 0 {
+		batchSize = op.uow.config.BatchSize
+	}
+
+	typedSlice := ConvertToTypedSlice(op.entities, op.GetEntityType())
+	result := db.Clauses(clauses...).CreateInBatches(typedSlice, batchSize)
+	if result.Error != nil {
+		return fmt.Errorf("failed to bulk upsert entities %T: %w", op.entityType, result.Error)
+	}
+
+	if returning {
+		return nil
+	}
+	return readBackUpserted(db, op.entities, op.conflictColumns)
+}
+
+// GetEntity 实现Operation接口
+func (op *BulkUpsertOperation) GetEntity() Entity {
+	if len(op.entities) > 0 {
+		return op.entities[0]
+	}
+	return nil
+}
+
+// GetConflictColumns 获取冲突列
+func (op *BulkUpsertOperation) GetConflictColumns() []string {
+	return op.conflictColumns
+}
+
+// SameIdentity 实现Operation接口
+func (op *BulkUpsertOperation) SameIdentity(other Operation) bool {
+	return false // 批量操作不参与身份比较
+}
+
+// CanMerge 实现Operation接口
+func (op *BulkUpsertOperation) CanMerge(other Operation) bool {
+	if op.GetEntityType() != other.GetEntityType() {
+		return false
+	}
+
+	switch other.GetOperationType() {
+	case OperationTypeUpsert, OperationTypeBulkUpsert:
+		return sameConflictColumns(op.conflictColumns, conflictColumnsOf(other))
+	case OperationTypeInsert, OperationTypeUpdate:
+		return op.contains(other.GetEntity())
+	default:
+		return false
+	}
+}
+
+// Merge 实现Operation接口
+func (op *BulkUpsertOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	allEntities := append([]Entity{}, op.entities...)
+	for _, entity := range operationEntities(other) {
+		if !op.contains(entity) {
+			allEntities = append(allEntities, entity)
+		}
+	}
+
+	return NewBulkUpsertOperation(allEntities, op.conflictColumns, op.uow)
+}
+
+// GetEntities 获取所有实体
+func (op *BulkUpsertOperation) GetEntities() []Entity {
+	return op.entities
+}
+
+func (op *BulkUpsertOperation) contains(entity Entity) bool {
+	for _, e := range op.entities {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareUpsert 插入或更新前验证实体并设置时间戳
+func prepareUpsert(ctx context.Context, entity Entity) error {
+	if err := validateWritable(ctx, entity); err != nil {
+		return err
+	}
+
+	if timestamped, ok := entity.(HasTimestamps); ok {
+		now := time.Now()
+		if timestamped.GetCreatedAt().IsZero() {
+			timestamped.SetCreatedAt(now)
+		}
+		timestamped.SetUpdatedAt(now)
+	}
+
+	return nil
+}
+
+// upsertClauses 构建插入或更新的子句，方言支持 RETURNING 时同时返回冲突时以已有行为准的列，
+// returning 为 false 时需在执行后调用 readBackUpserted 读取这些列
+func upsertClauses(db *gorm.DB, entity Entity, conflictColumns []string) ([]clause.Expression, bool, error) {
+	onConflict, err := buildOnConflict(db, entity, conflictColumns)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !utils.Contains(db.Callback().Create().Clauses, "RETURNING") {
+		return []clause.Expression{onConflict}, false, nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(entity); err != nil {
+		return nil, false, fmt.Errorf("failed to parse schema of %T: %w", entity, err)
+	}
+
+	// 同时返回数据库生成默认值的列，gorm 只在语句没有 RETURNING 子句时自动返回这些列
+	_, revisioned := entity.(HasRevision)
+	returned := clause.Returning{}
+	for _, field := range stmt.Schema.Fields {
+		if storedOnConflict(field, revisioned) || (field.DBName != "" && field.HasDefaultValue && field.DefaultValueInterface == nil) {
+			returned.Columns = append(returned.Columns, clause.Column{Name: field.DBName})
+		}
+	}
+
+	return []clause.Expression{onConflict, returned}, true, nil
+}
+
+// storedOnConflict 冲突时以已有行为准的字段：主键、创建时间、不可更新的字段，以及在原值基础上递增的版本号
+func storedOnConflict(field *schema.Field, revisioned bool) bool {
+	if field.DBName == "" {
+		return false
+	}
+	return field.PrimaryKey || field.AutoCreateTime > 0 || !field.Updatable || (revisioned && field.DBName == "revision")
+}
+
+// readBackUpserted 按冲突列读取插入或更新后的行，将以已有行为准的列写回实体，用于不支持 RETURNING 的方言
+func readBackUpserted(db *gorm.DB, entities []Entity, conflictColumns []string) error {
+	if db.DryRun || len(entities) == 0 {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(entities[0]); err != nil {
+		return fmt.Errorf("failed to parse schema of %T: %w", entities[0], err)
+	}
+
+	conflictFields := stmt.Schema.PrimaryFields
+	if len(conflictColumns) > 0 {
+		conflictFields = make([]*schema.Field, 0, len(conflictColumns))
+		for _, column := range conflictColumns {
+			field := stmt.Schema.LookUpField(column)
+			if field == nil {
+				return fmt.Errorf("unknown conflict column %s of %s", column, stmt.Schema.Name)
+			}
+			conflictFields = append(conflictFields, field)
+		}
+	}
+
+	_, revisioned := entities[0].(HasRevision)
+	storedFields := make([]*schema.Field, 0)
+	selected := make([]string, 0)
+	for _, field := range stmt.Schema.Fields {
+		if storedOnConflict(field, revisioned) {
+			storedFields = append(storedFields, field)
+			selected = append(selected, field.DBName)
+		}
+	}
+
+	ctx := db.Statement.Context
+	for _, entity := range entities {
+		value := reflect.ValueOf(entity)
+		stored := reflect.New(reflect.Indirect(value).Type())
+
+		query := db.Session(&gorm.Session{NewDB: true}).Unscoped().Model(stored.Interface()).Select(selected)
+		for _, field := range conflictFields {
+			conflictValue, _ := field.ValueOf(ctx, value)
+			query = query.Where(clause.Eq{
+				Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName},
+				Value:  conflictValue,
+			})
+		}
+
+		if err := query.Take(stored.Interface()).Error; err != nil {
+			return fmt.Errorf("failed to read back upserted entity %T: %w", entity, err)
+		}
+
+		for _, field := range storedFields {
+			field.ReflectValueOf(ctx, value).Set(field.ReflectValueOf(ctx, stored))
+		}
+	}
+
+	return nil
+}
+
+// buildOnConflict 构建冲突子句，由 gorm 方言渲染为 ON CONFLICT 或 ON DUPLICATE KEY UPDATE
+// 冲突时更新除主键、创建时间和冲突列以外的所有列，支持乐观锁的实体版本号在原值基础上递增
+func buildOnConflict(db *gorm.DB, entity Entity, conflictColumns []string) (clause.OnConflict, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(entity); err != nil {
+		return clause.OnConflict{}, fmt.Errorf("failed to parse schema of %T: %w", entity, err)
+	}
+
+	onConflict := clause.OnConflict{}
+	conflicting := make(map[string]bool)
+
+	for _, column := range conflictColumns {
+		name := column
+		if field := stmt.Schema.LookUpField(column); field != nil {
+			name = field.DBName
+		}
+		conflicting[name] = true
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: name})
+	}
+
+	if len(onConflict.Columns) == 0 {
+		for _, field := range stmt.Schema.PrimaryFields {
+			onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+		}
+	}
+
+	_, revisioned := entity.(HasRevision)
+	columns := make([]string, 0, len(stmt.Schema.DBNames))
+
+	for _, dbName := range stmt.Schema.DBNames {
+		field := stmt.Schema.FieldsByDBName[dbName]
+		if field.PrimaryKey || field.AutoCreateTime > 0 || !field.Updatable || conflicting[dbName] {
+			continue
+		}
+
+		if revisioned && dbName == "revision" {
+			onConflict.DoUpdates = append(onConflict.DoUpdates, clause.Assignment{
+				Column: clause.Column{Name: dbName},
+				Value:  clause.Expr{SQL: "? + 1", Vars: []interface{}{clause.Column{Table: stmt.Schema.Table, Name: dbName}}},
+			})
+			continue
+		}
+
+		columns = append(columns, dbName)
+	}
+
+	onConflict.DoUpdates = append(onConflict.DoUpdates, clause.AssignmentColumns(columns)...)
+	if len(onConflict.DoUpdates) == 0 {
+		onConflict.DoNothing = true
+	}
+
+	return onConflict, nil
+}
+
+// conflictColumnsOf 获取插入或更新操作的冲突列
+func conflictColumnsOf(operation Operation) []string {
+	if upsert, ok := operation.(interface{ GetConflictColumns() []string }); ok {
+		return upsert.GetConflictColumns()
+	}
+	return nil
+}
+
+func sameConflictColumns(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
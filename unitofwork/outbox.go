@@ -0,0 +1,320 @@
+package unitofwork
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// DefaultOutboxTable 默认发件箱表名
+const DefaultOutboxTable = "uow_outbox_messages"
+
+// OutboxMessage 发件箱消息
+type OutboxMessage struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	AggregateType string     `gorm:"size:255;index" json:"aggregate_type"`
+	AggregateID   string     `gorm:"size:64;index" json:"aggregate_id"`
+	EventType     string     `gorm:"size:255" json:"event_type"`
+	Payload       string     `gorm:"type:text" json:"payload"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	DispatchedAt  *time.Time `gorm:"index" json:"dispatched_at,omitempty"`
+	FailedAt      *time.Time `gorm:"index" json:"failed_at,omitempty"`
+}
+
+// MigrateOutbox 创建或迁移发件箱表，table 为空时使用默认表名
+func MigrateOutbox(db *gorm.DB, table string) error {
+	return db.Table(outboxTableName(table)).AutoMigrate(&OutboxMessage{})
+}
+
+func outboxTableName(table string) string {
+	if table == "" {
+		return DefaultOutboxTable
+	}
+	return table
+}
+
+// collectEventSources 收集工作单元中记录了领域事件的实体
+func (uow *UnitOfWork) collectEventSources() []EventSource {
+	sources := make([]EventSource, 0)
+	seen := make(map[Entity]bool)
+
+	collect := func(entity Entity) {
+		if seen[entity] {
+			return
+		}
+		seen[entity] = true
+
+		if source, ok := entity.(EventSource); ok && len(source.PendingEvents()) > 0 {
+			sources = append(sources, source)
+		}
+	}
+
+	// 按注册顺序收集，保证发件箱消息顺序与操作顺序一致
+	for _, operation := range uow.operations {
+		if entity := operation.GetEntity(); entity != nil {
+			collect(entity)
+		}
+	}
+
+	// 未发生字段变更但记录了事件的已跟踪实体
+	for key := range uow.snapshotManager.snapshots {
+		if entity, exists := uow.identityMap.get(key); exists {
+			collect(entity)
+		}
+	}
+
+	return sources
+}
+
+// writeOutbox 将领域事件写入发件箱表，必须在执行实体操作的同一事务中调用
+func (uow *UnitOfWork) writeOutbox(tx *gorm.DB, sources []EventSource) error {
+	if len(sources) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	messages := make([]*OutboxMessage, 0)
+
+	for _, source := range sources {
+		for _, event := range source.PendingEvents() {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to marshal event %s: %w", event.EventType(), err)
+			}
+
+			messages = append(messages, &OutboxMessage{
+				AggregateType: reflect.TypeOf(source).String(),
+				AggregateID:   IdentityOf(source).String(),
+				EventType:     event.EventType(),
+				Payload:       string(payload),
+				CreatedAt:     now,
+				NextAttemptAt: now,
+			})
+		}
+	}
+
+	if uow.memory != nil {
+		uow.memory.appendOutbox(messages)
+	} else if err := tx.Table(outboxTableName(uow.config.OutboxTable)).CreateInBatches(messages, uow.config.BatchSize).Error; err != nil {
+		return fmt.Errorf("failed to write outbox messages: %w", err)
+	}
+
+	if uow.config.EnableDetailLog {
+		zlogger.Debug().
+			Int("messages", len(messages)).
+			Msg("Wrote domain events to outbox")
+	}
+
+	return nil
+}
+
+// EventPublisher 领域事件发布器
+type EventPublisher interface {
+	// Publish 发布发件箱消息，返回错误时消息会按退避策略重试
+	Publish(ctx context.Context, message *OutboxMessage) error
+}
+
+// EventPublisherFunc 函数形式的事件发布器
+type EventPublisherFunc func(ctx context.Context, message *OutboxMessage) error
+
+// Publish 实现EventPublisher接口
+func (f EventPublisherFunc) Publish(ctx context.Context, message *OutboxMessage) error {
+	return f(ctx, message)
+}
+
+// OutboxRelayConfig 发件箱中继配置
+type OutboxRelayConfig struct {
+	// 发件箱表名
+	Table string
+
+	// 每次读取的消息数量
+	BatchSize int
+
+	// 轮询间隔
+	PollInterval time.Duration
+
+	// 最大尝试次数，超过后消息标记为失败
+	MaxAttempts int
+
+	// 初始退避时间
+	BaseBackoff time.Duration
+
+	// 最大退避时间
+	MaxBackoff time.Duration
+}
+
+// DefaultOutboxRelayConfig 默认发件箱中继配置
+func DefaultOutboxRelayConfig() *OutboxRelayConfig {
+	return &OutboxRelayConfig{
+		Table:        DefaultOutboxTable,
+		BatchSize:    100,
+		PollInterval: time.Second,
+		MaxAttempts:  10,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   5 * time.Minute,
+	}
+}
+
+// OutboxRelayOption 发件箱中继配置选项
+type OutboxRelayOption func(*OutboxRelayConfig)
+
+// WithRelayTable 配置发件箱表名
+func WithRelayTable(table string) OutboxRelayOption {
+	return func(c *OutboxRelayConfig) {
+		c.Table = table
+	}
+}
+
+// WithRelayBatchSize 配置每次读取的消息数量
+func WithRelayBatchSize(size int) OutboxRelayOption {
+	return func(c *OutboxRelayConfig) {
+		c.BatchSize = size
+	}
+}
+
+// WithRelayPollInterval 配置轮询间隔
+func WithRelayPollInterval(interval time.Duration) OutboxRelayOption {
+	return func(c *OutboxRelayConfig) {
+		c.PollInterval = interval
+	}
+}
+
+// WithRelayRetry 配置重试次数和退避时间
+func WithRelayRetry(maxAttempts int, baseBackoff, maxBackoff time.Duration) OutboxRelayOption {
+	return func(c *OutboxRelayConfig) {
+		c.MaxAttempts = maxAttempts
+		c.BaseBackoff = baseBackoff
+		c.MaxBackoff = maxBackoff
+	}
+}
+
+// OutboxRelay 发件箱中继，按写入顺序读取未发送的消息并交给发布器
+// 同一发件箱表只应运行一个中继实例，以保证消息顺序
+type OutboxRelay struct {
+	db        *gorm.DB
+	publisher EventPublisher
+	config    *OutboxRelayConfig
+	now       func() time.Time
+}
+
+// NewOutboxRelay 创建发件箱中继
+func NewOutboxRelay(db *gorm.DB, publisher EventPublisher, options ...OutboxRelayOption) *OutboxRelay {
+	config := DefaultOutboxRelayConfig()
+	for _, option := range options {
+		option(config)
+	}
+
+	return &OutboxRelay{
+		db:        db,
+		publisher: publisher,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+// Run 持续轮询发件箱直到上下文取消
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RelayOnce(ctx); err != nil {
+			zlogger.Error().Err(err).Msg("Outbox relay failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce 处理一批待发送的消息，返回成功发布的消息数量
+// 消息发布失败时停止处理本批次，以保证后续消息不会先于失败消息发布
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	var messages []*OutboxMessage
+	err := r.table(ctx).
+		Where("dispatched_at IS NULL AND failed_at IS NULL").
+		Order("id").
+		Limit(r.config.BatchSize).
+		Find(&messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load outbox messages: %w", err)
+	}
+
+	dispatched := 0
+	for _, message := range messages {
+		now := r.now()
+		if message.NextAttemptAt.After(now) {
+			break // 队首消息仍在退避中
+		}
+
+		publishErr := r.publisher.Publish(ctx, message)
+		if publishErr == nil {
+			if err := r.table(ctx).Where("id = ?", message.ID).Updates(map[string]interface{}{
+				"dispatched_at": now,
+				"attempts":      message.Attempts + 1,
+				"last_error":    "",
+			}).Error; err != nil {
+				return dispatched, fmt.Errorf("failed to mark outbox message %d dispatched: %w", message.ID, err)
+			}
+			dispatched++
+			continue
+		}
+
+		attempts := message.Attempts + 1
+		updates := map[string]interface{}{
+			"attempts":        attempts,
+			"last_error":      publishErr.Error(),
+			"next_attempt_at": now.Add(r.backoff(attempts)),
+		}
+
+		exhausted := r.config.MaxAttempts > 0 && attempts >= r.config.MaxAttempts
+		if exhausted {
+			updates["failed_at"] = now
+		}
+
+		if err := r.table(ctx).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+			return dispatched, fmt.Errorf("failed to record outbox message %d failure: %w", message.ID, err)
+		}
+
+		zlogger.Warn().
+			Err(publishErr).
+			Uint("message_id", message.ID).
+			Int("attempts", attempts).
+			Bool("exhausted", exhausted).
+			Msg("Failed to publish outbox message")
+
+		if !exhausted {
+			break
+		}
+	}
+
+	return dispatched, nil
+}
+
+// backoff 计算第 attempts 次失败后的退避时间
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if r.config.MaxBackoff > 0 && delay >= r.config.MaxBackoff {
+			return r.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (r *OutboxRelay) table(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Table(outboxTableName(r.config.Table))
+}
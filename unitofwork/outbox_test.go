@@ -0,0 +1,141 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// 示例实体：记录领域事件的订单
+type Order struct {
+	BaseEntity
+	EventRecorder
+	Number string `gorm:"size:64" json:"number"`
+}
+
+func (o *Order) GetTableName() string {
+	return "orders"
+}
+
+type OrderPlaced struct {
+	Number string `json:"number"`
+}
+
+func (e OrderPlaced) EventType() string {
+	return "order.placed"
+}
+
+func setupOutboxTestDB(t *testing.T) *gorm.DB {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&Order{}))
+	require.NoError(t, MigrateOutbox(db, ""))
+	return db
+}
+
+// TestUnitOfWork_Outbox 测试领域事件写入发件箱
+func TestUnitOfWork_Outbox(t *testing.T) {
+	t.Run("提交时在同一事务中写入发件箱", func(t *testing.T) {
+		db := setupOutboxTestDB(t)
+
+		order := &Order{Number: "SO-1"}
+		order.RecordEvent(OrderPlaced{Number: "SO-1"})
+
+		err := WithUnitOfWork(context.Background(), db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			return uow.Create(order)
+		})
+		require.NoError(t, err)
+		assert.Empty(t, order.PendingEvents())
+
+		var messages []OutboxMessage
+		require.NoError(t, db.Table(DefaultOutboxTable).Find(&messages).Error)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "order.placed", messages[0].EventType)
+		assert.Equal(t, "*unitofwork.Order", messages[0].AggregateType)
+		assert.Equal(t, "1", messages[0].AggregateID)
+		assert.JSONEq(t, `{"number":"SO-1"}`, messages[0].Payload)
+	})
+
+	t.Run("事务回滚时不写入发件箱", func(t *testing.T) {
+		db := setupOutboxTestDB(t)
+
+		order := &Order{Number: "SO-2"}
+		order.RecordEvent(OrderPlaced{Number: "SO-2"})
+
+		err := WithUnitOfWork(context.Background(), db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			if err := uow.Create(order); err != nil {
+				return err
+			}
+			return errors.New("business failed")
+		})
+		require.Error(t, err)
+		assert.Len(t, order.PendingEvents(), 1)
+
+		var count int64
+		require.NoError(t, db.Table(DefaultOutboxTable).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
+// TestOutboxRelay 测试发件箱中继
+func TestOutboxRelay(t *testing.T) {
+	db := setupOutboxTestDB(t)
+
+	uow := NewUnitOfWork(db)
+	for i, number := range []string{"SO-1", "SO-2", "SO-3"} {
+		order := &Order{BaseEntity: BaseEntity{ID: uint(i + 1)}, Number: number}
+		order.RecordEvent(OrderPlaced{Number: number})
+		require.NoError(t, uow.Create(order))
+	}
+	require.NoError(t, uow.Commit())
+
+	var published []string
+	failures := 1
+	publisher := EventPublisherFunc(func(ctx context.Context, message *OutboxMessage) error {
+		if message.ID == 2 && failures > 0 {
+			failures--
+			return errors.New("broker unavailable")
+		}
+		published = append(published, message.Payload)
+		return nil
+	})
+
+	now := time.Now()
+	relay := NewOutboxRelay(db, publisher, WithRelayRetry(3, time.Minute, time.Hour))
+	relay.now = func() time.Time { return now }
+
+	dispatched, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+
+	// 失败的消息处于退避期，后续消息不会越过它发布
+	dispatched, err = relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, dispatched)
+
+	now = now.Add(2 * time.Minute)
+	dispatched, err = relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+
+	assert.Equal(t, []string{`{"number":"SO-1"}`, `{"number":"SO-2"}`, `{"number":"SO-3"}`}, published)
+
+	var retried OutboxMessage
+	require.NoError(t, db.Table(DefaultOutboxTable).First(&retried, 2).Error)
+	assert.Equal(t, 2, retried.Attempts)
+	assert.NotNil(t, retried.DispatchedAt)
+}
+
+// TestOutboxRelay_Backoff 测试退避时间计算
+func TestOutboxRelay_Backoff(t *testing.T) {
+	relay := NewOutboxRelay(nil, nil, WithRelayRetry(10, time.Second, 5*time.Second))
+
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 2*time.Second, relay.backoff(2))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, 5*time.Second, relay.backoff(4))
+}
@@ -0,0 +1,234 @@
+package unitofwork
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/logger"
+)
+
+// CommitPlan 提交计划，描述 Commit 将按什么顺序执行哪些操作
+type CommitPlan struct {
+	// 按执行顺序排列的操作
+	Operations []PlannedOperation `json:"operations"`
+}
+
+// PlannedOperation 提交计划中的单个操作
+type PlannedOperation struct {
+	// 执行顺序，从0开始
+	Index int `json:"index"`
+	// 操作类型，例如 INSERT、BULK_UPDATE
+	OperationType string `json:"operation_type"`
+	// 实体类型
+	EntityType string `json:"entity_type"`
+	// 操作涉及的实体ID，新实体在插入前为零值
+	EntityIDs []string `json:"entity_ids"`
+	// 批量操作每批的实体数量，非批量操作为 0
+	BatchSize int `json:"batch_size,omitempty"`
+	// 批量操作拆分的批次数，非批量操作为 1
+	Batches int `json:"batches"`
+	// gorm 在 DryRun 模式下生成的 SQL
+	SQL []string `json:"sql"`
+}
+
+// Plan 生成提交计划，执行与 Commit 相同的操作优化、合并与依赖排序，
+// 并在 DryRun 会话中对实体副本执行操作以获取 SQL，不会访问数据库，也不会修改工作单元和实体
+func (uow *UnitOfWork) Plan() (*CommitPlan, error) {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	if uow.isCommitted {
+		return nil, fmt.Errorf("unit of work is already committed")
+	}
+
+	if uow.isRolledBack {
+		return nil, fmt.Errorf("unit of work is already rolled back")
+	}
+
+	sortedOps, err := uow.sortOperationsByDependency(uow.optimizeOperations())
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CommitPlan{Operations: make([]PlannedOperation, 0, len(sortedOps))}
+	for i, operation := range sortedOps {
+		planned, err := uow.planOperation(i, operation)
+		if err != nil {
+			return nil, err
+		}
+		plan.Operations = append(plan.Operations, planned)
+	}
+
+	return plan, nil
+}
+
+// planOperation 生成单个操作的计划
+func (uow *UnitOfWork) planOperation(index int, operation Operation) (PlannedOperation, error) {
+	entities := operationEntities(operation)
+
+	planned := PlannedOperation{
+		Index:         index,
+		OperationType: operation.GetOperationType().String(),
+		EntityType:    operation.GetEntityType().String(),
+		EntityIDs:     make([]string, 0, len(entities)),
+		Batches:       1,
+		SQL:           make([]string, 0),
+	}
+
+	for _, entity := range entities {
+		planned.EntityIDs = append(planned.EntityIDs, IdentityOf(entity).String())
+	}
+
+	if isBulkOperation(operation.GetOperationType()) && uow.config.BatchSize > 0 {
+		planned.BatchSize = uow.config.BatchSize
+		planned.Batches = (len(entities) + uow.config.BatchSize - 1) / uow.config.BatchSize
+	}
+
+	if uow.db == nil {
+		return planned, nil
+	}
+
+	clone, ok := cloneOperation(operation)
+	if !ok {
+		return planned, nil
+	}
+
+	recorder := &sqlRecorder{}
+	dryRun := uow.db.Session(&gorm.Session{
+		DryRun:                 true,
+		NewDB:                  true,
+		SkipDefaultTransaction: true,
+		Context:                uow.queryContext(),
+		Logger:                 recorder,
+	})
+
+	// DryRun 不返回影响行数，乐观锁等依赖影响行数的检查会失败，已生成 SQL 时忽略
+	if err := clone.Execute(dryRun); err != nil && len(recorder.statements) == 0 {
+		return planned, fmt.Errorf("failed to plan operation %d: %w", index, err)
+	}
+	planned.SQL = append(planned.SQL, recorder.statements...)
+
+	return planned, nil
+}
+
+func isBulkOperation(operationType OperationType) bool {
+	return operationType == OperationTypeBulkInsert ||
+		operationType == OperationTypeBulkUpdate ||
+		operationType == OperationTypeBulkDelete ||
+		operationType == OperationTypeBulkUpsert ||
+		operationType == OperationTypeBulkRestore ||
+		operationType == OperationTypeBulkPurge
+}
+
+// cloneOperation 使用实体副本复制操作，避免 DryRun 执行时修改实体的时间戳、版本号等字段
+func cloneOperation(operation Operation) (Operation, bool) {
+	switch op := operation.(type) {
+	case *InsertOperation:
+		return NewInsertOperation(cloneEntity(op.entity), op.uow), true
+	case *UpdateOperation:
+		clone := NewUpdateOperation(cloneEntity(op.entity), op.changes)
+		if op.deferral != nil {
+			clone.deferral = &foreignKeyDeferral{entity: clone.entity, relations: op.deferral.relations}
+		}
+		return clone, true
+	case *DeleteOperation:
+		return NewDeleteOperation(cloneEntity(op.entity)), true
+	case *BulkInsertOperation:
+		return NewBulkInsertOperation(cloneEntities(op.entities), op.insertOperation), true
+	case *BulkUpdateOperation:
+		return newBulkUpdateOperation(cloneEntities(op.entities), op.changes, op.uow), true
+	case *BulkDeleteOperation:
+		return NewBulkDeleteOperation(cloneEntities(op.entities)), true
+	case *UpsertOperation:
+		return NewUpsertOperation(cloneEntity(op.entity), op.conflictColumns, op.uow), true
+	case *BulkUpsertOperation:
+		return NewBulkUpsertOperation(cloneEntities(op.entities), op.conflictColumns, op.uow), true
+	case *RestoreOperation:
+		return NewRestoreOperation(cloneEntity(op.entity)), true
+	case *BulkRestoreOperation:
+		return NewBulkRestoreOperation(cloneEntities(op.entities)), true
+	case *PurgeOperation:
+		return NewPurgeOperation(cloneEntity(op.entity)), true
+	case *BulkPurgeOperation:
+		return NewBulkPurgeOperation(cloneEntities(op.entities)), true
+	case *deferredInsertOperation:
+		inner, ok := cloneOperation(op.Operation)
+		if !ok {
+			return nil, false
+		}
+
+		relations := make(map[Entity][]*foreignKeyRelation, len(op.deferrals))
+		for _, deferral := range op.deferrals {
+			relations[deferral.entity] = deferral.relations
+		}
+
+		clone := &deferredInsertOperation{Operation: inner}
+		for i, entity := range operationEntities(op.Operation) {
+			if entityRelations, exists := relations[entity]; exists {
+				clone.deferrals = append(clone.deferrals, &foreignKeyDeferral{
+					entity:    operationEntities(inner)[i],
+					relations: entityRelations,
+				})
+			}
+		}
+		return clone, true
+	default:
+		return nil, false
+	}
+}
+
+// cloneEntity 浅拷贝实体
+func cloneEntity(entity Entity) Entity {
+	value := reflect.ValueOf(entity)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return entity
+	}
+
+	clone := reflect.New(value.Elem().Type())
+	clone.Elem().Set(value.Elem())
+	return clone.Interface().(Entity)
+}
+
+func cloneEntities(entities []Entity) []Entity {
+	clones := make([]Entity, 0, len(entities))
+	for _, entity := range entities {
+		clones = append(clones, cloneEntity(entity))
+	}
+	return clones
+}
+
+// sqlRecorder 记录 DryRun 模式下生成的 SQL
+type sqlRecorder struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+// LogMode 实现logger.Interface接口
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+// Info 实现logger.Interface接口
+func (r *sqlRecorder) Info(context.Context, string, ...interface{}) {}
+
+// Warn 实现logger.Interface接口
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{}) {}
+
+// Error 实现logger.Interface接口
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+// Trace 实现logger.Interface接口
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, _ := fc()
+	if sql == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, sql)
+}
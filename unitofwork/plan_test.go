@@ -0,0 +1,70 @@
+package unitofwork
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitOfWork_Plan 测试提交计划
+func TestUnitOfWork_Plan(t *testing.T) {
+	db := setupTestDB()
+	require.NoError(t, db.Create(&User{Name: "张三", Email: "zhangsan@example.com", Age: 20}).Error)
+
+	uow := NewUnitOfWork(db, WithBatchSize(1))
+
+	var existing *User
+	require.NoError(t, uow.Find(&existing, 1))
+	existing.Age = 21
+	require.NoError(t, uow.Update(existing))
+
+	post := &Post{BaseEntity: BaseEntity{ID: 1}, Title: "计划", UserID: 2}
+	require.NoError(t, uow.Create(post))
+	require.NoError(t, uow.Create(&User{BaseEntity: BaseEntity{ID: 2}, Name: "李四", Email: "lisi@example.com"}))
+	require.NoError(t, uow.Create(&User{BaseEntity: BaseEntity{ID: 3}, Name: "王五", Email: "wangwu@example.com"}))
+
+	plan, err := uow.Plan()
+	require.NoError(t, err)
+	require.Len(t, plan.Operations, 3)
+
+	users := plan.Operations[0]
+	assert.Equal(t, "BULK_INSERT", users.OperationType)
+	assert.Equal(t, "*unitofwork.User", users.EntityType)
+	assert.Equal(t, []string{"2", "3"}, users.EntityIDs)
+	assert.Equal(t, 1, users.BatchSize)
+	assert.Equal(t, 2, users.Batches)
+	require.Len(t, users.SQL, 2)
+	assert.Contains(t, users.SQL[0], "INSERT INTO `users`")
+	assert.Contains(t, users.SQL[0], "李四")
+
+	posts := plan.Operations[1]
+	assert.Equal(t, "INSERT", posts.OperationType)
+	assert.Equal(t, []string{"1"}, posts.EntityIDs)
+	assert.Equal(t, 1, posts.Batches)
+	require.Len(t, posts.SQL, 1)
+	assert.Contains(t, posts.SQL[0], "INSERT INTO `posts`")
+
+	update := plan.Operations[2]
+	assert.Equal(t, "UPDATE", update.OperationType)
+	require.Len(t, update.SQL, 1)
+	assert.Contains(t, update.SQL[0], "UPDATE `users`")
+	assert.Contains(t, update.SQL[0], "revision = 1")
+
+	data, err := json.Marshal(plan)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"operation_type":"BULK_INSERT"`)
+	assert.Contains(t, string(data), `"entity_ids":["2","3"]`)
+
+	// 生成计划不访问数据库，也不修改实体
+	var count int64
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int64(1), existing.Revision)
+	assert.True(t, post.CreatedAt.IsZero())
+
+	require.NoError(t, uow.Commit())
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
@@ -29,11 +29,14 @@ type PluginConfig struct {
 	// 上下文键名
 	ContextKey string
 
-	// 是否启用自动依赖注册
+	// 是否启用自动依赖注册：创建工作单元时将 DependencyMapping 注册到其依赖管理器
 	AutoDependencyRegistration bool
 
 	// 依赖关系映射
 	DependencyMapping map[reflect.Type][]reflect.Type
+
+	// 全局生命周期监听器，注册到使用该数据库创建的所有工作单元
+	Listeners []Listener
 }
 
 // DefaultPluginConfig 默认插件配置
@@ -92,6 +95,13 @@ func WithPluginDependencyMapping(mapping map[reflect.Type][]reflect.Type) Plugin
 	}
 }
 
+// WithPluginListener 配置全局生命周期监听器
+func WithPluginListener(listener Listener) PluginOption {
+	return func(c *PluginConfig) {
+		c.Listeners = append(c.Listeners, listener)
+	}
+}
+
 // Name 实现gorm.Plugin接口
 func (p *Plugin) Name() string {
 	return p.name
@@ -166,7 +176,7 @@ func (p *Plugin) checkDest(db *gorm.DB) bool {
 	case reflect.Struct:
 		// 单个实体
 		if entity, ok := db.Statement.Dest.(Entity); ok {
-			return entity.GetID() > 0
+			return !IdentityOf(entity).IsZero()
 		}
 		return false
 	case reflect.Slice:
@@ -174,11 +184,11 @@ func (p *Plugin) checkDest(db *gorm.DB) bool {
 			item := destValue.Index(i)
 			if item.Kind() == reflect.Ptr && !item.IsNil() {
 				if entity, ok := item.Interface().(Entity); ok {
-					return entity.GetID() > 0
+					return !IdentityOf(entity).IsZero()
 				}
 			} else if item.CanInterface() {
 				if entity, ok := item.Interface().(Entity); ok {
-					return entity.GetID() > 0
+					return !IdentityOf(entity).IsZero()
 				}
 			}
 		}
@@ -290,7 +300,7 @@ func (p *Plugin) unitOfWorkCreate(db *gorm.DB) {
 		if p.config.UnitOfWorkConfig.EnableDetailLog {
 			zlogger.Debug().
 				Str("entity_type", reflect.TypeOf(entity).String()).
-				Interface("entity_id", entity.GetID()).
+				Str("entity_id", IdentityOf(entity).String()).
 				Msg("Entity registered for creation in unit of work")
 		}
 		return nil
@@ -455,7 +465,7 @@ func (p *Plugin) afterCreate(db *gorm.DB) {
 		p.processEntities(db, func(entity Entity) error {
 			zlogger.Debug().
 				Str("entity_type", reflect.TypeOf(entity).String()).
-				Interface("entity_id", entity.GetID()).
+				Str("entity_id", IdentityOf(entity).String()).
 				Msg("Entity created in unit of work")
 			return nil
 		})
@@ -483,7 +493,7 @@ func (p *Plugin) afterUpdate(db *gorm.DB) {
 		p.processEntities(db, func(entity Entity) error {
 			zlogger.Debug().
 				Str("entity_type", reflect.TypeOf(entity).String()).
-				Interface("entity_id", entity.GetID()).
+				Str("entity_id", IdentityOf(entity).String()).
 				Msg("Entity updated in unit of work")
 			return nil
 		})
@@ -511,20 +521,29 @@ func (p *Plugin) afterDelete(db *gorm.DB) {
 		p.processEntities(db, func(entity Entity) error {
 			zlogger.Debug().
 				Str("entity_type", reflect.TypeOf(entity).String()).
-				Interface("entity_id", entity.GetID()).
+				Str("entity_id", IdentityOf(entity).String()).
 				Msg("Entity deleted in unit of work")
 			return nil
 		})
 	}
 }
 
-// registerDependencies 注册依赖关系
-func (p *Plugin) registerDependencies(uow *UnitOfWork) {
-	depManager := uow.GetDependencyManager()
+// registerPluginDependencies 将插件配置的依赖关系映射注册到新建的工作单元
+func registerPluginDependencies(db *gorm.DB, depManager *DependencyManager) {
+	if db == nil || db.Config == nil {
+		return
+	}
 
-	for dependent, dependencies := range p.config.DependencyMapping {
-		for _, dependency := range dependencies {
-			depManager.RegisterDependency(dependent, dependency)
+	for _, plugin := range db.Config.Plugins {
+		p, ok := plugin.(*Plugin)
+		if !ok || !p.config.AutoDependencyRegistration {
+			continue
+		}
+
+		for dependent, dependencies := range p.config.DependencyMapping {
+			for _, dependency := range dependencies {
+				depManager.RegisterDependency(dependent, dependency)
+			}
 		}
 	}
 }
@@ -558,18 +577,32 @@ func SetUnitOfWorkToContext(ctx context.Context, uow *UnitOfWork, key string) co
 }
 
 // WithUnitOfWork 在上下文中使用工作单元
+// 如果上下文中已存在未完成的工作单元，则作为其子工作单元执行，提交时在 SAVEPOINT 下刷新，
+// 此时子工作单元沿用父工作单元的配置，options 将被忽略
 func WithUnitOfWork(ctx context.Context, db *gorm.DB, fn func(*gorm.DB, *UnitOfWork) error, options ...ConfigOption) error {
+	if parent := activeUnitOfWork(ctx, db); parent != nil {
+		return withChildUnitOfWork(ctx, parent, fn)
+	}
+
 	config := DefaultConfig()
 	for _, option := range options {
 		option(config)
 	}
 
+	span, ctx := startSpan(ctx, config, "unitofwork.WithUnitOfWork")
+	err := withUnitOfWork(ctx, db, fn, config)
+	finishSpan(span, err)
+	return err
+}
+
+// withUnitOfWork 在事务中创建工作单元并执行业务逻辑
+func withUnitOfWork(ctx context.Context, db *gorm.DB, fn func(*gorm.DB, *UnitOfWork) error, config *Config) error {
 	// 在事务中执行
 	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// 使用事务连接创建工作单元
 		uow := NewUnitOfWork(tx, func(c *Config) {
 			*c = *config
-		})
+		}).WithContext(ctx)
 
 		// 将工作单元添加到上下文
 		txCtx := SetUnitOfWorkToContext(tx.Statement.Context, uow, "unitofwork")
@@ -615,7 +648,65 @@ func WithUnitOfWork(ctx context.Context, db *gorm.DB, fn func(*gorm.DB, *UnitOfW
 	})
 }
 
-// afterQuery 查询后回调，为查询到的实体创建快照
+// activeUnitOfWork 从上下文或数据库会话中获取未完成的工作单元
+func activeUnitOfWork(ctx context.Context, db *gorm.DB) *UnitOfWork {
+	uow := GetUnitOfWorkFromContext(ctx, "unitofwork")
+	if uow == nil && db != nil && db.Statement != nil {
+		uow = GetUnitOfWorkFromContext(db.Statement.Context, "unitofwork")
+	}
+
+	if uow == nil || uow.IsCommitted() || uow.IsRolledBack() {
+		return nil
+	}
+
+	return uow
+}
+
+// queryContext 返回工作单元自身查询数据库时使用的上下文，保留 uow.ctx 中的租户、截止时间和追踪信息，
+// 但屏蔽其中的工作单元，避免插件将查询结果纳入身份映射或拦截试运行的写入
+func (uow *UnitOfWork) queryContext() context.Context {
+	ctx := context.WithValue(uow.ctx, ContextUnitOfWork("unitofwork"), (*UnitOfWork)(nil))
+	if uow.db == nil || uow.db.Config == nil {
+		return ctx
+	}
+
+	for _, plugin := range uow.db.Config.Plugins {
+		if p, ok := plugin.(*Plugin); ok {
+			ctx = context.WithValue(ctx, ContextUnitOfWork(p.config.ContextKey), (*UnitOfWork)(nil))
+		}
+	}
+	return ctx
+}
+
+// withChildUnitOfWork 在父工作单元的事务中执行子工作单元
+func withChildUnitOfWork(ctx context.Context, parent *UnitOfWork, fn func(*gorm.DB, *UnitOfWork) error) error {
+	child, err := parent.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin child unit of work: %w", err)
+	}
+	child.WithContext(ctx)
+
+	childCtx := SetUnitOfWorkToContext(ctx, child, "unitofwork")
+	tx := parent.db.WithContext(childCtx)
+
+	if err := fn(tx, child); err != nil {
+		if rollbackErr := child.Rollback(); rollbackErr != nil {
+			zlogger.Error().Err(rollbackErr).Msg("Failed to rollback child unit of work")
+		}
+		return fmt.Errorf("business logic failed: %w", err)
+	}
+
+	if err := child.Commit(); err != nil {
+		if rollbackErr := child.Rollback(); rollbackErr != nil {
+			zlogger.Error().Err(rollbackErr).Msg("Failed to rollback child unit of work")
+		}
+		return fmt.Errorf("failed to commit unit of work: %w", err)
+	}
+
+	return nil
+}
+
+// afterQuery 查询后回调，将查询到的实体纳入身份映射并创建快照
 func (p *Plugin) afterQuery(db *gorm.DB) {
 	if !p.config.AutoManage {
 		return
@@ -631,14 +722,15 @@ func (p *Plugin) afterQuery(db *gorm.DB) {
 		return
 	}
 
-	// 处理实体（支持单个实体或实体切片）
+	// 处理实体（支持单个实体或实体切片），纳入身份映射并创建快照
 	p.processEntities(db, func(entity Entity) error {
-		uow.TakeSnapshot(entity)
+		tracked := uow.Attach(entity)
 		if p.config.UnitOfWorkConfig.EnableDetailLog {
 			zlogger.Debug().
 				Str("entity_type", reflect.TypeOf(entity).String()).
-				Interface("entity_id", entity.GetID()).
-				Msg("Created snapshot for queried entity")
+				Str("entity_id", IdentityOf(entity).String()).
+				Bool("already_tracked", tracked != entity).
+				Msg("Tracked queried entity")
 		}
 		return nil
 	})
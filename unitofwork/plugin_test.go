@@ -221,6 +221,13 @@ func TestPlugin_DependencyManagement(t *testing.T) {
 		assert.Equal(t, int64(1), userCount)
 		assert.Equal(t, int64(1), postCount)
 	})
+
+	t.Run("创建工作单元时注册依赖映射且不修改其配置", func(t *testing.T) {
+		uow := NewUnitOfWork(db, WithAutoDependency(false))
+
+		assert.True(t, uow.GetDependencyManager().HasDependency(reflect.TypeOf(&Tag{}), reflect.TypeOf(&User{})))
+		assert.False(t, uow.config.EnableAutoDependency)
+	})
 }
 
 // TestPlugin_Context 测试上下文管理
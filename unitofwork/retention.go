@@ -0,0 +1,188 @@
+package unitofwork
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/clause"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// PurgeJobConfig 软删除数据清理任务配置
+type PurgeJobConfig struct {
+	// 每批永久删除的行数
+	BatchSize int
+
+	// Run 的执行间隔
+	Interval time.Duration
+}
+
+// DefaultPurgeJobConfig 默认清理任务配置
+func DefaultPurgeJobConfig() *PurgeJobConfig {
+	return &PurgeJobConfig{
+		BatchSize: 500,
+		Interval:  time.Hour,
+	}
+}
+
+// PurgeJobOption 清理任务配置选项
+type PurgeJobOption func(*PurgeJobConfig)
+
+// WithPurgeBatchSize 配置每批永久删除的行数
+func WithPurgeBatchSize(size int) PurgeJobOption {
+	return func(c *PurgeJobConfig) {
+		c.BatchSize = size
+	}
+}
+
+// WithPurgeInterval 配置执行间隔
+func WithPurgeInterval(interval time.Duration) PurgeJobOption {
+	return func(c *PurgeJobConfig) {
+		c.Interval = interval
+	}
+}
+
+// PurgeJob 软删除数据清理任务，永久删除软删除时间早于保留期限的行
+type PurgeJob struct {
+	db        *gorm.DB
+	retention time.Duration
+	models    []Entity
+	config    *PurgeJobConfig
+	now       func() time.Time
+}
+
+// NewPurgeJob 创建清理任务，models 为需要清理的实体类型的零值，例如 &User{}
+func NewPurgeJob(db *gorm.DB, retention time.Duration, models []Entity, options ...PurgeJobOption) *PurgeJob {
+	config := DefaultPurgeJobConfig()
+	for _, option := range options {
+		option(config)
+	}
+
+	return &PurgeJob{
+		db:        db,
+		retention: retention,
+		models:    models,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+// Run 按执行间隔持续清理直到上下文取消
+func (j *PurgeJob) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.PurgeOnce(ctx); err != nil {
+			zlogger.Error().Err(err).Msg("Soft delete purge failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce 清理所有实体类型中超过保留期限的软删除行，返回永久删除的行数
+func (j *PurgeJob) PurgeOnce(ctx context.Context) (int64, error) {
+	cutoff := j.now().Add(-j.retention)
+
+	var total int64
+	for _, model := range j.models {
+		purged, err := PurgeSoftDeleted(ctx, j.db, model, cutoff, j.config.BatchSize)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+
+		if purged > 0 {
+			zlogger.Info().
+				Str("entity_type", reflect.TypeOf(model).String()).
+				Int64("purged", purged).
+				Time("cutoff", cutoff).
+				Msg("Purged soft deleted rows")
+		}
+	}
+
+	return total, nil
+}
+
+// PurgeSoftDeleted 分批永久删除软删除时间早于 cutoff 的行，返回永久删除的行数
+// 每批先按主键读取待删除的行，再以一条语句删除，每批是独立的语句，中途失败时已删除的批次不会回滚
+func PurgeSoftDeleted(ctx context.Context, db *gorm.DB, model Entity, cutoff time.Time, batchSize int) (int64, error) {
+	entityType := reflect.TypeOf(model)
+	if entityType.Kind() != reflect.Ptr {
+		return 0, fmt.Errorf("model must be a pointer to an entity, got %s", entityType)
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return 0, fmt.Errorf("failed to parse schema of %s: %w", entityType, err)
+	}
+
+	field := deletedAtField(stmt.Schema)
+	if field == nil {
+		return 0, fmt.Errorf("entity %s has no gorm.DeletedAt field", entityType)
+	}
+
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeJobConfig().BatchSize
+	}
+
+	primaryColumns := make([]string, 0, len(stmt.Schema.PrimaryFields))
+	for _, primaryField := range stmt.Schema.PrimaryFields {
+		primaryColumns = append(primaryColumns, primaryField.DBName)
+	}
+	if len(primaryColumns) == 0 {
+		return 0, fmt.Errorf("failed to purge entities %s: %w", entityType, gorm.ErrPrimaryKeyRequired)
+	}
+
+	expired := clause.Lt{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: cutoff}
+	session := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		batch := reflect.New(reflect.SliceOf(entityType))
+		if err := session.Unscoped().Select(primaryColumns).Clauses(clause.Where{Exprs: []clause.Expression{expired}}).
+			Limit(batchSize).Find(batch.Interface()).Error; err != nil {
+			return total, fmt.Errorf("failed to load soft deleted entities %s: %w", entityType, err)
+		}
+
+		rows := batch.Elem()
+		if rows.Len() == 0 {
+			return total, nil
+		}
+
+		identities := make([]Identity, 0, rows.Len())
+		for i := 0; i < rows.Len(); i++ {
+			identities = append(identities, IdentityOf(rows.Index(i).Interface().(Entity)))
+		}
+
+		target := reflect.New(entityType.Elem()).Interface()
+		query, err := whereIdentities(session.Unscoped(), target, identities)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge entities %s: %w", entityType, err)
+		}
+
+		// 读取后被恢复的行不再删除
+		result := query.Clauses(clause.Where{Exprs: []clause.Expression{expired}}).Delete(target)
+		if result.Error != nil {
+			return total, fmt.Errorf("failed to purge entities %s: %w", entityType, result.Error)
+		}
+		total += result.RowsAffected
+
+		if rows.Len() < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package unitofwork
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/zlogger"
+)
+
+// RetryPolicy 乐观锁冲突重试策略
+type RetryPolicy struct {
+	// 最大提交次数（包含首次提交），零值表示不限制
+	MaxAttempts int
+
+	// 初始退避时间，之后每次重试翻倍
+	BaseBackoff time.Duration
+
+	// 最大退避时间
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}
+}
+
+// backoff 计算第 attempts 次提交失败后的退避时间
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	delay := p.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Conflict 乐观锁冲突
+type Conflict struct {
+	// 本地实体，解决冲突后按其状态重新提交
+	Entity Entity
+
+	// 本地实体相对于加载时快照的字段变更
+	Changes map[string]FieldChange
+
+	// 数据库中的最新状态，行已被删除时为 nil
+	Current Entity
+}
+
+// ConflictResolver 冲突解决函数，通过修改 conflict.Entity 决定重试时写入的状态，返回错误则放弃重试
+// 解决后工作单元以数据库最新状态作为快照基准并采用最新版本号，本地实体不再有变更时不再更新
+type ConflictResolver func(conflict *Conflict) error
+
+// ErrEntityDeleted 冲突的行已被删除
+var ErrEntityDeleted = errors.New("entity has been deleted")
+
+// ReapplyLocalChanges 默认冲突解决函数，以数据库最新状态为准重新应用本地变更的字段，行已被删除时放弃重试
+func ReapplyLocalChanges(conflict *Conflict) error {
+	if conflict.Current == nil {
+		return ErrEntityDeleted
+	}
+
+	local := reflect.Indirect(reflect.ValueOf(conflict.Entity))
+	current := reflect.Indirect(reflect.ValueOf(conflict.Current))
+
+	for i := 0; i < local.NumField(); i++ {
+		field := local.Type().Field(i)
+		if !local.Field(i).CanSet() || field.Tag.Get("unitofwork") == "ignore" {
+			continue
+		}
+
+		if _, changed := conflict.Changes[field.Name]; changed {
+			continue
+		}
+
+		local.Field(i).Set(current.Field(i))
+	}
+
+	return nil
+}
+
+// CommitWithRetry 提交所有变更，遇到乐观锁冲突时重新加载冲突的行，交给 resolver 解决后按 policy 退避重试
+// 每次提交都在事务或保存点中执行，失败时实体恢复到提交前的状态；resolver 为 nil 时使用 ReapplyLocalChanges
+func (uow *UnitOfWork) CommitWithRetry(policy RetryPolicy, resolver ConflictResolver) error {
+	err := uow.commitWithRetry(policy, resolver)
+	if err != nil {
+		uow.observeCommitFailure()
+	}
+	return err
+}
+
+// commitWithRetry 重试提交直到成功或放弃，放弃时才由 CommitWithRetry 记录一次回滚
+func (uow *UnitOfWork) commitWithRetry(policy RetryPolicy, resolver ConflictResolver) error {
+	if resolver == nil {
+		resolver = ReapplyLocalChanges
+	}
+
+	for attempts := 1; ; attempts++ {
+		states := uow.saveEntityStates()
+
+		err := uow.commit(true)
+		if err == nil {
+			return nil
+		}
+
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+
+		restoreEntityStates(states)
+
+		if policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := policy.backoff(attempts)
+		zlogger.Warn().
+			Int("attempts", attempts).
+			Int("conflicts", len(conflict.Entities)).
+			Dur("backoff", delay).
+			Msg("Optimistic lock conflict, retrying unit of work commit")
+
+		select {
+		case <-uow.ctx.Done():
+			return fmt.Errorf("unit of work commit retry canceled: %w", uow.ctx.Err())
+		case <-time.After(delay):
+		}
+
+		if err := uow.resolveConflicts(conflict.Entities, resolver); err != nil {
+			return err
+		}
+	}
+}
+
+// saveEntityStates 保存待提交实体的副本，提交失败时恢复时间戳、版本号、自增主键等被修改的字段
+func (uow *UnitOfWork) saveEntityStates() map[Entity]Entity {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	states := make(map[Entity]Entity)
+	for _, operation := range uow.operations {
+		for _, entity := range operationEntities(operation) {
+			if _, saved := states[entity]; !saved {
+				states[entity] = cloneEntity(entity)
+			}
+		}
+	}
+	return states
+}
+
+func restoreEntityStates(states map[Entity]Entity) {
+	for entity, state := range states {
+		target := reflect.ValueOf(entity)
+		if target.Kind() == reflect.Ptr && !target.IsNil() {
+			target.Elem().Set(reflect.ValueOf(state).Elem())
+		}
+	}
+}
+
+// resolveConflicts 重新加载冲突的行并交给 resolver 解决，然后重建更新操作
+func (uow *UnitOfWork) resolveConflicts(entities []Entity, resolver ConflictResolver) error {
+	for _, entity := range entities {
+		current, err := uow.reload(entity)
+		if err != nil {
+			return err
+		}
+
+		uow.mu.RLock()
+		changes := uow.lookupSnapshotManager(entity).GetChangedFields(entity)
+		uow.mu.RUnlock()
+
+		if err := resolver(&Conflict{Entity: entity, Changes: changes, Current: current}); err != nil {
+			return fmt.Errorf("failed to resolve conflict of entity %T with id %v: %w", entity, IdentityOf(entity), err)
+		}
+
+		uow.mu.Lock()
+		if current == nil {
+			uow.discardUpdate(entity)
+			uow.mu.Unlock()
+			continue
+		}
+
+		if revisioned, ok := entity.(HasRevision); ok {
+			revisioned.SetRevision(current.(HasRevision).GetRevision())
+		}
+		uow.snapshotManager.TakeSnapshot(current)
+
+		if !uow.snapshotManager.IsDirty(entity) {
+			uow.discardUpdate(entity)
+		}
+		uow.mu.Unlock()
+	}
+
+	return nil
+}
+
+// reload 从数据库加载实体的最新状态，行已被删除时返回 nil
+func (uow *UnitOfWork) reload(entity Entity) (Entity, error) {
+	if uow.memory != nil {
+		current, err := uow.loadFromMemory(reflect.TypeOf(entity), IdentityOf(entity), false)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to reload entity %T with id %v: %w", entity, IdentityOf(entity), err)
+		}
+		return current, nil
+	}
+
+	current := reflect.New(reflect.TypeOf(entity).Elem()).Interface()
+
+	// 使用屏蔽了工作单元的上下文，避免插件将最新状态纳入身份映射
+	db := uow.db.Session(&gorm.Session{NewDB: true, Context: uow.queryContext()})
+	query, err := whereIdentity(db, current, IdentityOf(entity))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload entity %T with id %v: %w", entity, IdentityOf(entity), err)
+	}
+
+	if err := query.First(current).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to reload entity %T with id %v: %w", entity, IdentityOf(entity), err)
+	}
+
+	return current.(Entity), nil
+}
+
+// discardUpdate 移除实体的更新注册，调用方需持有锁
+func (uow *UnitOfWork) discardUpdate(entity Entity) {
+	if uow.removeFromEntityList(uow.dirtyEntities, entity) {
+		uow.removeOperationByEntity(entity)
+	}
+}
@@ -0,0 +1,150 @@
+package unitofwork
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitOfWork_CommitWithRetry 测试乐观锁冲突自动重试
+func TestUnitOfWork_CommitWithRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	t.Run("以最新状态重新应用本地变更", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+
+		// 新实体在冲突的提交中被回滚，重试时只插入一次
+		require.NoError(t, uow.Create(&User{Name: "李四", Email: "lisi@example.com"}))
+
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 1).Updates(map[string]interface{}{"name": "外部修改", "revision": 2}).Error)
+
+		require.NoError(t, uow.CommitWithRetry(policy, nil))
+		assert.Equal(t, int64(3), user.Revision)
+
+		var saved User
+		require.NoError(t, db.First(&saved, 1).Error)
+		assert.Equal(t, "外部修改", saved.Name)
+		assert.Equal(t, 30, saved.Age)
+		assert.Equal(t, int64(3), saved.Revision)
+
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("解决函数获得本地变更和数据库最新状态", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 3)
+
+		uow := NewUnitOfWork(db)
+		for i := 1; i <= 3; i++ {
+			var user *User
+			require.NoError(t, uow.Find(&user, i))
+			user.Age = 40
+			require.NoError(t, uow.Update(user))
+		}
+
+		require.NoError(t, db.Model(&User{}).Where("id IN ?", []int{1, 3}).Updates(map[string]interface{}{"age": 50, "revision": 2}).Error)
+
+		var conflicts []*Conflict
+		err := uow.CommitWithRetry(policy, func(conflict *Conflict) error {
+			conflicts = append(conflicts, conflict)
+
+			// 年龄取较大值
+			current := conflict.Current.(*User)
+			local := conflict.Entity.(*User)
+			if current.Age > local.Age {
+				local.Age = current.Age
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.Len(t, conflicts, 2)
+		assert.Equal(t, 40, conflicts[0].Changes["Age"].NewValue)
+		assert.Equal(t, 20, conflicts[0].Changes["Age"].OldValue)
+
+		var saved []User
+		require.NoError(t, db.Order("id").Find(&saved).Error)
+		assert.Equal(t, 50, saved[0].Age)
+		assert.Equal(t, int64(3), saved[0].Revision)
+		assert.Equal(t, 40, saved[1].Age)
+		assert.Equal(t, int64(2), saved[1].Revision)
+		assert.Equal(t, 50, saved[2].Age)
+	})
+
+	t.Run("超过最大提交次数后放弃", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 1).Update("revision", 2).Error)
+
+		calls := 0
+		err := uow.CommitWithRetry(RetryPolicy{MaxAttempts: 1}, func(conflict *Conflict) error {
+			calls++
+			return nil
+		})
+
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict))
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, int64(1), user.Revision)
+		assert.False(t, uow.IsCommitted())
+	})
+
+	t.Run("行已被删除时放弃", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+
+		require.NoError(t, db.Unscoped().Delete(&User{}, 1).Error)
+
+		err := uow.CommitWithRetry(policy, nil)
+		assert.ErrorIs(t, err, ErrEntityDeleted)
+	})
+
+	t.Run("接受数据库状态后不再更新", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 1).Updates(map[string]interface{}{"age": 35, "revision": 2}).Error)
+
+		err := uow.CommitWithRetry(policy, func(conflict *Conflict) error {
+			*conflict.Entity.(*User) = *conflict.Current.(*User)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.True(t, uow.IsCommitted())
+
+		var saved User
+		require.NoError(t, db.First(&saved, 1).Error)
+		assert.Equal(t, 35, saved.Age)
+		assert.Equal(t, int64(2), saved.Revision)
+	})
+}
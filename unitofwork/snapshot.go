@@ -1,16 +1,27 @@
 package unitofwork
 
 import (
+	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/unionj-cloud/toolkit/copier"
 )
 
-// EntitySnapshot 实体状态快照，用于脏检查
+// Snapshot 实体状态快照，用于脏检查
+type Snapshot interface {
+	// IsDirty 检查实体是否发生变更
+	IsDirty(entity Entity) bool
+
+	// GetChangedFields 获取发生变更的字段
+	GetChangedFields(entity Entity) map[string]FieldChange
+}
+
+// EntitySnapshot 完整复制所有字段值的实体状态快照
 type EntitySnapshot struct {
 	entityType   reflect.Type
-	entityID     interface{}
+	identity     Identity
 	fieldValues  map[string]interface{}
 	snapshotTime time.Time
 }
@@ -21,7 +32,7 @@ func NewEntitySnapshot(entity Entity) *EntitySnapshot {
 
 	return &EntitySnapshot{
 		entityType:   reflect.TypeOf(entity),
-		entityID:     entity.GetID(),
+		identity:     IdentityOf(entity),
 		fieldValues:  fieldValues,
 		snapshotTime: time.Now(),
 	}
@@ -33,7 +44,7 @@ func (s *EntitySnapshot) IsDirty(entity Entity) bool {
 		return true // 类型不匹配，认为是脏的
 	}
 
-	if s.entityID != entity.GetID() {
+	if !s.identity.Equal(IdentityOf(entity)) {
 		return true // ID不匹配，认为是脏的
 	}
 
@@ -108,10 +119,10 @@ func (s *EntitySnapshot) GetChangedFields(entity Entity) map[string]FieldChange
 
 // FieldChange 字段变更信息
 type FieldChange struct {
-	FieldName string
-	OldValue  interface{}
-	NewValue  interface{}
-	Type      FieldChangeType
+	FieldName string          `json:"field_name"`
+	OldValue  interface{}     `json:"old_value"`
+	NewValue  interface{}     `json:"new_value"`
+	Type      FieldChangeType `json:"type"`
 }
 
 // FieldChangeType 字段变更类型
@@ -137,6 +148,26 @@ func (t FieldChangeType) String() string {
 	}
 }
 
+// MarshalText 以字符串形式序列化字段变更类型
+func (t FieldChangeType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText 从字符串反序列化字段变更类型
+func (t *FieldChangeType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "ADDED":
+		*t = FieldChangeTypeAdded
+	case "MODIFIED":
+		*t = FieldChangeTypeModified
+	case "DELETED":
+		*t = FieldChangeTypeDeleted
+	default:
+		return fmt.Errorf("unknown field change type %q", text)
+	}
+	return nil
+}
+
 // extractFieldValues 提取实体的所有字段值
 func extractFieldValues(entity Entity) map[string]interface{} {
 	fieldValues := make(map[string]interface{})
@@ -150,15 +181,34 @@ func extractFieldValues(entity Entity) map[string]interface{} {
 		return fieldValues
 	}
 
-	entityType := entityValue.Type()
+	for _, field := range snapshotFieldsOf(entityValue.Type()) {
+		fieldValues[field.name] = copyFieldValue(entityValue.Field(field.index))
+	}
 
-	// 遍历所有字段
-	for i := 0; i < entityValue.NumField(); i++ {
-		field := entityValue.Field(i)
-		fieldType := entityType.Field(i)
+	return fieldValues
+}
+
+// snapshotField 参与脏检查的字段
+type snapshotField struct {
+	index int
+	name  string
+}
+
+// snapshotFieldCache 实体类型参与脏检查的字段缓存
+var snapshotFieldCache sync.Map
+
+// snapshotFieldsOf 获取结构体参与脏检查的字段：导出且未标记 unitofwork:"ignore" 的顶层字段
+func snapshotFieldsOf(structType reflect.Type) []snapshotField {
+	if cached, ok := snapshotFieldCache.Load(structType); ok {
+		return cached.([]snapshotField)
+	}
+
+	fields := make([]snapshotField, 0, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i)
 
 		// 跳过非导出字段
-		if !field.CanInterface() {
+		if !fieldType.IsExported() {
 			continue
 		}
 
@@ -167,23 +217,25 @@ func extractFieldValues(entity Entity) map[string]interface{} {
 			continue
 		}
 
-		fieldName := fieldType.Name
-		fieldValue := field.Interface()
+		fields = append(fields, snapshotField{index: i, name: fieldType.Name})
+	}
 
-		// 深拷贝复杂类型
-		if needDeepCopy(field.Type()) {
-			var copiedValue interface{}
-			if err := copier.DeepCopy(fieldValue, &copiedValue); err == nil {
-				fieldValues[fieldName] = copiedValue
-			} else {
-				fieldValues[fieldName] = fieldValue
-			}
-		} else {
-			fieldValues[fieldName] = fieldValue
-		}
+	snapshotFieldCache.Store(structType, fields)
+	return fields
+}
+
+// copyFieldValue 获取字段值，复杂类型深拷贝
+func copyFieldValue(field reflect.Value) interface{} {
+	fieldValue := field.Interface()
+	if !needDeepCopy(field.Type()) {
+		return fieldValue
 	}
 
-	return fieldValues
+	var copiedValue interface{}
+	if err := copier.DeepCopy(fieldValue, &copiedValue); err != nil {
+		return fieldValue
+	}
+	return copiedValue
 }
 
 // needDeepCopy 判断是否需要深拷贝
@@ -224,20 +276,79 @@ func deepEqual(a, b interface{}) bool {
 
 // SnapshotManager 快照管理器
 type SnapshotManager struct {
-	snapshots map[string]*EntitySnapshot
+	snapshots map[string]Snapshot
+	strategy  SnapshotStrategy
+
+	// 快照数量上限，超过时清理实体已不再被引用的快照，零值表示不限制
+	limit     int
+	nextSweep int
+	refs      map[string]weakEntity
+
+	// 快照被清理时的回调，工作单元用于同步移除身份映射中的条目
+	onEvict func(key string)
 }
 
-// NewSnapshotManager 创建快照管理器
+// NewSnapshotManager 创建快照管理器，使用完整复制策略且不限制快照数量
 func NewSnapshotManager() *SnapshotManager {
-	return &SnapshotManager{
-		snapshots: make(map[string]*EntitySnapshot),
+	return newSnapshotManager(FullCopySnapshotStrategy{}, 0)
+}
+
+func newSnapshotManager(strategy SnapshotStrategy, limit int) *SnapshotManager {
+	if strategy == nil {
+		strategy = FullCopySnapshotStrategy{}
+	}
+
+	sm := &SnapshotManager{
+		snapshots: make(map[string]Snapshot),
+		strategy:  strategy,
+		limit:     limit,
+		nextSweep: limit,
 	}
+	if limit > 0 {
+		sm.refs = make(map[string]weakEntity)
+	}
+	return sm
 }
 
 // TakeSnapshot 创建实体快照
 func (sm *SnapshotManager) TakeSnapshot(entity Entity) {
 	key := sm.buildKey(entity)
-	sm.snapshots[key] = NewEntitySnapshot(entity)
+	sm.snapshots[key] = sm.strategy.TakeSnapshot(entity)
+
+	if sm.limit > 0 {
+		sm.refs[key] = makeWeakEntity(entity)
+		if len(sm.snapshots) > sm.nextSweep {
+			sm.sweep()
+		}
+	}
+}
+
+// sweep 清理实体已被回收的快照
+// 仍被引用的实体可能还会被修改，其快照不会被清理；清理后快照数量仍超过上限时，
+// 下一次清理推迟到数量翻倍，避免每次创建快照都遍历所有快照
+func (sm *SnapshotManager) sweep() {
+	for key, ref := range sm.refs {
+		if ref.get() != nil {
+			continue
+		}
+
+		delete(sm.snapshots, key)
+		delete(sm.refs, key)
+		if sm.onEvict != nil {
+			sm.onEvict(key)
+		}
+	}
+
+	sm.nextSweep = sm.limit
+	if len(sm.snapshots)*2 > sm.nextSweep {
+		sm.nextSweep = len(sm.snapshots) * 2
+	}
+}
+
+// HasSnapshot 检查是否存在实体快照
+func (sm *SnapshotManager) HasSnapshot(entity Entity) bool {
+	_, exists := sm.snapshots[sm.buildKey(entity)]
+	return exists
 }
 
 // IsDirty 检查实体是否脏
@@ -266,18 +377,38 @@ func (sm *SnapshotManager) GetChangedFields(entity Entity) map[string]FieldChang
 func (sm *SnapshotManager) RemoveSnapshot(entity Entity) {
 	key := sm.buildKey(entity)
 	delete(sm.snapshots, key)
+	delete(sm.refs, key)
+}
+
+// Len 获取快照数量
+func (sm *SnapshotManager) Len() int {
+	return len(sm.snapshots)
 }
 
 // Clear 清空所有快照
 func (sm *SnapshotManager) Clear() {
-	sm.snapshots = make(map[string]*EntitySnapshot)
+	sm.snapshots = make(map[string]Snapshot)
+	if sm.limit > 0 {
+		sm.refs = make(map[string]weakEntity)
+	}
+	sm.nextSweep = sm.limit
 }
 
 // buildKey 构建实体唯一键
 func (sm *SnapshotManager) buildKey(entity Entity) string {
-	entityType := reflect.TypeOf(entity).String()
-	entityID := entity.GetID()
-	return entityType + "#" + toString(entityID)
+	return identityKey(reflect.TypeOf(entity), IdentityOf(entity))
+}
+
+// Merge 合并其他快照管理器中的快照，已存在的快照保持不变
+func (sm *SnapshotManager) Merge(other *SnapshotManager) {
+	for key, snapshot := range other.snapshots {
+		if _, exists := sm.snapshots[key]; !exists {
+			sm.snapshots[key] = snapshot
+			if ref, tracked := other.refs[key]; tracked && sm.limit > 0 {
+				sm.refs[key] = ref
+			}
+		}
+	}
 }
 
 // toString 将任意类型转换为字符串
@@ -286,5 +417,5 @@ func toString(v interface{}) string {
 		return "nil"
 	}
 
-	return reflect.ValueOf(v).String()
+	return fmt.Sprint(v)
 }
@@ -0,0 +1,211 @@
+package unitofwork
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// SnapshotStrategy 快照策略，决定快照以何种形式保存实体加载时的状态
+type SnapshotStrategy interface {
+	// TakeSnapshot 创建实体快照
+	TakeSnapshot(entity Entity) Snapshot
+}
+
+// FullCopySnapshotStrategy 完整复制所有字段值，内存占用最大，变更记录包含旧值
+type FullCopySnapshotStrategy struct{}
+
+// TakeSnapshot 实现SnapshotStrategy接口
+func (FullCopySnapshotStrategy) TakeSnapshot(entity Entity) Snapshot {
+	return NewEntitySnapshot(entity)
+}
+
+// HashSnapshotStrategy 只保存每个字段编码后的 64 位指纹，内存占用最小，变更记录不包含旧值，
+// 不适用于依赖旧值的审计日志、变更集导出和补偿日志
+type HashSnapshotStrategy struct{}
+
+// TakeSnapshot 实现SnapshotStrategy接口
+func (HashSnapshotStrategy) TakeSnapshot(entity Entity) Snapshot {
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	fields := snapshotFieldsOf(value.Type())
+
+	hashes := make([]uint64, len(fields))
+	for i, field := range fields {
+		hashes[i] = hashFieldValue(value.Field(field.index))
+	}
+
+	return &HashSnapshot{
+		entityType: reflect.TypeOf(entity),
+		identity:   IdentityOf(entity),
+		hashes:     hashes,
+	}
+}
+
+// SerializedSnapshotStrategy 将所有字段编码为一段连续的字节，比较时重新编码当前值，
+// 变更记录的旧值从字节中解码
+type SerializedSnapshotStrategy struct{}
+
+// TakeSnapshot 实现SnapshotStrategy接口
+func (SerializedSnapshotStrategy) TakeSnapshot(entity Entity) Snapshot {
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	fields := snapshotFieldsOf(value.Type())
+
+	var image bytes.Buffer
+	offsets := make([]uint32, 0, len(fields)+1)
+	offsets = append(offsets, 0)
+	for _, field := range fields {
+		encodeFieldValue(&image, value.Field(field.index))
+		offsets = append(offsets, uint32(image.Len()))
+	}
+
+	return &SerializedSnapshot{
+		entityType: reflect.TypeOf(entity),
+		identity:   IdentityOf(entity),
+		image:      image.Bytes(),
+		offsets:    offsets,
+	}
+}
+
+// HashSnapshot 字段指纹快照
+type HashSnapshot struct {
+	entityType reflect.Type
+	identity   Identity
+	hashes     []uint64
+}
+
+// IsDirty 实现Snapshot接口
+func (s *HashSnapshot) IsDirty(entity Entity) bool {
+	if s.entityType != reflect.TypeOf(entity) || !s.identity.Equal(IdentityOf(entity)) {
+		return true
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	for i, field := range snapshotFieldsOf(value.Type()) {
+		if hashFieldValue(value.Field(field.index)) != s.hashes[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// GetChangedFields 实现Snapshot接口，旧值为 nil
+func (s *HashSnapshot) GetChangedFields(entity Entity) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	if s.entityType != reflect.TypeOf(entity) {
+		return changes
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	for i, field := range snapshotFieldsOf(value.Type()) {
+		fieldValue := value.Field(field.index)
+		if hashFieldValue(fieldValue) != s.hashes[i] {
+			changes[field.name] = FieldChange{
+				FieldName: field.name,
+				NewValue:  copyFieldValue(fieldValue),
+				Type:      FieldChangeTypeModified,
+			}
+		}
+	}
+	return changes
+}
+
+// SerializedSnapshot 字节映像快照
+type SerializedSnapshot struct {
+	entityType reflect.Type
+	identity   Identity
+	image      []byte
+	offsets    []uint32
+}
+
+// IsDirty 实现Snapshot接口
+func (s *SerializedSnapshot) IsDirty(entity Entity) bool {
+	if s.entityType != reflect.TypeOf(entity) || !s.identity.Equal(IdentityOf(entity)) {
+		return true
+	}
+
+	var buf bytes.Buffer
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	for i, field := range snapshotFieldsOf(value.Type()) {
+		buf.Reset()
+		encodeFieldValue(&buf, value.Field(field.index))
+		if !bytes.Equal(buf.Bytes(), s.segment(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetChangedFields 实现Snapshot接口，旧值无法解码时为 nil
+func (s *SerializedSnapshot) GetChangedFields(entity Entity) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	if s.entityType != reflect.TypeOf(entity) {
+		return changes
+	}
+
+	var buf bytes.Buffer
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	for i, field := range snapshotFieldsOf(value.Type()) {
+		fieldValue := value.Field(field.index)
+
+		buf.Reset()
+		encodeFieldValue(&buf, fieldValue)
+		if bytes.Equal(buf.Bytes(), s.segment(i)) {
+			continue
+		}
+
+		change := FieldChange{
+			FieldName: field.name,
+			NewValue:  copyFieldValue(fieldValue),
+			Type:      FieldChangeTypeModified,
+		}
+
+		old := reflect.New(fieldValue.Type())
+		if err := msgpack.Unmarshal(s.segment(i), old.Interface()); err == nil {
+			change.OldValue = old.Elem().Interface()
+		}
+
+		changes[field.name] = change
+	}
+	return changes
+}
+
+// segment 获取第 i 个字段的编码
+func (s *SerializedSnapshot) segment(i int) []byte {
+	return s.image[s.offsets[i]:s.offsets[i+1]]
+}
+
+// hashFieldValue 计算字段编码的 FNV-1a 指纹
+func hashFieldValue(value reflect.Value) uint64 {
+	hash := fnv.New64a()
+	encodeFieldValue(hash, value)
+	return hash.Sum64()
+}
+
+// encodeFieldValue 以 msgpack 编码字段值，map 的键排序以保证相同的值编码相同，
+// 无法编码的值使用 fmt 格式化
+func encodeFieldValue(w io.Writer, value reflect.Value) {
+	encoder := msgpack.NewEncoder(w)
+	encoder.SetSortMapKeys(true)
+	if err := encoder.EncodeValue(value); err != nil {
+		_, _ = fmt.Fprintf(w, "%#v", value.Interface())
+	}
+}
+
+// WithSnapshotStrategy 配置快照策略，默认为 FullCopySnapshotStrategy
+func WithSnapshotStrategy(strategy SnapshotStrategy) ConfigOption {
+	return func(c *Config) {
+		c.SnapshotStrategy = strategy
+	}
+}
+
+// WithSnapshotLimit 配置快照数量上限，超过时清理实体已不再被引用的快照
+// 配置后身份映射以弱引用持有实体，调用方不再引用的已加载实体可以被回收
+func WithSnapshotLimit(limit int) ConfigOption {
+	return func(c *Config) {
+		c.SnapshotLimit = limit
+	}
+}
@@ -0,0 +1,132 @@
+package unitofwork
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Profile 带有 map 字段的测试实体，不需要建表
+type Profile struct {
+	BaseEntity
+	Attributes map[string]string
+	Tags       []string
+}
+
+// TestSnapshotStrategy 测试快照策略
+func TestSnapshotStrategy(t *testing.T) {
+	strategies := map[string]SnapshotStrategy{
+		"完整复制": FullCopySnapshotStrategy{},
+		"字段指纹": HashSnapshotStrategy{},
+		"字节映像": SerializedSnapshotStrategy{},
+	}
+
+	for name, strategy := range strategies {
+		strategy := strategy
+		t.Run(name+"策略检测变更字段", func(t *testing.T) {
+			db := setupTestDB()
+			seedUsers(t, db, 2)
+
+			uow := NewUnitOfWork(db, WithSnapshotStrategy(strategy))
+			var changed, unchanged *User
+			require.NoError(t, uow.Find(&changed, 1))
+			require.NoError(t, uow.Find(&unchanged, 2))
+
+			changed.Age = 30
+			require.NoError(t, uow.Update(changed))
+			require.NoError(t, uow.Update(unchanged))
+			assert.Equal(t, 1, uow.GetStats().DirtyEntities)
+
+			changes := uow.snapshotManager.GetChangedFields(changed)
+			require.Contains(t, changes, "Age")
+			assert.Len(t, changes, 1)
+			assert.Equal(t, 30, changes["Age"].NewValue)
+
+			require.NoError(t, uow.Commit())
+
+			var stored User
+			require.NoError(t, db.First(&stored, 1).Error)
+			assert.Equal(t, 30, stored.Age)
+		})
+
+		t.Run(name+"策略对 map 字段的比较与顺序无关", func(t *testing.T) {
+			profile := &Profile{Attributes: map[string]string{}, Tags: []string{"a"}}
+			profile.ID = 1
+			for i := 0; i < 20; i++ {
+				profile.Attributes[string(rune('a'+i))] = "v"
+			}
+
+			snapshot := strategy.TakeSnapshot(profile)
+			assert.False(t, snapshot.IsDirty(profile))
+
+			profile.Attributes["a"] = "changed"
+			profile.Tags = append(profile.Tags, "b")
+			assert.True(t, snapshot.IsDirty(profile))
+
+			changes := snapshot.GetChangedFields(profile)
+			assert.Len(t, changes, 2)
+			assert.Contains(t, changes, "Attributes")
+			assert.Contains(t, changes, "Tags")
+		})
+	}
+
+	t.Run("字节映像策略从快照中解码旧值", func(t *testing.T) {
+		user := &User{Name: "张三", Email: "zhangsan@example.com", Age: 20}
+		user.ID = 1
+
+		snapshot := SerializedSnapshotStrategy{}.TakeSnapshot(user)
+		user.Age = 30
+		user.Name = "李四"
+
+		changes := snapshot.GetChangedFields(user)
+		assert.Equal(t, 20, changes["Age"].OldValue)
+		assert.Equal(t, "张三", changes["Name"].OldValue)
+
+		// 字段指纹策略不保存旧值
+		user.Age = 20
+		user.Name = "张三"
+		snapshot = HashSnapshotStrategy{}.TakeSnapshot(user)
+		user.Age = 30
+		changes = snapshot.GetChangedFields(user)
+		assert.Nil(t, changes["Age"].OldValue)
+		assert.Equal(t, 30, changes["Age"].NewValue)
+	})
+
+	t.Run("超过快照上限时清理不再被引用的实体", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 100)
+
+		uow := NewUnitOfWork(db, WithSnapshotLimit(10), WithSnapshotStrategy(HashSnapshotStrategy{}))
+
+		var kept *User
+		require.NoError(t, uow.Find(&kept, 1))
+		kept.Age = 40
+
+		for i := 2; i <= 100; i++ {
+			var user *User
+			require.NoError(t, uow.Find(&user, i))
+			if i%10 == 0 {
+				runtime.GC()
+			}
+		}
+
+		assert.Less(t, uow.snapshotManager.Len(), 50)
+		assert.Less(t, uow.GetStats().TrackedEntities, 50)
+
+		// 仍被引用的实体保留快照和身份映射
+		var again *User
+		require.NoError(t, uow.Find(&again, 1))
+		assert.Same(t, kept, again)
+		assert.True(t, uow.snapshotManager.HasSnapshot(kept))
+
+		require.NoError(t, uow.Update(kept))
+		assert.Contains(t, uow.snapshotManager.GetChangedFields(kept), "Age")
+		require.NoError(t, uow.Commit())
+
+		var stored User
+		require.NoError(t, db.First(&stored, 1).Error)
+		assert.Equal(t, 40, stored.Age)
+	})
+}
@@ -0,0 +1,363 @@
+package unitofwork
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/gorm/schema"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// RestoreOperation 恢复软删除实体的操作
+type RestoreOperation struct {
+	entity Entity
+}
+
+// NewRestoreOperation 创建恢复操作
+func NewRestoreOperation(entity Entity) *RestoreOperation {
+	return &RestoreOperation{
+		entity: entity,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *RestoreOperation) GetEntityType() reflect.Type {
+	return reflect.TypeOf(op.entity)
+}
+
+// GetOperationType 实现Operation接口
+func (op *RestoreOperation) GetOperationType() OperationType {
+	return OperationTypeRestore
+}
+
+// Execute 实现Operation接口
+func (op *RestoreOperation) Execute(db *gorm.DB) error {
+	return restoreEntities(db, op.GetEntityType(), []Entity{op.entity})
+}
+
+// GetEntity 实现Operation接口
+func (op *RestoreOperation) GetEntity() Entity {
+	return op.entity
+}
+
+// SameIdentity 实现Operation接口
+func (op *RestoreOperation) SameIdentity(other Operation) bool {
+	if other.GetOperationType() != OperationTypeRestore {
+		return false
+	}
+
+	otherEntity := other.GetEntity()
+	if otherEntity == nil {
+		return false
+	}
+
+	return sameEntity(op.entity, otherEntity)
+}
+
+// CanMerge 实现Operation接口
+func (op *RestoreOperation) CanMerge(other Operation) bool {
+	return other.GetOperationType() == OperationTypeRestore &&
+		op.GetEntityType() == other.GetEntityType()
+}
+
+// Merge 实现Operation接口
+func (op *RestoreOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	return NewBulkRestoreOperation([]Entity{op.entity, other.GetEntity()})
+}
+
+// BulkRestoreOperation 批量恢复操作，以一条语句清除所有实体的删除时间
+type BulkRestoreOperation struct {
+	entities   []Entity
+	entityType reflect.Type
+}
+
+// NewBulkRestoreOperation 创建批量恢复操作
+func NewBulkRestoreOperation(entities []Entity) *BulkRestoreOperation {
+	var entityType reflect.Type
+	if len(entities) > 0 {
+		entityType = reflect.TypeOf(entities[0])
+	}
+
+	return &BulkRestoreOperation{
+		entities:   entities,
+		entityType: entityType,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *BulkRestoreOperation) GetEntityType() reflect.Type {
+	return op.entityType
+}
+
+// GetOperationType 实现Operation接口
+func (op *BulkRestoreOperation) GetOperationType() OperationType {
+	return OperationTypeBulkRestore
+}
+
+// Execute 实现Operation接口
+func (op *BulkRestoreOperation) Execute(db *gorm.DB) error {
+	if len(op.entities) == 0 {
+		return nil
+	}
+
+	return restoreEntities(db, op.entityType, op.entities)
+}
+
+// GetEntity 实现Operation接口
+func (op *BulkRestoreOperation) GetEntity() Entity {
+	if len(op.entities) > 0 {
+		return op.entities[0]
+	}
+	return nil
+}
+
+// SameIdentity 实现Operation接口
+func (op *BulkRestoreOperation) SameIdentity(other Operation) bool {
+	return false // 批量操作不参与身份比较
+}
+
+// CanMerge 实现Operation接口
+func (op *BulkRestoreOperation) CanMerge(other Operation) bool {
+	return (other.GetOperationType() == OperationTypeRestore ||
+		other.GetOperationType() == OperationTypeBulkRestore) &&
+		op.GetEntityType() == other.GetEntityType()
+}
+
+// Merge 实现Operation接口
+func (op *BulkRestoreOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	allEntities := append([]Entity{}, op.entities...)
+	allEntities = append(allEntities, operationEntities(other)...)
+	return NewBulkRestoreOperation(allEntities)
+}
+
+// GetEntities 获取所有实体
+func (op *BulkRestoreOperation) GetEntities() []Entity {
+	return op.entities
+}
+
+// PurgeOperation 永久删除操作，软删除的实体也会从数据库中移除
+type PurgeOperation struct {
+	entity Entity
+}
+
+// NewPurgeOperation 创建永久删除操作
+func NewPurgeOperation(entity Entity) *PurgeOperation {
+	return &PurgeOperation{
+		entity: entity,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *PurgeOperation) GetEntityType() reflect.Type {
+	return reflect.TypeOf(op.entity)
+}
+
+// GetOperationType 实现Operation接口
+func (op *PurgeOperation) GetOperationType() OperationType {
+	return OperationTypePurge
+}
+
+// Execute 实现Operation接口
+func (op *PurgeOperation) Execute(db *gorm.DB) error {
+	return purgeEntities(db, op.GetEntityType(), []Entity{op.entity})
+}
+
+// GetEntity 实现Operation接口
+func (op *PurgeOperation) GetEntity() Entity {
+	return op.entity
+}
+
+// SameIdentity 实现Operation接口
+func (op *PurgeOperation) SameIdentity(other Operation) bool {
+	if other.GetOperationType() != OperationTypePurge {
+		return false
+	}
+
+	otherEntity := other.GetEntity()
+	if otherEntity == nil {
+		return false
+	}
+
+	return sameEntity(op.entity, otherEntity)
+}
+
+// CanMerge 实现Operation接口
+func (op *PurgeOperation) CanMerge(other Operation) bool {
+	return other.GetOperationType() == OperationTypePurge &&
+		op.GetEntityType() == other.GetEntityType()
+}
+
+// Merge 实现Operation接口
+func (op *PurgeOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	return NewBulkPurgeOperation([]Entity{op.entity, other.GetEntity()})
+}
+
+// BulkPurgeOperation 批量永久删除操作
+type BulkPurgeOperation struct {
+	entities   []Entity
+	entityType reflect.Type
+}
+
+// NewBulkPurgeOperation 创建批量永久删除操作
+func NewBulkPurgeOperation(entities []Entity) *BulkPurgeOperation {
+	var entityType reflect.Type
+	if len(entities) > 0 {
+		entityType = reflect.TypeOf(entities[0])
+	}
+
+	return &BulkPurgeOperation{
+		entities:   entities,
+		entityType: entityType,
+	}
+}
+
+// GetEntityType 实现Operation接口
+func (op *BulkPurgeOperation) GetEntityType() reflect.Type {
+	return op.entityType
+}
+
+// GetOperationType 实现Operation接口
+func (op *BulkPurgeOperation) GetOperationType() OperationType {
+	return OperationTypeBulkPurge
+}
+
+// Execute 实现Operation接口
+func (op *BulkPurgeOperation) Execute(db *gorm.DB) error {
+	if len(op.entities) == 0 {
+		return nil
+	}
+
+	return purgeEntities(db, op.entityType, op.entities)
+}
+
+// GetEntity 实现Operation接口
+func (op *BulkPurgeOperation) GetEntity() Entity {
+	if len(op.entities) > 0 {
+		return op.entities[0]
+	}
+	return nil
+}
+
+// SameIdentity 实现Operation接口
+func (op *BulkPurgeOperation) SameIdentity(other Operation) bool {
+	return false // 批量操作不参与身份比较
+}
+
+// CanMerge 实现Operation接口
+func (op *BulkPurgeOperation) CanMerge(other Operation) bool {
+	return (other.GetOperationType() == OperationTypePurge ||
+		other.GetOperationType() == OperationTypeBulkPurge) &&
+		op.GetEntityType() == other.GetEntityType()
+}
+
+// Merge 实现Operation接口
+func (op *BulkPurgeOperation) Merge(other Operation) Operation {
+	if !op.CanMerge(other) {
+		return op
+	}
+
+	allEntities := append([]Entity{}, op.entities...)
+	allEntities = append(allEntities, operationEntities(other)...)
+	return NewBulkPurgeOperation(allEntities)
+}
+
+// GetEntities 获取所有实体
+func (op *BulkPurgeOperation) GetEntities() []Entity {
+	return op.entities
+}
+
+// restoreEntities 清除同类型实体的删除时间，有行不存在时返回 gorm.ErrRecordNotFound
+func restoreEntities(db *gorm.DB, entityType reflect.Type, entities []Entity) error {
+	model := reflect.New(entityType.Elem()).Interface()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return fmt.Errorf("failed to parse schema of %s: %w", entityType, err)
+	}
+
+	deletedAt := deletedAtField(stmt.Schema)
+	if deletedAt == nil {
+		return fmt.Errorf("entity %s has no gorm.DeletedAt field", entityType)
+	}
+
+	now := time.Now()
+	values := map[string]interface{}{deletedAt.DBName: nil}
+	identities := make([]Identity, 0, len(entities))
+
+	for _, entity := range entities {
+		softDeletable, ok := entity.(SoftDelete)
+		if !ok {
+			return fmt.Errorf("entity %T does not support soft delete", entity)
+		}
+		softDeletable.SetDeletedAt(gorm.DeletedAt{})
+
+		if timestamped, ok := entity.(HasTimestamps); ok {
+			timestamped.SetUpdatedAt(now)
+			if field := stmt.Schema.LookUpField("UpdatedAt"); field != nil {
+				values[field.DBName] = now
+			}
+		}
+
+		identities = append(identities, IdentityOf(entity))
+	}
+
+	query, err := whereIdentities(db.Unscoped().Model(model), model, identities)
+	if err != nil {
+		return fmt.Errorf("failed to restore entities %s: %w", entityType, err)
+	}
+
+	result := query.UpdateColumns(values)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore entities %s: %w", entityType, result.Error)
+	}
+
+	if !result.DryRun && result.RowsAffected < int64(len(entities)) {
+		return fmt.Errorf("failed to restore entities %s with ids %v: %w", entityType, identities, gorm.ErrRecordNotFound)
+	}
+
+	return nil
+}
+
+// purgeEntities 按主键永久删除同类型实体，不论是否已软删除
+func purgeEntities(db *gorm.DB, entityType reflect.Type, entities []Entity) error {
+	identities := make([]Identity, 0, len(entities))
+	for _, entity := range entities {
+		identities = append(identities, IdentityOf(entity))
+	}
+
+	model := reflect.New(entityType.Elem()).Interface()
+	query, err := whereIdentities(db.Unscoped(), model, identities)
+	if err != nil {
+		return fmt.Errorf("failed to purge entities %s: %w", entityType, err)
+	}
+
+	if err := query.Delete(model).Error; err != nil {
+		return fmt.Errorf("failed to purge entities %s: %w", entityType, err)
+	}
+
+	return nil
+}
+
+// deletedAtField 查找 gorm.DeletedAt 类型的字段
+func deletedAtField(s *schema.Schema) *schema.Field {
+	for _, field := range s.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package unitofwork
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// loadDeletedUsers 加载包括已软删除在内的用户并纳入跟踪
+func loadDeletedUsers(t *testing.T, db *gorm.DB, uow *UnitOfWork) []*User {
+	var users []*User
+	require.NoError(t, db.Unscoped().Order("id").Find(&users).Error)
+	for i, user := range users {
+		users[i] = uow.Attach(user).(*User)
+	}
+	return users
+}
+
+// TestUnitOfWork_RestoreAndPurge 测试恢复和永久删除软删除实体
+func TestUnitOfWork_RestoreAndPurge(t *testing.T) {
+	t.Run("批量恢复软删除的实体", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 3)
+		require.NoError(t, db.Delete(&User{}, []uint{1, 2, 3}).Error)
+
+		uow := NewUnitOfWork(db)
+		users := loadDeletedUsers(t, db, uow)
+		for _, user := range users {
+			require.True(t, user.IsDeleted())
+			require.NoError(t, uow.Restore(user))
+		}
+
+		// 恢复后修改的字段在恢复之后更新
+		users[0].Age = 30
+		require.NoError(t, uow.Update(users[0]))
+
+		plan, err := uow.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 2)
+		assert.Equal(t, "BULK_RESTORE", plan.Operations[0].OperationType)
+		assert.Equal(t, "UPDATE", plan.Operations[1].OperationType)
+
+		require.NoError(t, uow.Commit())
+		assert.False(t, users[1].IsDeleted())
+
+		var restored []User
+		require.NoError(t, db.Order("id").Find(&restored).Error)
+		require.Len(t, restored, 3)
+		assert.Equal(t, 30, restored[0].Age)
+	})
+
+	t.Run("删除后恢复相互抵消", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 2)
+		require.NoError(t, db.Delete(&User{}, 2).Error)
+
+		uow := NewUnitOfWork(db)
+		users := loadDeletedUsers(t, db, uow)
+
+		require.NoError(t, uow.Delete(users[0]))
+		require.NoError(t, uow.Restore(users[0]))
+		require.NoError(t, uow.Restore(users[1]))
+		require.NoError(t, uow.Delete(users[1]))
+		assert.Empty(t, uow.PendingEntities())
+	})
+
+	t.Run("永久删除覆盖其他操作", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 3)
+		require.NoError(t, db.Delete(&User{}, 3).Error)
+
+		uow := NewUnitOfWork(db)
+		users := loadDeletedUsers(t, db, uow)
+
+		users[0].Age = 30
+		require.NoError(t, uow.Update(users[0]))
+		require.NoError(t, uow.Delete(users[1]))
+		require.NoError(t, uow.Restore(users[2]))
+
+		for _, user := range users {
+			require.NoError(t, uow.Purge(user))
+		}
+		assert.Len(t, uow.PendingEntities(OperationTypePurge), 3)
+		assert.Len(t, uow.PendingEntities(), 3)
+
+		assert.Error(t, uow.Update(users[0]))
+		assert.Error(t, uow.Delete(users[1]))
+		assert.Error(t, uow.Restore(users[2]))
+
+		require.NoError(t, uow.Commit())
+
+		var count int64
+		require.NoError(t, db.Unscoped().Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("恢复不存在的行失败", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		users := loadDeletedUsers(t, db, uow)
+		require.NoError(t, db.Unscoped().Delete(&User{}, 1).Error)
+
+		require.NoError(t, uow.Restore(users[0]))
+		assert.ErrorIs(t, uow.Commit(), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("不支持软删除或未持久化的实体不能恢复", func(t *testing.T) {
+		db := setupAggregateTestDB(t)
+		uow := NewUnitOfWork(db)
+
+		assert.Error(t, uow.Restore(&Thread{ID: 1}))
+		assert.Error(t, uow.Restore(&Forum{Name: "go"}))
+	})
+}
+
+// TestPurgeSoftDeleted 测试按保留期限分批永久删除软删除的行
+func TestPurgeSoftDeleted(t *testing.T) {
+	db := setupTestDB()
+	seedUsers(t, db, 6)
+
+	now := time.Now()
+	require.NoError(t, db.Model(&User{}).Where("id <= ?", 5).Update("deleted_at", now.Add(-48*time.Hour)).Error)
+	require.NoError(t, db.Unscoped().Model(&User{}).Where("id = ?", 5).Update("deleted_at", now.Add(-time.Hour)).Error)
+
+	job := NewPurgeJob(db, 24*time.Hour, []Entity{&User{}}, WithPurgeBatchSize(2))
+	purged, err := job.PurgeOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), purged)
+
+	var remaining []User
+	require.NoError(t, db.Unscoped().Order("id").Find(&remaining).Error)
+	require.Len(t, remaining, 2)
+	assert.Equal(t, uint(5), remaining[0].ID)
+	assert.Equal(t, uint(6), remaining[1].ID)
+
+	_, err = PurgeSoftDeleted(context.Background(), db, &Thread{}, now, 10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultMetricsNamespace = "unitofwork"
+
+	// 回滚原因
+	rollbackReasonExplicit     = "explicit"
+	rollbackReasonCommitFailed = "commit_failed"
+)
+
+// Metrics 工作单元 Prometheus 指标，通过 WithMetrics 配置到工作单元
+type Metrics struct {
+	namespace  string
+	registerer prometheus.Registerer
+
+	commitDuration      *prometheus.HistogramVec
+	operationsPerCommit prometheus.Histogram
+	mergedOperations    prometheus.Counter
+	conflicts           prometheus.Counter
+	rollbacks           *prometheus.CounterVec
+}
+
+// MetricsOption 指标配置选项
+type MetricsOption func(*Metrics)
+
+// WithMetricsNamespace 配置指标命名空间，默认为 unitofwork
+func WithMetricsNamespace(namespace string) MetricsOption {
+	return func(m *Metrics) {
+		m.namespace = namespace
+	}
+}
+
+// WithMetricsRegisterer 配置指标注册器，默认为 prometheus.DefaultRegisterer
+func WithMetricsRegisterer(registerer prometheus.Registerer) MetricsOption {
+	return func(m *Metrics) {
+		m.registerer = registerer
+	}
+}
+
+// NewMetrics 创建并注册工作单元指标，同一注册器中只能创建一次
+func NewMetrics(options ...MetricsOption) *Metrics {
+	m := &Metrics{
+		namespace:  defaultMetricsNamespace,
+		registerer: prometheus.DefaultRegisterer,
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	m.commitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: m.namespace,
+			Name:      "commit_duration_seconds",
+			Help:      "Latency of unit of work commits",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	m.operationsPerCommit = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: m.namespace,
+			Name:      "operations_per_commit",
+			Help:      "Number of operations executed per commit after merging",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+	)
+	m.mergedOperations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: m.namespace,
+			Name:      "merged_operations_total",
+			Help:      "Number of registered operations saved by merging and cancellation",
+		},
+	)
+	m.conflicts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: m.namespace,
+			Name:      "optimistic_lock_conflicts_total",
+			Help:      "Number of entities that failed the optimistic lock check",
+		},
+	)
+	m.rollbacks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: m.namespace,
+			Name:      "rollbacks_total",
+			Help:      "Number of rolled back units of work",
+		},
+		[]string{"reason"},
+	)
+
+	m.registerer.MustRegister(m.commitDuration, m.operationsPerCommit, m.mergedOperations, m.conflicts, m.rollbacks)
+
+	return m
+}
+
+// observeCommit 记录提交耗时和冲突，乐观锁冲突后重试的每次提交都会记录
+func (m *Metrics) observeCommit(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	if err == nil {
+		m.commitDuration.WithLabelValues("success").Observe(duration.Seconds())
+		return
+	}
+
+	m.commitDuration.WithLabelValues("failure").Observe(duration.Seconds())
+
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		m.conflicts.Add(float64(len(conflict.Entities)))
+	}
+}
+
+// observeOperations 记录一次提交（包括之前的增量刷新）注册的操作数量和优化后执行的操作数量
+func (m *Metrics) observeOperations(registered, executed int) {
+	if m == nil {
+		return
+	}
+
+	m.operationsPerCommit.Observe(float64(executed))
+	if saved := registered - executed; saved > 0 {
+		m.mergedOperations.Add(float64(saved))
+	}
+}
+
+// observeRollback 按原因记录回滚
+func (m *Metrics) observeRollback(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.rollbacks.WithLabelValues(reason).Inc()
+}
+
+// WithMetrics 配置 Prometheus 指标
+func WithMetrics(metrics *Metrics) ConfigOption {
+	return func(c *Config) {
+		c.Metrics = metrics
+	}
+}
+
+// WithTracer 配置 opentracing 追踪器，未配置时使用 opentracing.GlobalTracer()
+func WithTracer(tracer opentracing.Tracer) ConfigOption {
+	return func(c *Config) {
+		c.Tracer = tracer
+	}
+}
+
+// tracerOf 获取配置的追踪器
+func tracerOf(config *Config) opentracing.Tracer {
+	if config != nil && config.Tracer != nil {
+		return config.Tracer
+	}
+	return opentracing.GlobalTracer()
+}
+
+// startSpan 以上下文中的 span 为父级开启 span
+func startSpan(ctx context.Context, config *Config, operationName string) (opentracing.Span, context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return opentracing.StartSpanFromContextWithTracer(ctx, tracerOf(config), operationName)
+}
+
+// startChildSpan 以提交 span 为父级开启 span，不在提交中时以工作单元的上下文为父级
+func (uow *UnitOfWork) startChildSpan(operationName string) opentracing.Span {
+	if uow.commitSpan != nil {
+		return tracerOf(uow.config).StartSpan(operationName, opentracing.ChildOf(uow.commitSpan.Context()))
+	}
+
+	span, _ := startSpan(uow.ctx, uow.config, operationName)
+	return span
+}
+
+// finishSpan 记录错误并结束 span
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.Error(err))
+	}
+	span.Finish()
+}
+
+// tagOperationSpan 为操作 span 添加实体类型和行数标签
+func tagOperationSpan(span opentracing.Span, operation Operation) {
+	span.SetTag("unitofwork.operation_type", operation.GetOperationType().String())
+	if entityType := operation.GetEntityType(); entityType != nil {
+		span.SetTag("unitofwork.entity_type", entityType.String())
+	}
+	span.SetTag("unitofwork.rows", len(operationEntities(operation)))
+}
+
+// Stats 工作单元统计信息
+type Stats struct {
+	NewEntities      int  `json:"new_entities"`
+	DirtyEntities    int  `json:"dirty_entities"`
+	RemovedEntities  int  `json:"removed_entities"`
+	UpsertedEntities int  `json:"upserted_entities"`
+	RestoredEntities int  `json:"restored_entities"`
+	PurgedEntities   int  `json:"purged_entities"`
+	TotalOperations  int  `json:"total_operations"`
+	TrackedEntities  int  `json:"tracked_entities"`
+	IsCommitted      bool `json:"is_committed"`
+	IsRolledBack     bool `json:"is_rolled_back"`
+	Depth            int  `json:"depth"`
+	ActiveChildren   int  `json:"active_children"`
+}
+
+// GetStats 获取统计信息
+func (uow *UnitOfWork) GetStats() Stats {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	return Stats{
+		NewEntities:      uow.getEntityCountByType(uow.newEntities),
+		DirtyEntities:    uow.getEntityCountByType(uow.dirtyEntities),
+		RemovedEntities:  uow.getEntityCountByType(uow.removedEntities),
+		UpsertedEntities: uow.getEntityCountByType(uow.upsertedEntities),
+		RestoredEntities: uow.getEntityCountByType(uow.restoredEntities),
+		PurgedEntities:   uow.getEntityCountByType(uow.purgedEntities),
+		TotalOperations:  len(uow.operations),
+		TrackedEntities:  uow.identityMap.Len(),
+		IsCommitted:      uow.isCommitted,
+		IsRolledBack:     uow.isRolledBack,
+		Depth:            uow.depth,
+		ActiveChildren:   uow.activeChildren,
+	}
+}
@@ -0,0 +1,156 @@
+package unitofwork
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+)
+
+// TestUnitOfWork_Telemetry 测试提交的追踪和指标
+func TestUnitOfWork_Telemetry(t *testing.T) {
+	t.Run("提交、依赖排序和每个操作都有 span", func(t *testing.T) {
+		db := setupTestDB()
+		tracer := mocktracer.New()
+
+		err := WithUnitOfWork(context.Background(), db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			if err := uow.Create(&User{Name: "张三", Email: "zhangsan@example.com"}); err != nil {
+				return err
+			}
+			if err := uow.Create(&User{Name: "李四", Email: "lisi@example.com"}); err != nil {
+				return err
+			}
+			return uow.Create(&Tag{Name: "go"})
+		}, WithTracer(tracer))
+		require.NoError(t, err)
+
+		spans := make(map[string][]*mocktracer.MockSpan)
+		for _, span := range tracer.FinishedSpans() {
+			spans[span.OperationName] = append(spans[span.OperationName], span)
+		}
+
+		require.Len(t, spans["unitofwork.WithUnitOfWork"], 1)
+		require.Len(t, spans["unitofwork.Commit"], 1)
+		require.Len(t, spans["unitofwork.SortOperations"], 1)
+		require.Len(t, spans["unitofwork.Operation"], 2)
+
+		commit := spans["unitofwork.Commit"][0]
+		assert.Equal(t, spans["unitofwork.WithUnitOfWork"][0].SpanContext.SpanID, commit.ParentID)
+		assert.Equal(t, 3, commit.Tag("unitofwork.operations"))
+
+		rows := make(map[string]interface{})
+		for _, span := range spans["unitofwork.Operation"] {
+			assert.Equal(t, commit.SpanContext.SpanID, span.ParentID)
+			rows[span.Tag("unitofwork.entity_type").(string)] = span.Tag("unitofwork.rows")
+		}
+		assert.Equal(t, map[string]interface{}{"*unitofwork.User": 2, "*unitofwork.Tag": 1}, rows)
+	})
+
+	t.Run("失败的 span 标记错误", func(t *testing.T) {
+		db := setupTestDB()
+		tracer := mocktracer.New()
+		uow := NewUnitOfWork(db, WithTracer(tracer), WithValidation(false))
+
+		require.NoError(t, uow.Create(&User{Name: "张三", Email: "zhangsan@example.com"}))
+		require.NoError(t, db.Create(&User{Name: "李四", Email: "zhangsan@example.com"}).Error)
+		require.Error(t, uow.Commit())
+
+		for _, span := range tracer.FinishedSpans() {
+			if span.OperationName == "unitofwork.SortOperations" {
+				assert.Nil(t, span.Tag("error"))
+				continue
+			}
+			assert.Equal(t, true, span.Tag("error"), span.OperationName)
+		}
+	})
+
+	t.Run("记录提交耗时、合并节省、冲突和回滚", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 2)
+
+		registry := prometheus.NewRegistry()
+		metrics := NewMetrics(WithMetricsRegisterer(registry), WithMetricsNamespace("test"))
+
+		uow := NewUnitOfWork(db, WithMetrics(metrics))
+		var first, second *User
+		require.NoError(t, uow.Find(&first, 1))
+		require.NoError(t, uow.Find(&second, 2))
+		first.Age = 30
+		second.Age = 30
+		require.NoError(t, uow.Update(first))
+		require.NoError(t, uow.Update(second))
+		require.NoError(t, uow.Commit())
+
+		// 两个更新合并为一个批量更新
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.mergedOperations))
+		assert.Equal(t, 1, testutil.CollectAndCount(metrics.commitDuration))
+
+		// 其他事务修改了版本号
+		uow = NewUnitOfWork(db, WithMetrics(metrics))
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 1).Update("revision", 10).Error)
+		user.Age = 40
+		require.NoError(t, uow.Update(user))
+		require.Error(t, uow.Commit())
+		// 提交失败已记录回滚，之后的 Rollback 不再重复记录
+		require.NoError(t, uow.Rollback())
+
+		require.NoError(t, NewUnitOfWork(db, WithMetrics(metrics)).Rollback())
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.conflicts))
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.rollbacks.WithLabelValues("commit_failed")))
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.rollbacks.WithLabelValues("explicit")))
+		assert.Equal(t, 2, testutil.CollectAndCount(metrics.commitDuration))
+
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		names := make([]string, 0, len(families))
+		for _, family := range families {
+			names = append(names, family.GetName())
+		}
+		assert.Contains(t, names, "test_operations_per_commit")
+	})
+
+	t.Run("重试成功的提交不记录回滚，增量刷新与提交合计记录一次操作数量", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		registry := prometheus.NewRegistry()
+		metrics := NewMetrics(WithMetricsRegisterer(registry))
+		uow := NewUnitOfWork(db, WithMetrics(metrics))
+
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		require.NoError(t, db.Model(&User{}).Where("id = ?", 1).Update("revision", 2).Error)
+
+		require.NoError(t, uow.Create(&User{Name: "李四", Email: "lisi@example.com"}))
+		require.NoError(t, uow.Flush())
+
+		user.Age = 30
+		require.NoError(t, uow.Update(user))
+		require.NoError(t, uow.CommitWithRetry(RetryPolicy{MaxAttempts: 3}, nil))
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(metrics.conflicts))
+		assert.Zero(t, testutil.ToFloat64(metrics.rollbacks.WithLabelValues("commit_failed")))
+		assert.Equal(t, 2, testutil.CollectAndCount(metrics.commitDuration))
+
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		var samples uint64
+		var sum float64
+		for _, family := range families {
+			if family.GetName() == "unitofwork_operations_per_commit" {
+				samples = family.GetMetric()[0].GetHistogram().GetSampleCount()
+				sum = family.GetMetric()[0].GetHistogram().GetSampleSum()
+			}
+		}
+		assert.Equal(t, uint64(1), samples)
+		assert.Equal(t, float64(2), sum)
+	})
+}
@@ -0,0 +1,75 @@
+package unitofwork
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/tenant"
+)
+
+// stampTenant 将上下文中的租户写入待插入的实体，实体已属于其他租户时返回 tenant.ErrCrossTenant
+func (uow *UnitOfWork) stampTenant(entity Entity) error {
+	if err := tenant.Stamp(uow.ctx, uow.db, entity, uow.config.TenantColumn); err != nil {
+		return fmt.Errorf("cannot create entity %T: %w", entity, err)
+	}
+	return nil
+}
+
+// checkTenant 拒绝更新、删除、恢复或永久删除其他租户的行
+// 实体的租户字段可能被调用方改写，因此同时检查存储中该行的租户，调用方需持有锁
+func (uow *UnitOfWork) checkTenant(entity Entity, action string) error {
+	err := tenant.Check(uow.ctx, uow.db, entity, uow.config.TenantColumn)
+	if err == nil {
+		err = uow.checkStoredTenant(entity)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot %s entity %T with id %v: %w", action, entity, IdentityOf(entity), err)
+	}
+	return nil
+}
+
+// checkStoredTenant 按主键读取存储中的行（包括已软删除的行）并检查其租户，行不存在时不做检查
+func (uow *UnitOfWork) checkStoredTenant(entity Entity) error {
+	entityType := reflect.TypeOf(entity)
+	if _, ok := tenant.FromContext(uow.ctx); !ok || tenant.FieldOf(uow.db, entityType, uow.config.TenantColumn) == nil {
+		return nil
+	}
+
+	var stored interface{}
+	var err error
+	if uow.memory != nil {
+		stored, err = uow.memory.load(entityType, IdentityOf(entity), true, uow.flushed || uow.parentFlushed)
+	} else {
+		loaded := reflect.New(reflect.Indirect(reflect.ValueOf(entity)).Type()).Interface()
+		query, queryErr := whereIdentity(uow.db.WithContext(tenant.SkipScope(uow.queryContext())), loaded, IdentityOf(entity))
+		if queryErr != nil {
+			return queryErr
+		}
+		stored, err = loaded, query.Unscoped().Take(loaded).Error
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load stored tenant: %w", err)
+	}
+
+	return tenant.Check(uow.ctx, uow.db, stored, uow.config.TenantColumn)
+}
+
+// visibleToTenant 检查加载的行是否属于上下文中的租户，其他租户的行视为不存在
+func (uow *UnitOfWork) visibleToTenant(entity Entity) bool {
+	return tenant.Check(uow.ctx, uow.db, entity, uow.config.TenantColumn) == nil
+}
+
+// WithTenantColumn 配置租户列名，工作单元从 WithContext 设置的上下文中读取 tenant.WithTenant 写入的租户，
+// 插入时写入租户，拒绝更新或删除其他租户的行
+func WithTenantColumn(column string) ConfigOption {
+	return func(c *Config) {
+		c.TenantColumn = column
+	}
+}
@@ -0,0 +1,157 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+
+	"github.com/unionj-cloud/toolkit/tenant"
+)
+
+// 示例实体：按租户隔离的发票
+type Invoice struct {
+	BaseEntity
+	TenantID uint   `gorm:"index" json:"tenant_id"`
+	Number   string `gorm:"size:64" json:"number"`
+}
+
+func (i *Invoice) GetTableName() string {
+	return "invoices"
+}
+
+func setupTenantTestDB(t *testing.T) *gorm.DB {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&Invoice{}))
+	require.NoError(t, db.Create(&[]Invoice{
+		{TenantID: 1, Number: "INV-1"},
+		{TenantID: 2, Number: "INV-2"},
+	}).Error)
+	return db
+}
+
+// TestUnitOfWork_Tenant 测试多租户隔离
+func TestUnitOfWork_Tenant(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), uint(1))
+
+	t.Run("插入时写入上下文中的租户", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+		uow := NewUnitOfWork(db).WithContext(ctx)
+
+		invoice := &Invoice{Number: "INV-3"}
+		require.NoError(t, uow.Create(invoice))
+		assert.Equal(t, uint(1), invoice.TenantID)
+
+		err := uow.Create(&Invoice{TenantID: 2, Number: "INV-4"})
+		assert.True(t, errors.Is(err, tenant.ErrCrossTenant))
+
+		require.NoError(t, uow.Upsert(&Invoice{Number: "INV-5"}))
+		require.NoError(t, uow.Commit())
+
+		var count int64
+		require.NoError(t, db.Model(&Invoice{}).Where("tenant_id = ?", 1).Count(&count).Error)
+		assert.Equal(t, int64(3), count)
+	})
+
+	t.Run("拒绝更新或删除其他租户的行", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+
+		var other Invoice
+		require.NoError(t, db.Where("tenant_id = ?", 2).First(&other).Error)
+
+		uow := NewUnitOfWork(db).WithContext(ctx)
+		attached := uow.Attach(&other).(*Invoice)
+		attached.Number = "changed"
+
+		assert.True(t, errors.Is(uow.Update(attached), tenant.ErrCrossTenant))
+		assert.True(t, errors.Is(uow.Delete(attached), tenant.ErrCrossTenant))
+		assert.True(t, errors.Is(uow.Purge(attached), tenant.ErrCrossTenant))
+		assert.Zero(t, uow.GetStats().TotalOperations)
+
+		// 改写实体的租户字段不能绕过检查
+		uow = NewUnitOfWork(db).WithContext(ctx)
+		forged := &Invoice{BaseEntity: BaseEntity{ID: other.ID}, TenantID: 1, Number: "forged"}
+		assert.True(t, errors.Is(uow.Update(forged), tenant.ErrCrossTenant))
+		assert.True(t, errors.Is(uow.Delete(forged), tenant.ErrCrossTenant))
+		assert.Zero(t, uow.GetStats().TotalOperations)
+	})
+
+	t.Run("内存存储同样检查存储中的租户", func(t *testing.T) {
+		store := NewMemoryStore()
+		require.NoError(t, store.Seed(&Invoice{BaseEntity: BaseEntity{ID: 2}, TenantID: 2, Number: "INV-2"}))
+
+		uow := NewMemoryUnitOfWork(store).WithContext(ctx)
+		forged := &Invoice{BaseEntity: BaseEntity{ID: 2}, TenantID: 1, Number: "forged"}
+		assert.True(t, errors.Is(uow.Update(forged), tenant.ErrCrossTenant))
+	})
+
+	t.Run("其他租户的行视为不存在", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+		uow := NewUnitOfWork(db).WithContext(ctx)
+
+		var own, other *Invoice
+		require.NoError(t, uow.Find(&own, 1))
+		err := uow.Find(&other, 2)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+		// 跳过租户隔离的上下文可以访问所有租户
+		admin := NewUnitOfWork(db).WithContext(tenant.SkipScope(ctx))
+		require.NoError(t, admin.Find(&other, 2))
+		other.Number = "changed"
+		require.NoError(t, admin.Update(other))
+		require.NoError(t, admin.Commit())
+	})
+
+	t.Run("查询使用工作单元的上下文", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		var own *Invoice
+		err := NewUnitOfWork(db).WithContext(cancelled).Find(&own, 1)
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("插件为工作单元的写入添加租户条件", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+		require.NoError(t, db.Use(tenant.NewPlugin()))
+
+		err := WithUnitOfWork(ctx, db, func(tx *gorm.DB, uow *UnitOfWork) error {
+			var invoices []*Invoice
+			if err := tx.Find(&invoices).Error; err != nil {
+				return err
+			}
+			require.Len(t, invoices, 1)
+
+			return uow.Create(&Invoice{Number: "INV-3"})
+		})
+		require.NoError(t, err)
+
+		var invoices []Invoice
+		require.NoError(t, db.WithContext(ctx).Find(&invoices).Error)
+		assert.Len(t, invoices, 2)
+	})
+
+	t.Run("插件下批量更新同一租户的多行", func(t *testing.T) {
+		db := setupTenantTestDB(t)
+		require.NoError(t, db.Create(&Invoice{TenantID: 1, Number: "INV-3"}).Error)
+		require.NoError(t, db.Use(tenant.NewPlugin()))
+
+		uow := NewUnitOfWork(db, WithDirtyCheck(false)).WithContext(ctx)
+		var first, third *Invoice
+		require.NoError(t, uow.Find(&first, 1))
+		require.NoError(t, uow.Find(&third, 3))
+		first.Number = "INV-1-changed"
+		third.Number = "INV-3-changed"
+		require.NoError(t, uow.Update(first))
+		require.NoError(t, uow.Update(third))
+		require.NoError(t, uow.Commit())
+
+		var numbers []string
+		require.NoError(t, db.WithContext(ctx).Model(&Invoice{}).Order("id").Pluck("number", &numbers).Error)
+		assert.Equal(t, []string{"INV-1-changed", "INV-3-changed"}, numbers)
+	})
+}
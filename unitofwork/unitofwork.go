@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
 	"github.com/wubin1989/gorm"
 
 	"github.com/unionj-cloud/toolkit/zlogger"
@@ -17,14 +18,23 @@ type UnitOfWork struct {
 	// 数据库连接
 	db *gorm.DB
 
+	// 内存存储，不为 nil 时操作应用到内存存储而不是数据库
+	memory *MemoryStore
+
 	// 状态管理
-	newEntities     map[reflect.Type][]Entity
-	dirtyEntities   map[reflect.Type][]Entity
-	removedEntities map[reflect.Type][]Entity
+	newEntities      map[reflect.Type][]Entity
+	dirtyEntities    map[reflect.Type][]Entity
+	removedEntities  map[reflect.Type][]Entity
+	upsertedEntities map[reflect.Type][]Entity
+	restoredEntities map[reflect.Type][]Entity
+	purgedEntities   map[reflect.Type][]Entity
 
 	// 快照管理器
 	snapshotManager *SnapshotManager
 
+	// 身份映射，子工作单元与父工作单元共享
+	identityMap *IdentityMap
+
 	// 依赖管理器
 	dependencyManager *DependencyManager
 
@@ -44,6 +54,34 @@ type UnitOfWork struct {
 
 	// 上下文
 	ctx context.Context
+
+	// 正在进行的提交的 span，操作和依赖排序的 span 以其为父级
+	commitSpan opentracing.Span
+
+	// 生命周期监听器，插件的全局监听器在前
+	listeners []Listener
+
+	// 嵌套工作单元
+	parent         *UnitOfWork
+	depth          int
+	savepoint      string
+	childSeq       int
+	activeChildren int
+
+	// 增量刷新：flushed 表示已开启增量刷新的事务或保存点，ownsTx 表示该事务由工作单元自行开启，
+	// parentFlushed 表示祖先工作单元的增量刷新事务仍在进行中，memorySavepoint 为内存存储首次刷新前的工作副本
+	flushed         bool
+	ownsTx          bool
+	parentFlushed   bool
+	memorySavepoint *memoryState
+
+	// 所属的全局事务，由协调器在分支事务中执行，不能提前刷新或开启子工作单元
+	globalTransaction string
+
+	// 指标：已执行的刷新累计注册和执行的操作数量在提交成功时记录，commitFailed 表示提交失败已记录为回滚并通知监听器
+	registeredOperations int
+	executedOperations   int
+	commitFailed         bool
 }
 
 // Config 工作单元配置
@@ -63,6 +101,47 @@ type Config struct {
 
 	// 是否启用详细日志
 	EnableDetailLog bool
+
+	// 发件箱表名，为空时使用 DefaultOutboxTable
+	OutboxTable string
+
+	// 审计记录接收器
+	AuditSinks []AuditSink
+
+	// 是否根据 gorm schema 的关联关系自动发现实体依赖
+	EnableAutoDependency bool
+
+	// 是否在插入存在依赖环时延迟可为空的外键，先插入再回填
+	EnableCycleBreaking bool
+
+	// 删除聚合时级联的最大关联层数，零值表示不限制
+	CascadeDepth int
+
+	// 是否在执行任何操作之前校验所有待写入的实体
+	EnableValidation bool
+
+	// 生命周期监听器
+	Listeners []Listener
+
+	// Prometheus 指标，为 nil 时不记录
+	Metrics *Metrics
+
+	// opentracing 追踪器，为 nil 时使用 opentracing.GlobalTracer()
+	Tracer opentracing.Tracer
+
+	// 快照策略，为 nil 时完整复制所有字段值
+	SnapshotStrategy SnapshotStrategy
+
+	// 快照数量上限，超过时清理实体已不再被引用的快照
+	// 零值表示不限制
+	SnapshotLimit int
+
+	// 待刷新的实体数量达到该值时自动执行 Flush
+	// 零值表示不自动刷新
+	AutoFlushThreshold int
+
+	// 租户列名或字段名，为空时使用 tenant.DefaultColumn
+	TenantColumn string
 }
 
 // DefaultConfig 默认配置
@@ -73,6 +152,9 @@ func DefaultConfig() *Config {
 		EnableOperationMerge: true,
 		MaxEntityCount:       0,
 		EnableDetailLog:      false,
+		OutboxTable:          DefaultOutboxTable,
+		EnableAutoDependency: true,
+		EnableValidation:     true,
 	}
 }
 
@@ -83,17 +165,39 @@ func NewUnitOfWork(db *gorm.DB, options ...ConfigOption) *UnitOfWork {
 		option(config)
 	}
 
-	return &UnitOfWork{
+	identityMap := NewIdentityMap()
+	if config.SnapshotLimit > 0 {
+		identityMap = newWeakIdentityMap()
+	}
+
+	uow := &UnitOfWork{
 		db:                db,
 		newEntities:       make(map[reflect.Type][]Entity),
 		dirtyEntities:     make(map[reflect.Type][]Entity),
 		removedEntities:   make(map[reflect.Type][]Entity),
-		snapshotManager:   NewSnapshotManager(),
+		upsertedEntities:  make(map[reflect.Type][]Entity),
+		restoredEntities:  make(map[reflect.Type][]Entity),
+		purgedEntities:    make(map[reflect.Type][]Entity),
+		identityMap:       identityMap,
 		dependencyManager: DefaultDependencyManager(),
 		operations:        make([]Operation, 0),
 		config:            config,
 		ctx:               context.Background(),
+		listeners:         append(pluginListeners(db), config.Listeners...),
+	}
+	uow.snapshotManager = uow.newSnapshotManager()
+	registerPluginDependencies(db, uow.dependencyManager)
+
+	return uow
+}
+
+// newSnapshotManager 按配置创建快照管理器，快照被清理时同步移除身份映射中的条目
+func (uow *UnitOfWork) newSnapshotManager() *SnapshotManager {
+	snapshotManager := newSnapshotManager(uow.config.SnapshotStrategy, uow.config.SnapshotLimit)
+	if uow.config.SnapshotLimit > 0 {
+		snapshotManager.onEvict = uow.identityMap.removeKey
 	}
+	return snapshotManager
 }
 
 // ConfigOption 配置选项函数
@@ -134,14 +238,122 @@ func WithDetailLog(enabled bool) ConfigOption {
 	}
 }
 
+// WithAutoDependency 配置依赖自动发现
+func WithAutoDependency(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.EnableAutoDependency = enabled
+	}
+}
+
+// WithCycleBreaking 配置依赖环打破
+func WithCycleBreaking(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.EnableCycleBreaking = enabled
+	}
+}
+
+// WithCascadeDepth 配置删除聚合时级联的最大关联层数
+func WithCascadeDepth(depth int) ConfigOption {
+	return func(c *Config) {
+		c.CascadeDepth = depth
+	}
+}
+
+// WithOutboxTable 配置发件箱表名
+func WithOutboxTable(table string) ConfigOption {
+	return func(c *Config) {
+		c.OutboxTable = table
+	}
+}
+
 // WithContext 设置上下文
 func (uow *UnitOfWork) WithContext(ctx context.Context) *UnitOfWork {
 	uow.ctx = ctx
 	return uow
 }
 
+// Begin 开启子工作单元
+// 子工作单元拥有独立的操作队列和快照，提交时在 SAVEPOINT 下刷新自身操作，
+// 回滚时只丢弃自身的变更，父工作单元不受影响
+//
+// 父工作单元不在事务中时，与首次 Flush 相同地开启事务，已在事务中时创建保存点，
+// 父工作单元回滚时撤销已提交的子工作单元的变更，因此开启子工作单元后父工作单元必须提交或回滚
+func (uow *UnitOfWork) Begin() (*UnitOfWork, error) {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return nil, fmt.Errorf("unit of work is already finished")
+	}
+
+	if uow.globalTransaction != "" {
+		return nil, fmt.Errorf("cannot begin child unit of work in global transaction %s", uow.globalTransaction)
+	}
+
+	if err := uow.beginFlush(); err != nil {
+		return nil, fmt.Errorf("failed to begin child unit of work: %w", err)
+	}
+
+	uow.childSeq++
+	uow.activeChildren++
+
+	prefix := uow.savepoint
+	if prefix == "" {
+		prefix = "uow_sp"
+	}
+
+	child := &UnitOfWork{
+		db:                uow.db,
+		memory:            uow.memory,
+		newEntities:       make(map[reflect.Type][]Entity),
+		dirtyEntities:     make(map[reflect.Type][]Entity),
+		removedEntities:   make(map[reflect.Type][]Entity),
+		upsertedEntities:  make(map[reflect.Type][]Entity),
+		restoredEntities:  make(map[reflect.Type][]Entity),
+		purgedEntities:    make(map[reflect.Type][]Entity),
+		identityMap:       uow.identityMap,
+		dependencyManager: uow.dependencyManager,
+		operations:        make([]Operation, 0),
+		config:            uow.config,
+		ctx:               uow.ctx,
+		listeners:         append([]Listener(nil), uow.listeners...),
+		parent:            uow,
+		depth:             uow.depth + 1,
+		savepoint:         fmt.Sprintf("%s_%d", prefix, uow.childSeq),
+		parentFlushed:     uow.flushed || uow.parentFlushed,
+	}
+	child.snapshotManager = child.newSnapshotManager()
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Int("depth", child.depth).
+			Str("savepoint", child.savepoint).
+			Msg("Began child unit of work")
+	}
+
+	return child, nil
+}
+
+// Parent 获取父工作单元，顶层工作单元返回nil
+func (uow *UnitOfWork) Parent() *UnitOfWork {
+	return uow.parent
+}
+
+// Depth 获取嵌套深度，顶层工作单元为0
+func (uow *UnitOfWork) Depth() int {
+	return uow.depth
+}
+
 // Create 注册新实体
 func (uow *UnitOfWork) Create(entity Entity) error {
+	if err := uow.create(entity); err != nil {
+		return err
+	}
+	return uow.autoFlush()
+}
+
+// create 注册新实体，注册后由 Create 检查是否需要自动刷新
+func (uow *UnitOfWork) create(entity Entity) error {
 	uow.mu.Lock()
 	defer uow.mu.Unlock()
 
@@ -160,19 +372,25 @@ func (uow *UnitOfWork) Create(entity Entity) error {
 		return fmt.Errorf("entity is already marked as dirty")
 	}
 
-	if uow.containsEntity(uow.removedEntities, entity) {
+	if uow.containsEntity(uow.removedEntities, entity) || uow.containsEntity(uow.purgedEntities, entity) {
 		return fmt.Errorf("entity is already marked for removal")
 	}
 
-	if uow.containsEntity(uow.newEntities, entity) {
+	if uow.containsEntity(uow.newEntities, entity) || uow.containsEntity(uow.upsertedEntities, entity) {
 		return nil
 	}
 
+	if err := uow.stampTenant(entity); err != nil {
+		return err
+	}
+
 	// 检查内存限制
 	if err := uow.checkMemoryLimit(); err != nil {
 		return err
 	}
 
+	uow.discoverDependencies(entityType)
+
 	// 添加到新实体列表
 	uow.newEntities[entityType] = append(uow.newEntities[entityType], entity)
 
@@ -183,7 +401,7 @@ func (uow *UnitOfWork) Create(entity Entity) error {
 	if uow.config.EnableDetailLog {
 		zlogger.Info().
 			Str("entity_type", entityType.String()).
-			Interface("entity_id", entity.GetID()).
+			Str("entity_id", IdentityOf(entity).String()).
 			Msg("Registered new entity")
 	}
 
@@ -192,6 +410,14 @@ func (uow *UnitOfWork) Create(entity Entity) error {
 
 // Update 注册脏实体
 func (uow *UnitOfWork) Update(entity Entity) error {
+	if err := uow.update(entity); err != nil {
+		return err
+	}
+	return uow.autoFlush()
+}
+
+// update 注册脏实体，注册后由 Update 检查是否需要自动刷新
+func (uow *UnitOfWork) update(entity Entity) error {
 	uow.mu.Lock()
 	defer uow.mu.Unlock()
 
@@ -205,13 +431,21 @@ func (uow *UnitOfWork) Update(entity Entity) error {
 
 	entityType := reflect.TypeOf(entity)
 
-	// 如果是新实体，不需要标记为脏
-	if uow.containsEntity(uow.newEntities, entity) {
+	// 如果是新实体或待插入或更新的实体，不需要标记为脏
+	if uow.containsEntity(uow.newEntities, entity) || uow.containsEntity(uow.upsertedEntities, entity) {
 		return nil
 	}
 
+	if err := uow.checkIdentity(entity); err != nil {
+		return err
+	}
+
+	if err := uow.checkTenant(entity, "update"); err != nil {
+		return err
+	}
+
 	// 检查是否已经标记为删除
-	if uow.containsEntity(uow.removedEntities, entity) {
+	if uow.containsEntity(uow.removedEntities, entity) || uow.containsEntity(uow.purgedEntities, entity) {
 		return fmt.Errorf("cannot mark removed entity as dirty")
 	}
 
@@ -220,87 +454,533 @@ func (uow *UnitOfWork) Update(entity Entity) error {
 		return nil
 	}
 
-	// 脏检查
-	var changes map[string]FieldChange
-	if uow.config.EnableDirtyCheck {
-		if !uow.snapshotManager.IsDirty(entity) {
-			return nil // 实际上没有变更
-		}
-		changes = uow.snapshotManager.GetChangedFields(entity)
+	// 祖先工作单元中待插入的实体会以最新状态插入，无需更新
+	if uow.isNewInAncestors(entity) {
+		return nil
+	}
+
+	// 脏检查
+	var changes map[string]FieldChange
+	if uow.config.EnableDirtyCheck {
+		snapshotManager := uow.lookupSnapshotManager(entity)
+		if !snapshotManager.IsDirty(entity) {
+			return nil // 实际上没有变更
+		}
+		changes = snapshotManager.GetChangedFields(entity)
+	}
+
+	uow.discoverDependencies(entityType)
+
+	// 添加到脏实体列表
+	uow.dirtyEntities[entityType] = append(uow.dirtyEntities[entityType], entity)
+
+	// 添加操作
+	operation := NewUpdateOperation(entity, changes)
+	operation.uow = uow
+	uow.addOperation(operation)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Str("entity_type", entityType.String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Int("changed_fields", len(changes)).
+			Msg("Registered dirty entity")
+	}
+
+	return nil
+}
+
+// Delete 注册删除实体
+func (uow *UnitOfWork) Delete(entity Entity) error {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	if entity == nil {
+		return fmt.Errorf("entity cannot be nil")
+	}
+
+	return uow.delete(entity, 0)
+}
+
+// delete 注册删除实体，depth 为实体在删除的聚合中的层级，调用方需持有锁
+func (uow *UnitOfWork) delete(entity Entity, depth int) error {
+	entityType := reflect.TypeOf(entity)
+
+	// 如果是新实体，直接从新实体列表移除
+	if uow.removeFromEntityList(uow.newEntities, entity) {
+		uow.removeOperationByEntity(entity)
+		if uow.config.EnableDetailLog {
+			zlogger.Info().
+				Str("entity_type", entityType.String()).
+				Str("entity_id", IdentityOf(entity).String()).
+				Msg("Removed new entity from registration")
+		}
+		return nil
+	}
+
+	if uow.isNewInAncestors(entity) {
+		return fmt.Errorf("entity is pending creation in parent unit of work")
+	}
+
+	if err := uow.checkIdentity(entity); err != nil {
+		return err
+	}
+
+	if err := uow.checkTenant(entity, "delete"); err != nil {
+		return err
+	}
+
+	if uow.containsEntity(uow.purgedEntities, entity) {
+		return fmt.Errorf("entity is already marked for purge")
+	}
+
+	// 恢复后再删除，两者相互抵消
+	if uow.removeFromEntityList(uow.restoredEntities, entity) {
+		uow.removeOperationByEntity(entity)
+		if uow.config.EnableDetailLog {
+			zlogger.Info().
+				Str("entity_type", entityType.String()).
+				Str("entity_id", IdentityOf(entity).String()).
+				Msg("Canceled restore of entity")
+		}
+		return nil
+	}
+
+	// 从脏实体列表和插入或更新列表移除
+	uow.removeFromEntityList(uow.dirtyEntities, entity)
+	uow.removeFromEntityList(uow.upsertedEntities, entity)
+	uow.removeOperationByEntity(entity)
+
+	uow.discoverDependencies(entityType)
+
+	// 添加到删除列表
+	uow.removedEntities[entityType] = append(uow.removedEntities[entityType], entity)
+
+	// 添加操作
+	operation := NewDeleteOperation(entity)
+	operation.depth = depth
+	uow.addOperation(operation)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Str("entity_type", entityType.String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Msg("Registered entity for removal")
+	}
+
+	return nil
+}
+
+// Restore 注册恢复软删除的实体，实体必须实现 SoftDelete
+// 软删除的行可通过 db.Unscoped() 加载后使用 Attach 纳入跟踪；同一工作单元内删除后再恢复，两者相互抵消
+func (uow *UnitOfWork) Restore(entity Entity) error {
+	if err := uow.restore(entity); err != nil {
+		return err
+	}
+	return uow.autoFlush()
+}
+
+// restore 注册恢复软删除的实体，注册后由 Restore 检查是否需要自动刷新
+func (uow *UnitOfWork) restore(entity Entity) error {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	if entity == nil {
+		return fmt.Errorf("entity cannot be nil")
+	}
+
+	entityType := reflect.TypeOf(entity)
+
+	if _, ok := entity.(SoftDelete); !ok {
+		return fmt.Errorf("entity %s does not support soft delete", entityType)
+	}
+
+	if entity.IsNew() || uow.containsEntity(uow.newEntities, entity) || uow.containsEntity(uow.upsertedEntities, entity) {
+		return fmt.Errorf("cannot restore entity that is not persisted")
+	}
+
+	if uow.containsEntity(uow.purgedEntities, entity) {
+		return fmt.Errorf("entity is already marked for purge")
+	}
+
+	if uow.containsEntity(uow.restoredEntities, entity) {
+		return nil
+	}
+
+	if err := uow.checkIdentity(entity); err != nil {
+		return err
+	}
+
+	if err := uow.checkTenant(entity, "restore"); err != nil {
+		return err
+	}
+
+	// 删除后再恢复，两者相互抵消
+	if uow.removeFromEntityList(uow.removedEntities, entity) {
+		uow.removeOperationByEntity(entity)
+		if uow.config.EnableDetailLog {
+			zlogger.Info().
+				Str("entity_type", entityType.String()).
+				Str("entity_id", IdentityOf(entity).String()).
+				Msg("Canceled removal of entity")
+		}
+		return nil
+	}
+
+	if err := uow.checkMemoryLimit(); err != nil {
+		return err
+	}
+
+	uow.discoverDependencies(entityType)
+
+	// 添加到恢复列表
+	uow.restoredEntities[entityType] = append(uow.restoredEntities[entityType], entity)
+
+	// 添加操作
+	operation := NewRestoreOperation(entity)
+	uow.addOperation(operation)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Str("entity_type", entityType.String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Msg("Registered entity for restore")
+	}
+
+	return nil
+}
+
+// Purge 注册永久删除实体，不论实体是否实现 SoftDelete 或已被软删除，都从数据库中移除对应的行
+// 同一实体已注册的更新、删除、恢复被永久删除覆盖
+func (uow *UnitOfWork) Purge(entity Entity) error {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	if entity == nil {
+		return fmt.Errorf("entity cannot be nil")
+	}
+
+	entityType := reflect.TypeOf(entity)
+
+	// 如果是新实体，直接从新实体列表移除
+	if uow.removeFromEntityList(uow.newEntities, entity) {
+		uow.removeOperationByEntity(entity)
+		return nil
+	}
+
+	if uow.isNewInAncestors(entity) {
+		return fmt.Errorf("entity is pending creation in parent unit of work")
+	}
+
+	if err := uow.checkIdentity(entity); err != nil {
+		return err
+	}
+
+	if err := uow.checkTenant(entity, "purge"); err != nil {
+		return err
+	}
+
+	if uow.containsEntity(uow.purgedEntities, entity) {
+		return nil
+	}
+
+	// 从其他状态列表移除
+	uow.removeFromEntityList(uow.dirtyEntities, entity)
+	uow.removeFromEntityList(uow.upsertedEntities, entity)
+	uow.removeFromEntityList(uow.removedEntities, entity)
+	uow.removeFromEntityList(uow.restoredEntities, entity)
+	uow.removeOperationByEntity(entity)
+
+	uow.discoverDependencies(entityType)
+
+	// 添加到永久删除列表
+	uow.purgedEntities[entityType] = append(uow.purgedEntities[entityType], entity)
+
+	// 添加操作
+	operation := NewPurgeOperation(entity)
+	uow.addOperation(operation)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Str("entity_type", entityType.String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Msg("Registered entity for purge")
+	}
+
+	return nil
+}
+
+// Upsert 注册插入或更新实体，提交时以 conflictColumns 判断冲突，冲突时更新其余列
+// conflictColumns 可以是列名或字段名，为空时以主键判断冲突。已注册为新增或脏实体的实体改为插入或更新
+func (uow *UnitOfWork) Upsert(entity Entity, conflictColumns ...string) error {
+	if err := uow.upsert(entity, conflictColumns...); err != nil {
+		return err
+	}
+	return uow.autoFlush()
+}
+
+// upsert 注册插入或更新实体，注册后由 Upsert 检查是否需要自动刷新
+func (uow *UnitOfWork) upsert(entity Entity, conflictColumns ...string) error {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	if uow.isCommitted || uow.isRolledBack {
+		return fmt.Errorf("unit of work is already finished")
+	}
+
+	if entity == nil {
+		return fmt.Errorf("entity cannot be nil")
+	}
+
+	entityType := reflect.TypeOf(entity)
+
+	if uow.containsEntity(uow.removedEntities, entity) || uow.containsEntity(uow.purgedEntities, entity) {
+		return fmt.Errorf("entity is already marked for removal")
+	}
+
+	if !entity.IsNew() {
+		if err := uow.checkIdentity(entity); err != nil {
+			return err
+		}
+	}
+
+	if err := uow.stampTenant(entity); err != nil {
+		return err
+	}
+
+	// 插入或更新覆盖同一实体已注册的插入和更新
+	registered := uow.removeFromEntityList(uow.newEntities, entity)
+	registered = uow.removeFromEntityList(uow.dirtyEntities, entity) || registered
+	registered = uow.removeFromEntityList(uow.upsertedEntities, entity) || registered
+	if registered {
+		uow.removeOperationByEntity(entity)
+	} else if err := uow.checkMemoryLimit(); err != nil {
+		return err
+	}
+
+	uow.discoverDependencies(entityType)
+
+	// 添加到插入或更新列表
+	uow.upsertedEntities[entityType] = append(uow.upsertedEntities[entityType], entity)
+
+	// 添加操作
+	operation := NewUpsertOperation(entity, conflictColumns, uow)
+	uow.addOperation(operation)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Info().
+			Str("entity_type", entityType.String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Strs("conflict_columns", conflictColumns).
+			Msg("Registered entity for upsert")
+	}
+
+	return nil
+}
+
+// TakeSnapshot 手动为实体创建快照
+func (uow *UnitOfWork) TakeSnapshot(entity Entity) {
+	uow.snapshotManager.TakeSnapshot(entity)
+}
+
+// Find 按ID加载实体并纳入跟踪，复合主键使用 NewIdentity 按主键字段声明顺序传入
+// dest 必须是实体指针的指针，例如 var user *User; uow.Find(&user, 1)，
+// 同一行数据在工作单元内始终返回同一个内存实例
+func (uow *UnitOfWork) Find(dest interface{}, id interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("dest must be a pointer to an entity pointer, got %T", dest)
+	}
+
+	entityType := destValue.Elem().Type()
+	if !entityType.Implements(entityInterfaceType) {
+		return fmt.Errorf("type %s does not implement Entity", entityType)
+	}
+
+	uow.mu.RLock()
+	if uow.isCommitted || uow.isRolledBack {
+		uow.mu.RUnlock()
+		return fmt.Errorf("unit of work is already finished")
+	}
+	tracked, exists := uow.identityMap.Get(entityType, id)
+	uow.mu.RUnlock()
+
+	if exists {
+		destValue.Elem().Set(reflect.ValueOf(tracked))
+		return nil
+	}
+
+	if uow.memory != nil {
+		loaded, err := uow.loadFromMemory(entityType, toIdentity(id), false)
+		if err == nil && !uow.visibleToTenant(loaded) {
+			err = gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find entity %s with id %v: %w", entityType, id, err)
+		}
+
+		destValue.Elem().Set(reflect.ValueOf(uow.Attach(loaded)))
+		return nil
+	}
+
+	loaded := reflect.New(entityType.Elem())
+	query, err := whereIdentity(uow.db.WithContext(uow.queryContext()), loaded.Interface(), toIdentity(id))
+	if err != nil {
+		return fmt.Errorf("failed to find entity %s with id %v: %w", entityType, id, err)
+	}
+	if err := query.First(loaded.Interface()).Error; err != nil {
+		return fmt.Errorf("failed to find entity %s with id %v: %w", entityType, id, err)
+	}
+	if !uow.visibleToTenant(loaded.Interface().(Entity)) {
+		return fmt.Errorf("failed to find entity %s with id %v: %w", entityType, id, gorm.ErrRecordNotFound)
+	}
+
+	entity := uow.Attach(loaded.Interface().(Entity))
+	destValue.Elem().Set(reflect.ValueOf(entity))
+
+	return nil
+}
+
+// Attach 将已加载的实体纳入跟踪并自动创建快照
+// 如果工作单元已跟踪同一行数据的其他实例，则返回已跟踪的实例
+func (uow *UnitOfWork) Attach(entity Entity) Entity {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	return uow.attach(entity)
+}
+
+// attach 跟踪实体，调用方需持有锁
+func (uow *UnitOfWork) attach(entity Entity) Entity {
+	if entity == nil || entity.IsNew() {
+		return entity
+	}
+
+	tracked, added := uow.identityMap.Put(entity)
+	if !added && tracked != entity {
+		return tracked
+	}
+
+	// 新跟踪的实体或同一实例被重新加载，以数据库状态为准更新快照
+	uow.snapshotManager.TakeSnapshot(entity)
+
+	if uow.config.EnableDetailLog {
+		zlogger.Debug().
+			Str("entity_type", reflect.TypeOf(entity).String()).
+			Str("entity_id", IdentityOf(entity).String()).
+			Msg("Attached entity to identity map")
 	}
 
-	// 添加到脏实体列表
-	uow.dirtyEntities[entityType] = append(uow.dirtyEntities[entityType], entity)
+	return entity
+}
 
-	// 添加操作
-	operation := NewUpdateOperation(entity, changes)
-	uow.addOperation(operation)
+// checkIdentity 检查实体是否与身份映射中已跟踪的实例冲突
+func (uow *UnitOfWork) checkIdentity(entity Entity) error {
+	if entity.IsNew() {
+		return nil
+	}
 
-	if uow.config.EnableDetailLog {
-		zlogger.Info().
-			Str("entity_type", entityType.String()).
-			Interface("entity_id", entity.GetID()).
-			Int("changed_fields", len(changes)).
-			Msg("Registered dirty entity")
+	if tracked, exists := uow.identityMap.Lookup(entity); exists && tracked != entity {
+		return fmt.Errorf("entity %T with id %v is already tracked by another instance", entity, IdentityOf(entity))
 	}
 
 	return nil
 }
 
-// Delete 注册删除实体
-func (uow *UnitOfWork) Delete(entity Entity) error {
+// Commit 提交所有变更
+// 子工作单元在 SAVEPOINT 下刷新自身操作，刷新失败时仅回滚到该保存点
+func (uow *UnitOfWork) Commit() error {
+	err := uow.commit(false)
+	if err != nil {
+		uow.observeCommitFailure()
+	}
+	return err
+}
+
+// observeCommitFailure 将提交失败记录为回滚，同一工作单元只记录一次，之后调用 Rollback 不再重复记录
+func (uow *UnitOfWork) observeCommitFailure() {
 	uow.mu.Lock()
-	defer uow.mu.Unlock()
+	observed := uow.commitFailed || uow.isCommitted || uow.isRolledBack
+	uow.commitFailed = true
+	uow.mu.Unlock()
 
-	if uow.isCommitted || uow.isRolledBack {
-		return fmt.Errorf("unit of work is already finished")
+	if !observed {
+		uow.config.Metrics.observeRollback(rollbackReasonCommitFailed)
 	}
+}
 
-	if entity == nil {
-		return fmt.Errorf("entity cannot be nil")
+// commit 通知监听器并提交所有变更，atomic 为 true 时顶层工作单元也在事务或保存点中刷新，失败时数据库不留下部分变更
+func (uow *UnitOfWork) commit(atomic bool) error {
+	uow.mu.RLock()
+	finished := uow.isCommitted || uow.isRolledBack
+	uow.mu.RUnlock()
+
+	if finished {
+		return uow.commitOperations(atomic)
 	}
 
-	entityType := reflect.TypeOf(entity)
+	span, _ := startSpan(uow.ctx, uow.config, "unitofwork.Commit")
+	span.SetTag("unitofwork.depth", uow.depth)
+	startTime := time.Now()
 
-	// 如果是新实体，直接从新实体列表移除
-	if uow.removeFromEntityList(uow.newEntities, entity) {
-		uow.removeOperationByEntity(entity)
-		if uow.config.EnableDetailLog {
-			zlogger.Info().
-				Str("entity_type", entityType.String()).
-				Interface("entity_id", entity.GetID()).
-				Msg("Removed new entity from registration")
-		}
-		return nil
+	uow.mu.Lock()
+	uow.commitSpan = span
+	span.SetTag("unitofwork.entities", uow.getTotalEntityCount())
+	span.SetTag("unitofwork.operations", len(uow.operations))
+	registered, executed := uow.registeredOperations, uow.executedOperations
+	uow.mu.Unlock()
+
+	err := uow.flush(atomic)
+
+	uow.mu.Lock()
+	uow.commitSpan = nil
+	if err != nil {
+		// 失败的提交执行的操作已被撤销，重试时重新计数
+		uow.registeredOperations, uow.executedOperations = registered, executed
 	}
+	registered, executed = uow.registeredOperations, uow.executedOperations
+	uow.mu.Unlock()
 
-	// 从脏实体列表移除
-	uow.removeFromEntityList(uow.dirtyEntities, entity)
-	uow.removeOperationByEntity(entity)
+	uow.config.Metrics.observeCommit(time.Since(startTime), err)
+	if err == nil {
+		uow.config.Metrics.observeOperations(registered, executed)
+	}
+	finishSpan(span, err)
 
-	// 添加到删除列表
-	uow.removedEntities[entityType] = append(uow.removedEntities[entityType], entity)
+	return err
+}
 
-	// 添加操作
-	operation := NewDeleteOperation(entity)
-	uow.addOperation(operation)
+// flush 通知监听器并执行所有操作
+func (uow *UnitOfWork) flush(atomic bool) error {
+	if err := uow.beforeFlush(); err != nil {
+		err = fmt.Errorf("unit of work commit vetoed by listener: %w", err)
+		uow.afterRollback(err)
+		return err
+	}
 
-	if uow.config.EnableDetailLog {
-		zlogger.Info().
-			Str("entity_type", entityType.String()).
-			Interface("entity_id", entity.GetID()).
-			Msg("Registered entity for removal")
+	if err := uow.commitOperations(atomic); err != nil {
+		uow.afterRollback(err)
+		return err
 	}
 
+	uow.afterCommit()
 	return nil
 }
 
-func (uow *UnitOfWork) TakeSnapshot(entity Entity) {
-	uow.snapshotManager.TakeSnapshot(entity)
-}
-
-// Commit 提交所有变更
-func (uow *UnitOfWork) Commit() error {
+// commitOperations 执行所有操作
+func (uow *UnitOfWork) commitOperations(atomic bool) error {
 	uow.mu.Lock()
 	defer uow.mu.Unlock()
 
@@ -312,29 +992,64 @@ func (uow *UnitOfWork) Commit() error {
 		return fmt.Errorf("unit of work is already rolled back")
 	}
 
+	if uow.activeChildren > 0 {
+		return fmt.Errorf("unit of work has %d active child units", uow.activeChildren)
+	}
+
+	if err := uow.checkAncestorInserts(); err != nil {
+		return fmt.Errorf("unit of work commit failed: %w", err)
+	}
+
+	// 执行任何操作之前汇总所有实体的校验错误
+	if uow.config.EnableValidation {
+		if report := uow.validate(); report != nil {
+			return fmt.Errorf("unit of work commit failed: %w", report.operationError())
+		}
+	}
+
 	startTime := time.Now()
 	totalEntities := uow.getTotalEntityCount()
 
 	zlogger.Info().
+		Int("depth", uow.depth).
 		Int("new_entities", uow.getEntityCountByType(uow.newEntities)).
 		Int("dirty_entities", uow.getEntityCountByType(uow.dirtyEntities)).
 		Int("removed_entities", uow.getEntityCountByType(uow.removedEntities)).
+		Int("upserted_entities", uow.getEntityCountByType(uow.upsertedEntities)).
+		Int("restored_entities", uow.getEntityCountByType(uow.restoredEntities)).
+		Int("purged_entities", uow.getEntityCountByType(uow.purgedEntities)).
 		Int("total_operations", len(uow.operations)).
 		Msg("Starting unit of work commit")
 
+	// 发件箱消息和审计记录需要与实体写入在同一事务中提交
+	atomic = atomic || (uow.memory == nil && !inTransaction(uow.db) && (len(uow.config.AuditSinks) > 0 || len(uow.collectEventSources()) > 0))
+
 	// 设置执行状态，然后释放锁执行操作，避免死锁
 	uow.isExecuting = true
 	uow.mu.Unlock()
-	
-	// 执行所有操作
-	err := uow.executeOperations(uow.db)
-	
+
+	// 执行所有操作，已增量刷新的工作单元在刷新时开启的事务中执行剩余的操作
+	var err error
+	if uow.parent != nil {
+		uow.parent.setExecuting(true)
+		err = uow.flushInSavepoint(uow.savepoint)
+		uow.parent.setExecuting(false)
+	} else if atomic || uow.memory != nil || uow.flushed {
+		err = uow.flushInSavepoint("uow_sp_0")
+	} else {
+		err = uow.executeOperations(uow.db)
+	}
+
 	// 重新获取锁并清除执行状态
 	uow.mu.Lock()
 	uow.isExecuting = false
 
+	if err == nil {
+		err = uow.endFlush(true)
+	}
+
 	if err != nil {
-		zlogger.Error().Err(err).Msg("Unit of work commit failed")
+		zlogger.Error().Err(err).Int("depth", uow.depth).Msg("Unit of work commit failed")
 		return fmt.Errorf("unit of work commit failed: %w", err)
 	}
 
@@ -342,10 +1057,20 @@ func (uow *UnitOfWork) Commit() error {
 	duration := time.Since(startTime)
 
 	zlogger.Info().
+		Int("depth", uow.depth).
 		Int("total_entities", totalEntities).
 		Dur("duration", duration).
 		Msg("Unit of work committed successfully")
 
+	if uow.parent != nil {
+		uow.parent.adoptChild(uow)
+	}
+
+	// 领域事件已写入发件箱
+	for _, source := range uow.collectEventSources() {
+		source.ClearEvents()
+	}
+
 	// 清理资源
 	uow.clear()
 
@@ -353,7 +1078,26 @@ func (uow *UnitOfWork) Commit() error {
 }
 
 // Rollback 回滚所有变更
+// 子工作单元回滚只丢弃自身的待执行操作和快照
 func (uow *UnitOfWork) Rollback() error {
+	if err := uow.rollback(); err != nil {
+		return err
+	}
+
+	// 提交失败时已记录回滚并通知监听器
+	uow.mu.RLock()
+	commitFailed := uow.commitFailed
+	uow.mu.RUnlock()
+	if commitFailed {
+		return nil
+	}
+
+	uow.config.Metrics.observeRollback(rollbackReasonExplicit)
+	uow.afterRollback(nil)
+	return nil
+}
+
+func (uow *UnitOfWork) rollback() error {
 	uow.mu.Lock()
 	defer uow.mu.Unlock()
 
@@ -365,23 +1109,217 @@ func (uow *UnitOfWork) Rollback() error {
 		return fmt.Errorf("unit of work is already rolled back")
 	}
 
+	// 撤销所有已刷新的操作
+	err := uow.endFlush(false)
+
 	uow.isRolledBack = true
 
-	zlogger.Info().Msg("Unit of work rolled back")
+	zlogger.Info().Int("depth", uow.depth).Msg("Unit of work rolled back")
+
+	if uow.parent != nil {
+		// 子工作单元加载的实体不再被跟踪
+		for key := range uow.snapshotManager.snapshots {
+			uow.identityMap.removeKey(key)
+		}
+		uow.parent.releaseChild()
+	}
 
 	// 清理资源
 	uow.clear()
 
+	return err
+}
+
+// flushInSavepoint 在 SAVEPOINT 下执行工作单元的操作
+func (uow *UnitOfWork) flushInSavepoint(savepoint string) error {
+	if uow.memory != nil {
+		if uow.memoryStaged() {
+			// 增量刷新的事务仍在进行中，以工作副本的副本作为保存点
+			state := uow.memory.savepoint()
+			if err := uow.executeOperations(nil); err != nil {
+				uow.memory.rollbackTo(state)
+				return err
+			}
+			return nil
+		}
+
+		// 内存存储的每次刷新都是独立的事务
+		return uow.memory.transaction(func() error {
+			return uow.executeOperations(nil)
+		})
+	}
+
+	if !inTransaction(uow.db) {
+		// 不在事务中，使用独立事务
+		return uow.db.Transaction(func(tx *gorm.DB) error {
+			return uow.executeOperations(tx)
+		})
+	}
+
+	tx := uow.db.Session(&gorm.Session{})
+	if err := tx.SavePoint(savepoint).Error; err != nil {
+		return fmt.Errorf("failed to create savepoint %s: %w", savepoint, err)
+	}
+
+	if err := uow.executeOperations(tx); err != nil {
+		if rollbackErr := uow.db.Session(&gorm.Session{}).RollbackTo(savepoint).Error; rollbackErr != nil {
+			zlogger.Error().Err(rollbackErr).Str("savepoint", savepoint).Msg("Failed to rollback to savepoint")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// setExecuting 设置自身及所有祖先工作单元的执行状态
+func (uow *UnitOfWork) setExecuting(executing bool) {
+	for current := uow; current != nil; current = current.parent {
+		current.mu.Lock()
+		current.isExecuting = executing
+		current.mu.Unlock()
+	}
+}
+
+// adoptChild 子工作单元提交后，刷新父工作单元中已持久化实体的快照
+func (uow *UnitOfWork) adoptChild(child *UnitOfWork) {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+
+	uow.snapshotManager.Merge(child.snapshotManager)
+
+	for _, entities := range child.newEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.TakeSnapshot(entity)
+		}
+	}
+
+	for _, entities := range child.dirtyEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.TakeSnapshot(entity)
+		}
+	}
+
+	for _, entities := range child.upsertedEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.TakeSnapshot(entity)
+		}
+	}
+
+	for _, entities := range child.restoredEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.TakeSnapshot(entity)
+		}
+	}
+
+	for _, entities := range child.removedEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.RemoveSnapshot(entity)
+		}
+	}
+
+	for _, entities := range child.purgedEntities {
+		for _, entity := range entities {
+			uow.snapshotManager.RemoveSnapshot(entity)
+		}
+	}
+
+	uow.activeChildren--
+}
+
+// releaseChild 子工作单元回滚后释放计数
+func (uow *UnitOfWork) releaseChild() {
+	uow.mu.Lock()
+	defer uow.mu.Unlock()
+	uow.activeChildren--
+}
+
+// lookupSnapshotManager 查找持有实体快照的快照管理器，优先使用自身，其次是最近的祖先
+func (uow *UnitOfWork) lookupSnapshotManager(entity Entity) *SnapshotManager {
+	if uow.snapshotManager.HasSnapshot(entity) {
+		return uow.snapshotManager
+	}
+
+	for ancestor := uow.parent; ancestor != nil; ancestor = ancestor.parent {
+		ancestor.mu.RLock()
+		found := ancestor.snapshotManager.HasSnapshot(entity)
+		ancestor.mu.RUnlock()
+		if found {
+			return ancestor.snapshotManager
+		}
+	}
+
+	return uow.snapshotManager
+}
+
+// isNewInAncestors 检查实体是否在祖先工作单元中等待插入
+func (uow *UnitOfWork) isNewInAncestors(entity Entity) bool {
+	for ancestor := uow.parent; ancestor != nil; ancestor = ancestor.parent {
+		ancestor.mu.RLock()
+		found := ancestor.containsEntity(ancestor.newEntities, entity)
+		ancestor.mu.RUnlock()
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAncestorInserts 拒绝依赖于祖先工作单元中待插入实体类型的插入，调用方需持有锁
+// 子工作单元先于祖先工作单元刷新，被依赖的行此时尚未插入，需先刷新祖先工作单元
+func (uow *UnitOfWork) checkAncestorInserts() error {
+	if uow.parent == nil {
+		return nil
+	}
+
+	for _, entityMap := range []map[reflect.Type][]Entity{uow.newEntities, uow.upsertedEntities} {
+		for entityType, entities := range entityMap {
+			if len(entities) == 0 {
+				continue
+			}
+
+			for ancestor := uow.parent; ancestor != nil; ancestor = ancestor.parent {
+				ancestor.mu.RLock()
+				dependency, found := ancestor.pendingInsertDependency(entityType)
+				ancestor.mu.RUnlock()
+				if found {
+					return fmt.Errorf("entity %s depends on %s pending creation in parent unit of work, flush the parent first", entityType, dependency)
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
+// pendingInsertDependency 查找 entityType 所依赖的、在本工作单元中待插入的实体类型，调用方需持有锁
+func (uow *UnitOfWork) pendingInsertDependency(entityType reflect.Type) (reflect.Type, bool) {
+	for _, entityMap := range []map[reflect.Type][]Entity{uow.newEntities, uow.upsertedEntities} {
+		for dependency, entities := range entityMap {
+			if len(entities) > 0 && uow.dependencyManager.HasDependency(entityType, dependency) {
+				return dependency, true
+			}
+		}
+	}
+	return nil, false
+}
+
 // executeOperations 执行所有操作
 func (uow *UnitOfWork) executeOperations(tx *gorm.DB) error {
+	if tx != nil {
+		tx = tx.WithContext(uow.operationContext())
+	}
+
+	// 更新的字段变更需在执行前计算
+	audits := uow.prepareAudit()
+
 	// 操作优化
 	optimizedOps := uow.optimizeOperations()
 
 	// 按依赖顺序排序操作
+	sortSpan := uow.startChildSpan("unitofwork.SortOperations")
+	sortSpan.SetTag("unitofwork.operations", len(optimizedOps))
 	sortedOps, err := uow.sortOperationsByDependency(optimizedOps)
+	finishSpan(sortSpan, err)
 	if err != nil {
 		return err
 	}
@@ -396,14 +1334,67 @@ func (uow *UnitOfWork) executeOperations(tx *gorm.DB) error {
 				Msg("Executing operation")
 		}
 
-		if err := operation.Execute(tx); err != nil {
-			return fmt.Errorf("operation %d failed: %w", i, err)
+		if err := uow.executeOperation(tx, i, operation); err != nil {
+			return err
 		}
 	}
 
+	// 领域事件与实体变更写入同一事务
+	if err := uow.writeOutbox(tx, uow.collectEventSources()); err != nil {
+		return err
+	}
+
+	if err := uow.writeAudit(tx, audits); err != nil {
+		return err
+	}
+
+	uow.mu.Lock()
+	uow.registeredOperations += len(uow.operations)
+	uow.executedOperations += len(optimizedOps)
+	uow.mu.Unlock()
+
+	return nil
+}
+
+// executeOperation 在 span 中执行单个操作
+func (uow *UnitOfWork) executeOperation(tx *gorm.DB, index int, operation Operation) (err error) {
+	span := uow.startChildSpan("unitofwork.Operation")
+	tagOperationSpan(span, operation)
+	defer func() {
+		finishSpan(span, err)
+	}()
+
+	if err := uow.beforeOperation(tx, operation); err != nil {
+		return fmt.Errorf("operation %d vetoed by listener: %w", index, err)
+	}
+
+	if err := uow.applyOperation(tx, operation); err != nil {
+		return fmt.Errorf("operation %d failed: %w", index, err)
+	}
+
+	if err := uow.afterOperation(tx, operation); err != nil {
+		return fmt.Errorf("operation %d rejected by listener: %w", index, err)
+	}
+
 	return nil
 }
 
+// applyOperation 在数据库或内存存储上执行操作
+func (uow *UnitOfWork) applyOperation(tx *gorm.DB, operation Operation) error {
+	if uow.memory != nil {
+		return uow.memory.apply(uow.operationContext(), operation)
+	}
+	return operation.Execute(tx)
+}
+
+// operationContext 执行操作使用的上下文，提交和刷新前已运行校验阶段时操作不再重复校验实体
+func (uow *UnitOfWork) operationContext() context.Context {
+	if uow.config.EnableValidation {
+		return context.WithValue(uow.ctx, validatedKey{}, true)
+	}
+	return uow.ctx
+}
+
 // optimizeOperations 优化操作序列
 func (uow *UnitOfWork) optimizeOperations() []Operation {
 	if !uow.config.EnableOperationMerge {
@@ -445,7 +1436,7 @@ func (uow *UnitOfWork) removeInvalidOperations() []Operation {
 				if uow.config.EnableDetailLog {
 					zlogger.Debug().
 						Str("entity_type", op1.GetEntityType().String()).
-						Interface("entity_id", op1.GetEntity().GetID()).
+						Str("entity_id", IdentityOf(op1.GetEntity()).String()).
 						Msg("Canceled insert-delete operation pair")
 				}
 				break
@@ -514,22 +1505,32 @@ func (uow *UnitOfWork) sortOperationsByDependency(operations []Operation) ([]Ope
 
 	// 按操作类型分组
 	insertOps := make([]Operation, 0)
+	restoreOps := make([]Operation, 0)
 	updateOps := make([]Operation, 0)
 	deleteOps := make([]Operation, 0)
 
 	for _, op := range operations {
 		switch op.GetOperationType() {
-		case OperationTypeInsert, OperationTypeBulkInsert:
+		case OperationTypeInsert, OperationTypeBulkInsert, OperationTypeUpsert, OperationTypeBulkUpsert:
+			// 插入或更新可能插入新记录，与插入一同按依赖排序
 			insertOps = append(insertOps, op)
+		case OperationTypeRestore, OperationTypeBulkRestore:
+			// 恢复的行可能被更新，先于更新执行
+			restoreOps = append(restoreOps, op)
 		case OperationTypeUpdate, OperationTypeBulkUpdate:
 			updateOps = append(updateOps, op)
-		case OperationTypeDelete, OperationTypeBulkDelete:
+		case OperationTypeDelete, OperationTypeBulkDelete, OperationTypePurge, OperationTypeBulkPurge:
 			deleteOps = append(deleteOps, op)
 		}
 	}
 
 	// 对每类操作内部排序
-	sortedInserts, err := uow.sortOperationsByEntityDependency(insertOps, false)
+	sortedInserts, deferredUpdates, err := uow.sortInsertOperations(insertOps)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedRestores, err := uow.sortOperationsByEntityDependency(restoreOps, false)
 	if err != nil {
 		return nil, err
 	}
@@ -541,45 +1542,69 @@ func (uow *UnitOfWork) sortOperationsByDependency(operations []Operation) ([]Ope
 	if err != nil {
 		return nil, err
 	}
+	sortByAggregateDepth(sortedDeletes)
 
 	// 合并所有排序后的操作
 	result := make([]Operation, 0, len(operations))
 	result = append(result, sortedInserts...)
+	result = append(result, deferredUpdates...)
+	result = append(result, sortedRestores...)
 	result = append(result, sortedUpdates...)
 	result = append(result, sortedDeletes...)
 
 	return result, nil
 }
 
+// sortInsertOperations 按依赖关系排序插入操作
+// 启用依赖环打破时，额外返回在所有插入之后回填延迟外键的更新操作
+func (uow *UnitOfWork) sortInsertOperations(operations []Operation) ([]Operation, []Operation, error) {
+	if !uow.config.EnableCycleBreaking {
+		sortedOps, err := uow.sortOperationsByEntityDependency(operations, false)
+		return sortedOps, nil, err
+	}
+
+	var deferred []DeferredDependency
+	sortedOps, err := uow.reorderOperations(operations, func(entities []Entity) ([]Entity, error) {
+		sortedEntities, deferredDependencies, err := uow.dependencyManager.GetInsertionOrderBreakingCycles(entities)
+		deferred = deferredDependencies
+		return sortedEntities, err
+	})
+	if err != nil || len(deferred) == 0 {
+		return sortedOps, nil, err
+	}
+
+	sortedOps, deferredUpdates := uow.deferForeignKeys(sortedOps, deferred)
+	return sortedOps, deferredUpdates, nil
+}
+
 // sortOperationsByEntityDependency 按实体依赖关系排序操作
 func (uow *UnitOfWork) sortOperationsByEntityDependency(operations []Operation, reverse bool) ([]Operation, error) {
+	if reverse {
+		return uow.reorderOperations(operations, uow.dependencyManager.GetDeletionOrder)
+	}
+	return uow.reorderOperations(operations, uow.dependencyManager.GetInsertionOrder)
+}
+
+// reorderOperations 按实体排序结果重新组织操作
+func (uow *UnitOfWork) reorderOperations(operations []Operation, order func([]Entity) ([]Entity, error)) ([]Operation, error) {
 	if len(operations) <= 1 {
 		return operations, nil
 	}
 
 	// 提取实体
 	entities := make([]Entity, 0, len(operations))
-	opMap := make(map[string]Operation)
+	opMap := make(map[Entity]Operation)
 
 	for _, op := range operations {
 		entity := op.GetEntity()
 		if entity != nil {
 			entities = append(entities, entity)
-			key := fmt.Sprintf("%s#%v", reflect.TypeOf(entity).String(), entity.GetID())
-			opMap[key] = op
+			opMap[entity] = op
 		}
 	}
 
 	// 按依赖关系排序实体
-	var sortedEntities []Entity
-	var err error
-
-	if reverse {
-		sortedEntities, err = uow.dependencyManager.GetDeletionOrder(entities)
-	} else {
-		sortedEntities, err = uow.dependencyManager.GetInsertionOrder(entities)
-	}
-
+	sortedEntities, err := order(entities)
 	if err != nil {
 		return nil, err
 	}
@@ -587,8 +1612,7 @@ func (uow *UnitOfWork) sortOperationsByEntityDependency(operations []Operation,
 	// 重新组织操作
 	sortedOps := make([]Operation, 0, len(operations))
 	for _, entity := range sortedEntities {
-		key := fmt.Sprintf("%s#%v", reflect.TypeOf(entity).String(), entity.GetID())
-		if op, exists := opMap[key]; exists {
+		if op, exists := opMap[entity]; exists {
 			sortedOps = append(sortedOps, op)
 		}
 	}
@@ -598,6 +1622,19 @@ func (uow *UnitOfWork) sortOperationsByEntityDependency(operations []Operation,
 
 // 辅助方法
 
+var entityInterfaceType = reflect.TypeOf((*Entity)(nil)).Elem()
+
+// operationEntities 获取操作涉及的所有实体
+func operationEntities(operation Operation) []Entity {
+	if bulk, ok := operation.(interface{ GetEntities() []Entity }); ok {
+		return bulk.GetEntities()
+	}
+	if entity := operation.GetEntity(); entity != nil {
+		return []Entity{entity}
+	}
+	return nil
+}
+
 func (uow *UnitOfWork) containsEntity(entityMap map[reflect.Type][]Entity, entity Entity) bool {
 	entityType := reflect.TypeOf(entity)
 	entities, exists := entityMap[entityType]
@@ -606,7 +1643,7 @@ func (uow *UnitOfWork) containsEntity(entityMap map[reflect.Type][]Entity, entit
 	}
 
 	for _, e := range entities {
-		if e.GetID() == entity.GetID() {
+		if sameEntity(e, entity) {
 			return true
 		}
 	}
@@ -622,7 +1659,7 @@ func (uow *UnitOfWork) removeFromEntityList(entityMap map[reflect.Type][]Entity,
 	}
 
 	for i, e := range entities {
-		if e.GetID() == entity.GetID() {
+		if sameEntity(e, entity) {
 			// 移除实体
 			entityMap[entityType] = append(entities[:i], entities[i+1:]...)
 			return true
@@ -637,13 +1674,27 @@ func (uow *UnitOfWork) removeOperationByEntity(entity Entity) {
 	for _, op := range uow.operations {
 		if !op.SameIdentity(NewInsertOperation(entity, uow)) &&
 			!op.SameIdentity(NewUpdateOperation(entity, nil)) &&
-			!op.SameIdentity(NewDeleteOperation(entity)) {
+			!op.SameIdentity(NewDeleteOperation(entity)) &&
+			!op.SameIdentity(NewUpsertOperation(entity, nil, uow)) &&
+			!op.SameIdentity(NewRestoreOperation(entity)) &&
+			!op.SameIdentity(NewPurgeOperation(entity)) {
 			newOps = append(newOps, op)
 		}
 	}
 	uow.operations = newOps
 }
 
+// discoverDependencies 首次遇到实体类型时从 gorm schema 发现依赖关系
+func (uow *UnitOfWork) discoverDependencies(entityType reflect.Type) {
+	if !uow.config.EnableAutoDependency || uow.db == nil {
+		return
+	}
+
+	if err := uow.dependencyManager.DiscoverDependencies(uow.db, entityType); err != nil {
+		zlogger.Warn().Err(err).Str("entity_type", entityType.String()).Msg("Failed to discover entity dependencies")
+	}
+}
+
 func (uow *UnitOfWork) addOperation(operation Operation) {
 	uow.operations = append(uow.operations, operation)
 }
@@ -662,7 +1713,10 @@ func (uow *UnitOfWork) checkMemoryLimit() error {
 func (uow *UnitOfWork) getTotalEntityCount() int {
 	return uow.getEntityCountByType(uow.newEntities) +
 		uow.getEntityCountByType(uow.dirtyEntities) +
-		uow.getEntityCountByType(uow.removedEntities)
+		uow.getEntityCountByType(uow.removedEntities) +
+		uow.getEntityCountByType(uow.upsertedEntities) +
+		uow.getEntityCountByType(uow.restoredEntities) +
+		uow.getEntityCountByType(uow.purgedEntities)
 }
 
 func (uow *UnitOfWork) getEntityCountByType(entityMap map[reflect.Type][]Entity) int {
@@ -677,8 +1731,14 @@ func (uow *UnitOfWork) clear() {
 	uow.newEntities = make(map[reflect.Type][]Entity)
 	uow.dirtyEntities = make(map[reflect.Type][]Entity)
 	uow.removedEntities = make(map[reflect.Type][]Entity)
+	uow.upsertedEntities = make(map[reflect.Type][]Entity)
+	uow.restoredEntities = make(map[reflect.Type][]Entity)
+	uow.purgedEntities = make(map[reflect.Type][]Entity)
 	uow.operations = make([]Operation, 0)
 	uow.snapshotManager.Clear()
+	if uow.parent == nil {
+		uow.identityMap.Clear()
+	}
 }
 
 // IsCommitted 检查是否已提交
@@ -699,18 +1759,3 @@ func (uow *UnitOfWork) IsRolledBack() bool {
 func (uow *UnitOfWork) GetDependencyManager() *DependencyManager {
 	return uow.dependencyManager
 }
-
-// GetStats 获取统计信息
-func (uow *UnitOfWork) GetStats() map[string]interface{} {
-	uow.mu.RLock()
-	defer uow.mu.RUnlock()
-
-	return map[string]interface{}{
-		"new_entities":     uow.getEntityCountByType(uow.newEntities),
-		"dirty_entities":   uow.getEntityCountByType(uow.dirtyEntities),
-		"removed_entities": uow.getEntityCountByType(uow.removedEntities),
-		"total_operations": len(uow.operations),
-		"is_committed":     uow.isCommitted,
-		"is_rolled_back":   uow.isRolledBack,
-	}
-}
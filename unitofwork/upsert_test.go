@@ -0,0 +1,148 @@
+package unitofwork
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wubin1989/gorm"
+	"github.com/wubin1989/mysql"
+)
+
+// TestUnitOfWork_Upsert 测试插入或更新
+func TestUnitOfWork_Upsert(t *testing.T) {
+	t.Run("按冲突列插入或更新", func(t *testing.T) {
+		db := setupTestDB()
+
+		uow := NewUnitOfWork(db)
+		require.NoError(t, uow.Upsert(&User{Name: "张三", Email: "zhangsan@example.com", Age: 20}, "email"))
+		require.NoError(t, uow.Commit())
+
+		var created User
+		require.NoError(t, db.Where("email = ?", "zhangsan@example.com").First(&created).Error)
+		assert.Equal(t, 20, created.Age)
+		assert.Equal(t, int64(1), created.Revision)
+
+		uow = NewUnitOfWork(db)
+		updated := &User{Name: "张三丰", Email: "zhangsan@example.com", Age: 30}
+		require.NoError(t, uow.Upsert(updated, "Email"))
+		require.NoError(t, uow.Commit())
+
+		// 实体与数据库中的行一致
+		assert.Equal(t, created.ID, updated.ID)
+		assert.Equal(t, int64(2), updated.Revision)
+		assert.True(t, updated.CreatedAt.Equal(created.CreatedAt))
+
+		var users []User
+		require.NoError(t, db.Find(&users).Error)
+		require.Len(t, users, 1)
+		assert.Equal(t, created.ID, users[0].ID)
+		assert.Equal(t, "张三丰", users[0].Name)
+		assert.Equal(t, 30, users[0].Age)
+		assert.Equal(t, int64(2), users[0].Revision)
+		assert.True(t, users[0].CreatedAt.Equal(created.CreatedAt))
+	})
+
+	t.Run("同冲突列的插入或更新合并为批量操作并按依赖排序", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		require.NoError(t, uow.Upsert(&Post{BaseEntity: BaseEntity{ID: 1}, Title: "文章", UserID: 1}))
+		require.NoError(t, uow.Upsert(&User{BaseEntity: BaseEntity{ID: 1}, Name: "张三", Email: "zhangsan@example.com"}))
+		require.NoError(t, uow.Upsert(&User{BaseEntity: BaseEntity{ID: 2}, Name: "李四", Email: "lisi@example.com"}))
+
+		plan, err := uow.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 2)
+		assert.Equal(t, "BULK_UPSERT", plan.Operations[0].OperationType)
+		assert.Equal(t, []string{"1", "2"}, plan.Operations[0].EntityIDs)
+		assert.Contains(t, plan.Operations[0].SQL[0], "ON CONFLICT (`id`) DO UPDATE SET")
+		assert.Equal(t, "UPSERT", plan.Operations[1].OperationType)
+		assert.Equal(t, "*unitofwork.Post", plan.Operations[1].EntityType)
+
+		require.NoError(t, uow.Commit())
+
+		var count int64
+		require.NoError(t, db.Model(&User{}).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("插入或更新覆盖同一实体的插入和更新", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		user := &User{BaseEntity: BaseEntity{ID: 1}, Name: "张三", Email: "zhangsan@example.com"}
+		require.NoError(t, uow.Create(user))
+		require.NoError(t, uow.Upsert(user))
+		require.NoError(t, uow.Update(user))
+
+		stats := uow.GetStats()
+		assert.Equal(t, 0, stats.NewEntities)
+		assert.Equal(t, 1, stats.UpsertedEntities)
+		assert.Equal(t, 1, stats.TotalOperations)
+
+		require.NoError(t, uow.Delete(user))
+		stats = uow.GetStats()
+		assert.Equal(t, 0, stats.UpsertedEntities)
+		assert.Equal(t, 1, stats.RemovedEntities)
+		assert.Error(t, uow.Upsert(user))
+	})
+
+	t.Run("同一实体的更新操作合并进插入或更新", func(t *testing.T) {
+		user := &User{BaseEntity: BaseEntity{ID: 1}}
+		upsert := NewUpsertOperation(user, []string{"email"}, nil)
+
+		assert.True(t, upsert.CanMerge(NewUpdateOperation(user, nil)))
+		assert.Same(t, upsert, upsert.Merge(NewUpdateOperation(user, nil)))
+		assert.False(t, upsert.CanMerge(NewUpsertOperation(&User{}, []string{"name"}, nil)))
+
+		merged := upsert.Merge(NewUpsertOperation(&User{BaseEntity: BaseEntity{ID: 2}}, []string{"email"}, nil))
+		assert.Equal(t, OperationTypeBulkUpsert, merged.GetOperationType())
+		assert.Len(t, merged.(*BulkUpsertOperation).GetEntities(), 2)
+	})
+
+	t.Run("不支持 RETURNING 的方言按冲突列读回主键、创建时间和版本号", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		db, err := gorm.Open(mysql.New(mysql.Config{Conn: mockDB, SkipInitializeWithVersion: true}), &gorm.Config{SkipDefaultTransaction: true})
+		require.NoError(t, err)
+
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		mock.ExpectExec("INSERT INTO `tags`").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`created_at`,`revision` FROM `tags` WHERE `tags`.`name` = ?")).
+			WithArgs("go", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow(7, createdAt, 3))
+
+		tag := &Tag{Name: "go", Color: "#00ADD8"}
+		uow := NewUnitOfWork(db, WithAutoDependency(false))
+		require.NoError(t, uow.Upsert(tag, "name"))
+		require.NoError(t, uow.Commit())
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		assert.Equal(t, uint(7), tag.ID)
+		assert.True(t, tag.CreatedAt.Equal(createdAt))
+		assert.Equal(t, int64(3), tag.Revision)
+	})
+
+	t.Run("MySQL 渲染为 ON DUPLICATE KEY UPDATE", func(t *testing.T) {
+		mockDB, _, err := sqlmock.New()
+		require.NoError(t, err)
+		db, err := gorm.Open(mysql.New(mysql.Config{Conn: mockDB, SkipInitializeWithVersion: true}), &gorm.Config{})
+		require.NoError(t, err)
+
+		uow := NewUnitOfWork(db, WithAutoDependency(false))
+		require.NoError(t, uow.Upsert(&Tag{BaseEntity: BaseEntity{ID: 1}, Name: "go", Color: "#00ADD8"}, "name"))
+
+		plan, err := uow.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan.Operations, 1)
+		sql := plan.Operations[0].SQL[0]
+		assert.Contains(t, sql, "ON DUPLICATE KEY UPDATE")
+		assert.Contains(t, sql, "`color`=VALUES(`color`)")
+		assert.Contains(t, sql, "`revision`=`tags`.`revision` + 1")
+		assert.NotContains(t, sql, "`name`=VALUES(`name`)")
+	})
+}
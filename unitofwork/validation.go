@@ -0,0 +1,467 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationTag 字段校验规则的结构体标签，多个规则以分号分隔，例如
+// `validation:"required;max=100;regex=^[a-z0-9_]+$"`，regex 规则之后的内容都视为正则表达式，需放在最后
+const ValidationTag = "validation"
+
+// FieldError 字段校验错误，Validatable.Validate 可以返回 FieldError 或以 errors.Join 组合的多个 FieldError
+type FieldError struct {
+	// 字段路径，优先使用 json 标签名，例如 name、address.city、items[0].sku，实体级错误为空
+	Field string `json:"field,omitempty"`
+
+	// 校验规则：required、min、max、regex，Validate 返回的普通错误为 validate
+	Rule string `json:"rule"`
+
+	// 错误信息
+	Message string `json:"message"`
+}
+
+// Error 实现error接口
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + " " + e.Message
+}
+
+// EntityValidation 单个实体的校验错误
+type EntityValidation struct {
+	// 校验失败的实体
+	Entity Entity `json:"-"`
+
+	// 实体类型
+	EntityType string `json:"entity_type"`
+
+	// 表名
+	Table string `json:"table"`
+
+	// 实体ID，新实体为空
+	EntityID string `json:"entity_id,omitempty"`
+
+	// 新实体在同类型新实体中的注册序号，从0开始
+	Index int `json:"index"`
+
+	// 按字段排列的校验错误
+	Errors []FieldError `json:"errors"`
+
+	// 注册实体的第一个操作的序号
+	operation int
+}
+
+// Error 实现error接口
+func (v *EntityValidation) Error() string {
+	errs := make([]string, 0, len(v.Errors))
+	for i := range v.Errors {
+		errs = append(errs, v.Errors[i].Error())
+	}
+
+	return "validation failed for entity " + v.EntityType + ": " + strings.Join(errs, "; ")
+}
+
+// key 实体在报告中的分组键，新实体使用 new[序号]
+func (v *EntityValidation) key() string {
+	if v.EntityID != "" {
+		return v.EntityID
+	}
+	return fmt.Sprintf("new[%d]", v.Index)
+}
+
+// ValidationReport 校验报告，提交前运行所有校验规则和 Validate 方法，汇总所有实体的错误
+type ValidationReport struct {
+	Entities []*EntityValidation `json:"entities"`
+}
+
+// Error 实现error接口
+func (r *ValidationReport) Error() string {
+	messages := make([]string, 0, len(r.Entities))
+	for _, entity := range r.Entities {
+		messages = append(messages, entity.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// operationError 提交和刷新时返回的错误，与操作执行时的校验错误保持一致
+func (r *ValidationReport) operationError() error {
+	return fmt.Errorf("operation %d failed: %w", r.Entities[0].operation, r)
+}
+
+// StatusCode 校验失败对应的 HTTP 状态码
+func (r *ValidationReport) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// ValidationResponse 校验失败的 HTTP 响应体
+type ValidationResponse struct {
+	Message string `json:"message"`
+
+	// 表名 -> 实体ID（新实体为 new[序号]）-> 字段路径（实体级错误为空字符串）-> 错误信息
+	Errors map[string]map[string]map[string][]string `json:"errors"`
+}
+
+// Response 转换为 HTTP 422 响应体，错误按表名、实体ID和字段路径分组
+func (r *ValidationReport) Response() ValidationResponse {
+	response := ValidationResponse{
+		Message: "validation failed",
+		Errors:  make(map[string]map[string]map[string][]string),
+	}
+
+	for _, entity := range r.Entities {
+		entities, exists := response.Errors[entity.Table]
+		if !exists {
+			entities = make(map[string]map[string][]string)
+			response.Errors[entity.Table] = entities
+		}
+
+		fields, exists := entities[entity.key()]
+		if !exists {
+			fields = make(map[string][]string)
+			entities[entity.key()] = fields
+		}
+
+		for _, fieldError := range entity.Errors {
+			fields[fieldError.Field] = append(fields[fieldError.Field], fieldError.Message)
+		}
+	}
+
+	return response
+}
+
+// WithValidation 配置提交前的校验阶段
+func WithValidation(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.EnableValidation = enabled
+	}
+}
+
+// Validate 校验所有待插入、更新、插入或更新的实体，存在错误时返回 *ValidationReport
+func (uow *UnitOfWork) Validate() error {
+	uow.mu.RLock()
+	defer uow.mu.RUnlock()
+
+	if report := uow.validate(); report != nil {
+		return report
+	}
+	return nil
+}
+
+// validate 运行所有实体的校验，调用方需持有锁
+func (uow *UnitOfWork) validate() *ValidationReport {
+	report := &ValidationReport{}
+	seen := make(map[Entity]bool)
+	newCounts := make(map[reflect.Type]int)
+
+	for index, operation := range uow.operations {
+		switch operation.GetOperationType() {
+		case OperationTypeInsert, OperationTypeBulkInsert,
+			OperationTypeUpdate, OperationTypeBulkUpdate,
+			OperationTypeUpsert, OperationTypeBulkUpsert:
+		default:
+			continue
+		}
+
+		for _, entity := range operationEntities(operation) {
+			if seen[entity] {
+				continue
+			}
+			seen[entity] = true
+
+			entityType := reflect.TypeOf(entity)
+			validation := &EntityValidation{
+				Entity:     entity,
+				EntityType: entityType.String(),
+				Table:      entity.GetTableName(),
+				operation:  index,
+			}
+			if entity.IsNew() {
+				validation.Index = newCounts[entityType]
+				newCounts[entityType]++
+			} else {
+				validation.EntityID = IdentityOf(entity).String()
+			}
+
+			validation.Errors = validateEntity(entity)
+			if len(validation.Errors) > 0 {
+				report.Entities = append(report.Entities, validation)
+			}
+		}
+	}
+
+	if len(report.Entities) == 0 {
+		return nil
+	}
+	return report
+}
+
+// validateEntity 运行实体的标签规则和 Validate 方法
+func validateEntity(entity Entity) []FieldError {
+	errs := validateStruct(reflect.ValueOf(entity), "")
+
+	if validatable, ok := entity.(Validatable); ok {
+		if err := validatable.Validate(); err != nil {
+			errs = append(errs, validatorErrors(err)...)
+		}
+	}
+
+	return errs
+}
+
+// validatorErrors 展开 Validate 返回的错误，FieldError 保留字段路径
+func validatorErrors(err error) []FieldError {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []FieldError
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, validatorErrors(e)...)
+		}
+		return errs
+	}
+
+	var fieldError *FieldError
+	if errors.As(err, &fieldError) {
+		return []FieldError{*fieldError}
+	}
+
+	return []FieldError{{Rule: "validate", Message: err.Error()}}
+}
+
+// validatedKey 操作上下文中标记校验阶段已运行
+type validatedKey struct{}
+
+// validateWritable 执行实体的 Validate 方法，校验阶段已运行时不再重复执行
+func validateWritable(ctx context.Context, entity Entity) error {
+	if ctx != nil && ctx.Value(validatedKey{}) != nil {
+		return nil
+	}
+
+	if validatable, ok := entity.(Validatable); ok {
+		if err := validatable.Validate(); err != nil {
+			return fmt.Errorf("validation failed for entity %T: %w", entity, err)
+		}
+	}
+	return nil
+}
+
+// validateStruct 按标签规则校验结构体字段，并递归校验嵌套的值对象，关联的实体不在此校验
+func validateStruct(value reflect.Value, path string) []FieldError {
+	value = reflect.Indirect(value)
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs []FieldError
+	structType := value.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldValue := value.Field(i)
+		if field.Anonymous {
+			if fieldValue.Kind() == reflect.Ptr && fieldValue.IsNil() {
+				continue
+			}
+			errs = append(errs, validateStruct(fieldValue, path)...)
+			continue
+		}
+
+		fieldPath := fieldPathOf(path, field)
+		if tag, ok := field.Tag.Lookup(ValidationTag); ok {
+			errs = append(errs, checkRules(fieldValue, fieldPath, tag)...)
+		}
+		errs = append(errs, validateNested(fieldValue, fieldPath)...)
+	}
+
+	return errs
+}
+
+// validateNested 校验嵌套的值对象和值对象切片
+func validateNested(value reflect.Value, path string) []FieldError {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		return validateNested(value.Elem(), path)
+	case reflect.Struct:
+		if isEntityType(value.Type()) {
+			return nil
+		}
+		return validateStruct(value, path)
+	case reflect.Slice, reflect.Array:
+		elemType := value.Type().Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() != reflect.Struct || isEntityType(elemType) {
+			return nil
+		}
+
+		var errs []FieldError
+		for i := 0; i < value.Len(); i++ {
+			errs = append(errs, validateNested(value.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	default:
+		return nil
+	}
+}
+
+func isEntityType(structType reflect.Type) bool {
+	return reflect.PtrTo(structType).Implements(entityInterfaceType)
+}
+
+// fieldPathOf 拼接字段路径，优先使用 json 标签名
+func fieldPathOf(path string, field reflect.StructField) string {
+	name := field.Name
+	if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+		name = tag
+	}
+
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkRules 按标签规则校验字段值
+func checkRules(value reflect.Value, path, tag string) []FieldError {
+	var errs []FieldError
+
+	for tag != "" {
+		rule := tag
+		if !strings.HasPrefix(tag, "regex=") {
+			if i := strings.Index(tag, ";"); i >= 0 {
+				rule, tag = tag[:i], tag[i+1:]
+			} else {
+				tag = ""
+			}
+		} else {
+			tag = ""
+		}
+
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "" {
+			continue
+		}
+
+		if message, ok := checkRule(value, name, param); !ok {
+			errs = append(errs, FieldError{Field: path, Rule: name, Message: message})
+		}
+	}
+
+	return errs
+}
+
+// checkRule 校验单个规则，返回错误信息和是否通过
+func checkRule(value reflect.Value, name, param string) (string, bool) {
+	if name == "required" {
+		if value.IsZero() || (isSized(value) && value.Len() == 0) {
+			return "is required", false
+		}
+		return "", true
+	}
+
+	// 其余规则不校验空值，由 required 负责
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "", true
+		}
+		value = value.Elem()
+	}
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return fmt.Sprintf("has invalid %s rule %q", name, param), false
+		}
+
+		size, unit, ok := measure(value)
+		if !ok {
+			return fmt.Sprintf("does not support %s rule", name), false
+		}
+
+		if name == "min" && size < limit {
+			return fmt.Sprintf("must be at least %s%s", param, unit), false
+		}
+		if name == "max" && size > limit {
+			return fmt.Sprintf("must be at most %s%s", param, unit), false
+		}
+		return "", true
+	case "regex":
+		if value.Kind() != reflect.String {
+			return "does not support regex rule", false
+		}
+		if value.String() == "" {
+			return "", true
+		}
+
+		pattern, err := compileRegex(param)
+		if err != nil {
+			return fmt.Sprintf("has invalid regex rule %q", param), false
+		}
+		if !pattern.MatchString(value.String()) {
+			return fmt.Sprintf("must match %s", param), false
+		}
+		return "", true
+	default:
+		return fmt.Sprintf("has unknown validation rule %q", name), false
+	}
+}
+
+func isSized(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	default:
+		return false
+	}
+}
+
+// measure 字符串按字符数、集合按元素数、数字按数值比较
+func measure(value reflect.Value) (float64, string, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters", true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "", true
+	default:
+		return 0, "", false
+	}
+}
+
+var regexCache sync.Map
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexCache.Store(pattern, compiled)
+	return compiled, nil
+}
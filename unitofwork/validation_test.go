@@ -0,0 +1,179 @@
+package unitofwork
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 示例实体：客户，包含嵌套的值对象
+type Customer struct {
+	BaseEntity
+	Name    string  `json:"name" validation:"required;max=5"`
+	Code    string  `json:"code" validation:"regex=^[A-Z]{3}$"`
+	Level   int     `json:"level" validation:"min=1;max=3"`
+	Address Address `json:"address"`
+	Phones  []Phone `json:"phones" validation:"max=2"`
+}
+
+func (c *Customer) GetTableName() string {
+	return "customers"
+}
+
+func (c *Customer) Validate() error {
+	var errs []error
+	if c.Level == 3 && c.Code == "" {
+		errs = append(errs, &FieldError{Field: "code", Rule: "vip", Message: "is required for level 3"})
+	}
+	if c.Address.City == "北京" && c.Address.Zip == "" {
+		errs = append(errs, errors.New("北京的客户必须填写邮编"))
+	}
+	return errors.Join(errs...)
+}
+
+type Address struct {
+	City string `json:"city" validation:"required"`
+	Zip  string `json:"zip" validation:"regex=^[0-9]{6}$"`
+}
+
+type Phone struct {
+	Number string `json:"number" validation:"required;regex=^[0-9;]+$"`
+}
+
+// 记录 Validate 调用次数的标签
+type countedTag struct {
+	Tag
+	validations int
+}
+
+func (t *countedTag) TableName() string {
+	return "tags"
+}
+
+func (t *countedTag) Validate() error {
+	t.validations++
+	return nil
+}
+
+// TestUnitOfWork_Validate 测试提交前汇总校验错误
+func TestUnitOfWork_Validate(t *testing.T) {
+	t.Run("汇总所有实体的所有错误", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db)
+
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Create(&Customer{
+			Name:    "张三李四王五",
+			Code:    "ab",
+			Address: Address{Zip: "12"},
+			Phones:  []Phone{{Number: "1;2"}, {}, {Number: "x"}},
+		}))
+		require.NoError(t, uow.Create(&User{Email: "zhangsan@example.com"}))
+
+		err := uow.Commit()
+		var report *ValidationReport
+		require.True(t, errors.As(err, &report))
+		require.Len(t, report.Entities, 2)
+
+		customer := report.Entities[0]
+		assert.Equal(t, "*unitofwork.Customer", customer.EntityType)
+		assert.Empty(t, customer.EntityID)
+
+		rules := make(map[string][]string)
+		for _, fieldError := range customer.Errors {
+			rules[fieldError.Field] = append(rules[fieldError.Field], fieldError.Rule)
+		}
+		assert.Equal(t, map[string][]string{
+			"name":             {"max"},
+			"code":             {"regex"},
+			"level":            {"min"},
+			"address.city":     {"required"},
+			"address.zip":      {"regex"},
+			"phones":           {"max"},
+			"phones[1].number": {"required"},
+			"phones[2].number": {"regex"},
+		}, rules)
+
+		assert.Equal(t, "validation failed for entity *unitofwork.User: 用户名不能为空", report.Entities[1].Error())
+
+		// 校验失败时不执行任何操作
+		var count int64
+		require.NoError(t, db.Model(&Tag{}).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("转换为 HTTP 422 响应体", func(t *testing.T) {
+		db := setupTestDB()
+		seedUsers(t, db, 1)
+
+		uow := NewUnitOfWork(db)
+		var user *User
+		require.NoError(t, uow.Find(&user, 1))
+		user.Name = ""
+		require.NoError(t, uow.Update(user))
+		require.NoError(t, uow.Create(&Customer{Name: "张三", Level: 3, Address: Address{City: "北京"}}))
+
+		var report *ValidationReport
+		require.True(t, errors.As(uow.Validate(), &report))
+		assert.Equal(t, http.StatusUnprocessableEntity, report.StatusCode())
+
+		body, err := json.Marshal(report.Response())
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"message": "validation failed",
+			"errors": {
+				"users": {"1": {"": ["用户名不能为空"]}},
+				"customers": {"new[0]": {"code": ["is required for level 3"], "": ["北京的客户必须填写邮编"]}}
+			}
+		}`, string(body))
+	})
+
+	t.Run("校验阶段运行后操作不再重复校验", func(t *testing.T) {
+		for _, uow := range []*UnitOfWork{NewUnitOfWork(setupTestDB()), NewMemoryUnitOfWork(NewMemoryStore())} {
+			created := &countedTag{Tag: Tag{Name: "go"}}
+			upserted := &countedTag{Tag: Tag{Name: "gorm"}}
+			require.NoError(t, uow.Create(created))
+			require.NoError(t, uow.Upsert(upserted))
+			require.NoError(t, uow.Commit())
+
+			assert.Equal(t, 1, created.validations)
+			assert.Equal(t, 1, upserted.validations)
+		}
+
+		uow := NewUnitOfWork(setupTestDB(), WithValidation(false))
+		created := &countedTag{Tag: Tag{Name: "go"}}
+		require.NoError(t, uow.Create(created))
+		require.NoError(t, uow.Commit())
+		assert.Equal(t, 1, created.validations)
+	})
+
+	t.Run("提交失败的错误信息包含操作序号", func(t *testing.T) {
+		uow := NewUnitOfWork(setupTestDB())
+		require.NoError(t, uow.Create(&Tag{Name: "go"}))
+		require.NoError(t, uow.Create(&User{Name: "张三"}))
+
+		err := uow.Commit()
+		require.Error(t, err)
+		assert.EqualError(t, err, "unit of work commit failed: operation 1 failed: validation failed for entity *unitofwork.User: 邮箱不能为空")
+
+		var report *ValidationReport
+		assert.True(t, errors.As(err, &report))
+	})
+
+	t.Run("关闭校验阶段", func(t *testing.T) {
+		db := setupTestDB()
+		uow := NewUnitOfWork(db, WithValidation(false))
+
+		require.NoError(t, uow.Create(&User{Email: "zhangsan@example.com"}))
+		err := uow.Commit()
+		require.Error(t, err)
+
+		var report *ValidationReport
+		assert.False(t, errors.As(err, &report))
+		assert.Contains(t, err.Error(), "operation 0 failed")
+	})
+}
@@ -0,0 +1,159 @@
+package unitofwork
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/wubin1989/gorm"
+)
+
+// TwoPhaseDialect 两阶段提交方言，协调器按 gorm 方言名称选择
+// 分支事务在专用连接上开启和预提交，预提交之后的提交和回滚可以在任意连接上执行
+type TwoPhaseDialect interface {
+	// Begin 在专用连接上开启分支事务
+	Begin(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// Prepare 结束并预提交分支事务
+	Prepare(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// Rollback 回滚尚未预提交的分支事务
+	Rollback(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// CommitPrepared 提交已预提交的分支事务
+	CommitPrepared(ctx context.Context, db *gorm.DB, xid string) error
+
+	// RollbackPrepared 回滚已预提交的分支事务
+	RollbackPrepared(ctx context.Context, db *gorm.DB, xid string) error
+
+	// Recover 列出数据库中处于预提交状态的分支事务
+	Recover(ctx context.Context, db *gorm.DB) ([]string, error)
+}
+
+// defaultTwoPhaseDialects 内置支持两阶段提交的方言
+func defaultTwoPhaseDialects() map[string]TwoPhaseDialect {
+	return map[string]TwoPhaseDialect{
+		"mysql":    MySQLXA{},
+		"postgres": PostgresTwoPhase{},
+	}
+}
+
+// MySQLXA MySQL XA 事务
+type MySQLXA struct{}
+
+// Begin 实现TwoPhaseDialect接口
+func (MySQLXA) Begin(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "XA START "+quoteXID(xid))
+	return err
+}
+
+// Prepare 实现TwoPhaseDialect接口
+func (MySQLXA) Prepare(ctx context.Context, conn *sql.Conn, xid string) error {
+	if _, err := conn.ExecContext(ctx, "XA END "+quoteXID(xid)); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "XA PREPARE "+quoteXID(xid))
+	return err
+}
+
+// Rollback 实现TwoPhaseDialect接口
+func (MySQLXA) Rollback(ctx context.Context, conn *sql.Conn, xid string) error {
+	// 分支事务可能已经结束，XA END 的错误忽略
+	_, _ = conn.ExecContext(ctx, "XA END "+quoteXID(xid))
+	_, err := conn.ExecContext(ctx, "XA ROLLBACK "+quoteXID(xid))
+	return err
+}
+
+// CommitPrepared 实现TwoPhaseDialect接口
+func (MySQLXA) CommitPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return db.WithContext(ctx).Exec("XA COMMIT " + quoteXID(xid)).Error
+}
+
+// RollbackPrepared 实现TwoPhaseDialect接口
+func (MySQLXA) RollbackPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return db.WithContext(ctx).Exec("XA ROLLBACK " + quoteXID(xid)).Error
+}
+
+// Recover 实现TwoPhaseDialect接口
+func (MySQLXA) Recover(ctx context.Context, db *gorm.DB) ([]string, error) {
+	rows, err := db.WithContext(ctx).Raw("XA RECOVER").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	xids := make([]string, 0)
+	for rows.Next() {
+		var formatID, gtridLength, bqualLength int
+		var data string
+		if err := rows.Scan(&formatID, &gtridLength, &bqualLength, &data); err != nil {
+			return nil, err
+		}
+		xids = append(xids, data[:gtridLength])
+	}
+
+	return xids, rows.Err()
+}
+
+// PostgresTwoPhase PostgreSQL 预提交事务，需要将 max_prepared_transactions 配置为大于零
+type PostgresTwoPhase struct{}
+
+// Begin 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) Begin(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "BEGIN")
+	return err
+}
+
+// Prepare 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) Prepare(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "PREPARE TRANSACTION "+quoteXID(xid))
+	return err
+}
+
+// Rollback 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) Rollback(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+// CommitPrepared 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) CommitPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return db.WithContext(ctx).Exec("COMMIT PREPARED " + quoteXID(xid)).Error
+}
+
+// RollbackPrepared 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) RollbackPrepared(ctx context.Context, db *gorm.DB, xid string) error {
+	return db.WithContext(ctx).Exec("ROLLBACK PREPARED " + quoteXID(xid)).Error
+}
+
+// Recover 实现TwoPhaseDialect接口
+func (PostgresTwoPhase) Recover(ctx context.Context, db *gorm.DB) ([]string, error) {
+	var xids []string
+	err := db.WithContext(ctx).Raw("SELECT gid FROM pg_prepared_xacts WHERE database = current_database()").Scan(&xids).Error
+	return xids, err
+}
+
+// quoteXID 将事务标识转换为字符串字面量
+func quoteXID(xid string) string {
+	return "'" + strings.ReplaceAll(xid, "'", "''") + "'"
+}
+
+// branchXID 全局事务中第 index 个参与者的分支事务标识
+func branchXID(transactionID string, index int) string {
+	return fmt.Sprintf("%s.%d", transactionID, index)
+}
+
+// parseBranchXID 解析分支事务标识，不是协调器生成的标识时返回 false
+func parseBranchXID(xid string) (string, bool) {
+	if !strings.HasPrefix(xid, transactionIDPrefix) {
+		return "", false
+	}
+
+	dot := strings.LastIndex(xid, ".")
+	if dot < 0 {
+		return "", false
+	}
+
+	return xid[:dot], true
+}